@@ -41,6 +41,9 @@ type Backend interface {
 	// Gossip sends a message to all validators (exclude self)
 	Gossip(valSet ValidatorSet, code uint64, payload []byte) error
 
+	// Resend sends a message to the given validators (exclude self), even if it was already sent to them
+	Resend(targets []common.Address, code uint64, payload []byte) error
+
 	// Commit delivers an approved proposal to backend.
 	// The delivered proposal will be put into blockchain.
 	Commit(proposal Proposal, seals [][]byte, round *big.Int) error
@@ -74,6 +77,16 @@ type Backend interface {
 	// HasBadProposal returns whether the block with the hash is a bad block
 	HasBadProposal(hash common.Hash) bool
 
+	// RequestSync asks the node to synchronise the chain with its peers
+	RequestSync()
+
+	// ProposerChanged is called when the node becomes or stops being the proposer, at the view
+	// where its proposer status changed
+	ProposerChanged(isProposer bool, view View)
+
+	// Alert hands a condition operators should look into to the alert sinks
+	Alert(alert *Alert)
+
 	Close() error
 
 	// IsQBFTConsensus checks qbftBlock fork block and returns if it should be enabled
@@ -0,0 +1,99 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// alertQueueSize is the number of alerts waiting for a sink, the alerts raised beyond are dropped
+const alertQueueSize = 64
+
+// alertDropMeter counts the alerts dropped because a sink did not keep up with them
+var alertDropMeter = metrics.NewRegisteredMeter("consensus/istanbul/backend/alert/dropped", nil)
+
+// logAlertSink is the alert sink used while no other is registered, it logs the alerts
+type logAlertSink struct {
+	logger log.Logger
+}
+
+// Alert implements istanbul.AlertSink
+func (s logAlertSink) Alert(alert *istanbul.Alert) error {
+	ctx := append([]interface{}{"kind", alert.Kind, "view", alert.View}, alert.Context...)
+	s.logger.Warn("BFT: consensus alert, "+alert.Message, ctx...)
+	return nil
+}
+
+// alertWorker delivers the alerts queued for a sink one at a time, so that a slow sink neither holds the
+// consensus event loop back nor delays the other sinks
+type alertWorker struct {
+	sink  istanbul.AlertSink
+	queue chan *istanbul.Alert
+}
+
+// AddAlertSink registers a sink receiving the alerts of the consensus core, along with the other registered
+// sinks. The alerts are only logged while no sink is registered.
+func (sb *Backend) AddAlertSink(sink istanbul.AlertSink) {
+	worker := &alertWorker{
+		sink:  sink,
+		queue: make(chan *istanbul.Alert, alertQueueSize),
+	}
+	go func() {
+		for alert := range worker.queue {
+			sb.deliverAlert(worker.sink, alert)
+		}
+	}()
+
+	sb.alertSinksMu.Lock()
+	defer sb.alertSinksMu.Unlock()
+
+	sb.alertSinks = append(sb.alertSinks, worker)
+}
+
+// Alert implements istanbul.Backend.Alert
+func (sb *Backend) Alert(alert *istanbul.Alert) {
+	sb.alertSinksMu.RLock()
+	workers := sb.alertSinks
+	sb.alertSinksMu.RUnlock()
+
+	if len(workers) == 0 {
+		sb.deliverAlert(logAlertSink{logger: sb.logger}, alert)
+		return
+	}
+	for _, worker := range workers {
+		select {
+		case worker.queue <- alert:
+		default:
+			alertDropMeter.Mark(1)
+			sb.logger.Warn("BFT: alert sink queue full, alert dropped", "kind", alert.Kind)
+		}
+	}
+}
+
+// deliverAlert hands alert to sink, its failures are logged
+func (sb *Backend) deliverAlert(sink istanbul.AlertSink, alert *istanbul.Alert) {
+	defer func() {
+		if r := recover(); r != nil {
+			sb.logger.Error("BFT: alert sink panicked", "kind", alert.Kind, "panic", r)
+		}
+	}()
+	if err := sink.Alert(alert); err != nil {
+		sb.logger.Warn("BFT: alert sink failed", "kind", alert.Kind, "err", err)
+	}
+}
@@ -17,12 +17,26 @@
 package backend
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math/big"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
 	istanbulcommon "github.com/ethereum/go-ethereum/consensus/istanbul/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
@@ -32,6 +46,18 @@ type API struct {
 	backend *Backend
 }
 
+// DebugAPI is the RPC API to step through the events of the consensus core, not exposed unless enabled
+// explicitly as it can halt the consensus
+type DebugAPI struct {
+	backend *Backend
+}
+
+// AdminAPI is the RPC API to operate on the persisted consensus state of the node, not exposed unless enabled
+// explicitly as it can make the node equivocate
+type AdminAPI struct {
+	backend *Backend
+}
+
 // BlockSigners is contains who created and who signed a particular block, denoted by its number and hash
 type BlockSigners struct {
 	Number     uint64
@@ -174,6 +200,25 @@ func (api *API) Propose(address common.Address, auth bool) {
 	defer api.backend.candidatesLock.Unlock()
 
 	api.backend.candidates[address] = auth
+	delete(api.backend.removalReasons, address)
+}
+
+// ProposeRemoval votes for the removal of a validator, as Propose does, giving the reason code of the
+// removal: offline, byzantine or other. The reason is recorded by this node once the validator is removed,
+// it is not part of the vote.
+func (api *API) ProposeRemoval(address common.Address, reason string) error {
+	if err := checkRemovalReason(reason); err != nil {
+		return err
+	}
+	api.backend.candidatesLock.Lock()
+	defer api.backend.candidatesLock.Unlock()
+
+	api.backend.candidates[address] = false
+	if api.backend.removalReasons == nil {
+		api.backend.removalReasons = make(map[common.Address]string)
+	}
+	api.backend.removalReasons[address] = reason
+	return nil
 }
 
 // Discard drops a currently running candidate, stopping the validator from casting
@@ -183,6 +228,13 @@ func (api *API) Discard(address common.Address) {
 	defer api.backend.candidatesLock.Unlock()
 
 	delete(api.backend.candidates, address)
+	delete(api.backend.removalReasons, address)
+}
+
+// ValidatorRemovals returns the validators removed by governance votes, by block number, with the reason
+// code of the removals this node voted for through ProposeRemoval.
+func (api *API) ValidatorRemovals() ([]*ValidatorRemoval, error) {
+	return api.backend.validatorRemovals()
 }
 
 func (api *API) Status(startBlockNum *rpc.BlockNumber, endBlockNum *rpc.BlockNumber) (*Status, error) {
@@ -268,3 +320,593 @@ func (api *API) IsValidator(blockNum *rpc.BlockNumber) (bool, error) {
 	}
 	return false, nil
 }
+
+// roundChangeReporter is implemented by the consensus cores tracking their round changes
+type roundChangeReporter interface {
+	LastRoundChange() *istanbul.RoundChangeInfo
+}
+
+// LastRoundChange returns the reason, view and time of the last round change of the running
+// consensus core, or nil if it has not changed round yet
+func (api *API) LastRoundChange() (*istanbul.RoundChangeInfo, error) {
+	reporter, ok := api.backend.core.(roundChangeReporter)
+	if !ok {
+		return nil, errors.New("consensus core does not track round changes")
+	}
+	return reporter.LastRoundChange(), nil
+}
+
+// faultyProposerReporter is implemented by the consensus cores counting the invalid blocks proposed by validators
+type faultyProposerReporter interface {
+	FaultyProposers() map[common.Address]uint64
+}
+
+// FaultyProposers returns the validators the running consensus core saw proposing at least
+// InvalidProposalThreshold invalid blocks in a row, with the number of invalid blocks since their last
+// valid one. It is diagnostic only, these validators are still selected as proposers.
+func (api *API) FaultyProposers() (map[common.Address]uint64, error) {
+	reporter, ok := api.backend.core.(faultyProposerReporter)
+	if !ok {
+		return nil, errors.New("consensus core does not track faulty proposers")
+	}
+	return reporter.FaultyProposers(), nil
+}
+
+// backlogProber is implemented by the consensus cores able to classify their backlogged messages
+type backlogProber interface {
+	ProbeBacklog(src common.Address, view istanbul.View) []*istanbul.BacklogProbe
+}
+
+// ProbeBacklogMessage reports how the running consensus core classifies the messages backlogged
+// from source for the given view (accepted, future, old or invalid), without dispatching them.
+// It helps finding out why a message is not being processed, each probe explaining why the
+// message is still queued.
+func (api *API) ProbeBacklogMessage(source common.Address, sequence uint64, round uint64) ([]*istanbul.BacklogProbe, error) {
+	prober, ok := api.backend.core.(backlogProber)
+	if !ok {
+		return nil, errors.New("consensus core does not support backlog probes")
+	}
+	view := istanbul.View{
+		Sequence: new(big.Int).SetUint64(sequence),
+		Round:    new(big.Int).SetUint64(round),
+	}
+	return prober.ProbeBacklog(source, view), nil
+}
+
+// backlogStatsReporter is implemented by the consensus cores tracking their backlog drains
+type backlogStatsReporter interface {
+	BacklogStats() *istanbul.BacklogStats
+}
+
+// BacklogStats returns the statistics accumulated over the backlog drains of the running consensus
+// core: average backlog size, share of ready and future messages and drain duration
+func (api *API) BacklogStats() (*istanbul.BacklogStats, error) {
+	reporter, ok := api.backend.core.(backlogStatsReporter)
+	if !ok {
+		return nil, errors.New("consensus core does not track backlog drains")
+	}
+	return reporter.BacklogStats(), nil
+}
+
+// backlogPriorityExporter is implemented by the consensus cores exporting their backlog priorities
+type backlogPriorityExporter interface {
+	BacklogPriorities() []*istanbul.BacklogPriority
+}
+
+// BacklogPriorities dumps the priority of every message backlogged by the running consensus core, with the
+// exact value it is computed from, for the offline analysis of priority collisions due to the float32 precision
+func (api *API) BacklogPriorities() ([]*istanbul.BacklogPriority, error) {
+	exporter, ok := api.backend.core.(backlogPriorityExporter)
+	if !ok {
+		return nil, errors.New("consensus core does not export backlog priorities")
+	}
+	return exporter.BacklogPriorities(), nil
+}
+
+// quorumProgressReporter is implemented by the consensus cores exposing their vote accumulators
+type quorumProgressReporter interface {
+	QuorumProgress() *istanbul.QuorumProgress
+}
+
+// QuorumProgress returns how many PREPARE and COMMIT messages the running consensus core received
+// for its current view against the quorum size, or nil if no view has started yet
+func (api *API) QuorumProgress() (*istanbul.QuorumProgress, error) {
+	reporter, ok := api.backend.core.(quorumProgressReporter)
+	if !ok {
+		return nil, errors.New("consensus core does not report quorum progress")
+	}
+	return reporter.QuorumProgress(), nil
+}
+
+// roundChangeSetReporter is implemented by the consensus cores exposing their ROUND-CHANGE accumulator
+type roundChangeSetReporter interface {
+	RoundChanges() *istanbul.RoundChangeReport
+}
+
+// RoundChanges returns the ROUND-CHANGE messages the running consensus core collected for its current
+// sequence, grouped by target round, to tell how close a stuck round change is to its thresholds
+func (api *API) RoundChanges() (*istanbul.RoundChangeReport, error) {
+	reporter, ok := api.backend.core.(roundChangeSetReporter)
+	if !ok {
+		return nil, errors.New("consensus core does not report round changes")
+	}
+	return reporter.RoundChanges(), nil
+}
+
+// consensusStatsReporter is implemented by the consensus cores keeping statistics of their committed blocks
+type consensusStatsReporter interface {
+	RecentConsensusStats() []*istanbul.ConsensusStats
+}
+
+// RecentConsensusStats returns the round, duration, committers and round change reasons of the last
+// blocks committed by the running consensus core, from the oldest to the most recent one. The number
+// of blocks kept is set by the ConsensusStatsWindow option.
+func (api *API) RecentConsensusStats() ([]*istanbul.ConsensusStats, error) {
+	reporter, ok := api.backend.core.(consensusStatsReporter)
+	if !ok {
+		return nil, errors.New("consensus core does not keep consensus statistics")
+	}
+	return reporter.RecentConsensusStats(), nil
+}
+
+// uptimeReporter is implemented by the consensus cores tracking the participation of the validators
+type uptimeReporter interface {
+	ValidatorUptime() []*istanbul.ValidatorUptime
+}
+
+// ValidatorUptime returns, for each validator of the last blocks committed by the running consensus core,
+// the share of these blocks it sent a PREPARE or COMMIT message for. The number of blocks is set by the
+// UptimeWindow option.
+func (api *API) ValidatorUptime() ([]*istanbul.ValidatorUptime, error) {
+	reporter, ok := api.backend.core.(uptimeReporter)
+	if !ok {
+		return nil, errors.New("consensus core does not track validator uptime")
+	}
+	return reporter.ValidatorUptime(), nil
+}
+
+// SafetyViolation returns the evidence of the conflicting blocks finalized at the same height which halted
+// the consensus engine, nil if none was detected
+func (api *API) SafetyViolation() *istanbul.SafetyViolation {
+	return api.backend.SafetyViolation()
+}
+
+// eventStepper is implemented by the consensus cores supporting single-step debugging
+type eventStepper interface {
+	PauseEvents() (*istanbul.StepResult, error)
+	StepEvent() (*istanbul.StepResult, error)
+	ResumeEvents() (*istanbul.StepResult, error)
+}
+
+// PauseEvents pauses the event loop of the running consensus core, which queues its input events until they
+// are stepped through or resumed. It requires the StepDebugging option, for development networks only.
+func (api *DebugAPI) PauseEvents() (*istanbul.StepResult, error) {
+	stepper, ok := api.backend.core.(eventStepper)
+	if !ok {
+		return nil, errors.New("consensus core does not support single-step debugging")
+	}
+	return stepper.PauseEvents()
+}
+
+// StepEvent handles the first event queued by the paused consensus core and returns the resulting state
+func (api *DebugAPI) StepEvent() (*istanbul.StepResult, error) {
+	stepper, ok := api.backend.core.(eventStepper)
+	if !ok {
+		return nil, errors.New("consensus core does not support single-step debugging")
+	}
+	return stepper.StepEvent()
+}
+
+// ResumeEvents handles the events queued by the paused consensus core and resumes its event loop
+func (api *DebugAPI) ResumeEvents() (*istanbul.StepResult, error) {
+	stepper, ok := api.backend.core.(eventStepper)
+	if !ok {
+		return nil, errors.New("consensus core does not support single-step debugging")
+	}
+	return stepper.ResumeEvents()
+}
+
+// ExportState returns the consensus state of the running consensus core, to resume from it on a hot-standby
+// validator with ImportState
+func (api *AdminAPI) ExportState() (hexutil.Bytes, error) {
+	return api.backend.ExportConsensusState()
+}
+
+// ImportState sets the consensus state exported by another node, which the consensus core resumes from on the
+// next start of the engine. The engine must be stopped, and the exporting node must not take part in the
+// consensus anymore.
+func (api *AdminAPI) ImportState(data hexutil.Bytes) error {
+	return api.backend.ImportConsensusState(data)
+}
+
+// ClearSafetyViolation deletes the evidence of the conflicting finalized blocks recorded at the given height,
+// so that the engine can be started again once the operators resolved them. The engine must be stopped.
+func (api *AdminAPI) ClearSafetyViolation(number uint64) error {
+	return api.backend.ClearSafetyViolation(number)
+}
+
+// preparedCertificateReporter is implemented by the consensus cores exposing the block they prepared
+type preparedCertificateReporter interface {
+	PreparedCertificate() *istanbul.PreparedCertificate
+}
+
+// PreparedCertificate returns the block the running consensus core prepared for its current sequence, as the
+// digest, round and senders of the PREPARE messages of the certificate, nil if it did not prepare a block
+func (api *API) PreparedCertificate() (*istanbul.PreparedCertificate, error) {
+	reporter, ok := api.backend.core.(preparedCertificateReporter)
+	if !ok {
+		return nil, errors.New("consensus core does not report prepared certificates")
+	}
+	return reporter.PreparedCertificate(), nil
+}
+
+// proposalValidator is implemented by the consensus cores validating blocks as if they were proposed
+type proposalValidator interface {
+	ValidateProposal(proposal istanbul.Proposal) *istanbul.ProposalValidation
+}
+
+// ValidateProposal validates the RLP-encoded block with the checks the running consensus core applies to
+// proposed blocks, so that tooling can pre-validate a block before submitting it
+func (api *API) ValidateProposal(data hexutil.Bytes) (*istanbul.ProposalValidation, error) {
+	validator, ok := api.backend.core.(proposalValidator)
+	if !ok {
+		return nil, errors.New("consensus core does not validate proposals")
+	}
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(data, block); err != nil {
+		return nil, fmt.Errorf("invalid block: %v", err)
+	}
+	return validator.ValidateProposal(block), nil
+}
+
+// consensusEventSource is implemented by the consensus cores streaming their activity
+type consensusEventSource interface {
+	SubscribeConsensusEvents(ch chan<- *istanbul.ConsensusEvent) event.Subscription
+}
+
+// consensusEventsBuffer is the number of consensus events buffered for a subscriber, the core drops the
+// subscribers whose buffer is full rather than waiting for them
+const consensusEventsBuffer = 256
+
+// ConsensusEvents streams the state changes, round changes, commits and alerts of the running consensus core
+// to the subscriber as they happen, in order. It is the streaming counterpart of the polling methods, served
+// as istanbul_subscribe("consensusEvents") on the connections supporting notifications, e.g. websockets.
+// A connection too slow to keep up with the events stops receiving them, and has to subscribe again.
+func (api *API) ConsensusEvents(ctx context.Context) (*rpc.Subscription, error) {
+	source, ok := api.backend.core.(consensusEventSource)
+	if !ok {
+		return nil, errors.New("consensus core does not stream its events")
+	}
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+
+	rpcSub := notifier.CreateSubscription()
+	events := make(chan *istanbul.ConsensusEvent, consensusEventsBuffer)
+	sub := source.SubscribeConsensusEvents(events)
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-events:
+				notifier.Notify(rpcSub.ID, ev)
+			case err := <-sub.Err():
+				log.Warn("BFT: consensus events subscription ended", "id", rpcSub.ID, "err", err)
+				return
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// consensusMetricsPrefix is the name prefix of the metrics registered by the consensus engine
+const consensusMetricsPrefix = "consensus/istanbul/"
+
+// ConsensusMetrics is a snapshot of the consensus metrics and of the state reported by the running core
+type ConsensusMetrics struct {
+	Metrics         map[string]map[string]interface{} `json:"metrics"`                   // registered consensus metrics by name, empty unless metrics are enabled
+	BacklogStats    *istanbul.BacklogStats            `json:"backlogStats,omitempty"`    // backlog drain statistics
+	QuorumProgress  *istanbul.QuorumProgress          `json:"quorumProgress,omitempty"`  // votes received for the current view
+	LastRoundChange *istanbul.RoundChangeInfo         `json:"lastRoundChange,omitempty"` // last round change of the core
+}
+
+// Metrics returns in a single call the registered consensus metrics (round changes, latencies,
+// violations...) along with the backlog statistics, quorum progress and last round change of the
+// running consensus core, for nodes whose metrics endpoint is not exposed
+func (api *API) Metrics() *ConsensusMetrics {
+	snapshot := &ConsensusMetrics{Metrics: make(map[string]map[string]interface{})}
+	for name, values := range metrics.DefaultRegistry.GetAll() {
+		if strings.HasPrefix(name, consensusMetricsPrefix) {
+			snapshot.Metrics[strings.TrimPrefix(name, consensusMetricsPrefix)] = values
+		}
+	}
+	if reporter, ok := api.backend.core.(backlogStatsReporter); ok {
+		snapshot.BacklogStats = reporter.BacklogStats()
+	}
+	if reporter, ok := api.backend.core.(quorumProgressReporter); ok {
+		snapshot.QuorumProgress = reporter.QuorumProgress()
+	}
+	if reporter, ok := api.backend.core.(roundChangeReporter); ok {
+		snapshot.LastRoundChange = reporter.LastRoundChange()
+	}
+	return snapshot
+}
+
+// ValidatorChangeSimulation describes the validator set that would result from a change,
+// without the change being applied
+type ValidatorChangeSimulation struct {
+	Validators  []common.Address `json:"validators"`  // validator set after the change
+	Added       []common.Address `json:"added"`       // addresses effectively added to the set
+	Removed     []common.Address `json:"removed"`     // addresses effectively removed from the set
+	Size        int              `json:"size"`        // number of validators after the change
+	F           int              `json:"f"`           // maximum number of faulty validators after the change
+	QuorumSize  int              `json:"quorumSize"`  // number of confirmations required after the change
+	IsValidator bool             `json:"isValidator"` // whether this node is still a validator after the change
+	AboveQuorum bool             `json:"aboveQuorum"` // whether the validators kept from the current set still reach quorum
+}
+
+// SimulateValidatorChange computes the effect of adding and removing the given validators
+// on the validator set at the current block, without applying anything. A change removing
+// every validator is rejected.
+func (api *API) SimulateValidatorChange(adds []common.Address, removes []common.Address) (*ValidatorChangeSimulation, error) {
+	header := api.chain.CurrentHeader()
+	if header == nil {
+		return nil, istanbulcommon.ErrUnknownBlock
+	}
+	snap, err := api.backend.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	nextBlock := new(big.Int).Add(header.Number, common.Big1)
+	return simulateValidatorChange(api.backend.config, snap.ValSet, nextBlock, api.backend.Address(), adds, removes)
+}
+
+// simulateValidatorChange applies adds and removes to a copy of valSet and reports the
+// resulting set and quorum numbers at the given block, an error if the set ends up empty
+func simulateValidatorChange(config *istanbul.Config, valSet istanbul.ValidatorSet, blockNumber *big.Int, self common.Address, adds []common.Address, removes []common.Address) (*ValidatorChangeSimulation, error) {
+	simulated := valSet.Copy()
+	result := &ValidatorChangeSimulation{
+		Added:   []common.Address{},
+		Removed: []common.Address{},
+	}
+	for _, addr := range removes {
+		if simulated.RemoveValidator(addr) {
+			result.Removed = append(result.Removed, addr)
+		}
+	}
+	for _, addr := range adds {
+		if simulated.AddValidator(addr) {
+			result.Added = append(result.Added, addr)
+		}
+	}
+	if simulated.Size() == 0 {
+		return nil, fmt.Errorf("%w: the change removes every validator", istanbul.ErrTooFewValidators)
+	}
+
+	result.Validators = make([]common.Address, 0, simulated.Size())
+	retained := 0
+	for _, v := range simulated.List() {
+		result.Validators = append(result.Validators, v.Address())
+		if _, val := valSet.GetByAddress(v.Address()); val != nil {
+			retained++
+		}
+	}
+	result.Size = simulated.Size()
+	result.F = simulated.F()
+	result.QuorumSize = istanbul.QuorumSize(config, simulated, blockNumber)
+	_, val := simulated.GetByAddress(self)
+	result.IsValidator = val != nil
+	result.AboveQuorum = retained >= result.QuorumSize
+	return result, nil
+}
+
+// ValidatorConnectivity tells which validators of the current set this node is connected to, and whether
+// enough of them are connected for consensus to progress
+type ValidatorConnectivity struct {
+	Validators      map[common.Address]bool `json:"validators"`      // whether this node has a p2p connection to each validator, itself included
+	Connected       int                     `json:"connected"`       // number of validators connected, this node included if it is one
+	Size            int                     `json:"size"`            // number of validators
+	QuorumSize      int                     `json:"quorumSize"`      // number of confirmations required
+	QuorumReachable bool                    `json:"quorumReachable"` // whether the connected validators reach quorum
+}
+
+// ValidatorConnectivity reports which validators of the set at the current block this node has a p2p
+// connection to, and whether they reach quorum.
+func (api *API) ValidatorConnectivity() (*ValidatorConnectivity, error) {
+	header := api.chain.CurrentHeader()
+	if header == nil {
+		return nil, istanbulcommon.ErrUnknownBlock
+	}
+	snap, err := api.backend.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	nextBlock := new(big.Int).Add(header.Number, common.Big1)
+	return validatorConnectivity(api.backend.config, snap.ValSet, nextBlock, api.backend.Address(), api.backend.broadcaster), nil
+}
+
+// validatorConnectivity looks the validators of valSet up in the peers of broadcaster, a node is always
+// connected to itself
+func validatorConnectivity(config *istanbul.Config, valSet istanbul.ValidatorSet, blockNumber *big.Int, self common.Address, broadcaster consensus.Broadcaster) *ValidatorConnectivity {
+	targets := make(map[common.Address]bool)
+	for _, v := range valSet.List() {
+		if v.Address() != self {
+			targets[v.Address()] = true
+		}
+	}
+	var peers map[common.Address]consensus.Peer
+	if broadcaster != nil && len(targets) > 0 {
+		peers = broadcaster.FindPeers(targets)
+	}
+
+	result := &ValidatorConnectivity{
+		Validators: make(map[common.Address]bool, valSet.Size()),
+		Size:       valSet.Size(),
+		QuorumSize: istanbul.QuorumSize(config, valSet, blockNumber),
+	}
+	for _, v := range valSet.List() {
+		_, connected := peers[v.Address()]
+		connected = connected || v.Address() == self
+		result.Validators[v.Address()] = connected
+		if connected {
+			result.Connected++
+		}
+	}
+	result.QuorumReachable = result.Size > 0 && result.Connected >= result.QuorumSize
+	return result
+}
+
+// ConsensusParams are the consensus parameters in effect at a block, which must be identical on all
+// the nodes of a network
+type ConsensusParams struct {
+	QBFT                     bool                  `json:"qbft"`
+	RequestTimeout           uint64                `json:"requestTimeout"`
+	BlockPeriod              uint64                `json:"blockPeriod"`
+	EmptyBlockPeriod         uint64                `json:"emptyBlockPeriod"`
+	MaxRequestTimeoutSeconds uint64                `json:"maxRequestTimeoutSeconds"`
+	Epoch                    uint64                `json:"epoch"`
+	ProposerPolicy           uint64                `json:"proposerPolicy"`
+	AllowedFutureBlockTime   uint64                `json:"allowedFutureBlockTime"`
+	Use2FPlus1Quorum         bool                  `json:"use2FPlus1Quorum"`
+	QuorumSize               int                   `json:"quorumSize"`
+	BeneficiaryMode          string                `json:"beneficiaryMode,omitempty"`
+	BlockReward              *math.HexOrDecimal256 `json:"blockReward,omitempty"`
+	MiningBeneficiary        *common.Address       `json:"miningBeneficiary,omitempty"`
+	ValidatorSelectionMode   string                `json:"validatorSelectionMode"`
+	ValidatorContract        common.Address        `json:"validatorContract"`
+	Validators               []common.Address      `json:"validators"`
+	Transitions              []params.Transition   `json:"transitions"`
+	CommitSealChainID        *big.Int              `json:"commitSealChainId,omitempty"`
+	CommitSealChainIDBlock   *big.Int              `json:"commitSealChainIdBlock,omitempty"`
+	ProposerGracePeriod      uint64                `json:"proposerGracePeriod"`
+	SignedCertificates       bool                  `json:"signedCertificates"`
+	SignedCertificatesBlock  *big.Int              `json:"signedCertificatesBlock,omitempty"`
+}
+
+// ConsensusConfigReport holds the effective consensus parameters of the node and their hash,
+// comparing the hashes of several nodes tells whether they agree on them
+type ConsensusConfigReport struct {
+	Number uint64          `json:"number"`
+	Params ConsensusParams `json:"params"`
+	Hash   common.Hash     `json:"hash"`
+}
+
+// ValidateConfig returns the consensus parameters in effect at the current block and their hash,
+// so that operators can detect configuration drift between nodes before it causes a fork.
+func (api *API) ValidateConfig() (*ConsensusConfigReport, error) {
+	header := api.chain.CurrentHeader()
+	if header == nil {
+		return nil, istanbulcommon.ErrUnknownBlock
+	}
+	snap, err := api.backend.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return consensusConfigReport(api.backend.config, snap.ValSet, header.Number)
+}
+
+// consensusConfigReport computes the consensus parameters in effect at the given block and their hash
+func consensusConfigReport(config *istanbul.Config, valSet istanbul.ValidatorSet, blockNumber *big.Int) (*ConsensusConfigReport, error) {
+	effective := config.GetConfig(blockNumber)
+	p := ConsensusParams{
+		QBFT:                     config.IsQBFTConsensusAt(blockNumber),
+		RequestTimeout:           effective.RequestTimeout,
+		BlockPeriod:              effective.BlockPeriod,
+		EmptyBlockPeriod:         effective.EmptyBlockPeriod,
+		MaxRequestTimeoutSeconds: effective.MaxRequestTimeoutSeconds,
+		Epoch:                    effective.Epoch,
+		AllowedFutureBlockTime:   effective.AllowedFutureBlockTime,
+		Use2FPlus1Quorum:         effective.Use2FPlus1Quorum(blockNumber),
+		QuorumSize:               istanbul.QuorumSize(config, valSet, blockNumber),
+		BlockReward:              effective.BlockReward,
+		MiningBeneficiary:        effective.MiningBeneficiary,
+		ValidatorSelectionMode:   effective.GetValidatorSelectionMode(blockNumber),
+		ValidatorContract:        effective.GetValidatorContractAddress(blockNumber),
+		Validators:               make([]common.Address, 0, valSet.Size()),
+		Transitions:              effective.Transitions,
+		CommitSealChainID:        effective.CommitSealChainID(blockNumber),
+		CommitSealChainIDBlock:   effective.CommitSealChainIDBlock,
+		ProposerGracePeriod:      effective.ProposerGracePeriod,
+		SignedCertificates:       effective.IsSignedCertificates(blockNumber),
+		SignedCertificatesBlock:  effective.SignedCertificatesBlock,
+	}
+	if effective.ProposerPolicy != nil {
+		p.ProposerPolicy = uint64(effective.ProposerPolicy.Id)
+	}
+	if effective.BeneficiaryMode != nil {
+		p.BeneficiaryMode = *effective.BeneficiaryMode
+	}
+	for _, v := range valSet.List() {
+		p.Validators = append(p.Validators, v.Address())
+	}
+
+	encoded, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return &ConsensusConfigReport{
+		Number: blockNumber.Uint64(),
+		Params: p,
+		Hash:   crypto.Keccak256Hash(encoded),
+	}, nil
+}
+
+// maxUpcomingProposers bounds the number of upcoming proposers computed by a single call
+const maxUpcomingProposers = 1024
+
+// UpcomingProposer is the validator expected to propose a block at round 0
+type UpcomingProposer struct {
+	Number   uint64         `json:"number"`
+	Proposer common.Address `json:"proposer"`
+}
+
+// UpcomingProposers returns the proposers of round 0 of the next n blocks, as the network selects them
+// unless the validator set changes or a round change occurs. With the HashSeeded proposer policy only the
+// proposer of the next block is known.
+func (api *API) UpcomingProposers(n int) ([]UpcomingProposer, error) {
+	if n < 0 || n > maxUpcomingProposers {
+		return nil, fmt.Errorf("number of proposers should be between 0 and %d", maxUpcomingProposers)
+	}
+	header := api.chain.CurrentHeader()
+	if header == nil {
+		return nil, istanbulcommon.ErrUnknownBlock
+	}
+	snap, err := api.backend.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var lastProposer common.Address
+	if header.Number.Sign() > 0 {
+		if lastProposer, err = api.backend.Author(header); err != nil {
+			return nil, err
+		}
+	}
+	return upcomingProposers(snap.ValSet, lastProposer, header.Number.Uint64(), n), nil
+}
+
+// upcomingProposers selects the proposers of round 0 of the n blocks following block number, proposed by
+// lastProposer, on a copy of valSet. It stops at the first block the set selects no proposer for.
+func upcomingProposers(valSet istanbul.ValidatorSet, lastProposer common.Address, number uint64, n int) []UpcomingProposer {
+	valSet = valSet.Copy()
+	proposers := make([]UpcomingProposer, 0, n)
+	for i := 1; i <= n && valSet.Size() > 0; i++ {
+		if i > 1 && valSet.Policy().Id == istanbul.HashSeeded {
+			// the next proposers depend on the hashes of blocks yet to be produced
+			break
+		}
+		view := &istanbul.View{Sequence: new(big.Int).SetUint64(number + uint64(i)), Round: new(big.Int)}
+		valSet.CalcProposer(lastProposer, view)
+		proposer := valSet.GetProposer()
+		if proposer == nil {
+			break
+		}
+		lastProposer = proposer.Address()
+		proposers = append(proposers, UpcomingProposer{Number: view.Sequence.Uint64(), Proposer: lastProposer})
+	}
+	return proposers
+}
@@ -0,0 +1,629 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/testutils"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestSimulateValidatorChange(t *testing.T) {
+	vset, _ := newTestValidatorSet(4)
+	self := vset.GetByIndex(0).Address()
+	removed := vset.GetByIndex(1).Address()
+	adds := []common.Address{common.HexToAddress("0x1"), common.HexToAddress("0x2")}
+
+	testCases := []struct {
+		name        string
+		config      *istanbul.Config
+		quorum      int
+		aboveQuorum bool
+	}{
+		{"2F+1", &istanbul.Config{}, 3, true},
+		{"ceil(2N/3)", &istanbul.Config{Ceil2Nby3Block: big.NewInt(0)}, 4, false},
+	}
+	for _, test := range testCases {
+		sim, err := simulateValidatorChange(test.config, vset, big.NewInt(1), self, adds, []common.Address{removed})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if sim.Size != 5 {
+			t.Errorf("%s: size mismatch: have %v, want %v", test.name, sim.Size, 5)
+		}
+		if sim.F != 1 {
+			t.Errorf("%s: F mismatch: have %v, want %v", test.name, sim.F, 1)
+		}
+		if sim.QuorumSize != test.quorum {
+			t.Errorf("%s: quorum size mismatch: have %v, want %v", test.name, sim.QuorumSize, test.quorum)
+		}
+		if sim.AboveQuorum != test.aboveQuorum {
+			t.Errorf("%s: above quorum mismatch: have %v, want %v", test.name, sim.AboveQuorum, test.aboveQuorum)
+		}
+		if !sim.IsValidator {
+			t.Errorf("%s: node should still be a validator", test.name)
+		}
+		if len(sim.Added) != 2 || len(sim.Removed) != 1 || sim.Removed[0] != removed {
+			t.Errorf("%s: diff mismatch: added %v, removed %v", test.name, sim.Added, sim.Removed)
+		}
+	}
+
+	// the simulation must not mutate the source validator set
+	if vset.Size() != 4 {
+		t.Errorf("validator set size mismatch: have %v, want %v", vset.Size(), 4)
+	}
+	if _, v := vset.GetByAddress(removed); v == nil {
+		t.Errorf("removed validator should still be in the source set")
+	}
+	for _, addr := range adds {
+		if _, v := vset.GetByAddress(addr); v != nil {
+			t.Errorf("added validator %v should not be in the source set", addr)
+		}
+	}
+
+	// removing every validator leaves no valid set
+	all := make([]common.Address, 0, vset.Size())
+	for _, v := range vset.List() {
+		all = append(all, v.Address())
+	}
+	if _, err := simulateValidatorChange(&istanbul.Config{}, vset, big.NewInt(1), self, nil, all); !errors.Is(err, istanbul.ErrTooFewValidators) {
+		t.Errorf("empty set error mismatch: have %v, want %v", err, istanbul.ErrTooFewValidators)
+	}
+}
+
+func TestSimulateValidatorChangeIgnoresNoops(t *testing.T) {
+	vset, _ := newTestValidatorSet(4)
+	existing := vset.GetByIndex(2).Address()
+
+	sim, err := simulateValidatorChange(&istanbul.Config{}, vset, big.NewInt(1), common.Address{}, []common.Address{existing}, []common.Address{common.HexToAddress("0x1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sim.Added) != 0 || len(sim.Removed) != 0 {
+		t.Errorf("expected no effective change: added %v, removed %v", sim.Added, sim.Removed)
+	}
+	if sim.Size != 4 || sim.QuorumSize != 3 || !sim.AboveQuorum {
+		t.Errorf("unexpected simulation: %+v", sim)
+	}
+	if sim.IsValidator {
+		t.Errorf("node should not be a validator")
+	}
+}
+
+// roundChangeCore is a consensus core reporting a fixed last round change
+type roundChangeCore struct {
+	istanbul.Core
+	info *istanbul.RoundChangeInfo
+}
+
+func (c *roundChangeCore) LastRoundChange() *istanbul.RoundChangeInfo {
+	return c.info
+}
+
+// testPeers is a broadcaster connected to a fixed set of peers
+type testPeers struct {
+	consensus.Broadcaster
+	peers map[common.Address]consensus.Peer
+}
+
+func (b *testPeers) FindPeers(targets map[common.Address]bool) map[common.Address]consensus.Peer {
+	found := make(map[common.Address]consensus.Peer)
+	for addr := range targets {
+		if p, ok := b.peers[addr]; ok {
+			found[addr] = p
+		}
+	}
+	return found
+}
+
+func TestValidatorConnectivity(t *testing.T) {
+	vset, _ := newTestValidatorSet(4)
+	self := vset.GetByIndex(0).Address()
+	config := &istanbul.Config{}
+
+	testCases := []struct {
+		name      string
+		connected []int
+		reachable bool
+	}{
+		{"all connected", []int{1, 2, 3}, true},
+		{"quorum connected", []int{1, 3}, true},
+		{"below quorum", []int{2}, false},
+		{"isolated", nil, false},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			peers := &testPeers{peers: make(map[common.Address]consensus.Peer)}
+			for _, i := range test.connected {
+				peers.peers[vset.GetByIndex(uint64(i)).Address()] = nil
+			}
+			// peers outside the validator set are not reported
+			peers.peers[common.HexToAddress("0x1")] = nil
+
+			report := validatorConnectivity(config, vset, big.NewInt(1), self, peers)
+			if report.Size != 4 || report.QuorumSize != 3 || len(report.Validators) != 4 {
+				t.Fatalf("report mismatch: have %+v, want 4 validators with a quorum of 3", report)
+			}
+			if want := len(test.connected) + 1; report.Connected != want {
+				t.Errorf("connected mismatch: have %d, want %d", report.Connected, want)
+			}
+			if !report.Validators[self] {
+				t.Error("this node should be reported connected to itself")
+			}
+			for _, i := range test.connected {
+				if addr := vset.GetByIndex(uint64(i)).Address(); !report.Validators[addr] {
+					t.Errorf("validator %v should be reported connected", addr)
+				}
+			}
+			if report.QuorumReachable != test.reachable {
+				t.Errorf("quorum reachable mismatch: have %v, want %v", report.QuorumReachable, test.reachable)
+			}
+		})
+	}
+
+	// without p2p only this node is connected
+	if report := validatorConnectivity(config, vset, big.NewInt(1), self, nil); report.Connected != 1 || report.QuorumReachable {
+		t.Errorf("report mismatch without p2p: have %+v, want only this node connected", report)
+	}
+}
+
+func TestLastRoundChange(t *testing.T) {
+	info := &istanbul.RoundChangeInfo{Reason: "timeout waiting for PRE-PREPARE", Sequence: 10, Round: 0, TargetRound: 1}
+	api := &API{backend: &Backend{core: &roundChangeCore{info: info}}}
+
+	got, err := api.LastRoundChange()
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if got != info {
+		t.Errorf("last round change mismatch: have %v, want %v", got, info)
+	}
+
+	// stopped core
+	api = &API{backend: &Backend{}}
+	if _, err := api.LastRoundChange(); err == nil {
+		t.Errorf("error mismatch: have nil, want error")
+	}
+}
+
+// backlogProbeCore is a consensus core returning fixed backlog probes
+type backlogProbeCore struct {
+	istanbul.Core
+	src    common.Address
+	view   istanbul.View
+	probes []*istanbul.BacklogProbe
+}
+
+func (c *backlogProbeCore) ProbeBacklog(src common.Address, view istanbul.View) []*istanbul.BacklogProbe {
+	c.src, c.view = src, view
+	return c.probes
+}
+
+func TestProbeBacklogMessage(t *testing.T) {
+	src := common.HexToAddress("0x1")
+	core := &backlogProbeCore{probes: []*istanbul.BacklogProbe{{Code: 0x13, Sequence: 3, Round: 1, Result: "future"}}}
+	api := &API{backend: &Backend{core: core}}
+
+	probes, err := api.ProbeBacklogMessage(src, 3, 1)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if len(probes) != 1 || probes[0] != core.probes[0] {
+		t.Errorf("probes mismatch: have %v, want %v", probes, core.probes)
+	}
+	if core.src != src || core.view.Sequence.Uint64() != 3 || core.view.Round.Uint64() != 1 {
+		t.Errorf("probed message mismatch: have %v %v", core.src, core.view)
+	}
+
+	// stopped core
+	api = &API{backend: &Backend{}}
+	if _, err := api.ProbeBacklogMessage(src, 3, 1); err == nil {
+		t.Errorf("error mismatch: have nil, want error")
+	}
+}
+
+func TestConsensusConfigReportHash(t *testing.T) {
+	vset, _ := newTestValidatorSet(4)
+	block := big.NewInt(10)
+	base := func() *istanbul.Config {
+		return &istanbul.Config{
+			RequestTimeout: 10000,
+			BlockPeriod:    5,
+			Epoch:          30000,
+			ProposerPolicy: istanbul.NewRoundRobinProposerPolicy(),
+			Ceil2Nby3Block: big.NewInt(0),
+		}
+	}
+	want, err := consensusConfigReport(base(), vset, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, _ := consensusConfigReport(base(), vset, block)
+	if same.Hash != want.Hash {
+		t.Fatalf("hash mismatch for identical configs: have %v, want %v", same.Hash, want.Hash)
+	}
+
+	mode := "validators"
+	testCases := []struct {
+		name   string
+		change func(*istanbul.Config)
+	}{
+		{"request timeout", func(c *istanbul.Config) { c.RequestTimeout = 20000 }},
+		{"block period", func(c *istanbul.Config) { c.BlockPeriod = 1 }},
+		{"empty block period", func(c *istanbul.Config) { c.EmptyBlockPeriod = 60 }},
+		{"epoch", func(c *istanbul.Config) { c.Epoch = 100 }},
+		{"proposer policy", func(c *istanbul.Config) { c.ProposerPolicy = istanbul.NewStickyProposerPolicy() }},
+		{"quorum", func(c *istanbul.Config) { c.Ceil2Nby3Block = nil }},
+		{"beneficiary mode", func(c *istanbul.Config) { c.BeneficiaryMode = &mode }},
+		{"transition", func(c *istanbul.Config) {
+			c.Transitions = []params.Transition{{Block: big.NewInt(20), BlockPeriodSeconds: 2}}
+		}},
+		{"transition in effect", func(c *istanbul.Config) {
+			c.Transitions = []params.Transition{{Block: big.NewInt(5), EpochLength: 100}}
+		}},
+		{"commit seal chain id", func(c *istanbul.Config) {
+			c.ChainID, c.CommitSealChainIDBlock = big.NewInt(1337), big.NewInt(5)
+		}},
+		{"commit seal chain id fork", func(c *istanbul.Config) { c.CommitSealChainIDBlock = big.NewInt(20) }},
+		{"proposer grace period", func(c *istanbul.Config) { c.ProposerGracePeriod = 2 }},
+		{"signed certificates", func(c *istanbul.Config) { c.SignedCertificatesBlock = big.NewInt(5) }},
+		{"signed certificates fork", func(c *istanbul.Config) { c.SignedCertificatesBlock = big.NewInt(20) }},
+	}
+	for _, test := range testCases {
+		config := base()
+		test.change(config)
+		report, err := consensusConfigReport(config, vset, block)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if report.Hash == want.Hash {
+			t.Errorf("%s: hash should change", test.name)
+		}
+	}
+
+	// the settings local to the node do not change it
+	local := base()
+	local.PrepareQuorum, local.CommitQuorum = 4, 4
+	if report, _ := consensusConfigReport(local, vset, block); report.Hash != want.Hash {
+		t.Errorf("local quorums: hash should not change")
+	}
+
+	// a different validator set
+	other, _ := newTestValidatorSet(4)
+	report, _ := consensusConfigReport(base(), other, block)
+	if report.Hash == want.Hash {
+		t.Errorf("validators: hash should change")
+	}
+}
+
+func TestRecentConsensusStats(t *testing.T) {
+	genesis, nodeKeys := testutils.GenesisAndKeys(1, true)
+	config := copyConfig(istanbul.DefaultConfig)
+	config.BlockPeriod = 0
+	config.ConsensusStatsWindow = 3
+	chain, engine := newBlockchainFromConfig(genesis, nodeKeys, config)
+	defer engine.Stop()
+	api := &API{chain: chain, backend: engine}
+
+	var blocks []*types.Block
+	parent := chain.Genesis()
+	for i := 0; i < 5; i++ {
+		block := makeBlock(chain, engine, parent)
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		if err := engine.NewChainHead(); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		blocks = append(blocks, block)
+		parent = block
+	}
+
+	// the statistics of a block are recorded once the core is done committing it
+	var stats []*istanbul.ConsensusStats
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var err error
+		if stats, err = api.RecentConsensusStats(); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		if n := len(stats); (n > 0 && stats[n-1].Sequence == 5) || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	// only the last 3 blocks are kept, oldest first
+	if len(stats) != 3 {
+		t.Fatalf("stats size mismatch: have %d, want 3", len(stats))
+	}
+	for i, s := range stats {
+		want := blocks[i+2]
+		if s.Sequence != want.NumberU64() || s.Hash != want.Hash() {
+			t.Errorf("stats %d: block mismatch: have %d %v, want %d %v", i, s.Sequence, s.Hash, want.NumberU64(), want.Hash())
+		}
+		if s.Round != 0 || len(s.RoundChangeReasons) != 0 {
+			t.Errorf("stats %d: round mismatch: have %d %v, want 0 without round change", i, s.Round, s.RoundChangeReasons)
+		}
+		if len(s.Committers) != 1 || s.Committers[0] != engine.Address() {
+			t.Errorf("stats %d: committers mismatch: have %v, want [%v]", i, s.Committers, engine.Address())
+		}
+		if s.Duration < 0 || s.Timestamp.IsZero() {
+			t.Errorf("stats %d: timing mismatch: have %v at %v", i, s.Duration, s.Timestamp)
+		}
+	}
+}
+
+// metricsCore is a consensus core reporting fixed statistics
+type metricsCore struct {
+	istanbul.Core
+}
+
+func (c *metricsCore) BacklogStats() *istanbul.BacklogStats {
+	return &istanbul.BacklogStats{Drains: 3}
+}
+
+func (c *metricsCore) QuorumProgress() *istanbul.QuorumProgress {
+	return &istanbul.QuorumProgress{Sequence: 10, Prepares: 2, PrepareQuorum: 3, CommitQuorum: 3}
+}
+
+func (c *metricsCore) LastRoundChange() *istanbul.RoundChangeInfo {
+	return &istanbul.RoundChangeInfo{Sequence: 10, TargetRound: 1}
+}
+
+func TestMetrics(t *testing.T) {
+	gauge := new(metrics.StandardGauge)
+	gauge.Update(7)
+	metrics.DefaultRegistry.Register("consensus/istanbul/test/gauge", gauge)
+	defer metrics.DefaultRegistry.Unregister("consensus/istanbul/test/gauge")
+	metrics.DefaultRegistry.Register("chain/test/gauge", gauge)
+	defer metrics.DefaultRegistry.Unregister("chain/test/gauge")
+
+	api := &API{backend: &Backend{core: &metricsCore{}}}
+	snapshot := api.Metrics()
+	if value := snapshot.Metrics["test/gauge"]["value"]; value != int64(7) {
+		t.Errorf("gauge mismatch: have %v, want 7", value)
+	}
+	for name := range snapshot.Metrics {
+		if strings.Contains(name, "chain/") {
+			t.Errorf("unexpected metric %v", name)
+		}
+	}
+	if snapshot.BacklogStats == nil || snapshot.BacklogStats.Drains != 3 {
+		t.Errorf("backlog stats mismatch: have %v, want 3 drains", snapshot.BacklogStats)
+	}
+	if snapshot.QuorumProgress == nil || snapshot.QuorumProgress.Prepares != 2 {
+		t.Errorf("quorum progress mismatch: have %v, want 2 prepares", snapshot.QuorumProgress)
+	}
+	if snapshot.LastRoundChange == nil || snapshot.LastRoundChange.TargetRound != 1 {
+		t.Errorf("last round change mismatch: have %v, want target round 1", snapshot.LastRoundChange)
+	}
+
+	// stopped core, only the metrics are reported
+	snapshot = (&API{backend: &Backend{}}).Metrics()
+	if len(snapshot.Metrics) == 0 || snapshot.BacklogStats != nil || snapshot.QuorumProgress != nil || snapshot.LastRoundChange != nil {
+		t.Errorf("snapshot mismatch: have %+v, want metrics only", snapshot)
+	}
+}
+
+func TestUpcomingProposers(t *testing.T) {
+	vset, _ := newTestValidatorSet(4)
+	last := vset.GetByIndex(2).Address()
+
+	// round robin: the validators take turns after the last proposer
+	proposers := upcomingProposers(vset, last, 10, 6)
+	if len(proposers) != 6 {
+		t.Fatalf("proposers mismatch: have %d, want 6", len(proposers))
+	}
+	for i, p := range proposers {
+		want := vset.GetByIndex(uint64(3+i) % 4).Address()
+		if p.Number != uint64(11+i) || p.Proposer != want {
+			t.Errorf("proposer %d mismatch: have %+v, want block %d by %v", i, p, 11+i, want)
+		}
+	}
+
+	// the predictions match the selection of the network, block after block
+	actual := vset.Copy()
+	lastProposer := last
+	for i, p := range proposers {
+		actual.CalcProposer(lastProposer, &istanbul.View{Sequence: new(big.Int).SetUint64(p.Number), Round: big.NewInt(0)})
+		if lastProposer = actual.GetProposer().Address(); lastProposer != p.Proposer {
+			t.Errorf("proposer %d mismatch: predicted %v, selected %v", i, p.Proposer, lastProposer)
+		}
+	}
+
+	// validators held back from proposing are skipped
+	held := vset.Copy()
+	held.(istanbul.ProposerGrace).SetProposerGrace(map[common.Address]uint64{vset.GetByIndex(0).Address(): 13})
+	if p := upcomingProposers(held, last, 10, 2)[1]; p.Proposer != vset.GetByIndex(1).Address() {
+		t.Errorf("proposer of held validator mismatch: have %v, want %v", p.Proposer, vset.GetByIndex(1).Address())
+	}
+
+	// the given set is left untouched
+	if proposer := vset.GetProposer().Address(); proposer != vset.GetByIndex(0).Address() {
+		t.Errorf("validator set proposer changed: have %v", proposer)
+	}
+
+	// a set selecting no proposer ends the predictions
+	if proposers := upcomingProposers(&noProposerSet{vset}, last, 10, 6); len(proposers) != 0 {
+		t.Errorf("proposers without selection mismatch: have %+v, want none", proposers)
+	}
+}
+
+// noProposerSet is a validator set whose proposer selector selects no validator
+type noProposerSet struct {
+	istanbul.ValidatorSet
+}
+
+func (s *noProposerSet) Copy() istanbul.ValidatorSet                 { return s }
+func (s *noProposerSet) CalcProposer(common.Address, *istanbul.View) {}
+func (s *noProposerSet) GetProposer() istanbul.Validator             { return nil }
+
+// eventsCore is a consensus core streaming the events sent to its subscriber, which it drops as the
+// consensus core does once it lags behind
+type eventsCore struct {
+	istanbul.Core
+	mu  sync.Mutex
+	ch  chan<- *istanbul.ConsensusEvent
+	lag chan error
+}
+
+func (c *eventsCore) SubscribeConsensusEvents(ch chan<- *istanbul.ConsensusEvent) event.Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ch, c.lag = ch, make(chan error, 1)
+	lag := c.lag
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		select {
+		case err := <-lag:
+			return err
+		case <-quit:
+			return nil
+		}
+	})
+}
+
+// send delivers ev to the subscriber without blocking, and returns whether it was delivered
+func (c *eventsCore) send(ev *istanbul.ConsensusEvent) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ch == nil {
+		return false
+	}
+	select {
+	case c.ch <- ev:
+		return true
+	default:
+		c.ch = nil
+		c.lag <- errors.New("consensus events subscriber lagging behind")
+		return false
+	}
+}
+
+func TestConsensusEventsSubscription(t *testing.T) {
+	core := &eventsCore{}
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("istanbul", &API{backend: &Backend{core: core}}); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	received := make(chan *istanbul.ConsensusEvent, 10)
+	sub, err := client.Subscribe(context.Background(), "istanbul", received, "consensusEvents")
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	defer sub.Unsubscribe()
+
+	hash := common.HexToHash("0x1")
+	sent := []*istanbul.ConsensusEvent{
+		{Kind: istanbul.ConsensusEventState, Sequence: 1, State: "Preprepared"},
+		{Kind: istanbul.ConsensusEventRoundChange, Sequence: 1, Round: 1},
+		{Kind: istanbul.ConsensusEventAlert, Sequence: 1, Round: 1, Alert: &istanbul.Alert{Kind: istanbul.AlertEquivocation, Message: "conflicting messages"}},
+		{Kind: istanbul.ConsensusEventState, Sequence: 1, Round: 1, State: "Committed"},
+		{Kind: istanbul.ConsensusEventCommit, Sequence: 1, Round: 1, Hash: &hash},
+	}
+	for _, ev := range sent {
+		if !core.send(ev) {
+			t.Fatalf("event %+v not delivered", ev)
+		}
+	}
+	for i, want := range sent {
+		select {
+		case have := <-received:
+			if have.Kind != want.Kind || have.Sequence != want.Sequence || have.Round != want.Round || have.State != want.State {
+				t.Errorf("event %d mismatch: have %+v, want %+v", i, have, want)
+			}
+			if (have.Hash == nil) != (want.Hash == nil) || have.Hash != nil && *have.Hash != *want.Hash {
+				t.Errorf("event %d hash mismatch: have %v, want %v", i, have.Hash, want.Hash)
+			}
+			if (have.Alert == nil) != (want.Alert == nil) || have.Alert != nil && have.Alert.Kind != want.Alert.Kind {
+				t.Errorf("event %d alert mismatch: have %+v, want %+v", i, have.Alert, want.Alert)
+			}
+		case err := <-sub.Err():
+			t.Fatalf("subscription failed: %v", err)
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not pushed", i)
+		}
+	}
+
+	// connections without notifications, stopped core
+	api := &API{backend: &Backend{core: core}}
+	if _, err := api.ConsensusEvents(context.Background()); err != rpc.ErrNotificationsUnsupported {
+		t.Errorf("error mismatch: have %v, want %v", err, rpc.ErrNotificationsUnsupported)
+	}
+	api = &API{backend: &Backend{}}
+	if _, err := api.ConsensusEvents(context.Background()); err == nil {
+		t.Errorf("error mismatch: have nil, want error")
+	}
+}
+
+func TestImportConsensusState(t *testing.T) {
+	chain, engine := newBlockChain(1, big.NewInt(0))
+	defer engine.Stop()
+	api := &AdminAPI{backend: engine}
+
+	data, err := api.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	if err := api.ImportState(data); err != istanbul.ErrStartedEngine {
+		t.Errorf("error mismatch while started: have %v, want %v", err, istanbul.ErrStartedEngine)
+	}
+
+	// the state is checked and restored as the engine starts again
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("failed to stop engine: %v", err)
+	}
+	if err := api.ImportState(data); err != nil {
+		t.Fatalf("failed to import state: %v", err)
+	}
+	if err := engine.Start(chain, chain.CurrentBlock, rawdb.HasBadBlock); err != nil {
+		t.Fatalf("failed to start engine with the imported state: %v", err)
+	}
+	if engine.importedConsensusState != nil {
+		t.Errorf("imported state should be consumed by the start")
+	}
+
+	// an invalid state makes the start fail once, the next start resumes from the chain head
+	engine.Stop()
+	if err := api.ImportState([]byte{0x01}); err != nil {
+		t.Fatalf("failed to import state: %v", err)
+	}
+	if err := engine.Start(chain, chain.CurrentBlock, rawdb.HasBadBlock); err == nil {
+		t.Errorf("start with an invalid imported state should fail")
+	}
+	if err := engine.Start(chain, chain.CurrentBlock, rawdb.HasBadBlock); err != nil {
+		t.Errorf("failed to start engine: %v", err)
+	}
+}
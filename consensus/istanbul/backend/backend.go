@@ -18,6 +18,8 @@ package backend
 
 import (
 	"crypto/ecdsa"
+	"errors"
+	"fmt"
 	"math/big"
 	"sync"
 	"time"
@@ -28,16 +30,19 @@ import (
 	istanbulcommon "github.com/ethereum/go-ethereum/consensus/istanbul/common"
 	ibftcore "github.com/ethereum/go-ethereum/consensus/istanbul/ibft/core"
 	ibftengine "github.com/ethereum/go-ethereum/consensus/istanbul/ibft/engine"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/journal"
 	qbftcore "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/core"
 	qbftengine "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/engine"
 	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
 	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/wal"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	lru "github.com/hashicorp/golang-lru"
 )
 
@@ -46,6 +51,21 @@ const (
 	fetcherID = "istanbul"
 )
 
+// defaultBroadcastRetryBackoff is the delay before the first retry of a failed consensus message send,
+// doubled for each next retry, when BroadcastRetryBackoff is not set
+const defaultBroadcastRetryBackoff = 100 * time.Millisecond
+
+// maxBroadcastRetryBackoff caps the delay between two retries of a failed consensus message send, a message
+// delayed longer would be stale for the round it belongs to anyway
+const maxBroadcastRetryBackoff = 5 * time.Second
+
+var (
+	// broadcastRetryMeter counts the retries of consensus message sends to peers after a p2p failure
+	broadcastRetryMeter = metrics.NewRegisteredMeter("consensus/istanbul/backend/broadcast/retry", nil)
+	// broadcastFailureMeter counts the consensus message sends given up after their last retry
+	broadcastFailureMeter = metrics.NewRegisteredMeter("consensus/istanbul/backend/broadcast/failure", nil)
+)
+
 // New creates an Ethereum backend for Istanbul core engine.
 func New(config *istanbul.Config, privateKey *ecdsa.PrivateKey, db ethdb.Database) *Backend {
 	// Allocate the snapshot caches and create the engine
@@ -68,6 +88,30 @@ func New(config *istanbul.Config, privateKey *ecdsa.PrivateKey, db ethdb.Databas
 		knownMessages:    knownMessages,
 	}
 
+	if config.WAL == nil {
+		switch config.WALStore {
+		case istanbul.WALStoreDatabase:
+			config.WAL = wal.NewDatabaseWAL(db)
+		case istanbul.WALStoreMemory:
+			config.WAL = wal.NewMemoryWAL()
+		}
+	}
+	if config.Journal == nil && config.JournalFile != "" {
+		if j, err := journal.OpenFile(config.JournalFile); err != nil {
+			sb.logger.Error("BFT: failed to open the consensus event journal", "file", config.JournalFile, "err", err)
+		} else {
+			config.Journal = j
+		}
+	}
+
+	// a node which detected a safety violation stays halted once restarted
+	if violation, err := loadSafetyViolation(db); err != nil {
+		sb.logger.Error("BFT: failed to load the safety violation evidence", "err", err)
+	} else if violation != nil {
+		sb.logger.Error("BFT: CONSENSUS SAFETY VIOLATED before the restart, the consensus engine stays halted", "number", violation.Number)
+		sb.safetyViolation = violation
+	}
+
 	sb.qbftEngine = qbftengine.NewEngine(sb.config, sb.address, sb.Sign)
 	sb.ibftEngine = ibftengine.NewEngine(sb.config, sb.address, sb.Sign)
 
@@ -104,8 +148,26 @@ type Backend struct {
 	coreStarted       bool
 	coreMu            sync.RWMutex
 
+	// importedConsensusState is the consensus state imported while the engine was stopped, the QBFT core
+	// resumes from it once started
+	importedConsensusState []byte
+
+	// proposerHooks are called when the node becomes or stops being the proposer
+	proposerHooks   []ProposerHook
+	proposerHooksMu sync.RWMutex
+
+	// alertSinks deliver the alerts of the consensus core to the registered sinks
+	alertSinks   []*alertWorker
+	alertSinksMu sync.RWMutex
+
+	// safetyViolation is the evidence of conflicting finalized blocks, once detected the engine is halted
+	safetyViolation   *istanbul.SafetyViolation
+	safetyViolationMu sync.RWMutex
+
 	// Current list of candidates we are pushing
 	candidates map[common.Address]bool
+	// Reason codes of the removals we are pushing, recorded once the validator is removed
+	removalReasons map[common.Address]string
 	// Protects the signer fields
 	candidatesLock sync.RWMutex
 	// Snapshots for recent block to speed up reorgs
@@ -158,6 +220,7 @@ func (sb *Backend) Broadcast(valSet istanbul.ValidatorSet, code uint64, payload
 	msg := istanbul.MessageEvent{
 		Code:    code,
 		Payload: payload,
+		Local:   true,
 	}
 	go sb.istanbulEventMux.Post(msg)
 	return nil
@@ -192,20 +255,81 @@ func (sb *Backend) Gossip(valSet istanbul.ValidatorSet, code uint64, payload []b
 			m.Add(hash, true)
 			sb.recentMessages.Add(addr, m)
 
-			if sb.IsQBFTConsensus() {
-				var outboundCode uint64 = istanbulMsg
-				if _, ok := qbfttypes.MessageCodes()[code]; ok {
-					outboundCode = code
-				}
-				go p.SendQBFTConsensus(outboundCode, payload)
-			} else {
-				go p.SendConsensus(istanbulMsg, payload)
-			}
+			sb.sendConsensus(p, code, payload)
 		}
 	}
 	return nil
 }
 
+// Resend implements istanbul.Backend.Resend
+func (sb *Backend) Resend(targets []common.Address, code uint64, payload []byte) error {
+	peers := make(map[common.Address]bool)
+	for _, addr := range targets {
+		if addr != sb.Address() {
+			peers[addr] = true
+		}
+	}
+	if sb.broadcaster != nil && len(peers) > 0 {
+		ps := sb.broadcaster.FindPeers(peers)
+		for _, p := range ps {
+			sb.sendConsensus(p, code, payload)
+		}
+	}
+	return nil
+}
+
+// sendConsensus sends a consensus message to a peer in the background, on the subprotocol of the
+// consensus in use
+func (sb *Backend) sendConsensus(p consensus.Peer, code uint64, payload []byte) {
+	send := func() error {
+		return p.SendConsensus(istanbulMsg, payload)
+	}
+	if sb.IsQBFTConsensus() {
+		var outboundCode uint64 = istanbulMsg
+		if _, ok := qbfttypes.MessageCodes()[code]; ok {
+			outboundCode = code
+		}
+		send = func() error {
+			return p.SendQBFTConsensus(outboundCode, payload)
+		}
+	}
+	go sb.sendWithRetries(send, code)
+}
+
+// sendWithRetries sends a consensus message, retrying on failure up to BroadcastRetries times with a
+// capped exponential backoff so that a transient p2p failure does not lose it
+func (sb *Backend) sendWithRetries(send func() error, code uint64) {
+	backoff := time.Duration(sb.config.BroadcastRetryBackoff) * time.Millisecond
+	if backoff == 0 {
+		backoff = defaultBroadcastRetryBackoff
+	}
+	for attempt := uint64(0); ; attempt++ {
+		err := send()
+		if err == nil {
+			return
+		}
+		if attempt >= sb.config.BroadcastRetries {
+			broadcastFailureMeter.Mark(1)
+			sb.logger.Debug("BFT: failed to send consensus message", "code", code, "attempts", attempt+1, "err", err)
+			return
+		}
+		broadcastRetryMeter.Mark(1)
+		time.Sleep(retryBackoff(backoff, attempt))
+	}
+}
+
+// retryBackoff returns the delay before retrying a send after the given failed attempt, the initial backoff
+// doubled for each previous attempt and capped at maxBroadcastRetryBackoff
+func retryBackoff(backoff time.Duration, attempt uint64) time.Duration {
+	for ; attempt > 0 && backoff < maxBroadcastRetryBackoff; attempt-- {
+		backoff <<= 1
+	}
+	if backoff > maxBroadcastRetryBackoff {
+		return maxBroadcastRetryBackoff
+	}
+	return backoff
+}
+
 // Commit implements istanbul.Backend.Commit
 func (sb *Backend) Commit(proposal istanbul.Proposal, seals [][]byte, round *big.Int) (err error) {
 	// Check if the proposal is a valid block
@@ -249,6 +373,36 @@ func (sb *Backend) Commit(proposal istanbul.Proposal, seals [][]byte, round *big
 	return nil
 }
 
+// RequestSync implements istanbul.Backend.RequestSync
+func (sb *Backend) RequestSync() {
+	if sb.broadcaster != nil {
+		sb.broadcaster.RequestSync()
+	}
+}
+
+// ProposerHook is called with the view where the node became, or stopped being, the proposer
+type ProposerHook func(isProposer bool, view istanbul.View)
+
+// OnProposerChange registers a hook called on each view where the proposer status of the node changes,
+// e.g. to warm caches before proposing. Hooks run on the consensus event loop and must not block.
+// Only the QBFT consensus core reports proposer changes.
+func (sb *Backend) OnProposerChange(hook ProposerHook) {
+	sb.proposerHooksMu.Lock()
+	defer sb.proposerHooksMu.Unlock()
+
+	sb.proposerHooks = append(sb.proposerHooks, hook)
+}
+
+// ProposerChanged implements istanbul.Backend.ProposerChanged
+func (sb *Backend) ProposerChanged(isProposer bool, view istanbul.View) {
+	sb.proposerHooksMu.RLock()
+	defer sb.proposerHooksMu.RUnlock()
+
+	for _, hook := range sb.proposerHooks {
+		hook(isProposer, view)
+	}
+}
+
 // EventMux implements istanbul.Backend.EventMux
 func (sb *Backend) EventMux() *event.TypeMux {
 	return sb.istanbulEventMux
@@ -385,6 +539,10 @@ func (sb *Backend) startIBFT() error {
 	sb.config.ProposerPolicy.Use(istanbul.ValidatorSortByString())
 	sb.qbftConsensusEnabled = false
 
+	if sb.importedConsensusState != nil {
+		sb.logger.Warn("BFT: imported consensus state is only restored by QBFT, dropping it")
+		sb.importedConsensusState = nil
+	}
 	sb.core = ibftcore.New(sb, sb.config)
 	if err := sb.core.Start(); err != nil {
 		sb.logger.Error("BFT: failed to activate IBFT", "err", err)
@@ -401,6 +559,14 @@ func (sb *Backend) startQBFT() error {
 	sb.qbftConsensusEnabled = true
 
 	sb.core = qbftcore.New(sb, sb.config)
+	if data := sb.importedConsensusState; data != nil {
+		// the state is dropped even if invalid, starting again resumes from the chain head
+		sb.importedConsensusState = nil
+		if err := sb.core.(consensusStateCore).ImportState(data); err != nil {
+			sb.logger.Error("BFT: failed to import the consensus state", "err", err)
+			return fmt.Errorf("invalid imported consensus state: %w", err)
+		}
+	}
 	if err := sb.core.Start(); err != nil {
 		sb.logger.Error("BFT: failed to activate QBFT", "err", err)
 		return err
@@ -426,6 +592,42 @@ func (sb *Backend) stop() error {
 	return nil
 }
 
+// consensusStateCore is implemented by the consensus cores able to export their consensus state and to
+// resume from one exported by another node
+type consensusStateCore interface {
+	ExportState() ([]byte, error)
+	ImportState(data []byte) error
+}
+
+// ExportConsensusState serializes the consensus state of the running core, to clone it into a hot-standby
+// validator with ImportConsensusState
+func (sb *Backend) ExportConsensusState() ([]byte, error) {
+	sb.coreMu.RLock()
+	defer sb.coreMu.RUnlock()
+	if !sb.coreStarted {
+		return nil, istanbul.ErrStoppedEngine
+	}
+	exporter, ok := sb.core.(consensusStateCore)
+	if !ok {
+		return nil, errors.New("consensus core does not export its consensus state")
+	}
+	return exporter.ExportState()
+}
+
+// ImportConsensusState sets the consensus state exported by another node which the QBFT core resumes from on
+// the next start of the engine, which must be stopped. The state is checked as the engine starts, which fails
+// if the state does not apply to the chain head.
+func (sb *Backend) ImportConsensusState(data []byte) error {
+	sb.coreMu.Lock()
+	defer sb.coreMu.Unlock()
+	if sb.coreStarted {
+		return istanbul.ErrStartedEngine
+	}
+	sb.importedConsensusState = common.CopyBytes(data)
+	sb.logger.Info("BFT: consensus state imported, restored on the next start", "size", len(data))
+	return nil
+}
+
 // StartQBFTConsensus stops existing legacy ibft consensus and starts the new qbft consensus
 func (sb *Backend) StartQBFTConsensus() error {
 	sb.logger.Info("BFT: switch from IBFT to QBFT")
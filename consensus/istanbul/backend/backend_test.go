@@ -19,18 +19,23 @@ package backend
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"errors"
 	"math/big"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
 	istanbulcommon "github.com/ethereum/go-ethereum/consensus/istanbul/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 )
 
 func TestSign(t *testing.T) {
@@ -188,6 +193,123 @@ func TestGetProposer(t *testing.T) {
 	}
 }
 
+func TestProposerChangeHooks(t *testing.T) {
+	sb := &Backend{}
+	var calls []bool
+	for i := 0; i < 2; i++ {
+		sb.OnProposerChange(func(isProposer bool, view istanbul.View) {
+			if view.Round.Uint64() != 3 {
+				t.Errorf("round mismatch: have %v, want 3", view.Round)
+			}
+			calls = append(calls, isProposer)
+		})
+	}
+
+	sb.ProposerChanged(true, istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(3)})
+	if len(calls) != 2 || !calls[0] || !calls[1] {
+		t.Errorf("hook calls mismatch: have %v, want [true true]", calls)
+	}
+}
+
+// testAlertSink forwards the alerts it receives, failing as asked
+type testAlertSink struct {
+	alerts chan *istanbul.Alert
+	err    error
+	panics bool
+}
+
+func (s *testAlertSink) Alert(alert *istanbul.Alert) error {
+	s.alerts <- alert
+	if s.panics {
+		panic("sink down")
+	}
+	return s.err
+}
+
+func TestAlertSinks(t *testing.T) {
+	sb := &Backend{logger: log.New()}
+	sinks := []*testAlertSink{
+		{alerts: make(chan *istanbul.Alert, 4), panics: true},
+		{alerts: make(chan *istanbul.Alert, 4), err: errors.New("pager unreachable")},
+		{alerts: make(chan *istanbul.Alert, 4)},
+	}
+	for _, sink := range sinks {
+		sb.AddAlertSink(sink)
+	}
+
+	kinds := []string{istanbul.AlertStall, istanbul.AlertNoFaultTolerance, istanbul.AlertMaxRound, istanbul.AlertEquivocation}
+	for _, kind := range kinds {
+		sb.Alert(&istanbul.Alert{Kind: kind})
+	}
+
+	// every sink receives every alert, whatever the others do
+	for i, sink := range sinks {
+		received := make(map[string]bool)
+		for range kinds {
+			select {
+			case alert := <-sink.alerts:
+				received[alert.Kind] = true
+			case <-time.After(time.Second):
+				t.Fatalf("sink %d: alerts missing, have %v", i, received)
+			}
+		}
+		for _, kind := range kinds {
+			if !received[kind] {
+				t.Errorf("sink %d: alert %q not received", i, kind)
+			}
+		}
+	}
+}
+
+// blockingAlertSink blocks on every alert until it is released
+type blockingAlertSink struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingAlertSink) Alert(alert *istanbul.Alert) error {
+	s.started <- struct{}{}
+	<-s.release
+	return nil
+}
+
+func TestAlertSinkQueueBounded(t *testing.T) {
+	defer func(meter metrics.Meter) { alertDropMeter = meter }(alertDropMeter)
+	alertDropMeter = metrics.NewMeterForced()
+
+	sb := &Backend{logger: log.New()}
+	slow := &blockingAlertSink{started: make(chan struct{}, alertQueueSize+2), release: make(chan struct{})}
+	defer close(slow.release)
+	sb.AddAlertSink(slow)
+
+	// the slow sink blocks on the first alert, its queue then fills up
+	sb.Alert(&istanbul.Alert{Kind: istanbul.AlertStall})
+	select {
+	case <-slow.started:
+	case <-time.After(time.Second):
+		t.Fatal("slow sink: first alert not received")
+	}
+	for i := 0; i < alertQueueSize+10; i++ {
+		sb.Alert(&istanbul.Alert{Kind: istanbul.AlertMaxRound})
+	}
+	if dropped := alertDropMeter.Count(); dropped != 10 {
+		t.Errorf("dropped alerts mismatch: have %d, want 10", dropped)
+	}
+
+	// another sink is not held back by the slow one
+	fast := &testAlertSink{alerts: make(chan *istanbul.Alert, 1)}
+	sb.AddAlertSink(fast)
+	sb.Alert(&istanbul.Alert{Kind: istanbul.AlertEquivocation})
+	select {
+	case alert := <-fast.alerts:
+		if alert.Kind != istanbul.AlertEquivocation {
+			t.Errorf("alert kind mismatch: have %q, want %q", alert.Kind, istanbul.AlertEquivocation)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fast sink: alert not received")
+	}
+}
+
 // TestQBFTTransitionDeadlock test whether a deadlock occurs when testQBFTBlock is set to 1
 // This was fixed as part of commit 2a8310663ecafc0233758ca7883676bf568e926e
 func TestQBFTTransitionDeadlock(t *testing.T) {
@@ -304,3 +426,92 @@ func newBackend() (b *Backend) {
 	b.privateKey = key
 	return
 }
+
+// flakyPeer is a peer failing the given number of consensus message sends before the link recovers
+type flakyPeer struct {
+	consensus.Peer
+	mu       sync.Mutex
+	failures int
+	attempts int
+	sent     chan []byte
+}
+
+func (p *flakyPeer) send(payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts++
+	if p.attempts <= p.failures {
+		return errors.New("transient p2p failure")
+	}
+	p.sent <- payload
+	return nil
+}
+
+func (p *flakyPeer) SendConsensus(msgcode uint64, data interface{}) error {
+	return p.send(data.([]byte))
+}
+
+func (p *flakyPeer) SendQBFTConsensus(msgcode uint64, payload []byte) error {
+	return p.send(payload)
+}
+
+func TestBroadcastRetries(t *testing.T) {
+	vset, _ := newTestValidatorSet(2)
+	b := newBackend()
+	b.config.BroadcastRetries = 3
+	b.config.BroadcastRetryBackoff = 1
+	peer := &flakyPeer{failures: 2, sent: make(chan []byte, 1)}
+	b.broadcaster = &testPeers{peers: map[common.Address]consensus.Peer{vset.GetByIndex(0).Address(): peer}}
+
+	// the message gets through once the link recovers
+	retries, failures := broadcastRetryMeter.Count(), broadcastFailureMeter.Count()
+	b.Gossip(vset, istanbulMsg, []byte{0x01})
+	select {
+	case payload := <-peer.sent:
+		if !bytes.Equal(payload, []byte{0x01}) {
+			t.Errorf("payload mismatch: have %x, want 01", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not sent after the link recovered")
+	}
+	if metrics.Enabled {
+		if have := broadcastRetryMeter.Count() - retries; have != 2 {
+			t.Errorf("retries mismatch: have %d, want 2", have)
+		}
+		if have := broadcastFailureMeter.Count() - failures; have != 0 {
+			t.Errorf("failures mismatch: have %d, want 0", have)
+		}
+	}
+
+	// a link failing beyond the retries loses the message
+	attempts := 0
+	b.sendWithRetries(func() error {
+		attempts++
+		return errors.New("transient p2p failure")
+	}, istanbulMsg)
+	if attempts != 4 {
+		t.Errorf("attempts mismatch: have %d, want 4", attempts)
+	}
+	if have := broadcastFailureMeter.Count() - failures; metrics.Enabled && have != 1 {
+		t.Errorf("failures mismatch: have %d, want 1", have)
+	}
+}
+
+func TestBroadcastRetryBackoff(t *testing.T) {
+	tests := []struct {
+		backoff time.Duration
+		attempt uint64
+		want    time.Duration
+	}{
+		{100 * time.Millisecond, 0, 100 * time.Millisecond},
+		{100 * time.Millisecond, 3, 800 * time.Millisecond},
+		{100 * time.Millisecond, 6, maxBroadcastRetryBackoff},   // doubled past the cap
+		{100 * time.Millisecond, 200, maxBroadcastRetryBackoff}, // shifted past the bit size
+		{time.Minute, 0, maxBroadcastRetryBackoff},              // configured above the cap
+	}
+	for i, tt := range tests {
+		if have := retryBackoff(tt.backoff, tt.attempt); have != tt.want {
+			t.Errorf("test %d: backoff mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
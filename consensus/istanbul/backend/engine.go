@@ -66,13 +66,30 @@ func (sb *Backend) VerifyHeader(chain consensus.ChainHeaderReader, header *types
 }
 
 func (sb *Backend) verifyHeader(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header) error {
-	// Assemble the voting snapshot
-	snap, err := sb.snapshot(chain, header.Number.Uint64()-1, header.ParentHash, parents)
+	if sb.SafetyViolation() != nil {
+		return istanbul.ErrSafetyViolation
+	}
+
+	// Committed seals are checked against the validators active at the header, not the current ones
+	validators, err := sb.validatorsAt(chain, header, parents)
 	if err != nil {
 		return err
 	}
 
-	return sb.EngineForBlockNumber(header.Number).VerifyHeader(chain, header, parents, snap.ValSet)
+	if err := sb.EngineForBlockNumber(header.Number).VerifyHeader(chain, header, parents, validators); err != nil {
+		return err
+	}
+	return sb.checkFinality(chain, header)
+}
+
+// validatorsAt returns the validator set which was in charge of sealing the given header, that is the
+// one of the voting snapshot at its parent
+func (sb *Backend) validatorsAt(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header) (istanbul.ValidatorSet, error) {
+	snap, err := sb.snapshot(chain, header.Number.Uint64()-1, header.ParentHash, parents)
+	if err != nil {
+		return nil, err
+	}
+	return snap.ValSet, nil
 }
 
 // VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers
@@ -121,13 +138,12 @@ func (sb *Backend) VerifySeal(chain consensus.ChainHeaderReader, header *types.H
 		return istanbulcommon.ErrUnknownBlock
 	}
 
-	// Assemble the voting snapshot
-	snap, err := sb.snapshot(chain, number-1, header.ParentHash, nil)
+	validators, err := sb.validatorsAt(chain, header, nil)
 	if err != nil {
 		return err
 	}
 
-	return sb.EngineForBlockNumber(header.Number).VerifySeal(chain, header, snap.ValSet)
+	return sb.EngineForBlockNumber(header.Number).VerifySeal(chain, header, validators)
 }
 
 // Prepare initializes the consensus fields of a block header according to the
@@ -250,6 +266,16 @@ func (sb *Backend) APIs(chain consensus.ChainHeaderReader) []rpc.API {
 		Version:   "1.0",
 		Service:   &API{chain: chain, backend: sb},
 		Public:    true,
+	}, {
+		Namespace: "qbftdebug",
+		Version:   "1.0",
+		Service:   &DebugAPI{backend: sb},
+		Public:    false,
+	}, {
+		Namespace: "istanbuladmin",
+		Version:   "1.0",
+		Service:   &AdminAPI{backend: sb},
+		Public:    false,
 	}}
 }
 
@@ -260,6 +286,9 @@ func (sb *Backend) Start(chain consensus.ChainHeaderReader, currentBlock func()
 	if sb.coreStarted {
 		return istanbul.ErrStartedEngine
 	}
+	if sb.SafetyViolation() != nil {
+		return istanbul.ErrSafetyViolation
+	}
 
 	// clear previous data
 	sb.proposedBlockHash = common.Hash{}
@@ -272,6 +301,10 @@ func (sb *Backend) Start(chain consensus.ChainHeaderReader, currentBlock func()
 	sb.currentBlock = currentBlock
 	sb.hasBadBlock = hasBadBlock
 
+	if err := sb.checkValidatorSetSize(chain); err != nil {
+		return err
+	}
+
 	// Check if qbft Consensus needs to be used after chain is set
 	var err error
 	if sb.IsQBFTConsensus() {
@@ -291,6 +324,25 @@ func (sb *Backend) Start(chain consensus.ChainHeaderReader, currentBlock func()
 	return nil
 }
 
+// checkValidatorSetSize fails if the validator set at the chain head is smaller than the configured minimum,
+// to catch misconfigured genesis early instead of running consensus with too few validators
+func (sb *Backend) checkValidatorSetSize(chain consensus.ChainHeaderReader) error {
+	header := chain.CurrentHeader()
+	snap, err := sb.snapshot(chain, header.Number.Uint64(), header.Hash(), nil)
+	if err != nil {
+		return err
+	}
+
+	min := sb.config.MinValidators
+	if min == 0 {
+		min = 1
+	}
+	if size := uint64(snap.ValSet.Size()); size < min {
+		return fmt.Errorf("%w: %d validator(s) at block %d, at least %d required (check the genesis extra data or the validator contract)", istanbul.ErrTooFewValidators, size, header.Number, min)
+	}
+	return nil
+}
+
 // Stop implements consensus.Istanbul.Stop
 func (sb *Backend) Stop() error {
 	sb.coreMu.Lock()
@@ -352,6 +404,12 @@ func (sb *Backend) snapshot(chain consensus.ChainHeaderReader, number uint64, ha
 		// If an on-disk checkpoint snapshot can be found, use that
 		if number%checkpointInterval == 0 {
 			if s, err := loadSnapshot(sb.config.GetConfig(new(big.Int).SetUint64(number)).Epoch, sb.db, hash); err == nil {
+				// custom proposer selectors are not persisted, restore the configured one
+				if policy := sb.config.ProposerPolicy; policy != nil && policy.Selector != nil {
+					s.ValSet = validator.NewSet(s.validators(), policy)
+				}
+				s.applyProposerGrace(sb.config.GetConfig(new(big.Int).SetUint64(number)).ProposerGracePeriod)
+				s.applyProposerSeed()
 				snap = s
 				sb.snapLogger(snap).Trace("BFT: loaded voting snapshot from database")
 				break
@@ -401,7 +459,12 @@ func (sb *Backend) snapshot(chain consensus.ChainHeaderReader, number uint64, ha
 				}
 			}
 
-			snap = newSnapshot(sb.config.GetConfig(new(big.Int).SetUint64(number)).Epoch, 0, genesis.Hash(), validator.NewSet(validators, sb.config.ProposerPolicy))
+			valSet, err := sb.newValidatorSet(validators)
+			if err != nil {
+				log.Error("BFT: invalid genesis validators", "err", err)
+				return nil, err
+			}
+			snap = newSnapshot(sb.config.GetConfig(new(big.Int).SetUint64(number)).Epoch, 0, genesis.Hash(), valSet)
 			if err := sb.storeSnap(snap); err != nil {
 				return nil, err
 			}
@@ -462,13 +525,21 @@ func (sb *Backend) snapshot(chain consensus.ChainHeaderReader, number uint64, ha
 			return nil, err
 		}
 		sb.logger.Trace("Fetched validators from smart contract", "validators", validators)
-		valSet := validator.NewSet(validators, sb.config.ProposerPolicy)
+		valSet, err := sb.newValidatorSet(validators)
+		if err != nil {
+			log.Error("BFT: invalid validator smart contract", "err", err)
+			return nil, err
+		}
 		snap.ValSet = valSet
 	} else if validatorsFromTransitions := sb.config.GetValidatorsAt(targetBlockHeight); len(validatorsFromTransitions) > 0 && sb.config.GetValidatorSelectionMode(targetBlockHeight) == params.BlockHeaderMode {
 		//Note! we only want to set this once at this block height. Subsequent blocks will be propagated with the same
 		// 		validator as they are copied into the block header on the next block. Then normal voting can take place
 		// 		again.
-		valSet := validator.NewSet(validatorsFromTransitions, sb.config.ProposerPolicy)
+		valSet, err := sb.newValidatorSet(validatorsFromTransitions)
+		if err != nil {
+			log.Error("BFT: invalid transition validators", "err", err)
+			return nil, err
+		}
 		snap.ValSet = valSet
 	}
 
@@ -482,6 +553,14 @@ func (sb *Backend) snapshot(chain consensus.ChainHeaderReader, number uint64, ha
 	return snap, err
 }
 
+// newValidatorSet creates the validator set of the given validators, rejecting duplicate addresses
+func (sb *Backend) newValidatorSet(validators []common.Address) (istanbul.ValidatorSet, error) {
+	if err := validator.CheckDuplicates(validators); err != nil {
+		return nil, err
+	}
+	return validator.NewSet(validators, sb.config.ProposerPolicy), nil
+}
+
 // SealHash returns the hash of a block prior to it being sealed.
 func (sb *Backend) SealHash(header *types.Header) common.Hash {
 	return sb.EngineForBlockNumber(header.Number).SealHash(header)
@@ -512,6 +591,8 @@ func (sb *Backend) snapApply(snap *Snapshot, headers []*types.Header) (*Snapshot
 	}
 	snapCpy.Number += uint64(len(headers))
 	snapCpy.Hash = headers[len(headers)-1].Hash()
+	snapCpy.applyProposerGrace(sb.config.GetConfig(new(big.Int).SetUint64(snapCpy.Number)).ProposerGracePeriod)
+	snapCpy.applyProposerSeed()
 
 	return snapCpy, nil
 }
@@ -578,9 +659,17 @@ func (sb *Backend) snapApplyHeader(snap *Snapshot, header *types.Header) error {
 		if tally.Authorize {
 			logger.Info("BFT: reached majority to add validator")
 			snap.ValSet.AddValidator(candidate)
+			if sb.config.GetConfig(header.Number).ProposerGracePeriod > 0 {
+				if snap.Joined == nil {
+					snap.Joined = make(map[common.Address]uint64)
+				}
+				snap.Joined[candidate] = number
+			}
 		} else {
 			logger.Info("BFT: reached majority to remove validator")
 			snap.ValSet.RemoveValidator(candidate)
+			delete(snap.Joined, candidate)
+			sb.recordValidatorRemoval(candidate, header)
 
 			// Discard any previous votes the deauthorized validator cast
 			for i := 0; i < len(snap.Votes); i++ {
@@ -19,8 +19,10 @@ package backend
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"errors"
 	"math/big"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -34,6 +36,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 func newBlockchainFromConfig(genesis *core.Genesis, nodeKeys []*ecdsa.PrivateKey, cfg *istanbul.Config) (*core.BlockChain, *Backend) {
@@ -440,3 +443,142 @@ OUT3:
 		}
 	}
 }
+
+func TestStartWithTooFewValidators(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	testCases := []struct {
+		name          string
+		validators    int
+		minValidators uint64
+		expectedErr   string
+	}{
+		{"empty validator set", 0, 0, "0 validator(s) at block 0, at least 1 required"},
+		{"below configured minimum", 2, 4, "2 validator(s) at block 0, at least 4 required"},
+		{"configured minimum", 4, 4, ""},
+		{"single validator dev network", 1, 0, ""},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			genesis, nodeKeys := testutils.GenesisAndKeys(test.validators, true)
+			if test.validators == 0 {
+				nodeKeys = []*ecdsa.PrivateKey{key}
+			}
+			config := copyConfig(istanbul.DefaultConfig)
+			config.TestQBFTBlock = big.NewInt(0)
+			config.MinValidators = test.minValidators
+
+			memDB := rawdb.NewMemoryDatabase()
+			backend := New(config, nodeKeys[0], memDB)
+			genesis.MustCommit(memDB)
+			blockchain, err := core.NewBlockChain(memDB, nil, genesis.Config, backend, vm.Config{}, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+
+			err = backend.Start(blockchain, blockchain.CurrentBlock, rawdb.HasBadBlock)
+			if test.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("error mismatch: have %v, want nil", err)
+				}
+				backend.Stop()
+				return
+			}
+			if !errors.Is(err, istanbul.ErrTooFewValidators) {
+				t.Fatalf("error mismatch: have %v, want %v", err, istanbul.ErrTooFewValidators)
+			}
+			if !strings.Contains(err.Error(), test.expectedErr) {
+				t.Errorf("error message mismatch: have %q, want it to contain %q", err.Error(), test.expectedErr)
+			}
+		})
+	}
+}
+
+func TestDuplicateValidators(t *testing.T) {
+	// at genesis load
+	genesis, nodeKeys := testutils.GenesisAndKeys(2, true)
+	config := copyConfig(istanbul.DefaultConfig)
+	config.TestQBFTBlock = big.NewInt(0)
+	first := crypto.PubkeyToAddress(nodeKeys[0].PublicKey)
+	second := crypto.PubkeyToAddress(nodeKeys[1].PublicKey)
+	config.Validators = []common.Address{first, second, first}
+
+	memDB := rawdb.NewMemoryDatabase()
+	backend := New(config, nodeKeys[0], memDB)
+	genesis.MustCommit(memDB)
+	blockchain, err := core.NewBlockChain(memDB, nil, genesis.Config, backend, vm.Config{}, nil, nil, nil)
+	if err == nil {
+		_, err = backend.snapshot(blockchain, 0, blockchain.Genesis().Hash(), nil)
+	}
+	if !errors.Is(err, istanbul.ErrDuplicateValidator) {
+		t.Errorf("genesis: error mismatch: have %v, want %v", err, istanbul.ErrDuplicateValidator)
+	}
+
+	// at a validator set transition
+	chain, engine := newBlockChain(1, big.NewInt(0))
+	defer engine.Stop()
+	block := makeBlock(chain, engine, chain.Genesis())
+	if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	engine.config.Transitions = []params.Transition{{
+		Block:      big.NewInt(1),
+		Validators: []common.Address{engine.Address(), engine.Address()},
+	}}
+	// the transition validators replace the ones of the snapshot of block 1 once it is cached, e.g. by the
+	// sealing of block 2, rather than while it is built from the genesis one
+	if _, err := engine.snapshot(chain, 1, block.Hash(), nil); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if _, ok := engine.recents.Get(block.Hash()); !ok {
+		t.Fatal("snapshot of block 1 not cached")
+	}
+	if _, err := engine.snapshot(chain, 1, block.Hash(), nil); !errors.Is(err, istanbul.ErrDuplicateValidator) {
+		t.Errorf("transition: error mismatch: have %v, want %v", err, istanbul.ErrDuplicateValidator)
+	}
+}
+
+func TestVerifyHeaderWithHistoricalValidators(t *testing.T) {
+	chain, engine := newBlockChain(1, big.NewInt(0))
+	defer engine.Stop()
+
+	// a block is sealed once its parent is inserted and the core moved to its sequence
+	parent := chain.Genesis()
+	var blocks []*types.Block
+	for i := 0; i < 3; i++ {
+		block := makeBlock(chain, engine, parent)
+		if i < 2 {
+			if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+				t.Fatalf("block %d: error mismatch: have %v, want nil", i+1, err)
+			}
+			if err := engine.NewChainHead(); err != nil {
+				t.Fatalf("block %d: error mismatch: have %v, want nil", i+1, err)
+			}
+		}
+		blocks = append(blocks, block)
+		parent = block
+	}
+	block2, block3 := blocks[1], blocks[2]
+
+	// the validator set grows from block 2, a single committed seal is not enough anymore
+	engine.config.Transitions = []params.Transition{{
+		Block: big.NewInt(2),
+		Validators: []common.Address{
+			engine.Address(),
+			common.StringToAddress("validator1"),
+			common.StringToAddress("validator2"),
+			common.StringToAddress("validator3"),
+		},
+	}}
+	if snap, err := engine.snapshot(chain, 2, block2.Hash(), nil); err != nil || snap.ValSet.Size() != 4 {
+		t.Fatalf("validator set not changed at block 2: %v", err)
+	}
+
+	// block 2 was sealed by the validators of the previous epoch
+	if err := engine.VerifyHeader(chain, block2.Header(), false); err != nil {
+		t.Errorf("block 2: error mismatch: have %v, want nil", err)
+	}
+	// block 3 must be sealed by the new validators
+	if err := engine.VerifyHeader(chain, block3.Header(), false); err != istanbulcommon.ErrInvalidCommittedSeals {
+		t.Errorf("block 3: error mismatch: have %v, want %v", err, istanbulcommon.ErrInvalidCommittedSeals)
+	}
+}
@@ -43,11 +43,19 @@ var (
 
 	// errPayloadReadFailed is returned when qbft message read fails
 	errPayloadReadFailed = errors.New("unable to read payload from message")
+
+	// errMessageTooLarge is returned when a consensus message exceeds the configured maximum size
+	errMessageTooLarge = errors.New("istanbul message too large")
 )
 
 // Protocol implements consensus.Engine.Protocol
 func (sb *Backend) Protocol() consensus.Protocol {
-	return consensus.IstanbulProtocol
+	protocol, err := consensus.NewIstanbulProtocol(sb.config.MinProtocolVersion, sb.config.ProtocolVersion, sb.config.ProtocolCodeBase)
+	if err != nil {
+		sb.logger.Error("BFT: invalid protocol version configuration, using default versions", "err", err)
+		return consensus.IstanbulProtocol
+	}
+	return protocol
 }
 
 func (sb *Backend) decode(msg p2p.Msg) ([]byte, common.Hash, error) {
@@ -73,6 +81,11 @@ func (sb *Backend) HandleMsg(addr common.Address, msg p2p.Msg) (bool, error) {
 		if !sb.coreStarted {
 			return true, istanbul.ErrStoppedEngine
 		}
+		// Reject oversized messages before their payload is read into memory
+		if max := sb.config.MaxMessageSize; max > 0 && uint64(msg.Size) > max {
+			sb.logger.Debug("BFT: rejecting oversized message", "code", msg.Code, "size", msg.Size, "max", max, "sender", addr)
+			return true, errMessageTooLarge
+		}
 
 		data, hash, err := sb.decode(msg)
 		if err != nil {
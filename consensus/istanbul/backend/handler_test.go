@@ -193,3 +193,35 @@ func buildArbitraryP2PNewBlockMessage(t *testing.T, invalidMsg bool) (*types.Blo
 	arbitraryP2PMessage := p2p.Msg{Code: 0x07, Size: uint32(size), Payload: bytes.NewReader(payload)}
 	return arbitraryBlock, arbitraryP2PMessage
 }
+
+// unreadPayload fails the test if the message payload gets read
+type unreadPayload struct {
+	t *testing.T
+}
+
+func (p unreadPayload) Read([]byte) (int, error) {
+	p.t.Fatalf("payload of an oversized message must not be read")
+	return 0, nil
+}
+
+func TestHandleOversizedMessage(t *testing.T) {
+	_, backend := newBlockChain(1, nil)
+	defer backend.Stop()
+	backend.config.MaxMessageSize = 1024
+
+	addr := common.StringToAddress("address")
+	msg := p2p.Msg{Code: istanbulMsg, Size: 1 << 30, Payload: unreadPayload{t}}
+	handled, err := backend.HandleMsg(addr, msg)
+	if !handled || err != errMessageTooLarge {
+		t.Fatalf("handled, error mismatch: have %v, %v, want true, %v", handled, err, errMessageTooLarge)
+	}
+	if _, ok := backend.recentMessages.Get(addr); ok {
+		t.Fatalf("oversized message should not be cached for the peer")
+	}
+
+	// messages within the limit are still handled, the payload is decoded by the IBFT core as in TestIstanbulMessage
+	msg = makeMsg(istanbulMsg, []byte("data1"))
+	if _, err := backend.HandleMsg(addr, msg); err != nil {
+		t.Fatalf("handle message failed: %v", err)
+	}
+}
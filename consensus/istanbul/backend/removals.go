@@ -0,0 +1,105 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Reason codes of the validator removals voted through governance
+const (
+	RemovalReasonOffline   = "offline"   // the validator is permanently down
+	RemovalReasonByzantine = "byzantine" // the validator misbehaves
+	RemovalReasonOther     = "other"     // any other reason, e.g. the operator left the network
+)
+
+// dbKeyRemovalPrefix prefixes the keys of the validator removals, followed by the big endian block number
+// and the address of the validator
+var dbKeyRemovalPrefix = []byte("istanbul-removal-")
+
+// ValidatorRemoval records a validator removed from the set by a governance vote
+type ValidatorRemoval struct {
+	Address common.Address `json:"address"`
+	Number  uint64         `json:"number"` // block at which the removal took effect
+	Hash    common.Hash    `json:"hash"`
+	Reason  string         `json:"reason,omitempty"` // reason code given when this node voted for the removal
+}
+
+func removalKey(number uint64, address common.Address) []byte {
+	key := make([]byte, len(dbKeyRemovalPrefix)+8+common.AddressLength)
+	copy(key, dbKeyRemovalPrefix)
+	binary.BigEndian.PutUint64(key[len(dbKeyRemovalPrefix):], number)
+	copy(key[len(dbKeyRemovalPrefix)+8:], address.Bytes())
+	return key
+}
+
+// checkRemovalReason ensures reason is one of the known reason codes
+func checkRemovalReason(reason string) error {
+	switch reason {
+	case RemovalReasonOffline, RemovalReasonByzantine, RemovalReasonOther:
+		return nil
+	}
+	return fmt.Errorf("unknown removal reason %q, want %s, %s or %s", reason, RemovalReasonOffline, RemovalReasonByzantine, RemovalReasonOther)
+}
+
+// recordValidatorRemoval stores the removal of address by the vote passed in header, with the reason given
+// when the node voted for it if any. Snapshots are applied again on restarts and reorgs, an already stored
+// reason is kept.
+func (sb *Backend) recordValidatorRemoval(address common.Address, header *types.Header) {
+	if sb.db == nil {
+		return
+	}
+	sb.candidatesLock.RLock()
+	reason := sb.removalReasons[address]
+	sb.candidatesLock.RUnlock()
+
+	key := removalKey(header.Number.Uint64(), address)
+	if reason == "" {
+		if stored, _ := sb.db.Has(key); stored {
+			return
+		}
+	}
+	blob, err := json.Marshal(&ValidatorRemoval{Address: address, Number: header.Number.Uint64(), Hash: header.Hash(), Reason: reason})
+	if err != nil {
+		sb.logger.Error("BFT: failed to encode validator removal", "err", err)
+		return
+	}
+	if err := sb.db.Put(key, blob); err != nil {
+		sb.logger.Error("BFT: failed to store validator removal", "address", address, "err", err)
+	}
+}
+
+// validatorRemovals returns the stored validator removals, ordered by block number
+func (sb *Backend) validatorRemovals() ([]*ValidatorRemoval, error) {
+	it := sb.db.NewIterator(dbKeyRemovalPrefix, nil)
+	defer it.Release()
+
+	var removals []*ValidatorRemoval
+	for it.Next() {
+		removal := new(ValidatorRemoval)
+		if err := json.Unmarshal(it.Value(), removal); err != nil {
+			return nil, err
+		}
+		removals = append(removals, removal)
+	}
+	return removals, it.Error()
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// dbKeySafetyViolation is the database key of the safety violation evidence, which keeps the node halted
+// across restarts
+const dbKeySafetyViolation = "istanbul-safety-violation"
+
+// errNoSafetyViolation is returned when clearing the safety violation evidence while none was recorded
+var errNoSafetyViolation = errors.New("no safety violation recorded")
+
+// checkFinality compares a header whose committed seals are valid with the block finalized at the same height
+// in the local chain. Two different blocks carrying valid committed seals of a quorum at the same height mean
+// consensus safety got violated, the engine then halts with the evidence.
+func (sb *Backend) checkFinality(chain consensus.ChainHeaderReader, header *types.Header) error {
+	number := header.Number.Uint64()
+	local := chain.GetHeaderByNumber(number)
+	if local == nil || local.Hash() == header.Hash() {
+		return nil
+	}
+	// chains allowing shallow reorgs may replace the blocks within the reorg depth
+	if head := chain.CurrentHeader(); head != nil && number+sb.config.ReorgDepth > head.Number.Uint64() {
+		return nil
+	}
+
+	violation := &istanbul.SafetyViolation{Number: number, Detected: time.Now()}
+	for _, h := range []*types.Header{local, header} {
+		committers, err := sb.Signers(h)
+		if err != nil {
+			sb.logger.Error("BFT: failed to recover the committers of a conflicting finalized block", "number", number, "hash", h.Hash(), "err", err)
+		}
+		violation.Blocks = append(violation.Blocks, &istanbul.FinalizedBlock{Header: types.CopyHeader(h), Committers: committers})
+	}
+	sb.haltOnSafetyViolation(violation)
+	return istanbul.ErrSafetyViolation
+}
+
+// haltOnSafetyViolation records the evidence of the first safety violation detected in the database, raises a
+// critical alert and stops the consensus engine. The engine can not be started again nor verify headers
+// afterwards, even once the node restarted.
+func (sb *Backend) haltOnSafetyViolation(violation *istanbul.SafetyViolation) {
+	sb.safetyViolationMu.Lock()
+	if sb.safetyViolation != nil {
+		sb.safetyViolationMu.Unlock()
+		return
+	}
+	sb.safetyViolation = violation
+	sb.safetyViolationMu.Unlock()
+	if err := storeSafetyViolation(sb.db, violation); err != nil {
+		sb.logger.Error("BFT: failed to save the safety violation evidence", "err", err)
+	}
+
+	local, conflicting := violation.Blocks[0], violation.Blocks[1]
+	sb.logger.Error("BFT: CONSENSUS SAFETY VIOLATED, conflicting finalized blocks, halting the consensus engine",
+		"number", violation.Number, "local.hash", local.Header.Hash(), "local.committers", local.Committers,
+		"conflicting.hash", conflicting.Header.Hash(), "conflicting.committers", conflicting.Committers)
+	sb.Alert(&istanbul.Alert{
+		Kind:      istanbul.AlertSafetyViolation,
+		Message:   "conflicting blocks finalized at the same height, consensus engine halted",
+		Context:   []interface{}{"number", violation.Number, "evidence", violation},
+		Timestamp: violation.Detected,
+	})
+
+	// headers may be verified on behalf of the consensus core, which must not wait for its own stop
+	go func() {
+		if err := sb.Stop(); err != nil && err != istanbul.ErrStoppedEngine {
+			sb.logger.Error("BFT: failed to halt the consensus engine", "err", err)
+		}
+	}()
+}
+
+// SafetyViolation returns the evidence of the conflicting finalized blocks detected, nil if none was
+func (sb *Backend) SafetyViolation() *istanbul.SafetyViolation {
+	sb.safetyViolationMu.RLock()
+	defer sb.safetyViolationMu.RUnlock()
+
+	return sb.safetyViolation
+}
+
+// ClearSafetyViolation deletes the safety violation evidence once the operators resolved the conflicting
+// finalized blocks, so that the engine can be started again. The engine must be stopped, and the height of
+// the recorded violation is required so that the evidence is not cleared without having been looked at.
+func (sb *Backend) ClearSafetyViolation(number uint64) error {
+	sb.coreMu.Lock()
+	defer sb.coreMu.Unlock()
+	if sb.coreStarted {
+		return istanbul.ErrStartedEngine
+	}
+
+	sb.safetyViolationMu.Lock()
+	defer sb.safetyViolationMu.Unlock()
+	violation := sb.safetyViolation
+	if violation == nil {
+		return errNoSafetyViolation
+	}
+	if violation.Number != number {
+		return fmt.Errorf("safety violation recorded at block %d, not %d", violation.Number, number)
+	}
+	if err := sb.db.Delete([]byte(dbKeySafetyViolation)); err != nil {
+		return err
+	}
+	sb.safetyViolation = nil
+	sb.logger.Warn("BFT: safety violation evidence cleared by the operator", "number", number)
+	return nil
+}
+
+// loadSafetyViolation loads the safety violation evidence from the database, nil if none was recorded
+func loadSafetyViolation(db ethdb.KeyValueReader) (*istanbul.SafetyViolation, error) {
+	if has, err := db.Has([]byte(dbKeySafetyViolation)); err != nil || !has {
+		return nil, err
+	}
+	blob, err := db.Get([]byte(dbKeySafetyViolation))
+	if err != nil {
+		return nil, err
+	}
+	violation := new(istanbul.SafetyViolation)
+	if err := json.Unmarshal(blob, violation); err != nil {
+		return nil, err
+	}
+	return violation, nil
+}
+
+// storeSafetyViolation inserts the safety violation evidence into the database
+func storeSafetyViolation(db ethdb.KeyValueWriter, violation *istanbul.SafetyViolation) error {
+	blob, err := json.Marshal(violation)
+	if err != nil {
+		return err
+	}
+	return db.Put([]byte(dbKeySafetyViolation), blob)
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbftengine "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/engine"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestConflictingFinalizedBlocksHalt(t *testing.T) {
+	chain, engine := newBlockChain(1, big.NewInt(0))
+	defer engine.Stop()
+	sink := &testAlertSink{alerts: make(chan *istanbul.Alert, 16)}
+	engine.AddAlertSink(sink)
+
+	block1 := makeBlock(chain, engine, chain.Genesis())
+	if _, err := chain.InsertChain(types.Blocks{block1}); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if err := engine.VerifyHeader(chain, block1.Header(), false); err != nil {
+		t.Fatalf("finalized block: error mismatch: have %v, want nil", err)
+	}
+
+	// a different block 1 carrying a valid committed seal of the validator
+	header := makeHeader(chain.Genesis(), engine.config)
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	header.GasLimit--
+	header.UncleHash, header.Coinbase = types.EmptyUncleHash, engine.Address()
+	seal, err := engine.SignWithoutHashing(qbftengine.PrepareCommittedSeal(header, 0, engine.config.CommitSealChainID(header.Number)))
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if err := engine.EngineForBlockNumber(header.Number).CommitHeader(header, [][]byte{seal}, common.Big0); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if header.Hash() == block1.Hash() {
+		t.Fatal("conflicting block should differ from the finalized one")
+	}
+
+	if err := engine.VerifyHeader(chain, header, false); err != istanbul.ErrSafetyViolation {
+		t.Fatalf("conflicting block: error mismatch: have %v, want %v", err, istanbul.ErrSafetyViolation)
+	}
+
+	// the evidence holds both blocks and their committers
+	violation := engine.SafetyViolation()
+	if violation == nil || violation.Number != 1 || len(violation.Blocks) != 2 {
+		t.Fatalf("evidence mismatch: have %+v", violation)
+	}
+	for i, want := range []common.Hash{block1.Hash(), header.Hash()} {
+		block := violation.Blocks[i]
+		if block.Header.Hash() != want {
+			t.Errorf("block %d hash mismatch: have %v, want %v", i, block.Header.Hash(), want)
+		}
+		if len(block.Committers) != 1 || block.Committers[0] != engine.Address() {
+			t.Errorf("block %d committers mismatch: have %v, want [%v]", i, block.Committers, engine.Address())
+		}
+	}
+	// a single validator tolerates no faulty validator, the core alerts about it too
+	timeout := time.After(time.Second)
+	for raised := false; !raised; {
+		select {
+		case alert := <-sink.alerts:
+			raised = alert.Kind == istanbul.AlertSafetyViolation
+		case <-timeout:
+			t.Fatal("safety violation alert not raised")
+		}
+	}
+
+	// the node halts: the engine stops, can not be restarted and verifies no more headers
+	deadline := time.Now().Add(time.Second)
+	for {
+		engine.coreMu.RLock()
+		started := engine.coreStarted
+		engine.coreMu.RUnlock()
+		if !started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("consensus engine not stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := engine.Start(chain, chain.CurrentBlock, nil); err != istanbul.ErrSafetyViolation {
+		t.Errorf("restart error mismatch: have %v, want %v", err, istanbul.ErrSafetyViolation)
+	}
+	if err := engine.VerifyHeader(chain, block1.Header(), false); err != istanbul.ErrSafetyViolation {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbul.ErrSafetyViolation)
+	}
+
+	// the evidence survives a restart of the node, which stays halted
+	restarted := New(engine.config, engine.privateKey, engine.db)
+	if violation := restarted.SafetyViolation(); violation == nil || violation.Number != 1 || len(violation.Blocks) != 2 {
+		t.Fatalf("evidence after restart mismatch: have %+v", violation)
+	}
+	if err := restarted.Start(chain, chain.CurrentBlock, nil); err != istanbul.ErrSafetyViolation {
+		t.Errorf("restart error mismatch: have %v, want %v", err, istanbul.ErrSafetyViolation)
+	}
+	if err := restarted.VerifyHeader(chain, block1.Header(), false); err != istanbul.ErrSafetyViolation {
+		t.Errorf("error mismatch after restart: have %v, want %v", err, istanbul.ErrSafetyViolation)
+	}
+
+	// the operators clear the evidence once resolved, with the engine stopped and the height of the violation
+	if err := restarted.ClearSafetyViolation(2); err == nil {
+		t.Errorf("evidence cleared with the wrong height")
+	}
+	if err := restarted.ClearSafetyViolation(1); err != nil {
+		t.Fatalf("failed to clear the evidence: %v", err)
+	}
+	if violation := restarted.SafetyViolation(); violation != nil {
+		t.Errorf("evidence not cleared: have %+v", violation)
+	}
+	if violation, err := loadSafetyViolation(restarted.db); err != nil || violation != nil {
+		t.Errorf("stored evidence not cleared: have %+v, err %v", violation, err)
+	}
+	if err := restarted.ClearSafetyViolation(1); err != errNoSafetyViolation {
+		t.Errorf("error mismatch: have %v, want %v", err, errNoSafetyViolation)
+	}
+	if err := restarted.VerifyHeader(chain, block1.Header(), false); err != nil {
+		t.Errorf("error mismatch after clear: have %v, want nil", err)
+	}
+}
@@ -55,6 +55,8 @@ type Snapshot struct {
 	Votes  []*Vote                  // List of votes cast in chronological order
 	Tally  map[common.Address]Tally // Current vote tally to avoid recalculating
 	ValSet istanbul.ValidatorSet    // Set of authorized validators at this moment
+
+	Joined map[common.Address]uint64 // Block at which the validators still in their proposer grace period were added
 }
 
 // newSnapshot create a new snapshot with the specified startup parameters. This
@@ -68,6 +70,7 @@ func newSnapshot(epoch uint64, number uint64, hash common.Hash, valSet istanbul.
 		ValSet: valSet,
 		Tally:  make(map[common.Address]Tally),
 	}
+	snap.applyProposerSeed()
 	return snap
 }
 
@@ -109,6 +112,12 @@ func (s *Snapshot) copy() *Snapshot {
 	for address, tally := range s.Tally {
 		cpy.Tally[address] = tally
 	}
+	if len(s.Joined) > 0 {
+		cpy.Joined = make(map[common.Address]uint64, len(s.Joined))
+		for address, number := range s.Joined {
+			cpy.Joined[address] = number
+		}
+	}
 	copy(cpy.Votes, s.Votes)
 
 	return cpy
@@ -157,6 +166,29 @@ func (s *Snapshot) uncast(address common.Address, authorize bool) bool {
 	return true
 }
 
+// applyProposerGrace holds the validators added less than period blocks before the snapshot back from the
+// proposer selection, forgetting the ones whose grace period is over
+func (s *Snapshot) applyProposerGrace(period uint64) {
+	eligible := make(map[common.Address]uint64)
+	for address, number := range s.Joined {
+		if from := number + period + 1; period > 0 && from > s.Number+1 {
+			eligible[address] = from
+		} else {
+			delete(s.Joined, address)
+		}
+	}
+	if grace, ok := s.ValSet.(istanbul.ProposerGrace); ok {
+		grace.SetProposerGrace(eligible)
+	}
+}
+
+// applyProposerSeed seeds the proposer selection of the HashSeeded policy with the hash of the snapshot block
+func (s *Snapshot) applyProposerSeed() {
+	if seed, ok := s.ValSet.(istanbul.ProposerSeed); ok {
+		seed.SetProposerSeed(s.Hash)
+	}
+}
+
 // validators retrieves the list of authorized validators in ascending order.
 func (s *Snapshot) validators() []common.Address {
 	validators := make([]common.Address, 0, s.ValSet.Size())
@@ -174,11 +206,12 @@ func (s *Snapshot) validators() []common.Address {
 }
 
 type snapshotJSON struct {
-	Epoch  uint64                   `json:"epoch"`
-	Number uint64                   `json:"number"`
-	Hash   common.Hash              `json:"hash"`
-	Votes  []*Vote                  `json:"votes"`
-	Tally  map[common.Address]Tally `json:"tally"`
+	Epoch  uint64                    `json:"epoch"`
+	Number uint64                    `json:"number"`
+	Hash   common.Hash               `json:"hash"`
+	Votes  []*Vote                   `json:"votes"`
+	Tally  map[common.Address]Tally  `json:"tally"`
+	Joined map[common.Address]uint64 `json:"joined,omitempty"`
 
 	// for validator set
 	Validators []common.Address          `json:"validators"`
@@ -192,6 +225,7 @@ func (s *Snapshot) toJSONStruct() *snapshotJSON {
 		Hash:       s.Hash,
 		Votes:      s.Votes,
 		Tally:      s.Tally,
+		Joined:     s.Joined,
 		Validators: s.validators(),
 		Policy:     s.ValSet.Policy().Id,
 	}
@@ -209,6 +243,7 @@ func (s *Snapshot) UnmarshalJSON(b []byte) error {
 	s.Hash = j.Hash
 	s.Votes = j.Votes
 	s.Tally = j.Tally
+	s.Joined = j.Joined
 
 	// Setting the By function to ValidatorSortByStringFunc should be fine, as the validator do not change only the order changes
 	pp := istanbul.NewProposerPolicyByIdAndSortFunc(j.Policy, istanbul.ValidatorSortByString())
@@ -407,6 +407,71 @@ func TestVoting(t *testing.T) {
 	}
 }
 
+func TestValidatorRemovalReason(t *testing.T) {
+	accounts := newTesterAccountPool()
+	validators := []common.Address{accounts.address("A"), accounts.address("B"), accounts.address("C")}
+	genesis := testutils.Genesis(validators, true)
+	config := new(istanbul.Config)
+	*config = *istanbul.DefaultConfig
+	config.TestQBFTBlock = big.NewInt(0)
+
+	chain, backend := newBlockchainFromConfig(genesis, []*ecdsa.PrivateKey{accounts.accounts["A"]}, config)
+	defer backend.Stop()
+	api := &API{chain: chain, backend: backend}
+
+	if err := api.ProposeRemoval(accounts.address("C"), "bored"); err == nil {
+		t.Errorf("error mismatch: have nil, want unknown reason error")
+	}
+	if err := api.ProposeRemoval(accounts.address("C"), RemovalReasonByzantine); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if auth, ok := api.Candidates()[accounts.address("C")]; !ok || auth {
+		t.Errorf("candidate mismatch: have %v %v, want false true", auth, ok)
+	}
+
+	// A and B vote C out
+	var headers []*types.Header
+	for i, voter := range []string{"A", "B"} {
+		header := &types.Header{
+			Number:     big.NewInt(int64(i) + 1),
+			Coinbase:   accounts.address(voter),
+			Difficulty: istanbulcommon.DefaultDifficulty,
+			MixDigest:  types.IstanbulDigest,
+		}
+		if i > 0 {
+			header.ParentHash = headers[i-1].Hash()
+		}
+		header.Extra = append([]byte{}, genesis.ExtraData...)
+		if err := accounts.writeValidatorVote(header, voter, "C", false); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		headers = append(headers, header)
+	}
+	head := headers[len(headers)-1]
+	if _, err := backend.snapshot(chain, head.Number.Uint64(), head.Hash(), headers); err != nil {
+		t.Fatalf("failed to create voting snapshot: %v", err)
+	}
+
+	removals, err := api.ValidatorRemovals()
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	want := &ValidatorRemoval{Address: accounts.address("C"), Number: 2, Hash: head.Hash(), Reason: RemovalReasonByzantine}
+	if len(removals) != 1 || !reflect.DeepEqual(removals[0], want) {
+		t.Fatalf("removals mismatch: have %v, want [%v]", removals, want)
+	}
+
+	// the reason is kept when the headers are applied again, once this node forgot it
+	api.Discard(accounts.address("C"))
+	backend.recents.Purge()
+	if _, err := backend.snapshot(chain, head.Number.Uint64(), head.Hash(), headers); err != nil {
+		t.Fatalf("failed to create voting snapshot: %v", err)
+	}
+	if removals, _ = api.ValidatorRemovals(); len(removals) != 1 || !reflect.DeepEqual(removals[0], want) {
+		t.Errorf("removals mismatch after re-applying: have %v, want [%v]", removals, want)
+	}
+}
+
 func TestSaveAndLoad(t *testing.T) {
 	snap := &Snapshot{
 		Epoch:  5,
@@ -457,3 +522,61 @@ func TestSaveAndLoad(t *testing.T) {
 		t.Errorf("validator set mismatch: have %v, want %v", snap1.ValSet, snap.ValSet)
 	}
 }
+
+func TestProposerGracePeriod(t *testing.T) {
+	accounts := newTesterAccountPool()
+	validators := []common.Address{accounts.address("A"), accounts.address("B"), accounts.address("C")}
+	genesis := testutils.Genesis(validators, true)
+	config := new(istanbul.Config)
+	*config = *istanbul.DefaultConfig
+	config.TestQBFTBlock = big.NewInt(0)
+	config.ProposerGracePeriod = 2
+
+	chain, backend := newBlockchainFromConfig(genesis, []*ecdsa.PrivateKey{accounts.accounts["A"]}, config)
+	defer backend.Stop()
+
+	// A and B vote D in at block 2, A and C then seal blocks 3 and 4 voting for nothing new
+	var headers []*types.Header
+	for i, vote := range [][]string{{"A", "D"}, {"B", "D"}, {"A", "B"}, {"C", "B"}} {
+		header := &types.Header{
+			Number:     big.NewInt(int64(i) + 1),
+			Coinbase:   accounts.address(vote[0]),
+			Difficulty: istanbulcommon.DefaultDifficulty,
+			MixDigest:  types.IstanbulDigest,
+		}
+		if i > 0 {
+			header.ParentHash = headers[i-1].Hash()
+		}
+		header.Extra = append([]byte{}, genesis.ExtraData...)
+		if err := accounts.writeValidatorVote(header, vote[0], vote[1], true); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		headers = append(headers, header)
+	}
+
+	newcomer := accounts.address("D")
+	proposer := func(snap *Snapshot) common.Address {
+		// the round of the view the newcomer is picked by the round robin
+		idx, _ := snap.ValSet.GetByAddress(newcomer)
+		snap.ValSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: new(big.Int).SetUint64(snap.Number + 1), Round: big.NewInt(int64(idx))})
+		return snap.ValSet.GetProposer().Address()
+	}
+
+	// D is a validator from block 3 but is not selected as proposer before block 5
+	for number := uint64(2); number <= 4; number++ {
+		snap, err := backend.snapshot(chain, number, headers[number-1].Hash(), headers[:number])
+		if err != nil {
+			t.Fatalf("failed to create voting snapshot: %v", err)
+		}
+		if _, v := snap.ValSet.GetByAddress(newcomer); v == nil {
+			t.Fatalf("newcomer not in the validator set at block %d", number)
+		}
+		if selected := proposer(snap) == newcomer; selected != (number == 4) {
+			t.Errorf("newcomer selection mismatch for block %d: have %v, want %v", number+1, selected, number == 4)
+		}
+		if _, joined := snap.Joined[newcomer]; joined == (number == 4) {
+			t.Errorf("joined mismatch at block %d: have %v, want %v", number, joined, number != 4)
+		}
+		backend.recents.Purge()
+	}
+}
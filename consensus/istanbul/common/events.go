@@ -0,0 +1,82 @@
+package istanbulcommon
+
+import "sync"
+
+// DefaultEventQueueLimit is the number of queued events past which TryPost refuses events
+const DefaultEventQueueLimit = 1024
+
+// EventQueue hands events over to a post function one at a time, in the order
+// they were enqueued, from a single goroutine. It replaces spawning a goroutine
+// per event, which gives no ordering guarantee between events and piles up
+// goroutines when the consumer is slow.
+//
+// Events are mostly enqueued from the consumer loop itself, so enqueuing never
+// blocks: blocking until the consumer catches up would deadlock. Instead events
+// the caller can hold on to are posted with TryPost, which refuses them once
+// Limit events are queued, bounding the queue under a flood of such events.
+// The caller keeps a refused event and posts it again later.
+//
+// The zero value is ready to use.
+type EventQueue struct {
+	// Limit is the number of queued events past which TryPost refuses events,
+	// DefaultEventQueueLimit if zero
+	Limit int
+
+	mu       sync.Mutex
+	queue    []interface{}
+	draining bool
+}
+
+// Post enqueues ev to be delivered through post once every previously enqueued
+// event has been delivered. The event is never dropped.
+func (q *EventQueue) Post(ev interface{}, post func(interface{})) {
+	q.mu.Lock()
+	q.enqueue(ev, post)
+}
+
+// TryPost is like Post but does not enqueue ev and returns false if the queue is full
+func (q *EventQueue) TryPost(ev interface{}, post func(interface{})) bool {
+	q.mu.Lock()
+	limit := q.Limit
+	if limit == 0 {
+		limit = DefaultEventQueueLimit
+	}
+	if len(q.queue) >= limit {
+		q.mu.Unlock()
+		return false
+	}
+	q.enqueue(ev, post)
+	return true
+}
+
+// enqueue appends ev to the queue and starts draining it if needed, it must be
+// called with the lock held and releases it
+func (q *EventQueue) enqueue(ev interface{}, post func(interface{})) {
+	q.queue = append(q.queue, ev)
+	if q.draining {
+		q.mu.Unlock()
+		return
+	}
+	q.draining = true
+	q.mu.Unlock()
+
+	go q.drain(post)
+}
+
+// drain delivers queued events until the queue is empty
+func (q *EventQueue) drain(post func(interface{})) {
+	for {
+		q.mu.Lock()
+		if len(q.queue) == 0 {
+			q.draining = false
+			q.mu.Unlock()
+			return
+		}
+		ev := q.queue[0]
+		q.queue[0] = nil
+		q.queue = q.queue[1:]
+		q.mu.Unlock()
+
+		post(ev)
+	}
+}
@@ -0,0 +1,24 @@
+package istanbulcommon
+
+import "sync/atomic"
+
+// LogSampler decides whether a hot-path log line should be emitted, letting
+// through one call out of every rate. A nil sampler or a rate lower than 2
+// lets every call through.
+type LogSampler struct {
+	rate    uint64
+	counter uint64
+}
+
+// NewLogSampler returns a sampler emitting 1 in rate log lines
+func NewLogSampler(rate uint64) *LogSampler {
+	return &LogSampler{rate: rate}
+}
+
+// Sample reports whether the current log line should be emitted
+func (s *LogSampler) Sample() bool {
+	if s == nil || s.rate < 2 {
+		return true
+	}
+	return (atomic.AddUint64(&s.counter, 1)-1)%s.rate == 0
+}
@@ -33,12 +33,16 @@ type ProposerPolicyId uint64
 const (
 	RoundRobin ProposerPolicyId = iota
 	Sticky
+	// HashSeeded selects the proposers in round robin from a validator drawn with the hash of the previous
+	// block, so that they are only known once that block is
+	HashSeeded
 )
 
 // ProposerPolicy represents the Validator Proposer Policy
 type ProposerPolicy struct {
-	Id         ProposerPolicyId    // Could be RoundRobin or Sticky
+	Id         ProposerPolicyId    // Could be RoundRobin, Sticky or HashSeeded
 	By         ValidatorSortByFunc // func that defines how the ValidatorSet should be sorted
+	Selector   ProposerSelector    // Custom proposer selection, overrides the selection of Id when set
 	registry   []ValidatorSet      // Holds the ValidatorSet for a given block height
 	registryMU *sync.Mutex         // Mutex to lock access to changes to Registry
 }
@@ -61,6 +65,14 @@ func NewProposerPolicyByIdAndSortFunc(id ProposerPolicyId, by ValidatorSortByFun
 	return &ProposerPolicy{Id: id, By: by, registryMU: new(sync.Mutex)}
 }
 
+// NewCustomProposerPolicy returns a ProposerPolicy selecting the proposers with the given selector,
+// id is only used to identify the policy, e.g. in snapshots
+func NewCustomProposerPolicy(id ProposerPolicyId, selector ProposerSelector) *ProposerPolicy {
+	policy := NewProposerPolicy(id)
+	policy.Selector = selector
+	return policy
+}
+
 type proposerPolicyToml struct {
 	Id ProposerPolicyId
 }
@@ -139,9 +151,94 @@ type Config struct {
 	ValidatorSelectionMode   *string               `toml:",omitempty"`
 	Client                   bind.ContractCaller   `toml:",omitempty"`
 	MaxRequestTimeoutSeconds uint64                `toml:",omitempty"`
+	ChainID                  *big.Int              `toml:",omitempty"` // Chain ID the committed seals are bound to from CommitSealChainIDBlock
+	CommitSealChainIDBlock   *big.Int              `toml:",omitempty"` // Fork block from which committed seals are bound to the chain ID, so they cannot be replayed on another chain
+	ProposerGracePeriod      uint64                `toml:"-"`          // Number of blocks a validator added by vote is not selected as proposer, its votes still count, set from the QBFT chain config (0 = disabled)
+	SignedCertificatesBlock  *big.Int              `toml:",omitempty"` // Fork block from which proposers sign the justification of their PRE-PREPARE messages and justifications without a valid signature are rejected
 	Transitions              []params.Transition
+
+	// Node local consensus core tuning
+	TraceLogSampleRate         uint64 `toml:",omitempty"` // Only log 1 in N hot-path backlog trace lines, 0 or 1 logs all of them
+	FirstTimeoutGracePeriod    uint64 `toml:",omitempty"` // Extra time (in milliseconds) added once to the first round change timeout after boot or resync
+	FutureMessageSyncThreshold uint64 `toml:",omitempty"` // Number of messages for sequences ahead of the current one, from F+1 validators, after which a chain sync is requested (0 = disabled)
+	MaxRound                   uint64 `toml:",omitempty"` // Highest round of a sequence, consensus halts for the height once it is exceeded (0 = unlimited)
+	WatchdogTimeout            uint64 `toml:",omitempty"` // Time (in milliseconds) after which an unresponsive consensus event loop is reported (0 = disabled)
+	PreprepareRebroadcasts     uint64 `toml:",omitempty"` // Number of times the proposer re-sends its PRE-PREPARE to validators it did not receive PREPARE from (0 = disabled)
+	PreprepareRebroadcastDelay uint64 `toml:",omitempty"` // Time (in milliseconds) between PRE-PREPARE re-broadcasts, defaults to spreading them over the request timeout
+	MinValidators              uint64 `toml:",omitempty"` // Minimum size of the validator set for the engine to start, defaults to 1 so that single node dev networks can run
+	BacklogDrainBudget         uint64 `toml:",omitempty"` // Time (in milliseconds) the backlog drain may run before yielding to the event loop (0 = unbounded)
+	BacklogViewIndex           bool   `toml:",omitempty"` // Index the backlog messages of all the sources by view, so that pruning old sequences only visits the messages involved
+	CommittedMessagePolicy     string `toml:",omitempty"` // Handling of PREPARE and COMMIT messages received for an already committed view, "reject" (default) or "account"
+	ConsensusStatsWindow       uint64 `toml:",omitempty"` // Number of recently committed blocks whose consensus statistics are kept in memory for the RPC API (0 = disabled)
+	UptimeWindow               uint64 `toml:",omitempty"` // Number of recently committed blocks over which the participation of each validator is tracked for the RPC API (0 = disabled)
+	StrictPreparedCertificates bool   `toml:",omitempty"` // Reject ROUND-CHANGE messages claiming a prepared block without a valid certificate of PREPARE messages, instead of ignoring the claimed block
+	LogEscalationRound         uint64 `toml:",omitempty"` // Round above which the consensus core logs its debug and trace messages at info level, until the block is committed (0 = disabled)
+	PreprepareMaxFutureDrift   uint64 `toml:",omitempty"` // Seconds a proposed block timestamp may be ahead of the local clock, PRE-PREPARE messages beyond cause a round change (0 = unbounded)
+	PreprepareMaxAge           uint64 `toml:",omitempty"` // Seconds a proposed block timestamp may be behind the local clock, except for blocks proposed again after being prepared (0 = unbounded)
+	PreprepareParentCheck      bool   `toml:",omitempty"` // Reject PRE-PREPARE messages whose block does not extend the local chain head before verifying it, unless its parent is already known locally, changing round at once
+	FutureRoundWindow          uint64 `toml:",omitempty"` // Number of rounds ahead of the current one accepted for messages of the current sequence, messages beyond are invalid instead of backlogged, except ROUND-CHANGE messages (0 = unbounded)
+	CommitSealTarget           uint64 `toml:",omitempty"` // Number of COMMIT messages the proposer waits for before committing its block, clamped between the quorum and the validator set size (0 = quorum)
+	CommitSealTimeout          uint64 `toml:",omitempty"` // Time (in milliseconds) the proposer waits for the commit seal target once it has a quorum of COMMIT messages, defaults to a quarter of the request timeout
+	RejectedProposalPolicy     string `toml:",omitempty"` // Handling of COMMIT messages for a block this node rejected by local policy, "import" (default, once a quorum committed it, recording the dissent) or "ignore"
+	SkipEmptyBlocks            bool   `toml:",omitempty"` // Proposer does not propose blocks without transactions at round 0, consensus idles until transactions arrive, later rounds still propose them
+	InvalidProposalThreshold   uint64 `toml:",omitempty"` // Number of invalid blocks in a row after which a validator is reported as a faulty proposer, by a metric, an alert and the RPC API, it still proposes (0 = disabled)
+	ReorgDepth                 uint64 `toml:",omitempty"` // Number of finalized sequences whose messages are retained for chains allowing shallow reorgs, consensus resumes from a chain reorganised within it (0 = instant finality)
+	RoundChangeAnomalyWindow   uint64 `toml:",omitempty"` // Number of latest messages of each validator inspected to flag the ones sending mostly ROUND-CHANGE messages (0 = disabled)
+	RoundChangeAnomalyRatio    uint64 `toml:",omitempty"` // Share (in percent) of ROUND-CHANGE messages among the inspected ones from which a validator is flagged, defaults to 90
+	PrepareQuorum              uint64 `toml:",omitempty"` // Number of PREPARE messages needed to move to the prepared state, clamped between the quorum and the validator set size (0 = quorum)
+	CommitQuorum               uint64 `toml:",omitempty"` // Number of COMMIT messages needed to commit a block, clamped between the quorum and the validator set size (0 = quorum)
+	RoundChangeTieBreakRound   uint64 `toml:",omitempty"` // Round from which validators split between rounds converge: on timeout, validators joined at their round by others wait once more for the ones behind (0 = disabled)
+	DuplicateCommitPolicy      string `toml:",omitempty"` // Handling of a COMMIT message from a validator which already sent one with another digest for the view, "log" (default), "ignore" or "equivocation" (raise an equivocation alert)
+	ReentryRebroadcast         bool   `toml:",omitempty"` // Re-send the PREPARE and COMMIT messages the node sent for a view when a round change lands back on it, so that peers which missed them catch up
+	StepDebugging              bool   `toml:",omitempty"` // Let the qbftdebug RPC API, not exposed unless enabled explicitly, pause the consensus event loop and step through its events one at a time, for development networks only: it is ignored unless the node is built with the qbftdebug tag
+
+	// Consensus subprotocol
+	ProtocolVersion       uint   `toml:",omitempty"` // Highest istanbul subprotocol version advertised to peers, defaults to istanbul/100
+	MinProtocolVersion    uint   `toml:",omitempty"` // Lowest istanbul subprotocol version advertised to peers, keeps older nodes connected during upgrades
+	ProtocolCodeBase      uint64 `toml:",omitempty"` // First message code of the consensus messages on the dedicated istanbul/101 subprotocol, from 0x11, peers using another one are disconnected
+	MaxMessageSize        uint64 `toml:",omitempty"` // Largest consensus message (in bytes) accepted from peers, bigger ones are rejected before being read (0 = only the p2p limit applies)
+	BroadcastRetries      uint64 `toml:",omitempty"` // Number of times a consensus message failing to be sent to a peer is sent again, with an exponential backoff (0 = disabled)
+	BroadcastRetryBackoff uint64 `toml:",omitempty"` // Time (in milliseconds) before the first retry of a failed consensus message send, doubled for each next retry up to 5s, defaults to 100
+
+	// Header verification
+	CommittedSealWorkers int `toml:",omitempty"` // Number of workers recovering the signers of the committed seals of a QBFT header, for large validator sets (0 or 1 = serial)
+
+	// Consensus write-ahead log
+	WALStore    string `toml:",omitempty"` // Store of the write-ahead log of the consensus messages, "database" or "memory" (empty = disabled)
+	WAL         WAL    `toml:"-"`          // Write-ahead log used by the consensus core, set from WALStore unless provided
+	WALRequests bool   `toml:",omitempty"` // Record the block proposal requests in the write-ahead log too, a proposer restarted before proposing resumes with the same block
+
+	// Consensus event journal
+	JournalFile string  `toml:",omitempty"` // File the input events of the consensus core are appended to, to replay them when reproducing a bug (empty = disabled)
+	Journal     Journal `toml:"-"`          // Journal of the input events of the consensus core, opened from JournalFile unless provided
 }
 
+// Stores of the consensus write-ahead log
+const (
+	WALStoreDatabase = "database" // node database, the log survives restarts of the node
+	WALStoreMemory   = "memory"   // memory, the log only survives restarts of the consensus engine
+)
+
+// Policies for the PREPARE and COMMIT messages received for an already committed view
+const (
+	CommittedMessageReject  = "reject"  // reject them as invalid messages
+	CommittedMessageAccount = "account" // hand them to the accountability sink of the consensus core
+)
+
+// Policies for the COMMIT messages conflicting with the one a validator already sent for the view, resent
+// COMMIT messages are counted once whatever the policy
+const (
+	DuplicateCommitLog          = "log"          // reject them, logging an error
+	DuplicateCommitIgnore       = "ignore"       // reject them silently
+	DuplicateCommitEquivocation = "equivocation" // reject them, raising an equivocation alert with both digests
+)
+
+// Policies for the COMMIT messages received for a block rejected by local policy, e.g. its timestamp
+const (
+	RejectedProposalImport = "import" // import the block once a quorum of validators committed it, recording the dissent
+	RejectedProposalIgnore = "ignore" // ignore them, the block is imported by the chain synchronisation
+)
+
 var DefaultConfig = &Config{
 	RequestTimeout:         10000,
 	BlockPeriod:            5,
@@ -223,11 +320,29 @@ func (c Config) GetConfig(blockNumber *big.Int) Config {
 		if transition.MaxRequestTimeoutSeconds != nil {
 			newConfig.MaxRequestTimeoutSeconds = *transition.MaxRequestTimeoutSeconds
 		}
+		if transition.ProposerGracePeriod != nil {
+			newConfig.ProposerGracePeriod = *transition.ProposerGracePeriod
+		}
 	})
 
 	return newConfig
 }
 
+// IsSignedCertificates returns whether the justification of the PRE-PREPARE messages for the given block
+// is signed by their proposer, from the SignedCertificatesBlock fork
+func (c Config) IsSignedCertificates(blockNumber *big.Int) bool {
+	return c.SignedCertificatesBlock != nil && blockNumber != nil && blockNumber.Cmp(c.SignedCertificatesBlock) >= 0
+}
+
+// CommitSealChainID returns the chain ID committed seals are bound to at the given block,
+// or nil before the CommitSealChainIDBlock fork
+func (c Config) CommitSealChainID(blockNumber *big.Int) *big.Int {
+	if c.ChainID == nil || c.CommitSealChainIDBlock == nil || blockNumber == nil || blockNumber.Cmp(c.CommitSealChainIDBlock) < 0 {
+		return nil
+	}
+	return c.ChainID
+}
+
 func (c Config) GetValidatorContractAddress(blockNumber *big.Int) common.Address {
 	validatorContractAddress := c.ValidatorContract
 	c.getTransitionValue(blockNumber, func(transition params.Transition) {
@@ -276,6 +391,11 @@ func (c Config) Get2FPlus1Enabled(blockNumber *big.Int) bool {
 	return twoFPlusOneEnabled
 }
 
+// Use2FPlus1Quorum reports whether the quorum at the given block is computed as 2F+1 rather than ceil(2N/3)
+func (c Config) Use2FPlus1Quorum(blockNumber *big.Int) bool {
+	return c.Get2FPlus1Enabled(blockNumber) || c.Ceil2Nby3Block == nil || (blockNumber != nil && blockNumber.Cmp(c.Ceil2Nby3Block) < 0)
+}
+
 func (c *Config) getTransitionValue(num *big.Int, callback func(transition params.Transition)) {
 	if c != nil && num != nil && c.Transitions != nil {
 		for i := 0; i < len(c.Transitions) && c.Transitions[i].Block.Cmp(num) <= 0; i++ {
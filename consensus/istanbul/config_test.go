@@ -108,6 +108,38 @@ func TestGetConfig(t *testing.T) {
 	}
 }
 
+func TestGetConfigProposerGracePeriod(t *testing.T) {
+	disabled, period := uint64(0), uint64(4)
+	config := *DefaultConfig
+	config.ProposerGracePeriod = 2
+	config.Transitions = []params.Transition{{
+		Block:               big.NewInt(10),
+		ProposerGracePeriod: &period,
+	}, {
+		Block:               big.NewInt(20),
+		ProposerGracePeriod: &disabled,
+	}, {
+		Block:       big.NewInt(30),
+		EpochLength: 40000,
+	}}
+
+	tests := []struct {
+		blockNumber int64
+		period      uint64
+	}{
+		{1, 2},
+		{10, 4},
+		{19, 4},
+		{20, 0},
+		{30, 0},
+	}
+	for _, test := range tests {
+		if have := config.GetConfig(big.NewInt(test.blockNumber)).ProposerGracePeriod; have != test.period {
+			t.Errorf("proposer grace period mismatch at block %d: have %d, want %d", test.blockNumber, have, test.period)
+		}
+	}
+}
+
 func TestIsQBFTConsensusAt(t *testing.T) {
 	config1 := *DefaultConfig
 	config1.TestQBFTBlock = nil
@@ -1,6 +1,12 @@
 package istanbul
 
-import "github.com/ethereum/go-ethereum/common"
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
 
 type Core interface {
 	Start() error
@@ -15,3 +21,248 @@ type Core interface {
 	// to avoid any race condition of coming propagated blocks
 	IsCurrentProposal(blockHash common.Hash) bool
 }
+
+// RoundChangeInfo describes why and when a consensus core changed round
+type RoundChangeInfo struct {
+	Reason      string    `json:"reason"`
+	Sequence    uint64    `json:"sequence"`
+	Round       uint64    `json:"round"`
+	TargetRound uint64    `json:"targetRound"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// BacklogStats summarises the backlog drains of a consensus core
+type BacklogStats struct {
+	Drains          uint64        `json:"drains"`
+	AverageSize     float64       `json:"averageSize"`     // average number of backlogged messages when a drain starts
+	ReadyRatio      float64       `json:"readyRatio"`      // share of the inspected messages which were dispatched
+	FutureRatio     float64       `json:"futureRatio"`     // share of the inspected messages which were kept for later
+	AverageDuration time.Duration `json:"averageDuration"` // average duration of a drain
+
+	Stops map[common.Address]*BacklogStops `json:"stops,omitempty"` // why the drains of each source stopped
+}
+
+// BacklogStops counts why the drains of a backlog source stopped, frequent stops on a future
+// message show the source messages arrive out of order
+type BacklogStops struct {
+	Future uint64 `json:"future"` // stopped on a message for a later view
+	Empty  uint64 `json:"empty"`  // stopped once the backlog was emptied
+}
+
+// QuorumProgress describes how far the current view of a consensus core is from quorum
+type QuorumProgress struct {
+	Sequence      uint64 `json:"sequence"`
+	Round         uint64 `json:"round"`
+	State         string `json:"state"`
+	Prepares      int    `json:"prepares"`      // number of PREPARE messages received for the view
+	Commits       int    `json:"commits"`       // number of COMMIT messages received for the view
+	PrepareQuorum int    `json:"prepareQuorum"` // number of PREPARE messages needed to prepare the block
+	CommitQuorum  int    `json:"commitQuorum"`  // number of COMMIT messages needed to commit the block
+	Validators    int    `json:"validators"`    // size of the validator set
+}
+
+// PreparedCertificate is the block a consensus core prepared for its current sequence, along with the validators
+// whose PREPARE messages prepared it, which justify proposing it again in the next rounds
+type PreparedCertificate struct {
+	Sequence     uint64           `json:"sequence"`
+	Round        uint64           `json:"round"`        // round the block got prepared at
+	CurrentRound uint64           `json:"currentRound"` // round of the core, above the prepared round after round changes
+	Digest       common.Hash      `json:"digest"`
+	HasBlock     bool             `json:"hasBlock"` // whether the core holds the prepared block, not only the PREPARE messages for it
+	Senders      []common.Address `json:"senders"`  // validators whose PREPARE messages make the certificate, sorted
+}
+
+// ProposalValidation is the result of validating a block as if it was proposed to a consensus core
+type ProposalValidation struct {
+	Number uint64          `json:"number"`
+	Hash   common.Hash     `json:"hash"`
+	Valid  bool            `json:"valid"`  // whether the core would accept the block proposed in a PRE-PREPARE message
+	Checks []ProposalCheck `json:"checks"` // checks in the order the core runs them
+
+	// Pending is set for a block in the future, which the core checks again once Delay elapsed rather than
+	// rejecting it
+	Pending bool          `json:"pending"`
+	Delay   time.Duration `json:"delay,omitempty"`
+}
+
+// ProposalCheck is the outcome of one of the checks a consensus core applies to a proposed block
+type ProposalCheck struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"` // reason the block failed the check, empty if it passed
+}
+
+// RoundChangeReport describes the ROUND-CHANGE messages a consensus core collected for its current sequence
+type RoundChangeReport struct {
+	Sequence uint64              `json:"sequence"`
+	Round    uint64              `json:"round"`
+	FPlusOne int                 `json:"fPlusOne"` // number of validators whose messages for higher rounds make the core catch up
+	Quorum   int                 `json:"quorum"`   // number of messages for a round its proposer needs to propose
+	Rounds   []*RoundChangeGroup `json:"rounds"`   // messages grouped by target round, in ascending order
+}
+
+// RoundChangeGroup lists the validators a consensus core received a ROUND-CHANGE message from for a target round
+type RoundChangeGroup struct {
+	Round     uint64           `json:"round"`
+	Count     int              `json:"count"`
+	AtOrAbove int              `json:"atOrAbove"` // number of validators which sent a message for this round or a higher one
+	Senders   []common.Address `json:"senders"`
+}
+
+// ConsensusStats describes how a consensus core reached consensus on a block it committed
+type ConsensusStats struct {
+	Sequence           uint64           `json:"sequence"`
+	Hash               common.Hash      `json:"hash"`
+	Round              uint64           `json:"round"`                        // round the block got committed at
+	Duration           time.Duration    `json:"duration"`                     // time from the start of the sequence to the commit
+	Committers         []common.Address `json:"committers"`                   // validators whose COMMIT messages made the quorum
+	RoundChangeReasons []string         `json:"roundChangeReasons,omitempty"` // reasons of the round changes of the sequence, in order
+	Dissent            string           `json:"dissent,omitempty"`            // reason this node rejected the block, imported once the other validators committed it
+	Timestamp          time.Time        `json:"timestamp"`                    // time of the commit
+}
+
+// ValidatorUptime describes how often a validator took part in the consensus of the last committed blocks
+type ValidatorUptime struct {
+	Address      common.Address `json:"address"`
+	Blocks       uint64         `json:"blocks"`       // blocks of the window committed while the address was a validator
+	Participated uint64         `json:"participated"` // blocks the validator sent a PREPARE or COMMIT message for
+	Uptime       float64        `json:"uptime"`       // participated blocks in percent of blocks
+}
+
+// Alert is a condition of a consensus core operators should look into, e.g. to page someone
+type Alert struct {
+	Kind      string        `json:"kind"`
+	Message   string        `json:"message"`
+	View      *View         `json:"view,omitempty"`    // view of the core when the condition was detected, if known
+	Context   []interface{} `json:"context,omitempty"` // key value pairs describing the condition, as for logs
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// ConsensusEvent is an activity of a consensus core, streamed to the subscribers as it happens
+type ConsensusEvent struct {
+	Kind     string       `json:"kind"`
+	Sequence uint64       `json:"sequence"`
+	Round    uint64       `json:"round"`
+	State    string       `json:"state,omitempty"` // state entered, for state changes
+	Hash     *common.Hash `json:"hash,omitempty"`  // committed block, for commits
+	Alert    *Alert       `json:"alert,omitempty"` // raised alert, for alerts
+	Time     time.Time    `json:"time"`
+}
+
+// Kinds of consensus events
+const (
+	ConsensusEventState       = "state"       // the core changed state
+	ConsensusEventRoundChange = "roundchange" // the core moved to a higher round of the sequence
+	ConsensusEventCommit      = "commit"      // the core committed a block
+	ConsensusEventAlert       = "alert"       // the core raised an alert
+)
+
+// Kinds of alerts
+const (
+	AlertStall            = "stall"            // the consensus event loop is unresponsive
+	AlertNoFaultTolerance = "nofaulttolerance" // the validator set tolerates no faulty validator
+	AlertMaxRound         = "maxround"         // the maximum round is exceeded, consensus halted for the height
+	AlertEquivocation     = "equivocation"     // a validator sent conflicting messages for the same view
+	AlertSafetyViolation  = "safetyviolation"  // two different blocks got finalized at the same height, the node halted
+	AlertFaultyProposer   = "faultyproposer"   // a validator proposed invalid blocks repeatedly
+)
+
+// SafetyViolation is the evidence of two different blocks finalized with valid committed seals at the same height
+type SafetyViolation struct {
+	Number   uint64            `json:"number"`
+	Blocks   []*FinalizedBlock `json:"blocks"` // the block finalized in the local chain first
+	Detected time.Time         `json:"detected"`
+}
+
+// FinalizedBlock is a block header along with the validators whose committed seals finalized it
+type FinalizedBlock struct {
+	Header     *types.Header    `json:"header"`
+	Committers []common.Address `json:"committers"`
+}
+
+// AlertSink receives the alerts of the consensus core, e.g. to route them to an incident management
+// service. Errors returned by a sink are logged, they never affect consensus.
+type AlertSink interface {
+	Alert(alert *Alert) error
+}
+
+// BacklogProbe describes how a consensus core classifies a backlogged message
+type BacklogProbe struct {
+	Code     uint64 `json:"code"`
+	Sequence uint64 `json:"sequence"`
+	Round    uint64 `json:"round"`
+	Result   string `json:"result"`           // accepted, future, old or invalid
+	Reason   string `json:"reason,omitempty"` // reason of the rejection of invalid messages
+
+	// Explanation tells why the message is still queued, e.g. "future by 2 sequences" or
+	// "blocked behind 3 higher-priority messages"
+	Explanation string `json:"explanation"`
+}
+
+// BacklogPriority is the priority a backlogged message is queued with, next to the exact value it is
+// computed from, so that messages whose priorities collide can be analysed
+type BacklogPriority struct {
+	Source        common.Address `json:"source"`
+	Code          uint64         `json:"code"`
+	Sequence      uint64         `json:"sequence"`
+	Round         uint64         `json:"round"`
+	Priority      float32        `json:"priority"`      // priority of the message in the backlog queue
+	ExactPriority int64          `json:"exactPriority"` // priority before its conversion to float32
+	Collides      bool           `json:"collides"`      // whether another message of the source has the same priority but another exact one
+}
+
+// WAL is a write-ahead log of the consensus messages a core acted upon for its current sequence,
+// replayed when the core restarts so that it resumes the sequence instead of starting it again
+type WAL interface {
+	// Append records a message, it must be durable once Append returns
+	Append(entry WALEntry) error
+
+	// Entries returns the recorded messages in the order they were appended
+	Entries() ([]WALEntry, error)
+
+	// Truncate removes all the recorded messages
+	Truncate() error
+}
+
+// WALEntry is a consensus message recorded in a WAL
+type WALEntry struct {
+	Code    uint64
+	Payload []byte // RLP-encoded message
+}
+
+// Journal records the input events handled by a consensus core, in order, so that they can be
+// replayed into a fresh core to reproduce a consensus bug
+type Journal interface {
+	// Record appends an event to the journal
+	Record(event JournalEvent) error
+}
+
+// Kinds of the events recorded in a Journal
+const (
+	JournalRequest        = "request"        // block to propose, the payload is the RLP-encoded block
+	JournalMessage        = "message"        // consensus message received, the payload is the RLP-encoded message
+	JournalBacklog        = "backlog"        // backlogged message handled again, the payload is the RLP-encoded message
+	JournalTimeout        = "timeout"        // round change timer fired
+	JournalRebroadcast    = "rebroadcast"    // PRE-PREPARE rebroadcast timer fired
+	JournalBacklogDrain   = "backlogdrain"   // backlog drain resumed after yielding
+	JournalFinalCommitted = "finalcommitted" // block imported into the chain
+	JournalCommitSeal     = "commitseal"     // commit seal target timer fired
+)
+
+// StepResult is the consensus state after a single-step debugging command
+type StepResult struct {
+	Event    string `json:"event,omitempty"` // type of the event handled by the step, empty if none was queued
+	Paused   bool   `json:"paused"`
+	Queued   int    `json:"queued"` // number of events queued while paused, waiting to be stepped through
+	Sequence uint64 `json:"sequence"`
+	Round    uint64 `json:"round"`
+	State    string `json:"state"`
+}
+
+// JournalEvent is a consensus input event recorded in a Journal
+type JournalEvent struct {
+	Time    time.Time     `json:"time"`
+	Kind    string        `json:"kind"`
+	Code    uint64        `json:"code,omitempty"`
+	Payload hexutil.Bytes `json:"payload,omitempty"`
+	Local   bool          `json:"local,omitempty"` // message sent by the node to itself
+}
@@ -26,4 +26,13 @@ var (
 	ErrStoppedEngine = errors.New("stopped engine")
 	// ErrStartedEngine is returned if the engine is already started
 	ErrStartedEngine = errors.New("started engine")
+	// ErrTooFewValidators is returned if the engine is started with a validator set
+	// smaller than the configured minimum
+	ErrTooFewValidators = errors.New("validator set too small")
+	// ErrDuplicateValidator is returned if a validator set lists the same address several times
+	ErrDuplicateValidator = errors.New("duplicate validator")
+	// ErrSafetyViolation is returned once two different blocks got finalized at the same height, the engine
+	// halts until the operators investigate. Once resolved, they clear the evidence with the non-public
+	// istanbuladmin_clearSafetyViolation RPC method, given the height of the violation, the engine stopped.
+	ErrSafetyViolation = errors.New("conflicting finalized blocks, consensus safety violated")
 )
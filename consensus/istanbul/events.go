@@ -25,6 +25,8 @@ type RequestEvent struct {
 type MessageEvent struct {
 	Code    uint64
 	Payload []byte
+	// Local is set for the messages a node broadcasts to itself, the other ones are received from peers
+	Local bool
 }
 
 // FinalCommittedEvent is posted when a proposal is committed
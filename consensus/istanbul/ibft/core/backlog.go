@@ -85,12 +85,18 @@ func (c *core) storeBacklog(msg *ibfttypes.Message, src istanbul.Validator) {
 		return
 	}
 
-	logger.Trace("Store future message")
+	// sample once so both lines of a stored message are logged together
+	sampled := c.logSampler.Sample()
+	if sampled {
+		logger.Trace("Store future message")
+	}
 
 	c.backlogsMu.Lock()
 	defer c.backlogsMu.Unlock()
 
-	logger.Debug("Retrieving backlog queue", "for", src.Address(), "backlogs_size", len(c.backlogs))
+	if sampled {
+		logger.Debug("Retrieving backlog queue", "for", src.Address(), "backlogs_size", len(c.backlogs))
+	}
 	backlog := c.backlogs[src.Address()]
 	if backlog == nil {
 		backlog = prque.New()
@@ -117,6 +123,8 @@ func (c *core) processBacklog() {
 	c.backlogsMu.Lock()
 	defer c.backlogsMu.Unlock()
 
+	c.backlogDrainPending = false
+
 	for srcAddress, backlog := range c.backlogs {
 		if backlog == nil {
 			continue
@@ -160,24 +168,47 @@ func (c *core) processBacklog() {
 			err := c.checkMessage(msg.Code, view)
 			if err != nil {
 				if err == istanbulcommon.ErrFutureMessage {
-					logger.Trace("Stop processing backlog", "msg", msg)
+					if c.logSampler.Sample() {
+						logger.Trace("Stop processing backlog", "msg", msg)
+					}
 					backlog.Push(msg, prio)
 					isFuture = true
 					break
 				}
-				logger.Trace("Skip the backlog event", "msg", msg, "err", err)
+				if c.logSampler.Sample() {
+					logger.Trace("Skip the backlog event", "msg", msg, "err", err)
+				}
 				continue
 			}
-			logger.Trace("Post backlog event", "msg", msg)
+			if c.logSampler.Sample() {
+				logger.Trace("Post backlog event", "msg", msg)
+			}
 
-			go c.sendEvent(backlogEvent{
+			if !c.sendEventOrdered(backlogEvent{
 				src: src,
 				msg: msg,
-			})
+			}) {
+				// the event loop fell behind, messages are not resent so keep this one and resume
+				// once the queued events are handled
+				backlog.Push(msg, prio)
+				logger.Debug("Event queue full, defer backlog drain")
+				c.deferBacklogDrain()
+				return
+			}
 		}
 	}
 }
 
+// deferBacklogDrain schedules the backlog drain to resume from the event loop,
+// it must be called with backlogsMu held
+func (c *core) deferBacklogDrain() {
+	if c.backlogDrainPending {
+		return
+	}
+	c.backlogDrainPending = true
+	c.sendEventOrdered(backlogDrainEvent{})
+}
+
 func toPriority(msgCode uint64, view *istanbul.View) float32 {
 	if msgCode == ibfttypes.MsgRoundChange {
 		// For msgRoundChange, set the message priority based on its sequence
@@ -363,3 +363,144 @@ func testProcessBacklog(t *testing.T, msg *ibfttypes.Message) {
 		t.Error("unexpected timeout occurs")
 	}
 }
+
+func TestStoreBacklogLogSampling(t *testing.T) {
+	var logged int
+	logger := log.New("backend", "test", "id", 0)
+	logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		if r.Msg == "Store future message" {
+			logged++
+		}
+		return nil
+	}))
+
+	c := &core{
+		logger:     logger,
+		valSet:     newTestValidatorSet(1),
+		backlogs:   make(map[common.Address]*prque.Prque),
+		backlogsMu: new(sync.Mutex),
+		logSampler: istanbulcommon.NewLogSampler(10),
+	}
+	subject := &istanbul.Subject{
+		View: &istanbul.View{
+			Round:    big.NewInt(10),
+			Sequence: big.NewInt(10),
+		},
+		Digest: common.StringToHash("1234567890"),
+	}
+	subjectPayload, _ := ibfttypes.Encode(subject)
+	p := c.valSet.GetByIndex(0)
+
+	const total = 100
+	for i := 0; i < total; i++ {
+		c.storeBacklog(&ibfttypes.Message{
+			Code: ibfttypes.MsgCommit,
+			Msg:  subjectPayload,
+		}, p)
+	}
+
+	if logged != total/10 {
+		t.Errorf("sampled log lines mismatch: have %v, want %v", logged, total/10)
+	}
+	if size := c.backlogs[p.Address()].Size(); size != total {
+		t.Errorf("backlog size mismatch: have %v, want %v", size, total)
+	}
+
+	// without sampling every message is logged
+	logged = 0
+	c.logSampler = nil
+	for i := 0; i < total; i++ {
+		c.storeBacklog(&ibfttypes.Message{
+			Code: ibfttypes.MsgCommit,
+			Msg:  subjectPayload,
+		}, p)
+	}
+	if logged != total {
+		t.Errorf("log lines mismatch: have %v, want %v", logged, total)
+	}
+}
+
+func TestProcessBacklogOrdering(t *testing.T) {
+	v := &istanbul.View{
+		Round:    big.NewInt(0),
+		Sequence: big.NewInt(1),
+	}
+	preprepare := &istanbul.Preprepare{
+		View:     v,
+		Proposal: makeBlock(1),
+	}
+	prepreparePayload, _ := ibfttypes.Encode(preprepare)
+	subject := &istanbul.Subject{
+		View:   v,
+		Digest: common.StringToHash("1234567890"),
+	}
+	subjectPayload, _ := ibfttypes.Encode(subject)
+
+	msgs := []*ibfttypes.Message{
+		{Code: ibfttypes.MsgPrepare, Msg: subjectPayload},
+		{Code: ibfttypes.MsgCommit, Msg: subjectPayload},
+		{Code: ibfttypes.MsgPreprepare, Msg: prepreparePayload},
+		{Code: ibfttypes.MsgRoundChange, Msg: subjectPayload},
+	}
+	// whatever order messages are stored in, they must be dispatched by priority
+	want := []uint64{ibfttypes.MsgRoundChange, ibfttypes.MsgPreprepare, ibfttypes.MsgCommit, ibfttypes.MsgPrepare}
+
+	var permute func(prefix, rest []*ibfttypes.Message)
+	permute = func(prefix, rest []*ibfttypes.Message) {
+		if len(rest) == 0 {
+			for i := 0; i < 10; i++ {
+				testProcessBacklogOrdering(t, prefix, want)
+			}
+			return
+		}
+		for i := range rest {
+			next := append(append([]*ibfttypes.Message{}, rest[:i]...), rest[i+1:]...)
+			permute(append(append([]*ibfttypes.Message{}, prefix...), rest[i]), next)
+		}
+	}
+	permute(nil, msgs)
+}
+
+func testProcessBacklogOrdering(t *testing.T, msgs []*ibfttypes.Message, want []uint64) {
+	vset := newTestValidatorSet(1)
+	backend := &testSystemBackend{
+		events: new(event.TypeMux),
+		peers:  vset,
+	}
+	c := &core{
+		logger:     log.New("backend", "test", "id", 0),
+		backlogs:   make(map[common.Address]*prque.Prque),
+		backlogsMu: new(sync.Mutex),
+		valSet:     vset,
+		backend:    backend,
+		state:      ibfttypes.StatePrepared,
+		current: newRoundState(&istanbul.View{
+			Sequence: big.NewInt(1),
+			Round:    big.NewInt(0),
+		}, newTestValidatorSet(4), common.Hash{}, nil, nil, nil),
+	}
+	c.subscribeEvents()
+	defer c.unsubscribeEvents()
+
+	for _, msg := range msgs {
+		c.storeBacklog(msg, vset.GetByIndex(0))
+	}
+	c.processBacklog()
+
+	timeout := time.NewTimer(2 * time.Second)
+	defer timeout.Stop()
+	for i, code := range want {
+		select {
+		case ev := <-c.events.Chan():
+			e, ok := ev.Data.(backlogEvent)
+			if !ok {
+				t.Fatalf("unexpected event comes: %v", reflect.TypeOf(ev.Data))
+			}
+			if e.msg.Code != code {
+				t.Fatalf("event %d code mismatch: have %v, want %v", i, e.msg.Code, code)
+			}
+		case <-timeout.C:
+			t.Fatalf("unexpected timeout occurs at event %d", i)
+		}
+	}
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	istanbulcommon "github.com/ethereum/go-ethereum/consensus/istanbul/common"
 	ibfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/ibft/types"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/event"
@@ -37,6 +38,8 @@ var (
 	roundMeter     = metrics.NewRegisteredMeter("consensus/istanbul/core/round", nil)
 	sequenceMeter  = metrics.NewRegisteredMeter("consensus/istanbul/core/sequence", nil)
 	consensusTimer = metrics.NewRegisteredTimer("consensus/istanbul/core/consensus", nil)
+	// deferredEventMeter counts backlog events kept in the backlog because the event loop fell behind
+	deferredEventMeter = metrics.NewRegisteredMeter("consensus/istanbul/core/event/deferred", nil)
 )
 
 // New creates an Istanbul consensus core
@@ -53,6 +56,7 @@ func New(backend istanbul.Backend, config *istanbul.Config) *core {
 		pendingRequests:    prque.New(),
 		pendingRequestsMu:  new(sync.Mutex),
 		consensusTimestamp: time.Time{},
+		logSampler:         istanbulcommon.NewLogSampler(config.TraceLogSampleRate),
 	}
 
 	c.validateFn = c.checkValidatorSignature
@@ -79,6 +83,8 @@ type core struct {
 
 	backlogs   map[common.Address]*prque.Prque
 	backlogsMu *sync.Mutex
+	// backlogDrainPending is set while a backlogDrainEvent is queued, it is guarded by backlogsMu
+	backlogDrainPending bool
 
 	current   *roundState
 	handlerWg *sync.WaitGroup
@@ -90,6 +96,12 @@ type core struct {
 	pendingRequestsMu *sync.Mutex
 
 	consensusTimestamp time.Time
+
+	// logSampler throttles the per-message backlog logs
+	logSampler *istanbulcommon.LogSampler
+
+	// eventQueue keeps backlog and pending request events in dispatch order
+	eventQueue istanbulcommon.EventQueue
 }
 
 func (c *core) finalizeMessage(msg *ibfttypes.Message) ([]byte, error) {
@@ -251,7 +263,7 @@ func (c *core) startNewRound(round *big.Int) {
 	// New snapshot for new round
 	c.updateRoundState(newView, c.valSet, roundChange)
 	// Calculate new proposer
-	c.valSet.CalcProposer(lastProposer, newView.Round.Uint64())
+	c.valSet.CalcProposer(lastProposer, newView)
 	c.waitingForRoundChange = false
 	c.setState(ibfttypes.StateAcceptRequest)
 	if roundChange && c.IsProposer() && c.current != nil {
@@ -347,12 +359,12 @@ func (c *core) checkValidatorSignature(data []byte, sig []byte) (common.Address,
 }
 
 func (c *core) QuorumSize() int {
-	if c.config.Get2FPlus1Enabled(c.current.sequence) || c.config.Ceil2Nby3Block == nil || (c.current != nil && c.current.sequence.Cmp(c.config.Ceil2Nby3Block) < 0) {
+	if c.config.Use2FPlus1Quorum(c.current.sequence) {
 		c.logger.Trace("Confirmation Formula used 2F+ 1")
-		return (2 * c.valSet.F()) + 1
+	} else {
+		c.logger.Trace("Confirmation Formula used ceil(2N/3)")
 	}
-	c.logger.Trace("Confirmation Formula used ceil(2N/3)")
-	return int(math.Ceil(float64(2*c.valSet.Size()) / 3))
+	return istanbul.QuorumSize(c.config, c.valSet, c.current.sequence)
 }
 
 // PrepareCommittedSeal returns a committed seal for the given hash
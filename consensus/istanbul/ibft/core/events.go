@@ -26,4 +26,7 @@ type backlogEvent struct {
 	msg *ibfttypes.Message
 }
 
+// backlogDrainEvent resumes a backlog drain deferred because the event queue was full
+type backlogDrainEvent struct{}
+
 type timeoutEvent struct{}
@@ -56,6 +56,7 @@ func (c *core) subscribeEvents() {
 		istanbul.MessageEvent{},
 		// internal events
 		backlogEvent{},
+		backlogDrainEvent{},
 	)
 	c.timeoutSub = c.backend.EventMux().Subscribe(
 		timeoutEvent{},
@@ -112,6 +113,8 @@ func (c *core) handleEvents() {
 					}
 					c.backend.Gossip(c.valSet, ev.msg.Code, p)
 				}
+			case backlogDrainEvent:
+				c.processBacklog()
 			}
 		case _, ok := <-c.timeoutSub.Chan():
 			if !ok {
@@ -135,6 +138,22 @@ func (c *core) sendEvent(ev interface{}) {
 	c.backend.EventMux().Post(ev)
 }
 
+// sendEventOrdered sends events to mux asynchronously, preserving the order
+// in which they were sent, and returns whether ev was queued. Backlog events
+// are not queued if the event loop falls too far behind, the caller keeps
+// them in the backlog until the queue drains.
+func (c *core) sendEventOrdered(ev interface{}) bool {
+	if _, ok := ev.(backlogEvent); ok {
+		if !c.eventQueue.TryPost(ev, c.sendEvent) {
+			deferredEventMeter.Mark(1)
+			return false
+		}
+		return true
+	}
+	c.eventQueue.Post(ev, c.sendEvent)
+	return true
+}
+
 func (c *core) handleMsg(payload []byte) error {
 	logger := c.logger.New()
 
@@ -94,7 +94,7 @@ func (c *core) processPendingRequests() {
 		}
 		c.logger.Trace("Post pending request", "number", r.Proposal.Number(), "hash", r.Proposal.Hash())
 
-		go c.sendEvent(istanbul.RequestEvent{
+		c.sendEventOrdered(istanbul.RequestEvent{
 			Proposal: r.Proposal,
 		})
 	}
@@ -96,6 +96,10 @@ func (self *testSystemBackend) Gossip(valSet istanbul.ValidatorSet, code uint64,
 	return nil
 }
 
+func (self *testSystemBackend) Resend(targets []common.Address, code uint64, message []byte) error {
+	return nil
+}
+
 func (self *testSystemBackend) Commit(proposal istanbul.Proposal, seals [][]byte, round *big.Int) error {
 	testLogger.Info("commit message", "address", self.Address())
 	self.committedMsgs = append(self.committedMsgs, testCommittedMsgs{
@@ -144,6 +148,15 @@ func (self *testSystemBackend) HasBadProposal(hash common.Hash) bool {
 	return false
 }
 
+func (self *testSystemBackend) RequestSync() {
+}
+
+func (self *testSystemBackend) ProposerChanged(isProposer bool, view istanbul.View) {
+}
+
+func (self *testSystemBackend) Alert(alert *istanbul.Alert) {
+}
+
 func (self *testSystemBackend) LastProposal() (istanbul.Proposal, common.Address) {
 	l := len(self.committedMsgs)
 	if l > 0 {
@@ -211,20 +211,19 @@ func (e *Engine) verifyCommittedSeals(chain consensus.ChainHeaderReader, header
 	}
 
 	validatorsCpy := validators.Copy()
+	proposalSeal := PrepareCommittedSeal(header.Hash())
 
-	// Check whether the committed seals are generated by validators
+	// Check whether every committed seal is generated by a distinct validator
 	validSeal := 0
-	committers, err := e.Signers(header)
-	if err != nil {
-		return err
-	}
-
-	for _, addr := range committers {
-		if validatorsCpy.RemoveValidator(addr) {
-			validSeal++
-			continue
+	for _, seal := range committedSeal {
+		addr, err := istanbulcommon.GetSignatureAddress(proposalSeal, seal)
+		if err != nil {
+			return istanbulcommon.ErrInvalidSignature
 		}
-		return istanbulcommon.ErrInvalidCommittedSeals
+		if !validatorsCpy.RemoveValidator(addr) {
+			return istanbulcommon.ErrInvalidCommittedSeals
+		}
+		validSeal++
 	}
 
 	// The length of validSeal should be larger than number of faulty node + 1
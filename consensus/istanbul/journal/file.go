@@ -0,0 +1,80 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package journal implements stores for the journal of the istanbul consensus input events
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// FileJournal appends the events to a file, one JSON object per line, so that the file can be attached
+// to a bug report and read back with ReadFile
+type FileJournal struct {
+	file *os.File
+	enc  *json.Encoder
+	mu   sync.Mutex
+}
+
+// OpenFile opens the journal stored in the file at path, the events are appended to the ones already there
+func OpenFile(path string) (*FileJournal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileJournal{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Record implements istanbul.Journal.Record
+func (j *FileJournal) Record(event istanbul.JournalEvent) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.enc.Encode(event)
+}
+
+// Close closes the file of the journal
+func (j *FileJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
+
+// ReadFile returns the events of the journal stored in the file at path, in the order they were recorded
+func ReadFile(path string) ([]istanbul.JournalEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []istanbul.JournalEvent
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for dec.More() {
+		var event istanbul.JournalEvent
+		if err := dec.Decode(&event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
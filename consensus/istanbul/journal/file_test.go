@@ -0,0 +1,73 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package journal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+func TestFileJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "istanbul-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	events := []istanbul.JournalEvent{
+		{Time: time.Unix(1, 0), Kind: istanbul.JournalMessage, Code: 0x12, Payload: []byte("preprepare")},
+		{Time: time.Unix(2, 0), Kind: istanbul.JournalMessage, Code: 0x13, Payload: []byte("prepare"), Local: true},
+		{Time: time.Unix(3, 0), Kind: istanbul.JournalTimeout},
+	}
+
+	// a reopened journal continues the recorded one
+	for _, batch := range [][]istanbul.JournalEvent{events[:2], events[2:]} {
+		j, err := OpenFile(path)
+		if err != nil {
+			t.Fatalf("open failed: %v", err)
+		}
+		for _, event := range batch {
+			if err := j.Record(event); err != nil {
+				t.Fatalf("record failed: %v", err)
+			}
+		}
+		if err := j.Close(); err != nil {
+			t.Fatalf("close failed: %v", err)
+		}
+	}
+
+	have, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if len(have) != len(events) {
+		t.Fatalf("events mismatch: have %d, want %d", len(have), len(events))
+	}
+	for i, event := range events {
+		h := have[i]
+		if !h.Time.Equal(event.Time) || h.Kind != event.Kind || h.Code != event.Code || !bytes.Equal(h.Payload, event.Payload) || h.Local != event.Local {
+			t.Errorf("event %d mismatch: have %+v, want %+v", i, h, event)
+		}
+	}
+}
@@ -17,9 +17,19 @@
 package core
 
 import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
 	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
-	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 var (
@@ -32,19 +42,76 @@ var (
 	}
 )
 
+// maxPriorityRound is the highest round distinguished by the backlog priorities
+const maxPriorityRound = 99
+
+// roundChangePriorityBand is the range of priorities of the ROUND-CHANGE messages of a sequence, one per round
+const roundChangePriorityBand = maxPriorityRound + 1
+
+// backlogSourceSlack is the number of backlogs allowed beyond one per validator, for the validators just
+// removed from the set whose backlogs are only deleted by the next drain
+const backlogSourceSlack = 10
+
+// maxDispatchedBacklog bounds the number of backlog messages remembered as dispatched for the current view
+const maxDispatchedBacklog = 4096
+
+// maxSequenceGap caps the gap reported between the highest sequence seen and the current one
+const maxSequenceGap = 1 << 20
+
+// Reasons for checkMessage to reject a message as invalid
+const (
+	invalidMessageMalformedView = "malformedview" // message has no or an incomplete view
+	invalidMessageWrongState    = "wrongstate"    // message type is not expected anymore at the current state
+	invalidMessageCommitted     = "committed"     // current round is already committed
+	invalidMessageFarRound      = "farround"      // message round is beyond the window of future rounds of the current sequence
+)
+
+// invalidMessageMeters count the messages rejected as invalid by checkMessage, by reason
+var invalidMessageMeters = map[string]metrics.Meter{
+	invalidMessageMalformedView: metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/invalid/"+invalidMessageMalformedView, nil),
+	invalidMessageWrongState:    metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/invalid/"+invalidMessageWrongState, nil),
+	invalidMessageCommitted:     metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/invalid/"+invalidMessageCommitted, nil),
+	invalidMessageFarRound:      metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/invalid/"+invalidMessageFarRound, nil),
+}
+
 // checkMessage checks that a message matches our current QBFT state
 //
 // In particular it ensures that
 // - message has the expected round
 // - message has the expected sequence
+// - message round is not too far ahead of the current round, when a window of future rounds is set
 // - message type is expected given our current state
 
 // return errInvalidMessage if the message is invalid
 // return errFutureMessage if the message view is larger than current view
 // return errOldMessage if the message view is smaller than current view
 func (c *core) checkMessage(msgCode uint64, view *istanbul.View) error {
+	reason, err := c.classifyMessage(msgCode, view)
+	if reason != "" {
+		invalidMessageMeters[reason].Mark(1)
+	}
+	return err
+}
+
+// classifyMessage implements checkMessage without recording anything, it also returns the reason
+// of the rejection of invalid messages
+func (c *core) classifyMessage(msgCode uint64, view *istanbul.View) (string, error) {
 	if view == nil || view.Sequence == nil || view.Round == nil {
-		return errInvalidMessage
+		return invalidMessageMalformedView, errInvalidMessage
+	}
+
+	currentView := c.currentView()
+	if currentView == nil {
+		// the core is starting, keep the message until the first view is initialized
+		return "", errFutureMessage
+	}
+
+	// A message of the current sequence for a round that far ahead is most likely malicious or buggy,
+	// it is rejected rather than buffered in the backlog. ROUND-CHANGE messages are exempted: F+1 of them
+	// for a far round let a lagging validator jump to the round the others are at.
+	if window := c.config.FutureRoundWindow; window > 0 && msgCode != qbfttypes.RoundChangeCode && view.Sequence.Cmp(currentView.Sequence) == 0 &&
+		view.Round.Cmp(new(big.Int).Add(currentView.Round, new(big.Int).SetUint64(window))) > 0 {
+		return invalidMessageFarRound, errInvalidMessage
 	}
 
 	if msgCode == qbfttypes.RoundChangeCode {
@@ -52,22 +119,22 @@ func (c *core) checkMessage(msgCode uint64, view *istanbul.View) error {
 		// check that
 		// - sequence matches our current sequence
 		// - round is in the future
-		if view.Sequence.Cmp(c.currentView().Sequence) > 0 {
-			return errFutureMessage
-		} else if view.Cmp(c.currentView()) < 0 {
-			return errOldMessage
+		if view.Sequence.Cmp(currentView.Sequence) > 0 {
+			return "", errFutureMessage
+		} else if view.Cmp(currentView) < 0 {
+			return "", errOldMessage
 		}
-		return nil
+		return "", nil
 	}
 
 	// If not ROUND-CHANGE
 	// check that round and sequence equals our current round and sequence
-	if view.Cmp(c.currentView()) > 0 {
-		return errFutureMessage
+	if view.Cmp(currentView) > 0 {
+		return "", errFutureMessage
 	}
 
-	if view.Cmp(c.currentView()) < 0 {
-		return errOldMessage
+	if view.Cmp(currentView) < 0 {
+		return "", errOldMessage
 	}
 
 	switch c.state {
@@ -75,30 +142,30 @@ func (c *core) checkMessage(msgCode uint64, view *istanbul.View) error {
 		// StateAcceptRequest only accepts msgPreprepare and msgRoundChange
 		// other messages are future messages
 		if msgCode > qbfttypes.PreprepareCode {
-			return errFutureMessage
+			return "", errFutureMessage
 		}
-		return nil
+		return "", nil
 	case StatePreprepared:
 		// StatePreprepared only accepts msgPrepare and msgRoundChange
 		// message less than msgPrepare are invalid and greater are future messages
 		if msgCode < qbfttypes.PrepareCode {
-			return errInvalidMessage
+			return invalidMessageWrongState, errInvalidMessage
 		} else if msgCode > qbfttypes.PrepareCode {
-			return errFutureMessage
+			return "", errFutureMessage
 		}
-		return nil
+		return "", nil
 	case StatePrepared:
 		// StatePrepared only accepts msgCommit and msgRoundChange
 		// other messages are invalid messages
 		if msgCode < qbfttypes.CommitCode {
-			return errInvalidMessage
+			return invalidMessageWrongState, errInvalidMessage
 		}
-		return nil
+		return "", nil
 	case StateCommitted:
 		// StateCommit rejects all messages other than msgRoundChange
-		return errInvalidMessage
+		return invalidMessageCommitted, errInvalidMessage
 	}
-	return nil
+	return "", nil
 }
 
 // addToBacklog allows to postpone the processing of future messages
@@ -113,28 +180,316 @@ func (c *core) addToBacklog(msg qbfttypes.QBFTMessage) {
 		return
 	}
 
-	logger.Trace("QBFT: new backlog message", "backlogs_size", len(c.backlogs))
+	// backlogs are keyed by source, make sure it has not been spoofed
+	if err := c.checkSource(msg); err != nil {
+		logger.Warn("QBFT: reject backlog message", "err", err)
+		return
+	}
+
+	if c.logSampler.Sample() {
+		logger.Trace("QBFT: new backlog message", "backlogs_size", len(c.backlogs))
+	}
 
 	c.backlogsMu.Lock()
 	defer c.backlogsMu.Unlock()
 
+	if c.isDispatchedBacklog(msg) {
+		backlogRedispatchMeter.Mark(1)
+		if c.logSampler.Sample() {
+			logger.Trace("QBFT: backlog message already dispatched")
+		}
+		return
+	}
+
+	view := msg.View()
 	backlog := c.backlogs[src]
 	if backlog == nil {
-		backlog = prque.New()
+		// bound the number of backlogs, whatever the number of sources messages claim to come from
+		if !c.hasBacklogRoom() {
+			backlogSourceRejectMeter.Mark(1)
+			if c.logSampler.Sample() {
+				logger.Warn("QBFT: too many backlog sources, reject backlog message", "backlogs", len(c.backlogs))
+			}
+			return
+		}
+		backlog = c.newBacklogQueue()
 		c.backlogs[src] = backlog
 	}
-	view := msg.View()
 	backlog.Push(msg, toPriority(msg.Code(), &view))
+
+	if c.current != nil && view.Sequence.Cmp(c.current.Sequence()) > 0 {
+		c.trackFutureSequenceMessage(src)
+		seen := uint64(math.MaxUint64)
+		if view.Sequence.IsUint64() {
+			seen = view.Sequence.Uint64()
+		}
+		c.updateSequenceGap(seen)
+	}
+}
+
+// hasBacklogRoom reports whether a backlog can be added for a new source, the backlogs of sources which
+// are not validators anymore are deleted to make room. It must be called with backlogsMu held.
+func (c *core) hasBacklogRoom() bool {
+	limit := c.valSet.Size() + backlogSourceSlack
+	if len(c.backlogs) < limit {
+		return true
+	}
+	for addr := range c.backlogs {
+		if _, v := c.valSet.GetByAddress(addr); v == nil {
+			c.deleteBacklog(addr)
+		}
+	}
+	return len(c.backlogs) < limit
+}
+
+// Results of a backlog message probe
+const (
+	backlogProbeAccepted = "accepted"
+	backlogProbeFuture   = "future"
+	backlogProbeOld      = "old"
+	backlogProbeInvalid  = "invalid"
+)
+
+// ProbeBacklog classifies the messages backlogged from src for the given view, as processBacklog
+// would, without dispatching them nor recording any metric
+func (c *core) ProbeBacklog(src common.Address, view istanbul.View) []*istanbul.BacklogProbe {
+	c.backlogsMu.Lock()
+	defer c.backlogsMu.Unlock()
+
+	backlog := c.backlogs[src]
+	if backlog == nil {
+		return nil
+	}
+
+	// the queue can only be walked by popping it, push everything back once done
+	type item struct {
+		msg  qbfttypes.QBFTMessage
+		prio float32
+	}
+	var items []item
+	for !backlog.Empty() {
+		m, prio := backlog.Pop()
+		items = append(items, item{msg: m.(qbfttypes.QBFTMessage), prio: prio})
+	}
+
+	var probes []*istanbul.BacklogProbe
+	// a drain stops at the first future message, the messages queued behind it are blocked
+	blocked := false
+	kept := 0
+	for _, it := range items {
+		backlog.Push(it.msg, it.prio)
+
+		msgView := it.msg.View()
+		reason, err := c.classifyMessage(it.msg.Code(), &msgView)
+		if msgView.Cmp(&view) == 0 {
+			probe := &istanbul.BacklogProbe{
+				Code:     it.msg.Code(),
+				Sequence: msgView.Sequence.Uint64(),
+				Round:    msgView.Round.Uint64(),
+			}
+			switch err {
+			case nil:
+				probe.Result = backlogProbeAccepted
+				if blocked {
+					probe.Explanation = fmt.Sprintf("blocked behind %d higher-priority messages", kept)
+				} else {
+					probe.Explanation = "ready, dispatched on the next backlog drain"
+				}
+			case errFutureMessage:
+				probe.Result = backlogProbeFuture
+				probe.Explanation = c.explainFutureMessage(&msgView)
+			case errOldMessage:
+				probe.Result = backlogProbeOld
+				probe.Explanation = "older than the current view, dropped on the next backlog drain"
+			default:
+				probe.Result = backlogProbeInvalid
+				probe.Reason = reason
+				probe.Explanation = fmt.Sprintf("invalid (%s), dropped on the next backlog drain", reason)
+			}
+			probes = append(probes, probe)
+		}
+
+		// old and invalid messages ahead of the first future one are dropped by the drain
+		if err == errFutureMessage {
+			blocked = true
+		}
+		if blocked || err == nil {
+			kept++
+		}
+	}
+	return probes
+}
+
+// BacklogPriorities exports the priority of every backlogged message with the exact value it is computed
+// from, flagging the messages of a source whose priorities collide because of the float32 precision, which
+// the queues do not order by. The messages are listed by source, in queue order.
+func (c *core) BacklogPriorities() []*istanbul.BacklogPriority {
+	c.backlogsMu.Lock()
+	defer c.backlogsMu.Unlock()
+
+	sources := make([]common.Address, 0, len(c.backlogs))
+	for src, backlog := range c.backlogs {
+		if backlog != nil {
+			sources = append(sources, src)
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return bytes.Compare(sources[i][:], sources[j][:]) < 0 })
+
+	var priorities []*istanbul.BacklogPriority
+	for _, src := range sources {
+		backlog := c.backlogs[src]
+
+		// the queue can only be walked by popping it, push everything back once done
+		var msgs []qbfttypes.QBFTMessage
+		var prios []float32
+		for !backlog.Empty() {
+			m, prio := backlog.Pop()
+			msgs = append(msgs, m.(qbfttypes.QBFTMessage))
+			prios = append(prios, prio)
+		}
+
+		exacts := make(map[float32]int64)
+		collisions := make(map[float32]bool)
+		var entries []*istanbul.BacklogPriority
+		for i, msg := range msgs {
+			backlog.Push(msg, prios[i])
+
+			view := msg.View()
+			entry := &istanbul.BacklogPriority{
+				Source:        src,
+				Code:          msg.Code(),
+				Sequence:      view.Sequence.Uint64(),
+				Round:         view.Round.Uint64(),
+				Priority:      prios[i],
+				ExactPriority: exactPriority(msg.Code(), &view),
+			}
+			if exact, ok := exacts[entry.Priority]; ok && exact != entry.ExactPriority {
+				collisions[entry.Priority] = true
+			}
+			exacts[entry.Priority] = entry.ExactPriority
+			entries = append(entries, entry)
+		}
+		for _, entry := range entries {
+			entry.Collides = collisions[entry.Priority]
+		}
+		priorities = append(priorities, entries...)
+	}
+	return priorities
+}
+
+// explainFutureMessage tells how far ahead of the current view a future message is
+func (c *core) explainFutureMessage(view *istanbul.View) string {
+	currentView := c.currentView()
+	if currentView == nil {
+		return "the consensus core is starting"
+	}
+	if view.Sequence.Cmp(currentView.Sequence) > 0 {
+		return fmt.Sprintf("future by %d sequences", new(big.Int).Sub(view.Sequence, currentView.Sequence))
+	}
+	if view.Round.Cmp(currentView.Round) > 0 {
+		return fmt.Sprintf("future by %d rounds", new(big.Int).Sub(view.Round, currentView.Round))
+	}
+	return fmt.Sprintf("not accepted yet in state %s", c.state)
+}
+
+// checkSource ensures the source of the message is the address recovered from its signature,
+// the signer is recovered once and cached on the message so re-backlogging it is cheap
+func (c *core) checkSource(msg qbfttypes.QBFTMessage) error {
+	signer, ok := msg.Signer()
+	if !ok {
+		payload, err := msg.EncodePayloadForSigning()
+		if err != nil {
+			return err
+		}
+		if signer, err = c.validateFn(payload, msg.Signature()); err != nil {
+			return errInvalidSigner
+		}
+		msg.SetSigner(signer)
+	}
+	if signer != msg.Source() {
+		return errInvalidSigner
+	}
+	return nil
+}
+
+// trackFutureSequenceMessage records a message received for a sequence ahead of the current one.
+// Once enough of them have been received from F+1 validators, at least one of them honest, the
+// node is considered behind and a chain sync is requested rather than only buffering messages.
+func (c *core) trackFutureSequenceMessage(src common.Address) {
+	threshold := c.config.FutureMessageSyncThreshold
+	if threshold == 0 {
+		return
+	}
+	if c.futureSequenceSources == nil {
+		c.futureSequenceSources = make(map[common.Address]struct{})
+	}
+	c.futureSequenceMsgs++
+	c.futureSequenceSources[src] = struct{}{}
+
+	if c.futureSequenceMsgs >= threshold && len(c.futureSequenceSources) > c.valSet.F() {
+		c.currentLogger(true, nil).Warn("QBFT: persistent future sequence messages, requesting chain sync", "messages", c.futureSequenceMsgs, "sources", len(c.futureSequenceSources))
+		syncRequestMeter.Mark(1)
+		c.resetFutureSequenceTracking()
+		// the sync window starts over, a far sequence claimed by a faulty validator does not stick
+		c.highestSeenSequence = 0
+		c.updateSequenceGap(0)
+		c.backend.RequestSync()
+	}
+}
+
+// updateSequenceGap records a sequence seen in a future message and updates the gap between the
+// highest sequence seen and the current one, a growing gap means the node is lagging behind. The gap
+// is capped at maxSequenceGap so that a far sequence can not overflow the gauge.
+func (c *core) updateSequenceGap(seen uint64) {
+	if seen > c.highestSeenSequence {
+		c.highestSeenSequence = seen
+	}
+	gap := uint64(0)
+	if current := c.current.Sequence().Uint64(); c.highestSeenSequence > current {
+		gap = c.highestSeenSequence - current
+	}
+	if gap > maxSequenceGap {
+		gap = maxSequenceGap
+	}
+	sequenceGapGauge.Update(int64(gap))
+}
+
+// resetFutureSequenceTracking is called when reaching a new sequence or requesting a sync
+func (c *core) resetFutureSequenceTracking() {
+	c.futureSequenceMsgs = 0
+	c.futureSequenceSources = nil
 }
 
 // processBacklog lookup for future messages that have been backlogged and post it on
 // the event channel so main handler loop can handle it
 
-// It is called on every state change
+// It is called on every state change. When a drain budget is set, it yields once the budget
+// is spent and resumes from the event loop so that the node stays responsive.
 func (c *core) processBacklog() {
 	c.backlogsMu.Lock()
 	defer c.backlogsMu.Unlock()
 
+	stats := &c.backlogStats
+	stats.drains++
+	for _, backlog := range c.backlogs {
+		if backlog != nil {
+			stats.size += uint64(backlog.Size())
+		}
+	}
+	defer func(start time.Time) { stats.duration += time.Since(start) }(time.Now())
+
+	c.backlogDrainPending = false
+	if c.backlogIndex != nil && c.current != nil {
+		// the old sequences are dropped at once rather than popped one message at a time
+		stats.skipped += uint64(c.pruneBacklogBelow(c.current.Sequence().Uint64()))
+	}
+	c.pruneDispatchedBacklog()
+	c.updateTrackingGauges()
+	var deadline time.Time
+	if c.backlogDrainBudget > 0 {
+		deadline = time.Now().Add(c.backlogDrainBudget)
+	}
+
 	for srcAddress, backlog := range c.backlogs {
 		if backlog == nil {
 			continue
@@ -142,7 +497,7 @@ func (c *core) processBacklog() {
 		_, src := c.valSet.GetByAddress(srcAddress)
 		if src == nil {
 			// validator is not available
-			delete(c.backlogs, srcAddress)
+			c.deleteBacklog(srcAddress)
 			continue
 		}
 		logger := c.logger.New("from", src, "state", c.state)
@@ -170,29 +525,197 @@ func (c *core) processBacklog() {
 			if err != nil {
 				if err == errFutureMessage {
 					// this is still a future message
-					logger.Trace("QBFT: stop processing backlog", "msg", m)
+					if c.logSampler.Sample() {
+						logger.Trace("QBFT: stop processing backlog", "msg", m)
+					}
 					backlog.Push(m, prio)
 					isFuture = true
+					// the messages queued behind are at least as far in the future
+					stats.future += uint64(backlog.Size())
+					stats.stopsOf(srcAddress).Future++
+					backlogStopFutureMeter.Mark(1)
 					break
 				}
-				logger.Trace("QBFT: skip backlog message", "msg", m, "err", err)
+				if c.logSampler.Sample() {
+					logger.Trace("QBFT: skip backlog message", "msg", m, "err", err)
+				}
+				stats.skipped++
+				continue
+			}
+			if c.isDispatchedBacklog(msg) {
+				// an identical message was resent while the first one was dispatched
+				backlogRedispatchMeter.Mark(1)
+				stats.skipped++
 				continue
 			}
-			logger.Trace("QBFT: post backlog event", "msg", m)
+			if c.logSampler.Sample() {
+				logger.Trace("QBFT: post backlog event", "msg", m)
+			}
 
 			event.src = src
-			go c.sendEvent(event)
+			if !c.sendEventOrdered(event) {
+				// the event loop fell behind, messages are not resent so keep this one and resume
+				// once the queued events are handled
+				backlog.Push(m, prio)
+				logger.Debug("QBFT: event queue full, defer backlog drain")
+				c.yieldBacklogDrain()
+				return
+			}
+			c.markDispatchedBacklog(msg)
+			stats.ready++
+
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				logger.Debug("QBFT: backlog drain budget spent, yield")
+				c.yieldBacklogDrain()
+				return
+			}
+		}
+		if !isFuture {
+			stats.stopsOf(srcAddress).Empty++
+			backlogStopEmptyMeter.Mark(1)
+		}
+	}
+}
+
+// backlogIdentity identifies a backlog message by its source and content, so that copies resent by a peer match
+func backlogIdentity(msg qbfttypes.QBFTMessage) (common.Hash, bool) {
+	payload, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		return common.Hash{}, false
+	}
+	return crypto.Keccak256Hash(msg.Source().Bytes(), payload), true
+}
+
+// isDispatchedBacklog returns whether an identical message was dispatched from the backlog for the current
+// view, it must be called with backlogsMu held
+func (c *core) isDispatchedBacklog(msg qbfttypes.QBFTMessage) bool {
+	id, ok := backlogIdentity(msg)
+	if !ok {
+		return false
+	}
+	_, dispatched := c.dispatchedBacklog[id]
+	return dispatched
+}
+
+// markDispatchedBacklog records that msg was dispatched from the backlog, unless maxDispatchedBacklog messages
+// already are for the current view. It must be called with backlogsMu held.
+func (c *core) markDispatchedBacklog(msg qbfttypes.QBFTMessage) {
+	id, ok := backlogIdentity(msg)
+	if !ok {
+		return
+	}
+	if c.dispatchedBacklog == nil {
+		c.dispatchedBacklog = make(map[common.Hash]*istanbul.View)
+	}
+	if len(c.dispatchedBacklog) >= maxDispatchedBacklog {
+		// a copy resent later may be dispatched again, the handlers count each validator once
+		return
+	}
+	view := msg.View()
+	c.dispatchedBacklog[id] = &view
+}
+
+// pruneDispatchedBacklog forgets the dispatched messages of the views before the current one, copies of them are
+// rejected as old messages anyway. It must be called with backlogsMu held.
+func (c *core) pruneDispatchedBacklog() {
+	current := c.currentView()
+	for id, view := range c.dispatchedBacklog {
+		if current == nil || view.Cmp(current) < 0 {
+			delete(c.dispatchedBacklog, id)
+		}
+	}
+}
+
+// updateTrackingGauges reports the sizes of the maps tracking the messages and blocks of the current view,
+// it must be called with backlogsMu held
+func (c *core) updateTrackingGauges() {
+	dispatchedBacklogGauge.Update(int64(len(c.dispatchedBacklog)))
+	rejectedProposalsGauge.Update(int64(len(c.rejectedProposals)))
+	retained := 0
+	for _, msgs := range c.retainedMessages {
+		retained += len(msgs)
+	}
+	retainedMessagesGauge.Update(int64(retained))
+}
+
+// backlogDrainStats accumulates statistics over the backlog drains, it is guarded by backlogsMu
+type backlogDrainStats struct {
+	drains   uint64        // number of drain passes
+	size     uint64        // sum of the backlog sizes when the passes started
+	ready    uint64        // messages dispatched
+	future   uint64        // messages kept for a later view
+	skipped  uint64        // old or invalid messages dropped
+	duration time.Duration // time spent draining
+
+	stops map[common.Address]*istanbul.BacklogStops // why the drains of each source stopped
+}
+
+// stopsOf returns the drain stop counters of src
+func (s *backlogDrainStats) stopsOf(src common.Address) *istanbul.BacklogStops {
+	if s.stops == nil {
+		s.stops = make(map[common.Address]*istanbul.BacklogStops)
+	}
+	stops, ok := s.stops[src]
+	if !ok {
+		stops = new(istanbul.BacklogStops)
+		s.stops[src] = stops
+	}
+	return stops
+}
+
+// BacklogStats returns the statistics accumulated over the backlog drains, to help sizing the backlog
+func (c *core) BacklogStats() *istanbul.BacklogStats {
+	c.backlogsMu.Lock()
+	defer c.backlogsMu.Unlock()
+
+	stats := c.backlogStats
+	result := &istanbul.BacklogStats{Drains: stats.drains}
+	if stats.drains > 0 {
+		result.AverageSize = float64(stats.size) / float64(stats.drains)
+		result.AverageDuration = stats.duration / time.Duration(stats.drains)
+	}
+	if inspected := stats.ready + stats.future + stats.skipped; inspected > 0 {
+		result.ReadyRatio = float64(stats.ready) / float64(inspected)
+		result.FutureRatio = float64(stats.future) / float64(inspected)
+	}
+	if len(stats.stops) > 0 {
+		result.Stops = make(map[common.Address]*istanbul.BacklogStops, len(stats.stops))
+		for src, stops := range stats.stops {
+			copied := *stops
+			result.Stops[src] = &copied
 		}
 	}
+	return result
+}
+
+// yieldBacklogDrain schedules the backlog drain to resume from the event loop,
+// it must be called with backlogsMu held
+func (c *core) yieldBacklogDrain() {
+	if c.backlogDrainPending {
+		return
+	}
+	c.backlogDrainPending = true
+	c.sendEventOrdered(backlogDrainEvent{})
 }
 
 func toPriority(msgCode uint64, view *istanbul.View) float32 {
+	return float32(exactPriority(msgCode, view))
+}
+
+// exactPriority returns the backlog priority of a message before its conversion to float32, which
+// can not represent it exactly once the sequence is above 2^24 / prioritySequenceFactor
+func exactPriority(msgCode uint64, view *istanbul.View) int64 {
+	// rounds above maxPriorityRound are capped so that they never sort after the next sequence, which
+	// always restarts at round 0 (e.g. after a validator set change at an epoch boundary)
+	round := view.Round.Uint64()
+	if round > maxPriorityRound {
+		round = maxPriorityRound
+	}
 	if msgCode == qbfttypes.RoundChangeCode {
-		// For msgRoundChange, set the message priority based on its sequence
-		return -float32(view.Sequence.Uint64() * 1000)
+		// For msgRoundChange, set the message priority based on its sequence then round: ROUND-CHANGE
+		// messages drain before the other messages of their sequence, the highest rounds first
+		return -int64(view.Sequence.Uint64()*prioritySequenceFactor + maxPriorityRound - round)
 	}
-	// FIXME: round will be reset as 0 while new sequence
-	// 10 * Round limits the range of message code is from 0 to 9
-	// 1000 * Sequence limits the range of round is from 0 to 99
-	return -float32(view.Sequence.Uint64()*1000 + view.Round.Uint64()*10 + uint64(msgPriority[msgCode]))
+	// the other messages sort after the ROUND-CHANGE band of their sequence
+	return -int64(view.Sequence.Uint64()*prioritySequenceFactor + roundChangePriorityBand + round*priorityRoundFactor + uint64(msgPriority[msgCode]))
 }
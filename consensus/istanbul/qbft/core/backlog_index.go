@@ -0,0 +1,201 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+)
+
+// backlogView is the view of a backlog message, usable as a map key
+type backlogView struct {
+	sequence uint64
+	round    uint64
+}
+
+func backlogViewOf(view *istanbul.View) backlogView {
+	return backlogView{sequence: view.Sequence.Uint64(), round: view.Round.Uint64()}
+}
+
+// backlogIndex groups the messages of all the backlogs by view, along with the queue holding each of them,
+// so that pruning the backlogs only visits the views involved and their messages rather than popping every
+// queue. It is guarded by backlogsMu.
+type backlogIndex struct {
+	views map[backlogView]map[qbfttypes.QBFTMessage]*indexedQueue
+}
+
+func newBacklogIndex() *backlogIndex {
+	return &backlogIndex{views: make(map[backlogView]map[qbfttypes.QBFTMessage]*indexedQueue)}
+}
+
+func (idx *backlogIndex) add(msg qbfttypes.QBFTMessage, q *indexedQueue) {
+	view := msg.View()
+	key := backlogViewOf(&view)
+	msgs := idx.views[key]
+	if msgs == nil {
+		msgs = make(map[qbfttypes.QBFTMessage]*indexedQueue)
+		idx.views[key] = msgs
+	}
+	msgs[msg] = q
+}
+
+func (idx *backlogIndex) remove(msg qbfttypes.QBFTMessage) {
+	view := msg.View()
+	key := backlogViewOf(&view)
+	delete(idx.views[key], msg)
+	if len(idx.views[key]) == 0 {
+		delete(idx.views, key)
+	}
+}
+
+// take removes the messages of view from the index and from their queues, and returns how many were removed
+func (idx *backlogIndex) take(key backlogView) int {
+	msgs := idx.views[key]
+	delete(idx.views, key)
+
+	queues := make(map[*indexedQueue]struct{})
+	for msg, q := range msgs {
+		q.removed[msg] = struct{}{}
+		queues[q] = struct{}{}
+	}
+	for q := range queues {
+		q.compact()
+	}
+	return len(msgs)
+}
+
+// indexedQueue is a backlog queue whose messages are indexed by view. The queues can not remove arbitrary
+// messages, the ones removed through the index stay queued until they are popped, and are dropped then.
+// A queue holding more removed messages than live ones is rebuilt, so that their memory is released.
+type indexedQueue struct {
+	queue   backlogQueue
+	index   *backlogIndex
+	removed map[qbfttypes.QBFTMessage]struct{}
+}
+
+func newIndexedQueue(queue backlogQueue, index *backlogIndex) *indexedQueue {
+	return &indexedQueue{
+		queue:   queue,
+		index:   index,
+		removed: make(map[qbfttypes.QBFTMessage]struct{}),
+	}
+}
+
+// Push adds a backlog message to the queue and to the index, the data must be a QBFT message
+func (q *indexedQueue) Push(data interface{}, priority float32) {
+	q.index.add(data.(qbfttypes.QBFTMessage), q)
+	q.queue.Push(data, priority)
+}
+
+// Pop removes the first message not removed through the index yet, and returns it with its priority
+func (q *indexedQueue) Pop() (interface{}, float32) {
+	for {
+		data, prio := q.queue.Pop()
+		msg := data.(qbfttypes.QBFTMessage)
+		if _, ok := q.removed[msg]; ok {
+			delete(q.removed, msg)
+			continue
+		}
+		q.index.remove(msg)
+		return data, prio
+	}
+}
+
+// PopItem removes the first message not removed through the index yet, and returns it
+func (q *indexedQueue) PopItem() interface{} {
+	data, _ := q.Pop()
+	return data
+}
+
+// compact rebuilds the queue without the messages removed through the index once they outnumber the others
+func (q *indexedQueue) compact() {
+	if len(q.removed) <= q.Size() {
+		return
+	}
+	var kept []interface{}
+	var prios []float32
+	for !q.queue.Empty() {
+		data, prio := q.queue.Pop()
+		if _, ok := q.removed[data.(qbfttypes.QBFTMessage)]; ok {
+			continue
+		}
+		kept = append(kept, data)
+		prios = append(prios, prio)
+	}
+	for i, data := range kept {
+		q.queue.Push(data, prios[i])
+	}
+	q.removed = make(map[qbfttypes.QBFTMessage]struct{})
+}
+
+func (q *indexedQueue) Empty() bool { return q.Size() == 0 }
+
+func (q *indexedQueue) Size() int { return q.queue.Size() - len(q.removed) }
+
+// clear removes all the messages of the queue from the index, before the queue is dropped
+func (q *indexedQueue) clear() {
+	for !q.Empty() {
+		q.Pop()
+	}
+}
+
+// deleteBacklog drops the backlog of src, it must be called with backlogsMu held
+func (c *core) deleteBacklog(src common.Address) {
+	if q, ok := c.backlogs[src].(*indexedQueue); ok {
+		q.clear()
+	}
+	delete(c.backlogs, src)
+}
+
+// pruneBacklogBelow removes the backlog messages of the sequences before sequence, which the drains would
+// skip as old messages, and returns the number of messages removed. When the backlogs are indexed by view
+// only the views of the backlog and the pruned messages are visited, otherwise every queue is walked.
+// It must be called with backlogsMu held.
+func (c *core) pruneBacklogBelow(sequence uint64) int {
+	pruned := 0
+	if c.backlogIndex != nil {
+		for key := range c.backlogIndex.views {
+			if key.sequence < sequence {
+				pruned += c.backlogIndex.take(key)
+			}
+		}
+		return pruned
+	}
+	for _, backlog := range c.backlogs {
+		if backlog == nil {
+			continue
+		}
+		// the queue can only be walked by popping it, push back the messages kept
+		var kept []qbfttypes.QBFTMessage
+		var prios []float32
+		for !backlog.Empty() {
+			m, prio := backlog.Pop()
+			msg := m.(qbfttypes.QBFTMessage)
+			if view := msg.View(); view.Sequence.Uint64() < sequence {
+				pruned++
+				continue
+			}
+			kept = append(kept, msg)
+			prios = append(prios, prio)
+		}
+		for i, msg := range kept {
+			backlog.Push(msg, prios[i])
+		}
+	}
+	return pruned
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"container/heap"
+
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+)
+
+// The backlog priorities encode the view and code of a message in a single number:
+//
+//	-(sequence * prioritySequenceFactor + band + round * priorityRoundFactor + msgPriority[code])
+//
+// which is exact for rounds from 0 to maxPriorityRound, and for sequences up to 2^24 / prioritySequenceFactor
+// only as the priorities are float32. They are kept for reporting, the backlog queues order the messages by
+// comparing their views and codes instead, which holds whatever the sequence and round.
+const (
+	prioritySequenceFactor = 1000
+	priorityRoundFactor    = 9
+)
+
+// backlogQueue is the queue of the backlog messages of a source, popped in processing order
+type backlogQueue interface {
+	Push(data interface{}, priority float32)
+	Pop() (interface{}, float32)
+	PopItem() interface{}
+	Empty() bool
+	Size() int
+}
+
+// newBacklogQueue creates the backlog queue of a source, indexed by view when configured
+func (c *core) newBacklogQueue() backlogQueue {
+	var queue backlogQueue = newCompositeQueue()
+	if c.backlogIndex != nil {
+		return newIndexedQueue(queue, c.backlogIndex)
+	}
+	return queue
+}
+
+// compositeQueue orders the backlog messages by comparing their sequence, round and code, so that the
+// order holds whatever the ranges of these, which peers choose. The priorities pushed are only kept to be returned by Pop.
+type compositeQueue struct {
+	items compositeItems
+}
+
+func newCompositeQueue() *compositeQueue {
+	return &compositeQueue{}
+}
+
+type compositeItem struct {
+	msg  qbfttypes.QBFTMessage
+	prio float32
+}
+
+type compositeItems []compositeItem
+
+func (q compositeItems) Len() int            { return len(q) }
+func (q compositeItems) Less(i, j int) bool  { return backlogBefore(q[i].msg, q[j].msg) }
+func (q compositeItems) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *compositeItems) Push(x interface{}) { *q = append(*q, x.(compositeItem)) }
+func (q *compositeItems) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// Push adds a backlog message, the data must be a QBFT message
+func (q *compositeQueue) Push(data interface{}, priority float32) {
+	heap.Push(&q.items, compositeItem{msg: data.(qbfttypes.QBFTMessage), prio: priority})
+}
+
+// Pop removes the first message and returns it with its pushed priority
+func (q *compositeQueue) Pop() (interface{}, float32) {
+	item := heap.Pop(&q.items).(compositeItem)
+	return item.msg, item.prio
+}
+
+// PopItem removes the first message and returns it
+func (q *compositeQueue) PopItem() interface{} {
+	msg, _ := q.Pop()
+	return msg
+}
+
+func (q *compositeQueue) Empty() bool { return len(q.items) == 0 }
+
+func (q *compositeQueue) Size() int { return len(q.items) }
+
+// backlogBefore reports whether a must be processed before b, in the order of the backlog priorities
+// without their range limits: lowest sequence first, then the ROUND-CHANGE messages from the highest
+// round, then the other messages by round and code
+func backlogBefore(a, b qbfttypes.QBFTMessage) bool {
+	va, vb := a.View(), b.View()
+	if cmp := va.Sequence.Cmp(vb.Sequence); cmp != 0 {
+		return cmp < 0
+	}
+	rcA, rcB := a.Code() == qbfttypes.RoundChangeCode, b.Code() == qbfttypes.RoundChangeCode
+	if rcA != rcB {
+		return rcA
+	}
+	if cmp := va.Round.Cmp(vb.Round); cmp != 0 {
+		if rcA {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	return msgPriority[a.Code()] < msgPriority[b.Code()]
+}
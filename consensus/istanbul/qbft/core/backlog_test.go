@@ -0,0 +1,1213 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+func newFuturePrepare(sequence int64, src common.Address) qbfttypes.QBFTMessage {
+	return signedBy(qbfttypes.NewPrepare(big.NewInt(sequence), big.NewInt(0), common.Hash{}), src)
+}
+
+func TestPersistentFutureMessagesRequestSync(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.FutureMessageSyncThreshold = 6
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+	backend := c.backend.(*testBackend)
+
+	// messages from a single validator are not enough, it could be faulty
+	for i := 0; i < 10; i++ {
+		c.addToBacklog(newFuturePrepare(3, valSet.GetByIndex(1).Address()))
+	}
+	if backend.syncRequests != 0 {
+		t.Fatalf("sync requests mismatch: have %v, want 0", backend.syncRequests)
+	}
+
+	// once F+1 validators sent future sequence messages a sync is requested
+	c.addToBacklog(newFuturePrepare(3, valSet.GetByIndex(2).Address()))
+	if backend.syncRequests != 1 {
+		t.Fatalf("sync requests mismatch: have %v, want 1", backend.syncRequests)
+	}
+
+	// tracking restarts after the sync request
+	for i := 0; i < 5; i++ {
+		c.addToBacklog(newFuturePrepare(4, valSet.GetByIndex(uint64(i%2+1)).Address()))
+	}
+	if backend.syncRequests != 1 {
+		t.Fatalf("sync requests mismatch: have %v, want 1", backend.syncRequests)
+	}
+	c.addToBacklog(newFuturePrepare(4, valSet.GetByIndex(3).Address()))
+	if backend.syncRequests != 2 {
+		t.Fatalf("sync requests mismatch: have %v, want 2", backend.syncRequests)
+	}
+}
+
+func TestFutureRoundMessagesDoNotRequestSync(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.FutureMessageSyncThreshold = 1
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+
+	// messages for a future round of the current sequence do not mean the node is behind
+	for i := 1; i < valSet.Size(); i++ {
+		prepare := qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(3), common.Hash{})
+		c.addToBacklog(signedBy(prepare, valSet.GetByIndex(uint64(i)).Address()))
+	}
+	if requests := c.backend.(*testBackend).syncRequests; requests != 0 {
+		t.Errorf("sync requests mismatch: have %v, want 0", requests)
+	}
+}
+
+func TestBacklogRejectsSpoofedSource(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	signer := valSet.GetByIndex(1).Address()
+	victim := valSet.GetByIndex(2).Address()
+
+	// message signed by a validator but claiming to come from another one
+	spoofed := newFuturePrepare(3, signer)
+	spoofed.SetSource(victim)
+	c.addToBacklog(spoofed)
+	if backlog := c.backlogs[victim]; backlog != nil && !backlog.Empty() {
+		t.Errorf("spoofed message should not be stored in the claimed source backlog")
+	}
+	if backlog := c.backlogs[signer]; backlog != nil && !backlog.Empty() {
+		t.Errorf("spoofed message should not be stored in the signer backlog")
+	}
+
+	c.addToBacklog(newFuturePrepare(3, signer))
+	if backlog := c.backlogs[signer]; backlog == nil || backlog.Size() != 1 {
+		t.Errorf("authentic message should be stored in the signer backlog")
+	}
+}
+
+func TestBacklogSignerRecoveredOnce(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	validate := c.validateFn
+	recovered := 0
+	c.validateFn = func(data []byte, sig []byte) (common.Address, error) {
+		recovered++
+		return validate(data, sig)
+	}
+
+	// a message backlogged again, as when it is still in the future on drain, is not re-verified
+	prepare := newFuturePrepare(3, valSet.GetByIndex(1).Address())
+	c.addToBacklog(prepare)
+	c.addToBacklog(prepare)
+	if recovered != 1 {
+		t.Errorf("signer recoveries mismatch: have %d, want 1", recovered)
+	}
+
+	// a new signature invalidates the cached signer
+	prepare.SetSignature(valSet.GetByIndex(2).Address().Bytes())
+	c.addToBacklog(prepare)
+	if recovered != 2 {
+		t.Errorf("signer recoveries mismatch: have %d, want 2", recovered)
+	}
+}
+
+func TestBacklogSourcesBounded(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	limit := valSet.Size() + backlogSourceSlack
+
+	// messages claiming many distinct sources, the test validateFn accepts any of them
+	for i := 0; i < 1000; i++ {
+		c.addToBacklog(newFuturePrepare(3, common.BigToAddress(big.NewInt(int64(i+1)))))
+		if len(c.backlogs) > limit {
+			t.Fatalf("backlog sources mismatch after %d sources: have %d, want at most %d", i+1, len(c.backlogs), limit)
+		}
+	}
+
+	// the validators still get a backlog, room is made by dropping the other sources
+	for _, v := range valSet.List()[1:] {
+		c.addToBacklog(newFuturePrepare(3, v.Address()))
+		if backlog := c.backlogs[v.Address()]; backlog == nil || backlog.Size() != 1 {
+			t.Errorf("validator %v message not backlogged", v.Address())
+		}
+	}
+	if len(c.backlogs) > limit {
+		t.Errorf("backlog sources mismatch: have %d, want at most %d", len(c.backlogs), limit)
+	}
+}
+
+func TestCheckMessageInvalidReasons(t *testing.T) {
+	// count with forced meters, whether metrics are enabled or not
+	meters := invalidMessageMeters
+	defer func() { invalidMessageMeters = meters }()
+
+	config := *istanbul.DefaultConfig
+	config.FutureRoundWindow = 10
+	c := newTestCore(&config, newTestValidatorSet(4))
+	view := c.currentView()
+	testCases := []struct {
+		name   string
+		state  State
+		code   uint64
+		view   *istanbul.View
+		reason string
+	}{
+		{"nil view", StateAcceptRequest, qbfttypes.PrepareCode, nil, invalidMessageMalformedView},
+		{"nil round", StateAcceptRequest, qbfttypes.PrepareCode, &istanbul.View{Sequence: view.Sequence}, invalidMessageMalformedView},
+		{"PRE-PREPARE when preprepared", StatePreprepared, qbfttypes.PreprepareCode, view, invalidMessageWrongState},
+		{"PREPARE when prepared", StatePrepared, qbfttypes.PrepareCode, view, invalidMessageWrongState},
+		{"COMMIT when committed", StateCommitted, qbfttypes.CommitCode, view, invalidMessageCommitted},
+		{"PREPARE beyond the round window", StateAcceptRequest, qbfttypes.PrepareCode, &istanbul.View{Sequence: view.Sequence, Round: big.NewInt(11)}, invalidMessageFarRound},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			invalidMessageMeters = map[string]metrics.Meter{
+				invalidMessageMalformedView: metrics.NewMeterForced(),
+				invalidMessageWrongState:    metrics.NewMeterForced(),
+				invalidMessageCommitted:     metrics.NewMeterForced(),
+				invalidMessageFarRound:      metrics.NewMeterForced(),
+			}
+			c.state = test.state
+			if err := c.checkMessage(test.code, test.view); err != errInvalidMessage {
+				t.Fatalf("error mismatch: have %v, want %v", err, errInvalidMessage)
+			}
+			for reason, meter := range invalidMessageMeters {
+				want := int64(0)
+				if reason == test.reason {
+					want = 1
+				}
+				if meter.Count() != want {
+					t.Errorf("%s count mismatch: have %d, want %d", reason, meter.Count(), want)
+				}
+				meter.Stop()
+			}
+		})
+	}
+}
+
+func TestFutureRoundWindow(t *testing.T) {
+	testCases := []struct {
+		name     string
+		window   uint64
+		code     uint64
+		sequence int64
+		round    int64
+		err      error
+	}{
+		{"PREPARE at the edge of the window", 10, qbfttypes.PrepareCode, 1, 10, errFutureMessage},
+		{"PREPARE beyond the window", 10, qbfttypes.PrepareCode, 1, 11, errInvalidMessage},
+		{"PREPARE at an extreme round", 10, qbfttypes.PrepareCode, 1, 10000, errInvalidMessage},
+		{"ROUND-CHANGE at the edge of the window", 10, qbfttypes.RoundChangeCode, 1, 10, nil},
+		{"ROUND-CHANGE at an extreme round", 10, qbfttypes.RoundChangeCode, 1, 10000, nil},
+		{"extreme round of a future sequence", 10, qbfttypes.PrepareCode, 2, 10000, errFutureMessage},
+		{"extreme round without window", 0, qbfttypes.PrepareCode, 1, 10000, errFutureMessage},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := *istanbul.DefaultConfig
+			config.FutureRoundWindow = test.window
+			c := newTestCore(&config, newTestValidatorSet(4))
+
+			view := &istanbul.View{Sequence: big.NewInt(test.sequence), Round: big.NewInt(test.round)}
+			if err := c.checkMessage(test.code, view); err != test.err {
+				t.Errorf("error mismatch: have %v, want %v", err, test.err)
+			}
+		})
+	}
+
+	// the window follows the current round
+	config := *istanbul.DefaultConfig
+	config.FutureRoundWindow = 10
+	c := newTestCore(&config, newTestValidatorSet(4))
+	c.current.SetRound(big.NewInt(5))
+	if err := c.checkMessage(qbfttypes.PrepareCode, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(15)}); err != errFutureMessage {
+		t.Errorf("error mismatch: have %v, want %v", err, errFutureMessage)
+	}
+}
+
+func TestBacklogDeferredEventNotDispatched(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePreprepared
+	// the queue is always full, every backlog event is deferred
+	c.eventQueue.Limit = -1
+	src := valSet.GetByIndex(1).Address()
+	prepare := signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), src)
+
+	c.addToBacklog(prepare)
+	c.processBacklog()
+	if c.isDispatchedBacklog(prepare) {
+		t.Errorf("deferred backlog message recorded as dispatched")
+	}
+	if stats := c.BacklogStats(); stats.ReadyRatio != 0 {
+		t.Errorf("ready ratio mismatch: have %v, want 0", stats.ReadyRatio)
+	}
+
+	// the message is kept in the backlog and the drain resumes from the event loop
+	if backlog := c.backlogs[src]; backlog == nil || backlog.Size() != 1 {
+		t.Errorf("deferred message not kept in the backlog")
+	}
+	if !c.backlogDrainPending {
+		t.Errorf("backlog drain not resumed")
+	}
+}
+
+func TestProcessBacklogDrainBudget(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePreprepared
+	// spend the budget on every message
+	c.backlogDrainBudget = time.Nanosecond
+
+	const perValidator = 100
+	for i := 1; i < valSet.Size(); i++ {
+		for j := 0; j < perValidator; j++ {
+			prepare := qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.BigToHash(big.NewInt(int64(j))))
+			c.addToBacklog(signedBy(prepare, valSet.GetByIndex(uint64(i)).Address()))
+		}
+	}
+	total := perValidator * (valSet.Size() - 1)
+
+	sub := c.backend.EventMux().Subscribe(backlogEvent{}, backlogDrainEvent{})
+	defer sub.Unsubscribe()
+
+	// each pass yields after the budget is spent, the drain resumes from the event loop
+	passes, processed := 1, 0
+	c.processBacklog()
+	for processed < total {
+		select {
+		case ev := <-sub.Chan():
+			switch ev.Data.(type) {
+			case backlogEvent:
+				processed++
+			case backlogDrainEvent:
+				passes++
+				c.processBacklog()
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("backlog drain stalled: have %d processed, want %d", processed, total)
+		}
+	}
+	if passes < 2 {
+		t.Errorf("passes mismatch: have %d, want several", passes)
+	}
+	for addr, backlog := range c.backlogs {
+		if !backlog.Empty() {
+			t.Errorf("backlog of %v not drained: %d messages left", addr, backlog.Size())
+		}
+	}
+}
+
+// Consensus messages are not resent, the backlog messages which do not fit in a full event queue are
+// kept in the backlog and dispatched once the event loop catches up
+func TestProcessBacklogFullEventQueue(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePreprepared
+	c.eventQueue.Limit = 8
+
+	const perValidator = 100
+	for i := 1; i < valSet.Size(); i++ {
+		for j := 0; j < perValidator; j++ {
+			prepare := qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.BigToHash(big.NewInt(int64(j))))
+			c.addToBacklog(signedBy(prepare, valSet.GetByIndex(uint64(i)).Address()))
+		}
+	}
+	total := perValidator * (valSet.Size() - 1)
+	deferred := deferredEventMeter.Count()
+
+	sub := c.backend.EventMux().Subscribe(backlogEvent{}, backlogDrainEvent{})
+	defer sub.Unsubscribe()
+
+	// the event loop handles the events as they come, resuming the drain when asked to
+	delivered := make(map[common.Address]map[common.Hash]bool)
+	processed := 0
+	c.processBacklog()
+	for processed < total {
+		select {
+		case ev := <-sub.Chan():
+			switch e := ev.Data.(type) {
+			case backlogEvent:
+				src := e.msg.Source()
+				if delivered[src] == nil {
+					delivered[src] = make(map[common.Hash]bool)
+				}
+				digest := e.msg.(*qbfttypes.Prepare).Digest
+				if delivered[src][digest] {
+					t.Fatalf("message from %v delivered twice: %v", src, digest)
+				}
+				delivered[src][digest] = true
+				processed++
+			case backlogDrainEvent:
+				c.processBacklog()
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("backlog messages lost: have %d delivered, want %d", processed, total)
+		}
+	}
+	for addr, backlog := range c.backlogs {
+		if !backlog.Empty() {
+			t.Errorf("backlog of %v not drained: %d messages left", addr, backlog.Size())
+		}
+	}
+	if n := deferredEventMeter.Count() - deferred; metrics.Enabled && n == 0 {
+		t.Error("no backlog event deferred, the event queue never filled up")
+	}
+}
+
+func TestProcessBacklogOrdering(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	src := valSet.GetByIndex(1).Address()
+	msgs := []qbfttypes.QBFTMessage{
+		signedBy(qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), common.Hash{}, nil), src),
+		newTestRoundChange(0, src),
+		newTestRoundChange(2, src),
+		newTestRoundChange(1, src),
+		newFuturePrepare(2, src),
+	}
+	// whatever order messages are backlogged in, they must be dispatched by priority, ahead of the pending
+	// request dispatched after them
+	want := []dispatchedMessage{
+		{qbfttypes.RoundChangeCode, 2},
+		{qbfttypes.RoundChangeCode, 1},
+		{qbfttypes.RoundChangeCode, 0},
+		{qbfttypes.CommitCode, 0},
+	}
+
+	var permute func(prefix, rest []qbfttypes.QBFTMessage)
+	permute = func(prefix, rest []qbfttypes.QBFTMessage) {
+		if len(rest) == 0 {
+			for i := 0; i < 3; i++ {
+				testProcessBacklogOrdering(t, valSet, prefix, want)
+			}
+			return
+		}
+		for i := range rest {
+			next := append(append([]qbfttypes.QBFTMessage{}, rest[:i]...), rest[i+1:]...)
+			permute(append(append([]qbfttypes.QBFTMessage{}, prefix...), rest[i]), next)
+		}
+	}
+	permute(nil, msgs)
+}
+
+// dispatchedMessage is the code and round of a backlog message expected to be dispatched
+type dispatchedMessage struct {
+	code  uint64
+	round int64
+}
+
+func testProcessBacklogOrdering(t *testing.T, valSet istanbul.ValidatorSet, msgs []qbfttypes.QBFTMessage, want []dispatchedMessage) {
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePrepared
+	sub := c.backend.EventMux().Subscribe(backlogEvent{}, istanbul.RequestEvent{})
+	defer sub.Unsubscribe()
+
+	for _, msg := range msgs {
+		c.addToBacklog(msg)
+	}
+	c.storeRequestMsg(&Request{Proposal: makeBlock(2)})
+	c.storeRequestMsg(&Request{Proposal: makeBlock(1)})
+	c.processBacklog()
+	c.processPendingRequests()
+
+	timeout := time.NewTimer(2 * time.Second)
+	defer timeout.Stop()
+	for i := 0; i <= len(want); i++ {
+		select {
+		case ev := <-sub.Chan():
+			switch e := ev.Data.(type) {
+			case backlogEvent:
+				if i == len(want) {
+					t.Fatalf("event %d: backlog event after the request event", i)
+				}
+				if view := e.msg.View(); e.msg.Code() != want[i].code || view.Round.Int64() != want[i].round {
+					t.Fatalf("event %d mismatch: have code %#x round %v, want code %#x round %d", i, e.msg.Code(), view.Round, want[i].code, want[i].round)
+				}
+			case istanbul.RequestEvent:
+				if i != len(want) {
+					t.Fatalf("event %d: request event ahead of the backlog events", i)
+				}
+				if number := e.Proposal.Number().Uint64(); number != 1 {
+					t.Fatalf("request mismatch: have block %d, want 1", number)
+				}
+			default:
+				t.Fatalf("unexpected event comes: %v", reflect.TypeOf(ev.Data))
+			}
+		case <-timeout.C:
+			t.Fatalf("unexpected timeout occurs at event %d", i)
+		}
+	}
+	// the future messages and requests stay queued
+	if size := c.backlogs[msgs[0].Source()].Size(); size != 1 {
+		t.Errorf("backlog size mismatch: have %d, want 1", size)
+	}
+	if size := c.pendingRequests.Size(); size != 1 {
+		t.Errorf("pending requests mismatch: have %d, want 1", size)
+	}
+}
+
+func TestProbeBacklog(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePreprepared
+	src := valSet.GetByIndex(1).Address()
+
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(0), big.NewInt(0), common.Hash{}), src))
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), src))
+	c.addToBacklog(signedBy(qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), common.Hash{}, nil), src))
+	c.addToBacklog(newFuturePrepare(3, src))
+
+	testCases := []struct {
+		name     string
+		sequence int64
+		results  map[uint64]string
+	}{
+		{"old", 0, map[uint64]string{qbfttypes.PrepareCode: backlogProbeOld}},
+		{"current", 1, map[uint64]string{qbfttypes.PrepareCode: backlogProbeAccepted, qbfttypes.CommitCode: backlogProbeFuture}},
+		{"future", 3, map[uint64]string{qbfttypes.PrepareCode: backlogProbeFuture}},
+		{"none", 2, map[uint64]string{}},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			probes := c.ProbeBacklog(src, istanbul.View{Sequence: big.NewInt(test.sequence), Round: big.NewInt(0)})
+			if len(probes) != len(test.results) {
+				t.Fatalf("probes mismatch: have %d, want %d", len(probes), len(test.results))
+			}
+			for _, probe := range probes {
+				if want := test.results[probe.Code]; probe.Result != want {
+					t.Errorf("result mismatch for code %#x: have %s, want %s", probe.Code, probe.Result, want)
+				}
+			}
+		})
+	}
+
+	// invalid messages report the reason, and probing does not consume the backlog
+	c.state = StateCommitted
+	probes := c.ProbeBacklog(src, istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+	for _, probe := range probes {
+		if probe.Result != backlogProbeInvalid || probe.Reason != invalidMessageCommitted {
+			t.Errorf("probe mismatch for code %#x: have %s (%s), want %s (%s)", probe.Code, probe.Result, probe.Reason, backlogProbeInvalid, invalidMessageCommitted)
+		}
+	}
+	if size := c.backlogs[src].Size(); size != 4 {
+		t.Errorf("backlog size mismatch: have %d, want 4", size)
+	}
+}
+
+func TestProbeBacklogExplanation(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePreprepared
+	src, other := valSet.GetByIndex(1).Address(), valSet.GetByIndex(2).Address()
+
+	// queued in order: old PREPARE, COMMIT not accepted before PREPARE, PREPARE for the current view,
+	// PREPARE for a future round, PREPARE for a future sequence
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(0), big.NewInt(0), common.Hash{}), src))
+	c.addToBacklog(signedBy(qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), common.Hash{}, nil), src))
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), src))
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(2), common.Hash{}), src))
+	c.addToBacklog(newFuturePrepare(3, src))
+	// nothing queued ahead
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), other))
+
+	testCases := []struct {
+		name        string
+		src         common.Address
+		sequence    int64
+		round       int64
+		explanation map[uint64]string
+	}{
+		{"old", src, 0, 0, map[uint64]string{qbfttypes.PrepareCode: "older than the current view, dropped on the next backlog drain"}},
+		{"blocked", src, 1, 0, map[uint64]string{
+			qbfttypes.CommitCode:  "not accepted yet in state Preprepared",
+			qbfttypes.PrepareCode: "blocked behind 1 higher-priority messages",
+		}},
+		{"future round", src, 1, 2, map[uint64]string{qbfttypes.PrepareCode: "future by 2 rounds"}},
+		{"future sequence", src, 3, 0, map[uint64]string{qbfttypes.PrepareCode: "future by 2 sequences"}},
+		{"ready", other, 1, 0, map[uint64]string{qbfttypes.PrepareCode: "ready, dispatched on the next backlog drain"}},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			probes := c.ProbeBacklog(test.src, istanbul.View{Sequence: big.NewInt(test.sequence), Round: big.NewInt(test.round)})
+			if len(probes) != len(test.explanation) {
+				t.Fatalf("probes mismatch: have %d, want %d", len(probes), len(test.explanation))
+			}
+			for _, probe := range probes {
+				if want := test.explanation[probe.Code]; probe.Explanation != want {
+					t.Errorf("explanation mismatch for code %#x: have %q, want %q", probe.Code, probe.Explanation, want)
+				}
+			}
+		})
+	}
+
+	// invalid messages are explained with the reason of their rejection
+	c.state = StateCommitted
+	probes := c.ProbeBacklog(src, istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+	want := "invalid (" + invalidMessageCommitted + "), dropped on the next backlog drain"
+	for _, probe := range probes {
+		if probe.Explanation != want {
+			t.Errorf("explanation mismatch for code %#x: have %q, want %q", probe.Code, probe.Explanation, want)
+		}
+	}
+}
+
+func TestBacklogStats(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePreprepared
+	src := valSet.GetByIndex(1).Address()
+
+	if stats := c.BacklogStats(); stats.Drains != 0 || stats.AverageSize != 0 || stats.ReadyRatio != 0 {
+		t.Fatalf("unexpected statistics before any drain: %+v", stats)
+	}
+
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(0), big.NewInt(0), common.Hash{}), src))
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), src))
+	c.addToBacklog(newFuturePrepare(3, src))
+	c.addToBacklog(newFuturePrepare(4, src))
+
+	// first drain dispatches one message, drops the old one and keeps the two future ones
+	c.processBacklog()
+	stats := c.BacklogStats()
+	if stats.Drains != 1 || stats.AverageSize != 4 {
+		t.Errorf("first drain mismatch: have %d drains of average size %v, want 1 of 4", stats.Drains, stats.AverageSize)
+	}
+	if stats.ReadyRatio != 0.25 || stats.FutureRatio != 0.5 {
+		t.Errorf("first drain ratios mismatch: have ready %v future %v, want 0.25 and 0.5", stats.ReadyRatio, stats.FutureRatio)
+	}
+
+	// second drain only finds the two future messages
+	c.processBacklog()
+	stats = c.BacklogStats()
+	if stats.Drains != 2 || stats.AverageSize != 3 {
+		t.Errorf("second drain mismatch: have %d drains of average size %v, want 2 of 3", stats.Drains, stats.AverageSize)
+	}
+	if want := 1.0 / 6; stats.ReadyRatio != want || stats.FutureRatio != 4*want {
+		t.Errorf("second drain ratios mismatch: have ready %v future %v, want %v and %v", stats.ReadyRatio, stats.FutureRatio, want, 4*want)
+	}
+	if stats.AverageDuration <= 0 {
+		t.Errorf("average duration mismatch: have %v, want positive", stats.AverageDuration)
+	}
+}
+
+func TestBacklogDrainStops(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePreprepared
+	reordered, ordered := valSet.GetByIndex(1).Address(), valSet.GetByIndex(2).Address()
+
+	// the first source is ahead of this node, its messages for sequence 3 arrive before the current ones
+	c.addToBacklog(newFuturePrepare(3, reordered))
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), reordered))
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), ordered))
+
+	futureStops, emptyStops := backlogStopFutureMeter.Count(), backlogStopEmptyMeter.Count()
+	c.processBacklog()
+	c.processBacklog()
+
+	stops := c.BacklogStats().Stops
+	if s := stops[reordered]; s == nil || s.Future != 2 || s.Empty != 0 {
+		t.Errorf("stops mismatch for the reordered source: have %+v, want 2 future stops", s)
+	}
+	if s := stops[ordered]; s == nil || s.Future != 0 || s.Empty != 2 {
+		t.Errorf("stops mismatch for the ordered source: have %+v, want 2 empty stops", s)
+	}
+	if have := backlogStopFutureMeter.Count() - futureStops; metrics.Enabled && have != 2 {
+		t.Errorf("future stop meter mismatch: have %d, want 2", have)
+	}
+	if have := backlogStopEmptyMeter.Count() - emptyStops; metrics.Enabled && have != 2 {
+		t.Errorf("empty stop meter mismatch: have %d, want 2", have)
+	}
+}
+
+func TestBacklogPrioritiesRevealCollisions(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	src := valSet.GetByIndex(1).Address()
+
+	// float32 can not tell a PREPARE from a COMMIT of the same view this far in the chain
+	far := big.NewInt(20000000)
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(far, big.NewInt(0), common.Hash{}), src))
+	c.addToBacklog(signedBy(qbfttypes.NewCommit(far, big.NewInt(0), common.Hash{}, nil), src))
+	// while early sequences keep them apart
+	c.addToBacklog(newFuturePrepare(3, src))
+	c.addToBacklog(signedBy(qbfttypes.NewCommit(big.NewInt(3), big.NewInt(0), common.Hash{}, nil), src))
+
+	priorities := c.BacklogPriorities()
+	if len(priorities) != 4 {
+		t.Fatalf("priorities size mismatch: have %d, want 4", len(priorities))
+	}
+	for _, p := range priorities {
+		if p.Source != src {
+			t.Errorf("source mismatch: have %v, want %v", p.Source, src)
+		}
+		if want := p.Sequence == far.Uint64(); p.Collides != want {
+			t.Errorf("collision mismatch for code %#x at sequence %d: have %v, want %v", p.Code, p.Sequence, p.Collides, want)
+		}
+		if p.Collides && int64(p.Priority) == p.ExactPriority {
+			t.Errorf("colliding priority %v should differ from the exact one %d", p.Priority, p.ExactPriority)
+		}
+	}
+	if p := priorities[0]; p.Sequence != 3 || p.Priority != -3102 || p.ExactPriority != -3102 {
+		t.Errorf("first priority mismatch: have sequence %d priority %v exact %d, want the COMMIT of sequence 3 at -3102", p.Sequence, p.Priority, p.ExactPriority)
+	}
+
+	// the export leaves the backlog untouched
+	if size := c.backlogs[src].Size(); size != 4 {
+		t.Errorf("backlog size mismatch: have %d, want 4", size)
+	}
+}
+
+func TestRoundChangePriorityOrder(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	src := valSet.GetByIndex(1).Address()
+
+	newRoundChange := func(sequence, round int64) qbfttypes.QBFTMessage {
+		return signedBy(qbfttypes.NewRoundChange(big.NewInt(sequence), big.NewInt(round), nil, nil), src)
+	}
+	c.addToBacklog(newFuturePrepare(2, src))
+	c.addToBacklog(newRoundChange(2, 1))
+	c.addToBacklog(newRoundChange(2, 5))
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), src))
+	c.addToBacklog(newRoundChange(2, 3))
+
+	// the previous sequence first, then the ROUND-CHANGE messages by decreasing round, then the others
+	want := []struct {
+		code     uint64
+		sequence uint64
+		round    uint64
+	}{
+		{qbfttypes.PrepareCode, 1, 0},
+		{qbfttypes.RoundChangeCode, 2, 5},
+		{qbfttypes.RoundChangeCode, 2, 3},
+		{qbfttypes.RoundChangeCode, 2, 1},
+		{qbfttypes.PrepareCode, 2, 0},
+	}
+	priorities := c.BacklogPriorities()
+	if len(priorities) != len(want) {
+		t.Fatalf("backlog size mismatch: have %d, want %d", len(priorities), len(want))
+	}
+	for i, p := range priorities {
+		if p.Code != want[i].code || p.Sequence != want[i].sequence || p.Round != want[i].round {
+			t.Errorf("message %d mismatch: have code %#x sequence %d round %d, want code %#x sequence %d round %d",
+				i, p.Code, p.Sequence, p.Round, want[i].code, want[i].sequence, want[i].round)
+		}
+		if i > 0 && p.Priority >= priorities[i-1].Priority {
+			t.Errorf("message %d priority %v should be below the previous one %v", i, p.Priority, priorities[i-1].Priority)
+		}
+	}
+}
+
+func TestRoundResetsAcrossEpoch(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	backend := c.backend.(*testBackend)
+	src := valSet.GetByIndex(1).Address()
+
+	// the previous epoch went through many rounds
+	c.startNewRound(big.NewInt(120))
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(150), common.Hash{}), src))
+	c.addToBacklog(newFuturePrepare(2, src))
+
+	// high rounds of a sequence must not sort after the next sequence
+	if high, next := toPriority(qbfttypes.PrepareCode, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(150)}),
+		toPriority(qbfttypes.PreprepareCode, &istanbul.View{Sequence: big.NewInt(2), Round: big.NewInt(0)}); high <= next {
+		t.Errorf("priority mismatch: have %v for sequence 1 round 150, want above %v for sequence 2 round 0", high, next)
+	}
+
+	// the epoch block changes the validator set
+	epochValSet := valSet.Copy()
+	epochValSet.AddValidator(common.StringToAddress("newcomer"))
+	backend.peers = epochValSet
+	backend.lastProposal = makeBlock(1)
+	c.startNewRound(common.Big0)
+
+	if view := c.currentView(); view.Sequence.Uint64() != 2 || view.Round.Uint64() != 0 {
+		t.Fatalf("view mismatch: have %v, want sequence 2 round 0", view)
+	}
+	if c.valSet != epochValSet || c.roundChangeSet.validatorSet != epochValSet {
+		t.Errorf("validator set of the new epoch not in use")
+	}
+
+	// the stale high round message is dropped, the message for the new sequence is kept
+	c.processBacklog()
+	backlog := c.backlogs[src]
+	if backlog.Size() != 1 {
+		t.Fatalf("backlog size mismatch: have %d, want 1", backlog.Size())
+	}
+	msg, _ := backlog.Pop()
+	if view := msg.(qbfttypes.QBFTMessage).View(); view.Sequence.Uint64() != 2 || view.Round.Uint64() != 0 {
+		t.Errorf("backlogged view mismatch: have %v, want sequence 2 round 0", view)
+	}
+}
+
+func TestSequenceGapGauge(t *testing.T) {
+	defer func(gauge metrics.Gauge) { sequenceGapGauge = gauge }(sequenceGapGauge)
+	sequenceGapGauge = new(metrics.StandardGauge)
+
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	src := valSet.GetByIndex(1).Address()
+
+	// current sequence is 1
+	c.addToBacklog(newFuturePrepare(4, src))
+	if gap := sequenceGapGauge.Value(); gap != 3 {
+		t.Errorf("gap mismatch: have %d, want 3", gap)
+	}
+	c.addToBacklog(newFuturePrepare(6, src))
+	c.addToBacklog(newFuturePrepare(5, src))
+	if gap := sequenceGapGauge.Value(); gap != 5 {
+		t.Errorf("gap mismatch: have %d, want 5", gap)
+	}
+
+	// the gap shrinks as the node catches up, and closes once it reaches the network
+	c.backend.(*testBackend).lastProposal = makeBlock(3)
+	c.startNewRound(common.Big0)
+	if gap := sequenceGapGauge.Value(); gap != 2 {
+		t.Errorf("gap mismatch: have %d, want 2", gap)
+	}
+	c.backend.(*testBackend).lastProposal = makeBlock(6)
+	c.startNewRound(common.Big0)
+	if gap := sequenceGapGauge.Value(); gap != 0 {
+		t.Errorf("gap mismatch: have %d, want 0", gap)
+	}
+
+	// a far sequence is capped instead of overflowing the gauge
+	far := new(big.Int).Lsh(common.Big1, 64)
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(far, big.NewInt(0), common.Hash{}), src))
+	if gap := sequenceGapGauge.Value(); gap != maxSequenceGap {
+		t.Errorf("gap mismatch: have %d, want %d", gap, maxSequenceGap)
+	}
+}
+
+func TestSequenceGapResetOnSync(t *testing.T) {
+	defer func(gauge metrics.Gauge) { sequenceGapGauge = gauge }(sequenceGapGauge)
+	sequenceGapGauge = new(metrics.StandardGauge)
+
+	config := *istanbul.DefaultConfig
+	config.FutureMessageSyncThreshold = 2
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+
+	// the far sequence seen before the sync request does not stick, only the ones seen since count
+	c.addToBacklog(newFuturePrepare(1000, valSet.GetByIndex(1).Address()))
+	if gap := sequenceGapGauge.Value(); gap != 999 {
+		t.Errorf("gap mismatch: have %d, want 999", gap)
+	}
+	c.addToBacklog(newFuturePrepare(3, valSet.GetByIndex(2).Address()))
+	if requests := c.backend.(*testBackend).syncRequests; requests != 1 {
+		t.Fatalf("sync requests mismatch: have %d, want 1", requests)
+	}
+	if gap := sequenceGapGauge.Value(); gap != 2 {
+		t.Errorf("gap mismatch: have %d, want 2", gap)
+	}
+}
+
+func TestCheckMessageBeforeViewInitialized(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	c.current = nil
+
+	view := &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)}
+	for _, code := range []uint64{qbfttypes.PreprepareCode, qbfttypes.PrepareCode, qbfttypes.CommitCode, qbfttypes.RoundChangeCode} {
+		if err := c.checkMessage(code, view); err != errFutureMessage {
+			t.Errorf("code %#x: error mismatch: have %v, want %v", code, err, errFutureMessage)
+		}
+	}
+
+	// the message is backlogged until the view is initialized
+	src := valSet.GetByIndex(1).Address()
+	preprepare := signedBy(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), makeBlock(1)), src)
+	if err := c.handleDecodedMessage(preprepare); err != errFutureMessage {
+		t.Errorf("error mismatch: have %v, want %v", err, errFutureMessage)
+	}
+	if size := c.backlogs[src].Size(); size != 1 {
+		t.Errorf("backlog size mismatch: have %d, want 1", size)
+	}
+
+	c.startNewRound(common.Big0)
+	if err := c.checkMessage(qbfttypes.PreprepareCode, view); err != nil {
+		t.Errorf("error mismatch once initialized: have %v, want nil", err)
+	}
+}
+
+func TestBacklogOrderAtSameView(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	src := valSet.GetByIndex(1).Address()
+	sequence, round := big.NewInt(2), big.NewInt(0)
+	proposal := makeBlock(2)
+	msgs := []qbfttypes.QBFTMessage{
+		signedBy(qbfttypes.NewPrepare(sequence, round, proposal.Hash()), src),
+		signedBy(qbfttypes.NewCommit(sequence, round, proposal.Hash(), nil), src),
+		signedBy(qbfttypes.NewPreprepare(sequence, round, proposal), src),
+		signedBy(qbfttypes.NewRoundChange(sequence, round, nil, nil), src),
+	}
+	want := []uint64{qbfttypes.RoundChangeCode, qbfttypes.PreprepareCode, qbfttypes.CommitCode, qbfttypes.PrepareCode}
+
+	// whatever the arrival order, messages of a view are popped in the same order
+	for shift := range msgs {
+		c := newTestCore(istanbul.DefaultConfig, valSet)
+		for i := range msgs {
+			c.addToBacklog(msgs[(i+shift)%len(msgs)])
+		}
+		backlog := c.backlogs[src]
+		for i, code := range want {
+			m, _ := backlog.Pop()
+			if have := m.(qbfttypes.QBFTMessage).Code(); have != code {
+				t.Errorf("shift %d: code mismatch at %d: have %d, want %d", shift, i, have, code)
+			}
+		}
+	}
+}
+
+func TestBacklogResentDuringDrainDispatchedOnce(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePreprepared
+	prepare := signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), valSet.GetByIndex(1).Address())
+
+	sub := c.backend.EventMux().Subscribe(backlogEvent{})
+	defer sub.Unsubscribe()
+
+	// the peer resends the message while the backlog is drained
+	c.addToBacklog(prepare)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.processBacklog()
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			c.addToBacklog(prepare)
+		}
+	}()
+	wg.Wait()
+	c.processBacklog()
+
+	dispatched := 0
+wait:
+	for {
+		select {
+		case <-sub.Chan():
+			dispatched++
+		case <-time.After(100 * time.Millisecond):
+			break wait
+		}
+	}
+	if dispatched != 1 {
+		t.Errorf("dispatched events mismatch: have %d, want 1", dispatched)
+	}
+
+	// the copies are forgotten once the view moves on
+	c.current = newRoundState(&istanbul.View{Sequence: big.NewInt(2), Round: big.NewInt(0)}, valSet, nil, nil, nil, nil, nil)
+	c.processBacklog()
+	if len(c.dispatchedBacklog) != 0 {
+		t.Errorf("dispatched messages not pruned: have %d, want 0", len(c.dispatchedBacklog))
+	}
+}
+
+func TestTrackingMapsBounded(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePreprepared
+	src := valSet.GetByIndex(1).Address()
+	// the event queue holds the events of the whole flood, only the messages queued are tracked as dispatched
+	c.eventQueue.Limit = maxDispatchedBacklog + 100
+
+	// a validator floods the current view with distinct messages
+	for i := 0; i < maxDispatchedBacklog+100; i++ {
+		prepare := qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.BigToHash(big.NewInt(int64(i))))
+		c.addToBacklog(signedBy(prepare, src))
+	}
+	c.processBacklog()
+	for i := 0; i < maxRejectedProposals+4; i++ {
+		c.rejectProposal(makeBlock(int64(i+1)), "test")
+	}
+	if have := len(c.dispatchedBacklog); have != maxDispatchedBacklog {
+		t.Errorf("dispatched messages mismatch: have %d, want %d", have, maxDispatchedBacklog)
+	}
+	if have := len(c.rejectedProposals); have != maxRejectedProposals {
+		t.Errorf("rejected blocks mismatch: have %d, want %d", have, maxRejectedProposals)
+	}
+
+	// the view advances
+	c.backend.(*testBackend).lastProposal = makeBlock(1)
+	c.startNewRound(common.Big0)
+	if have := len(c.dispatchedBacklog); have != 0 {
+		t.Errorf("dispatched messages not cleared: have %d", have)
+	}
+	if have := len(c.rejectedProposals); have != 0 {
+		t.Errorf("rejected blocks not cleared: have %d", have)
+	}
+	if have := dispatchedBacklogGauge.Value(); metrics.Enabled && have != 0 {
+		t.Errorf("dispatched gauge mismatch: have %d, want 0", have)
+	}
+}
+
+func TestBacklogOrderBeyondPriorityRange(t *testing.T) {
+	// rounds beyond the range of the backlog priorities keep their order
+	config := *istanbul.DefaultConfig
+	config.MaxRound = 150
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+	src := valSet.GetByIndex(1).Address()
+	c.addToBacklog(newFuturePrepare(2, src))
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(140), common.Hash{}), src))
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(120), common.Hash{}), src))
+	c.addToBacklog(newTestRoundChange(110, src))
+	c.addToBacklog(newTestRoundChange(130, src))
+
+	want := []struct {
+		code     uint64
+		sequence int64
+		round    int64
+	}{
+		{qbfttypes.RoundChangeCode, 1, 130},
+		{qbfttypes.RoundChangeCode, 1, 110},
+		{qbfttypes.PrepareCode, 1, 120},
+		{qbfttypes.PrepareCode, 1, 140},
+		{qbfttypes.PrepareCode, 2, 0},
+	}
+	backlog := c.backlogs[src]
+	if backlog.Size() != len(want) {
+		t.Fatalf("backlog size mismatch: have %d, want %d", backlog.Size(), len(want))
+	}
+	for i, w := range want {
+		msg := backlog.PopItem().(qbfttypes.QBFTMessage)
+		view := msg.View()
+		if msg.Code() != w.code || view.Sequence.Int64() != w.sequence || view.Round.Int64() != w.round {
+			t.Errorf("message %d mismatch: have code %#x view %v, want code %#x sequence %d round %d",
+				i, msg.Code(), view, w.code, w.sequence, w.round)
+		}
+	}
+}
+
+func TestBacklogOrderPastExactSequences(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	src := valSet.GetByIndex(1).Address()
+	c.addToBacklog(newFuturePrepare(2, src))
+
+	// the priorities of a sequence past 2^24 / prioritySequenceFactor collide in float32, the order holds
+	sequence := int64(1<<24/prioritySequenceFactor + 1000)
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(sequence), big.NewInt(0), common.Hash{}), src))
+	c.addToBacklog(signedBy(qbfttypes.NewCommit(big.NewInt(sequence), big.NewInt(0), common.Hash{}, nil), src))
+	c.addToBacklog(signedBy(qbfttypes.NewPreprepare(big.NewInt(sequence), big.NewInt(0), makeBlock(sequence)), src))
+
+	want := []struct {
+		code     uint64
+		sequence int64
+	}{
+		{qbfttypes.PrepareCode, 2},
+		{qbfttypes.PreprepareCode, sequence},
+		{qbfttypes.CommitCode, sequence},
+		{qbfttypes.PrepareCode, sequence},
+	}
+	backlog := c.backlogs[src]
+	if backlog.Size() != len(want) {
+		t.Fatalf("backlog size mismatch: have %d, want %d", backlog.Size(), len(want))
+	}
+	for i, w := range want {
+		msg := backlog.PopItem().(qbfttypes.QBFTMessage)
+		if view := msg.View(); msg.Code() != w.code || view.Sequence.Int64() != w.sequence {
+			t.Errorf("message %d mismatch: have code %#x view %v, want code %#x sequence %d", i, msg.Code(), view, w.code, w.sequence)
+		}
+	}
+}
+
+func TestBacklogLockHoldTime(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePreprepared
+	src := valSet.GetByIndex(1).Address()
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), src))
+	c.addToBacklog(newFuturePrepare(3, src))
+
+	var holds []time.Duration
+	observe := c.backlogsMu.observe
+	c.backlogsMu.observe = func(d time.Duration) {
+		holds = append(holds, d)
+		observe(d)
+	}
+	count := backlogLockTimer.Count()
+	c.processBacklog()
+
+	if len(holds) == 0 {
+		t.Fatalf("no lock hold time recorded during the drain")
+	}
+	// a coarse clock may measure a short hold as zero
+	for i, hold := range holds {
+		if hold < 0 {
+			t.Errorf("hold time %d mismatch: have %v, want non-negative", i, hold)
+		}
+	}
+	if have := backlogLockTimer.Count() - count; metrics.Enabled && (have == 0 || have != int64(len(holds))) {
+		t.Errorf("lock hold timer mismatch: have %d samples, want %d", have, len(holds))
+	}
+}
+
+func TestBacklogIndexCompaction(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.BacklogViewIndex = true
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+	src := valSet.GetByIndex(1).Address()
+	for sequence := int64(3); sequence <= 6; sequence++ {
+		c.addToBacklog(newFuturePrepare(sequence, src))
+	}
+	q := c.backlogs[src].(*indexedQueue)
+
+	// the removed messages are kept queued while they do not outnumber the others
+	c.backlogsMu.Lock()
+	c.pruneBacklogBelow(5)
+	c.backlogsMu.Unlock()
+	if q.queue.Size() != 4 || q.Size() != 2 {
+		t.Fatalf("queue mismatch: have %d queued for %d live, want 4 for 2", q.queue.Size(), q.Size())
+	}
+	checkBacklogIndex(t, c)
+
+	// then the queue is rebuilt without them
+	c.backlogsMu.Lock()
+	c.pruneBacklogBelow(6)
+	c.backlogsMu.Unlock()
+	if q.queue.Size() != 1 || q.Size() != 1 || len(q.removed) != 0 {
+		t.Fatalf("compacted queue mismatch: have %d queued for %d live, want 1 for 1", q.queue.Size(), q.Size())
+	}
+	checkBacklogIndex(t, c)
+	if msg := q.PopItem().(qbfttypes.QBFTMessage); msg.View().Sequence.Uint64() != 6 {
+		t.Errorf("popped message of view %v, want sequence 6", msg.View())
+	}
+}
+
+// checkBacklogIndex checks the view index of c holds exactly the messages queued in its backlogs, apart
+// from the ones removed through the index
+func checkBacklogIndex(t *testing.T, c *core) {
+	t.Helper()
+	want := make(map[backlogView]map[qbfttypes.QBFTMessage]*indexedQueue)
+	for src, backlog := range c.backlogs {
+		q, ok := backlog.(*indexedQueue)
+		if !ok {
+			t.Fatalf("backlog of %v is not indexed", src)
+		}
+		var items []interface{}
+		var prios []float32
+		removed := 0
+		for !q.queue.Empty() {
+			item, prio := q.queue.Pop()
+			items = append(items, item)
+			prios = append(prios, prio)
+
+			msg := item.(qbfttypes.QBFTMessage)
+			if _, ok := q.removed[msg]; ok {
+				removed++
+				continue
+			}
+			view := msg.View()
+			key := backlogViewOf(&view)
+			if want[key] == nil {
+				want[key] = make(map[qbfttypes.QBFTMessage]*indexedQueue)
+			}
+			want[key][msg] = q
+		}
+		for i := range items {
+			q.queue.Push(items[i], prios[i])
+		}
+		if removed != len(q.removed) {
+			t.Errorf("removed messages of %v mismatch: have %d queued, want %d", src, removed, len(q.removed))
+		}
+	}
+	if !reflect.DeepEqual(c.backlogIndex.views, want) {
+		t.Errorf("backlog index mismatch: have %v, want %v", c.backlogIndex.views, want)
+	}
+}
+
+func TestBacklogViewIndex(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		config := *istanbul.DefaultConfig
+		config.BacklogViewIndex = indexed
+		valSet := newTestValidatorSet(4)
+		c := newTestCore(&config, valSet)
+		c.state = StatePreprepared
+		a, b := valSet.GetByIndex(1).Address(), valSet.GetByIndex(2).Address()
+		check := func(step string, sizeA, sizeB int) {
+			t.Helper()
+			if indexed {
+				checkBacklogIndex(t, c)
+			}
+			if have := c.backlogs[a].Size(); have != sizeA {
+				t.Errorf("indexed %v, %s: backlog size mismatch: have %d, want %d", indexed, step, have, sizeA)
+			}
+			if have := c.backlogs[b].Size(); have != sizeB {
+				t.Errorf("indexed %v, %s: backlog size mismatch: have %d, want %d", indexed, step, have, sizeB)
+			}
+		}
+
+		// push
+		c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), a))
+		c.addToBacklog(newFuturePrepare(3, a))
+		c.addToBacklog(newFuturePrepare(3, b))
+		c.addToBacklog(newFuturePrepare(4, b))
+		check("push", 2, 2)
+		if indexed && len(c.backlogIndex.views) != 3 {
+			t.Errorf("indexed views mismatch: have %d, want 3", len(c.backlogIndex.views))
+		}
+
+		// pop, the drain dispatches the message of the current view and walks the queues
+		c.processBacklog()
+		check("drain", 1, 2)
+
+		// prune
+		c.backlogsMu.Lock()
+		pruned := c.pruneBacklogBelow(4)
+		c.backlogsMu.Unlock()
+		if pruned != 2 {
+			t.Errorf("indexed %v: pruned messages mismatch: have %d, want 2", indexed, pruned)
+		}
+		check("prune", 0, 1)
+
+		// the messages removed through the index are dropped once popped, the others are kept in order
+		c.addToBacklog(newFuturePrepare(6, a))
+		c.processBacklog()
+		check("drain after removals", 1, 1)
+		if msg := c.backlogs[a].PopItem().(qbfttypes.QBFTMessage); msg.View().Sequence.Uint64() != 6 {
+			t.Errorf("indexed %v: popped message of view %v, want sequence 6", indexed, msg.View())
+		}
+		check("pop", 0, 1)
+
+		// dropping a backlog
+		c.deleteBacklog(b)
+		if indexed && len(c.backlogIndex.views) != 0 {
+			t.Errorf("index not emptied with the backlogs: %v", c.backlogIndex.views)
+		}
+	}
+}
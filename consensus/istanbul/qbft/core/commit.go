@@ -17,9 +17,14 @@
 package core
 
 import (
+	"bytes"
+	"time"
+
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
 	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
@@ -40,7 +45,7 @@ func (c *core) broadcastCommit() {
 		header = block.Header()
 	}
 	// Create Commit Seal
-	commitSeal, err := c.backend.SignWithoutHashing(PrepareCommittedSeal(header, uint32(c.currentView().Round.Uint64())))
+	commitSeal, err := c.backend.SignWithoutHashing(PrepareCommittedSeal(header, uint32(c.currentView().Round.Uint64()), c.config.CommitSealChainID(header.Number)))
 	if err != nil {
 		logger.Error("QBFT: failed to create COMMIT seal", "sub", sub, "err", err)
 		return
@@ -77,6 +82,30 @@ func (c *core) broadcastCommit() {
 		withMsg(logger, commit).Error("QBFT: failed to broadcast COMMIT message", "err", err)
 		return
 	}
+	c.recordSentPhaseMessage(sub.View, commit.Code(), payload)
+	c.sentCommitSeals = append(c.sentCommitSeals, commitSeal)
+}
+
+// checkCommitInclusion warns if this node sent a COMMIT message for the current sequence but none of its
+// commit seals made it to block, the committed block of the sequence. Its COMMIT messages then reach the
+// proposer too late, the node is lagging.
+func (c *core) checkCommitInclusion(block *types.Block, logger log.Logger) {
+	if len(c.sentCommitSeals) == 0 {
+		return
+	}
+	extra, err := types.ExtractQBFTExtra(block.Header())
+	if err != nil {
+		return
+	}
+	for _, seal := range extra.CommittedSeal {
+		for _, sent := range c.sentCommitSeals {
+			if bytes.Equal(seal, sent) {
+				return
+			}
+		}
+	}
+	excludedCommitMeter.Mark(1)
+	logger.Warn("QBFT: own COMMIT not included in the committed block seals, node may be lagging", "number", block.Number(), "hash", block.Hash(), "seals", len(extra.CommittedSeal))
 }
 
 // handleCommitMsg is called when receiving a COMMIT message from another validator
@@ -88,7 +117,12 @@ func (c *core) broadcastCommit() {
 func (c *core) handleCommitMsg(commit *qbfttypes.Commit) error {
 	logger := c.currentLogger(true, commit)
 
-	logger.Info("QBFT: handle COMMIT message", "commits.count", c.current.QBFTCommits.Size(), "quorum", c.QuorumSize())
+	logger.Info("QBFT: handle COMMIT message", "commits.count", c.current.QBFTCommits.Size(), "quorum", c.commitQuorumSize())
+
+	// A validator which already committed the view may resend its COMMIT, counted once, but not commit another block
+	if c.checkConflictingCommit(commit, logger) {
+		return errInvalidMessage
+	}
 
 	// Check digest
 	if commit.Digest != c.current.Proposal().Hash() {
@@ -98,14 +132,26 @@ func (c *core) handleCommitMsg(commit *qbfttypes.Commit) error {
 
 	// Add to received msgs
 	if err := c.current.QBFTCommits.Add(commit); err != nil {
+		if err == errNotFromValidator || err == errTooManyMessages {
+			// commits are deduplicated by sender and signed by validators, so this is a protocol violation
+			commitViolationMeter.Mark(1)
+			logger.Error("QBFT: COMMIT message protocol violation", "err", err, "commits.count", c.current.QBFTCommits.Size(), "validators", c.valSet.Size())
+			return err
+		}
 		c.logger.Error("QBFT: failed to save COMMIT message", "err", err)
 		return err
 	}
+	c.recordMessageLatency(commit)
 
-	logger = logger.New("commits.count", c.current.QBFTCommits.Size(), "quorum", c.QuorumSize())
+	logger = logger.New("commits.count", c.current.QBFTCommits.Size(), "quorum", c.commitQuorumSize())
 
 	// If we reached thresho
-	if c.current.QBFTCommits.Size() >= c.QuorumSize() {
+	if c.current.QBFTCommits.Size() >= c.commitQuorumSize() {
+		if target := c.commitSealTarget(); c.current.QBFTCommits.Size() < target {
+			logger.Debug("QBFT: received quorum of COMMIT messages, waiting for the commit seal target", "target", target)
+			c.waitCommitSealTarget()
+			return nil
+		}
 		logger.Info("QBFT: received quorum of COMMIT messages")
 		c.commitQBFT()
 	} else {
@@ -115,11 +161,34 @@ func (c *core) handleCommitMsg(commit *qbfttypes.Commit) error {
 	return nil
 }
 
+// checkConflictingCommit returns true if the source of commit already sent a COMMIT message with another digest
+// for the current view, handling it according to the duplicate commit policy
+func (c *core) checkConflictingCommit(commit *qbfttypes.Commit, logger log.Logger) bool {
+	previous, ok := c.current.QBFTCommits.Get(commit.Source()).(*qbfttypes.Commit)
+	if !ok || previous.Digest == commit.Digest {
+		return false
+	}
+
+	conflictingCommitMeter.Mark(1)
+	logger = logger.New("accepted.digest", previous.Digest, "digest", commit.Digest)
+	switch c.config.DuplicateCommitPolicy {
+	case istanbul.DuplicateCommitIgnore:
+		logger.Debug("QBFT: ignore conflicting COMMIT message")
+	case istanbul.DuplicateCommitEquivocation:
+		logger.Error("QBFT: validator equivocation, conflicting COMMIT for the current view")
+		c.raiseAlert(istanbul.AlertEquivocation, "validator sent conflicting COMMIT messages", c.currentView(), "source", commit.Source(), "accepted.digest", previous.Digest, "digest", commit.Digest)
+	default:
+		logger.Error("QBFT: conflicting COMMIT message, validator already committed another digest")
+	}
+	return true
+}
+
 // commitQBFT is called once quorum of commits is reached
 // - computes committedSeals from each received commit messages
 // - then commits block proposal to database with committed seals
 // - broadcast round change
 func (c *core) commitQBFT() {
+	c.stopCommitSealWait()
 	c.setState(StateCommitted)
 
 	proposal := c.current.Proposal()
@@ -138,5 +207,74 @@ func (c *core) commitQBFT() {
 			c.broadcastNextRoundChange()
 			return
 		}
+		ev := newConsensusEvent(istanbul.ConsensusEventCommit, c.currentView())
+		hash := proposal.Hash()
+		ev.Hash = &hash
+		c.sendConsensusEvent(ev)
+		c.recordConsensusStats()
+		c.recordParticipation()
+		c.revertLogEscalation()
+	}
+}
+
+// commitSealTarget returns the number of COMMIT messages to collect before committing the block of the current
+// view. The proposer may wait for more than the quorum, up to the validator set size, for a stronger seal.
+func (c *core) commitSealTarget() int {
+	quorum := c.commitQuorumSize()
+	if c.config.CommitSealTarget == 0 || !c.IsProposer() {
+		return quorum
+	}
+	target := int(c.config.CommitSealTarget)
+	if target < quorum {
+		return quorum
+	}
+	if target > c.valSet.Size() {
+		return c.valSet.Size()
+	}
+	return target
+}
+
+// commitSealTimeout returns how long the proposer waits for the commit seal target once it has a quorum
+func (c *core) commitSealTimeout() time.Duration {
+	if c.config.CommitSealTimeout > 0 {
+		return time.Duration(c.config.CommitSealTimeout) * time.Millisecond
+	}
+	return time.Duration(c.config.RequestTimeout) * time.Millisecond / 4
+}
+
+// waitCommitSealTarget starts the timer ending the wait for the commit seal target in the current view,
+// unless it is already running
+func (c *core) waitCommitSealTarget() {
+	view := c.currentView()
+	if c.commitSealWait != nil && c.commitSealWait.Cmp(view) == 0 {
+		return
+	}
+	c.stopCommitSealWait()
+	c.commitSealWait = view
+	c.commitSealTimer = time.AfterFunc(c.commitSealTimeout(), func() {
+		c.sendEvent(commitSealTimeoutEvent{})
+	})
+}
+
+// stopCommitSealWait cancels the wait for the commit seal target
+func (c *core) stopCommitSealWait() {
+	if c.commitSealTimer != nil {
+		c.commitSealTimer.Stop()
+		c.commitSealTimer = nil
+	}
+	c.commitSealWait = nil
+}
+
+// handleCommitSealTimeout commits the block of the current view with the COMMIT messages received so far,
+// once the proposer waited for the commit seal target long enough
+func (c *core) handleCommitSealTimeout() {
+	view := c.commitSealWait
+	c.stopCommitSealWait()
+	if view == nil || c.currentView().Cmp(view) != 0 || c.state.Cmp(StateCommitted) >= 0 {
+		return
+	}
+	if c.current.QBFTCommits.Size() >= c.commitQuorumSize() {
+		c.currentLogger(true, nil).Info("QBFT: commit seal target not reached in time, committing with a quorum of COMMIT messages", "commits.count", c.current.QBFTCommits.Size(), "target", c.commitSealTarget())
+		c.commitQBFT()
 	}
 }
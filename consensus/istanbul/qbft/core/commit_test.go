@@ -0,0 +1,210 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+func TestHandleCommitFromSpoofedSenders(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	proposal := makeBlock(1)
+	c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), proposal))
+	c.state = StatePrepared
+
+	before := commitViolationMeter.Count()
+	spoofed := 2 * valSet.Size()
+	for i := 0; i < spoofed; i++ {
+		key, _ := crypto.GenerateKey()
+		commit := qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), proposal.Hash(), nil)
+		commit.SetSource(crypto.PubkeyToAddress(key.PublicKey))
+		if err := c.handleCommitMsg(commit); err != errNotFromValidator {
+			t.Errorf("error mismatch: have %v, want %v", err, errNotFromValidator)
+		}
+	}
+	if size := c.current.QBFTCommits.Size(); size != 0 {
+		t.Errorf("spoofed COMMIT messages should not be counted: have %v, want 0", size)
+	}
+	if flagged := commitViolationMeter.Count() - before; metrics.Enabled && flagged != int64(spoofed) {
+		t.Errorf("violations mismatch: have %v, want %v", flagged, spoofed)
+	}
+
+	// commits from validators below quorum are still accepted, duplicates are not double counted
+	for i := 0; i < 2; i++ {
+		commit := qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), proposal.Hash(), nil)
+		commit.SetSource(valSet.GetByIndex(1).Address())
+		if err := c.handleCommitMsg(commit); err != nil {
+			t.Errorf("error mismatch: have %v, want nil", err)
+		}
+	}
+	if size := c.current.QBFTCommits.Size(); size != 1 {
+		t.Errorf("COMMIT count mismatch: have %v, want 1", size)
+	}
+}
+
+func TestQBFTMsgSetSourcesNeverExceedValidators(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	ms := newQBFTMsgSet(valSet)
+
+	newCommit := func(src common.Address) qbfttypes.QBFTMessage {
+		commit := qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), common.Hash{}, nil)
+		commit.SetSource(src)
+		return commit
+	}
+	for _, v := range valSet.List() {
+		if err := ms.Add(newCommit(v.Address())); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+	}
+
+	known := valSet.GetByIndex(1).Address()
+
+	// the validator set changes underneath the message set, a new validator
+	// must not push the number of sources above the validator set size
+	valSet.RemoveValidator(valSet.GetByIndex(0).Address())
+	key, _ := crypto.GenerateKey()
+	newcomer := crypto.PubkeyToAddress(key.PublicKey)
+	valSet.AddValidator(newcomer)
+
+	if err := ms.Add(newCommit(newcomer)); err != errTooManyMessages {
+		t.Errorf("error mismatch: have %v, want %v", err, errTooManyMessages)
+	}
+	if ms.Size() > valSet.Size() {
+		t.Errorf("message sources exceed validators: have %v, want at most %v", ms.Size(), valSet.Size())
+	}
+
+	// replacing the message of a known source is still allowed
+	if err := ms.Add(newCommit(known)); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+}
+
+func TestCommitSealTarget(t *testing.T) {
+	for _, timeout := range []bool{false, true} {
+		config := *istanbul.DefaultConfig
+		config.CommitSealTarget = 10
+		config.CommitSealTimeout = 3600000
+		valSet := newTestValidatorSet(4)
+		c := newTestCore(&config, valSet)
+		defer c.stopCommitSealWait()
+		valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+		proposal := makeBlock(1)
+		c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), proposal))
+		c.state = StatePrepared
+		backend := c.backend.(*testBackend)
+
+		// the target is clamped to the validator set size
+		if target := c.commitSealTarget(); target != 4 {
+			t.Fatalf("target mismatch: have %d, want 4", target)
+		}
+
+		// the proposer holds its block back once it has a quorum of COMMIT messages
+		for i := uint64(0); i < 3; i++ {
+			commit := qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), proposal.Hash(), nil)
+			if err := c.handleCommitMsg(signedBy(commit, valSet.GetByIndex(i).Address()).(*qbfttypes.Commit)); err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+		}
+		if c.state == StateCommitted || len(backend.committed) != 0 {
+			t.Fatalf("block committed before the target: state %v, %d blocks", c.state, len(backend.committed))
+		}
+
+		if timeout {
+			// the sub-timeout commits with the quorum
+			c.handleCommitSealTimeout()
+		} else {
+			commit := qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), proposal.Hash(), nil)
+			if err := c.handleCommitMsg(signedBy(commit, valSet.GetByIndex(3).Address()).(*qbfttypes.Commit)); err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+		}
+		if c.state != StateCommitted || len(backend.committed) != 1 {
+			t.Errorf("block not committed (timeout %v): state %v, %d blocks", timeout, c.state, len(backend.committed))
+		}
+		if c.commitSealTimer != nil {
+			t.Errorf("commit seal timer still running (timeout %v)", timeout)
+		}
+	}
+
+	// validators other than the proposer commit with the quorum
+	config := *istanbul.DefaultConfig
+	config.CommitSealTarget = 4
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+	valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(1)})
+	if target := c.commitSealTarget(); target != c.QuorumSize() {
+		t.Errorf("target mismatch: have %d, want %d", target, c.QuorumSize())
+	}
+}
+
+func TestDuplicateCommitPolicy(t *testing.T) {
+	for _, policy := range []string{"", istanbul.DuplicateCommitLog, istanbul.DuplicateCommitIgnore, istanbul.DuplicateCommitEquivocation} {
+		t.Run(policy, func(t *testing.T) {
+			config := *istanbul.DefaultConfig
+			config.DuplicateCommitPolicy = policy
+			valSet := newTestValidatorSet(4)
+			c := newTestCore(&config, valSet)
+			proposal := makeBlock(1)
+			c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), proposal))
+			c.state = StatePrepared
+			src := valSet.GetByIndex(1).Address()
+
+			// a resent COMMIT message is counted once
+			for i := 0; i < 2; i++ {
+				commit := qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), proposal.Hash(), nil)
+				commit.SetSource(src)
+				if err := c.handleCommitMsg(commit); err != nil {
+					t.Fatalf("resent COMMIT: error mismatch: have %v, want nil", err)
+				}
+			}
+			if size := c.current.QBFTCommits.Size(); size != 1 {
+				t.Errorf("COMMIT count mismatch: have %v, want 1", size)
+			}
+
+			// a COMMIT message for another block is rejected, the accepted one is kept
+			before := conflictingCommitMeter.Count()
+			conflicting := qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), makeBlock(2).Hash(), nil)
+			conflicting.SetSource(src)
+			if err := c.handleCommitMsg(conflicting); err != errInvalidMessage {
+				t.Errorf("conflicting COMMIT: error mismatch: have %v, want %v", err, errInvalidMessage)
+			}
+			if commit := c.current.QBFTCommits.Get(src).(*qbfttypes.Commit); commit.Digest != proposal.Hash() {
+				t.Errorf("accepted COMMIT replaced: have digest %v, want %v", commit.Digest, proposal.Hash())
+			}
+			if have := conflictingCommitMeter.Count() - before; metrics.Enabled && have != 1 {
+				t.Errorf("conflicts mismatch: have %d, want 1", have)
+			}
+
+			alerts := c.backend.(*testBackend).alerts
+			if policy == istanbul.DuplicateCommitEquivocation {
+				if len(alerts) != 1 || alerts[0].Kind != istanbul.AlertEquivocation {
+					t.Errorf("alerts mismatch: have %v, want an equivocation alert", alerts)
+				}
+			} else if len(alerts) != 0 {
+				t.Errorf("alerts mismatch: have %v, want none", alerts)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// consensusSubscriptions are the subscribers of the consensus events, guarded by their mutex as subscribing
+// happens outside of the consensus event loop
+type consensusSubscriptions struct {
+	mu   sync.Mutex
+	subs map[*consensusSubscription]struct{}
+}
+
+// consensusSubscription is a subscriber of the consensus events, dropped once it lags behind
+type consensusSubscription struct {
+	subs *consensusSubscriptions
+	ch   chan<- *istanbul.ConsensusEvent
+	err  chan error
+	once sync.Once
+}
+
+// Err returns the channel receiving errConsensusEventsLagging if the subscriber got dropped, it is closed
+// once the subscription ends
+func (sub *consensusSubscription) Err() <-chan error {
+	return sub.err
+}
+
+// Unsubscribe stops the delivery of the events, ch is not closed
+func (sub *consensusSubscription) Unsubscribe() {
+	sub.subs.remove(sub, nil)
+}
+
+// remove drops sub from the subscribers, reporting err on its error channel if not nil
+func (subs *consensusSubscriptions) remove(sub *consensusSubscription, err error) {
+	subs.mu.Lock()
+	delete(subs.subs, sub)
+	subs.mu.Unlock()
+	sub.once.Do(func() {
+		if err != nil {
+			sub.err <- err
+		}
+		close(sub.err)
+	})
+}
+
+// SubscribeConsensusEvents registers ch to receive the state changes, round changes, commits and alerts of
+// the core as they happen, in order. The events are sent from the consensus event loop, which never waits
+// for ch: a subscriber whose channel is full misses the event and is unsubscribed, its subscription then
+// reports errConsensusEventsLagging. Subscribers must buffer ch and read it promptly.
+func (c *core) SubscribeConsensusEvents(ch chan<- *istanbul.ConsensusEvent) event.Subscription {
+	sub := &consensusSubscription{subs: &c.consensusSubs, ch: ch, err: make(chan error, 1)}
+	c.consensusSubs.mu.Lock()
+	defer c.consensusSubs.mu.Unlock()
+	if c.consensusSubs.subs == nil {
+		c.consensusSubs.subs = make(map[*consensusSubscription]struct{})
+	}
+	c.consensusSubs.subs[sub] = struct{}{}
+	return sub
+}
+
+// newConsensusEvent creates an event of the given kind, happening at view if known
+func newConsensusEvent(kind string, view *istanbul.View) *istanbul.ConsensusEvent {
+	ev := &istanbul.ConsensusEvent{Kind: kind, Time: time.Now()}
+	if view != nil && view.Sequence != nil && view.Round != nil {
+		ev.Sequence, ev.Round = view.Sequence.Uint64(), view.Round.Uint64()
+	}
+	return ev
+}
+
+// sendConsensusEvent streams ev to the subscribers of the consensus events without blocking, the subscribers
+// which can not receive it are dropped
+func (c *core) sendConsensusEvent(ev *istanbul.ConsensusEvent) {
+	var lagging []*consensusSubscription
+	c.consensusSubs.mu.Lock()
+	for sub := range c.consensusSubs.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			lagging = append(lagging, sub)
+		}
+	}
+	c.consensusSubs.mu.Unlock()
+
+	for _, sub := range lagging {
+		consensusEventDropMeter.Mark(1)
+		c.logger.Warn("QBFT: consensus events subscriber lagging behind, unsubscribe it", "event", ev.Kind)
+		c.consensusSubs.remove(sub, errConsensusEventsLagging)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+)
+
+func TestConsensusEventsOrder(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	events := make(chan *istanbul.ConsensusEvent, 16)
+	sub := c.SubscribeConsensusEvents(events)
+	defer sub.Unsubscribe()
+
+	block := makeBlock(1)
+	c.setState(StatePreprepared)
+	c.startNewRound(common.Big1)
+	c.raiseAlert(istanbul.AlertEquivocation, "conflicting messages", c.currentView())
+	c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(1), block))
+	c.commitQBFT()
+
+	want := []struct {
+		kind  string
+		round uint64
+		state string
+	}{
+		{istanbul.ConsensusEventState, 0, StatePreprepared.String()},
+		{istanbul.ConsensusEventRoundChange, 1, ""},
+		{istanbul.ConsensusEventState, 1, StateAcceptRequest.String()},
+		{istanbul.ConsensusEventAlert, 1, ""},
+		{istanbul.ConsensusEventState, 1, StateCommitted.String()},
+		{istanbul.ConsensusEventCommit, 1, ""},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events mismatch: have %d, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		ev := <-events
+		if ev.Kind != w.kind || ev.Sequence != 1 || ev.Round != w.round || ev.State != w.state {
+			t.Errorf("event %d mismatch: have %+v, want kind %s round %d state %q", i, ev, w.kind, w.round, w.state)
+		}
+		switch ev.Kind {
+		case istanbul.ConsensusEventAlert:
+			if ev.Alert == nil || ev.Alert.Kind != istanbul.AlertEquivocation {
+				t.Errorf("alert mismatch: have %+v", ev.Alert)
+			}
+		case istanbul.ConsensusEventCommit:
+			if ev.Hash == nil || *ev.Hash != block.Hash() {
+				t.Errorf("committed hash mismatch: have %v, want %v", ev.Hash, block.Hash())
+			}
+		}
+	}
+}
+
+func TestConsensusEventsLaggingSubscriber(t *testing.T) {
+	c := newTestCore(istanbul.DefaultConfig, newTestValidatorSet(4))
+	defer c.stopTimer()
+	slow := make(chan *istanbul.ConsensusEvent, 1)
+	slowSub := c.SubscribeConsensusEvents(slow)
+	fast := make(chan *istanbul.ConsensusEvent, 4)
+	defer c.SubscribeConsensusEvents(fast).Unsubscribe()
+
+	// the event loop is not held back by the subscriber not reading its events, which is dropped
+	for i := 0; i < 3; i++ {
+		c.sendConsensusEvent(newConsensusEvent(istanbul.ConsensusEventState, c.currentView()))
+	}
+	if len(slow) != 1 || len(fast) != 3 {
+		t.Fatalf("events mismatch: have %d and %d, want 1 and 3", len(slow), len(fast))
+	}
+	if err := <-slowSub.Err(); err != errConsensusEventsLagging {
+		t.Errorf("error mismatch: have %v, want %v", err, errConsensusEventsLagging)
+	}
+	if _, ok := <-slowSub.Err(); ok {
+		t.Errorf("error channel of the dropped subscriber not closed")
+	}
+	<-slow
+	c.sendConsensusEvent(newConsensusEvent(istanbul.ConsensusEventState, c.currentView()))
+	if len(slow) != 0 || len(fast) != 4 {
+		t.Errorf("events after the drop mismatch: have %d and %d, want 0 and 4", len(slow), len(fast))
+	}
+	slowSub.Unsubscribe()
+}
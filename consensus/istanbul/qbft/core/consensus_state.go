@@ -0,0 +1,396 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// ConsensusState is a snapshot of the consensus state of a node, used to clone
+// it into a hot-standby validator
+type ConsensusState struct {
+	Head       common.Hash // hash of the last committed proposal the state builds on
+	Sequence   *big.Int
+	Round      *big.Int
+	State      uint64
+	Preprepare []byte // RLP-encoded PRE-PREPARE message of the current round, empty if none
+	Prepares   []EncodedMessage
+	Commits    []EncodedMessage
+	Prepared   *PreparedState `rlp:"nil"` // lock of the node, nil if it did not prepare a block for the sequence
+	Backlog    []EncodedMessage
+}
+
+// PreparedState is the round and block a node prepared for the sequence along with the PREPARE messages
+// justifying it, which a node cloned without could prepare another block in a later round
+type PreparedState struct {
+	Round    *big.Int
+	Block    []byte // RLP-encoded prepared block, empty if the node did not receive it
+	Prepares []EncodedMessage
+}
+
+// EncodedMessage is an RLP-encoded QBFT message along with its code
+type EncodedMessage struct {
+	Code    uint64
+	Payload []byte
+}
+
+// importedState is a consensus state checked by ImportState, restored once the core starts
+type importedState struct {
+	head             common.Hash
+	current          *roundState
+	state            State
+	preparedPrepares []*qbfttypes.Prepare
+	backlog          []qbfttypes.QBFTMessage
+}
+
+func encodeMessage(m qbfttypes.QBFTMessage) (EncodedMessage, error) {
+	payload, err := rlp.EncodeToBytes(m)
+	if err != nil {
+		return EncodedMessage{}, err
+	}
+	return EncodedMessage{Code: m.Code(), Payload: payload}, nil
+}
+
+func encodeMessages(msgs []qbfttypes.QBFTMessage) ([]EncodedMessage, error) {
+	encoded := make([]EncodedMessage, 0, len(msgs))
+	for _, m := range msgs {
+		e, err := encodeMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, e)
+	}
+	return encoded, nil
+}
+
+// ExportState serializes the current view, the messages received for it, the lock and
+// the backlog so they can be imported in another node
+func (c *core) ExportState() ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if !c.queryEventLoop(func() { data, err = c.exportState() }) {
+		return nil, errQueryTimeout
+	}
+	return data, err
+}
+
+// exportState serializes the consensus state, it must be called from the event loop
+func (c *core) exportState() ([]byte, error) {
+	if c.current == nil {
+		return nil, errNoConsensusState
+	}
+
+	state := &ConsensusState{
+		Sequence: c.current.Sequence(),
+		Round:    c.current.Round(),
+		State:    uint64(c.state),
+	}
+	if lastProposal, _ := c.backend.LastProposal(); lastProposal != nil {
+		state.Head = lastProposal.Hash()
+	}
+
+	var err error
+	if c.current.Preprepare != nil {
+		if state.Preprepare, err = rlp.EncodeToBytes(c.current.Preprepare); err != nil {
+			return nil, err
+		}
+	}
+	if state.Prepares, err = encodeMessages(c.current.QBFTPrepares.Values()); err != nil {
+		return nil, err
+	}
+	if state.Commits, err = encodeMessages(c.current.QBFTCommits.Values()); err != nil {
+		return nil, err
+	}
+	if c.current.preparedRound != nil {
+		state.Prepared = &PreparedState{Round: new(big.Int).Set(c.current.preparedRound)}
+		if c.current.preparedBlock != nil {
+			if state.Prepared.Block, err = rlp.EncodeToBytes(c.current.preparedBlock); err != nil {
+				return nil, err
+			}
+		}
+		prepares := make([]qbfttypes.QBFTMessage, 0, len(c.QBFTPreparedPrepares))
+		for _, prepare := range c.QBFTPreparedPrepares {
+			prepares = append(prepares, prepare)
+		}
+		if state.Prepared.Prepares, err = encodeMessages(prepares); err != nil {
+			return nil, err
+		}
+	}
+	if state.Backlog, err = encodeMessages(c.backlogMessages()); err != nil {
+		return nil, err
+	}
+
+	return rlp.EncodeToBytes(state)
+}
+
+// backlogMessages returns all the messages currently in the backlog, leaving it untouched
+func (c *core) backlogMessages() []qbfttypes.QBFTMessage {
+	c.backlogsMu.Lock()
+	defer c.backlogsMu.Unlock()
+
+	var msgs []qbfttypes.QBFTMessage
+	for _, backlog := range c.backlogs {
+		if backlog == nil {
+			continue
+		}
+		var items []interface{}
+		var prios []float32
+		for !backlog.Empty() {
+			item, prio := backlog.Pop()
+			items = append(items, item)
+			prios = append(prios, prio)
+			msgs = append(msgs, item.(qbfttypes.QBFTMessage))
+		}
+		for i := range items {
+			backlog.Push(items[i], prios[i])
+		}
+	}
+	return msgs
+}
+
+// ImportState checks a consensus state exported by another node, which is restored once the core
+// starts in place of the first round of the sequence. It must be called while the core is stopped.
+//
+// The last committed proposal of this node must match the one the state was exported at, the messages
+// are verified against its validator set and must be for the exported view, and the lock must be
+// justified by a quorum of PREPARE messages.
+func (c *core) ImportState(data []byte) error {
+	if atomic.LoadInt32(&c.handlingEvents) == 1 {
+		return istanbul.ErrStartedEngine
+	}
+
+	var state ConsensusState
+	if err := rlp.DecodeBytes(data, &state); err != nil {
+		return err
+	}
+	if state.Sequence == nil || state.Round == nil {
+		return errInvalidMessage
+	}
+
+	lastProposal, _ := c.backend.LastProposal()
+	if lastProposal == nil || lastProposal.Hash() != state.Head {
+		return errHeadMismatch
+	}
+	if next := new(big.Int).Add(lastProposal.Number(), common.Big1); state.Sequence.Cmp(next) != 0 {
+		return fmt.Errorf("%w: sequence %v does not follow the chain head %v", errInvalidConsensusState, state.Sequence, lastProposal.Number())
+	}
+	if state.State > uint64(StateCommitted) {
+		return fmt.Errorf("%w: unknown state %d", errInvalidConsensusState, state.State)
+	}
+
+	// the signatures are checked against the validators of the sequence, the core is stopped
+	c.valSet = c.backend.Validators(lastProposal)
+	imported, err := c.decodeState(&state)
+	if err != nil {
+		return err
+	}
+	imported.head = state.Head
+	c.importedState = imported
+	return nil
+}
+
+// decodeState decodes the messages of state and checks they make a consistent view
+func (c *core) decodeState(state *ConsensusState) (*importedState, error) {
+	view := &istanbul.View{Sequence: state.Sequence, Round: state.Round}
+	decode := func(e EncodedMessage) (qbfttypes.QBFTMessage, error) {
+		m, err := qbfttypes.Decode(e.Code, e.Payload)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.verifySignatures(m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	// decodeView decodes messages of code for the sequence at round, carrying digest
+	decodeView := func(encoded []EncodedMessage, code uint64, round *big.Int, digest common.Hash) ([]qbfttypes.QBFTMessage, error) {
+		msgs := make([]qbfttypes.QBFTMessage, 0, len(encoded))
+		for _, e := range encoded {
+			if e.Code != code {
+				return nil, fmt.Errorf("%w: message code %d instead of %d", errInvalidConsensusState, e.Code, code)
+			}
+			m, err := decode(e)
+			if err != nil {
+				return nil, err
+			}
+			if v := m.View(); v.Cmp(&istanbul.View{Sequence: state.Sequence, Round: round}) != 0 {
+				return nil, fmt.Errorf("%w: message from %v for view %v", errInvalidConsensusState, m.Source(), m.View())
+			}
+			var d common.Hash
+			switch m := m.(type) {
+			case *qbfttypes.Prepare:
+				d = m.Digest
+			case *qbfttypes.Commit:
+				d = m.Digest
+			}
+			if d != digest {
+				return nil, fmt.Errorf("%w: message from %v for block %v instead of %v", errInvalidConsensusState, m.Source(), d, digest)
+			}
+			msgs = append(msgs, m)
+		}
+		return msgs, nil
+	}
+
+	// PREPARE and COMMIT messages are for the block of the PRE-PREPARE message accepted in the round
+	var preprepare *qbfttypes.Preprepare
+	if len(state.Preprepare) > 0 {
+		m, err := decode(EncodedMessage{Code: qbfttypes.PreprepareCode, Payload: state.Preprepare})
+		if err != nil {
+			return nil, err
+		}
+		preprepare = m.(*qbfttypes.Preprepare)
+		if preprepare.Sequence.Cmp(state.Sequence) != 0 || preprepare.Round.Cmp(state.Round) > 0 {
+			return nil, fmt.Errorf("%w: PRE-PREPARE message for view %v", errInvalidConsensusState, preprepare.View())
+		}
+	}
+	var digest common.Hash
+	if preprepare != nil && preprepare.Round.Cmp(state.Round) == 0 {
+		digest = preprepare.Proposal.Hash()
+	} else if len(state.Prepares) > 0 || len(state.Commits) > 0 || State(state.State) != StateAcceptRequest {
+		return nil, fmt.Errorf("%w: no PRE-PREPARE message accepted in round %v", errInvalidConsensusState, state.Round)
+	}
+	prepares, err := decodeView(state.Prepares, qbfttypes.PrepareCode, state.Round, digest)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := decodeView(state.Commits, qbfttypes.CommitCode, state.Round, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	// the lock is justified by a quorum of PREPARE messages for the prepared block, from distinct validators
+	var (
+		preparedRound    *big.Int
+		preparedBlock    istanbul.Proposal
+		preparedPrepares []*qbfttypes.Prepare
+	)
+	if prepared := state.Prepared; prepared == nil {
+		if State(state.State) >= StatePrepared {
+			return nil, fmt.Errorf("%w: %v without prepared block", errInvalidConsensusState, State(state.State))
+		}
+	} else {
+		preparedRound = prepared.Round
+		if preparedRound == nil || preparedRound.Cmp(state.Round) > 0 || State(state.State) >= StatePrepared && preparedRound.Cmp(state.Round) != 0 {
+			return nil, fmt.Errorf("%w: prepared round %v at round %v", errInvalidConsensusState, preparedRound, state.Round)
+		}
+		if len(prepared.Prepares) == 0 {
+			return nil, fmt.Errorf("%w: prepared round %v without PREPARE messages", errInvalidConsensusState, preparedRound)
+		}
+		first, err := qbfttypes.Decode(qbfttypes.PrepareCode, prepared.Prepares[0].Payload)
+		if err != nil {
+			return nil, err
+		}
+		preparedDigest := first.(*qbfttypes.Prepare).Digest
+		if len(prepared.Block) > 0 {
+			block := new(types.Block)
+			if err := rlp.DecodeBytes(prepared.Block, block); err != nil {
+				return nil, err
+			}
+			if block.Hash() != preparedDigest {
+				return nil, fmt.Errorf("%w: prepared block %v does not match the PREPARE messages for %v", errInvalidConsensusState, block.Hash(), preparedDigest)
+			}
+			preparedBlock = block
+		}
+		msgs, err := decodeView(prepared.Prepares, qbfttypes.PrepareCode, preparedRound, preparedDigest)
+		if err != nil {
+			return nil, err
+		}
+		sources := make(map[common.Address]struct{})
+		for _, m := range msgs {
+			if _, v := c.valSet.GetByAddress(m.Source()); v == nil {
+				return nil, fmt.Errorf("%w: PREPARE message from non validator %v", errInvalidConsensusState, m.Source())
+			}
+			sources[m.Source()] = struct{}{}
+			preparedPrepares = append(preparedPrepares, m.(*qbfttypes.Prepare))
+		}
+		if quorum := istanbul.QuorumSize(c.config, c.valSet, state.Sequence); len(sources) < quorum {
+			return nil, fmt.Errorf("%w: %d validators prepared the block, %d required", errInvalidConsensusState, len(sources), quorum)
+		}
+	}
+
+	backlog := make([]qbfttypes.QBFTMessage, 0, len(state.Backlog))
+	for _, e := range state.Backlog {
+		m, err := decode(e)
+		if err != nil {
+			return nil, err
+		}
+		backlog = append(backlog, m)
+	}
+
+	current := newRoundState(view, c.valSet, preprepare, preparedRound, preparedBlock, nil, c.backend.HasBadProposal)
+	for _, m := range prepares {
+		if err := current.QBFTPrepares.Add(m); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range commits {
+		if err := current.QBFTCommits.Add(m); err != nil {
+			return nil, err
+		}
+	}
+	return &importedState{
+		current:          current,
+		state:            State(state.State),
+		preparedPrepares: preparedPrepares,
+		backlog:          backlog,
+	}, nil
+}
+
+// restoreImportedState installs the consensus state imported while the core was stopped, in place of the
+// first round of the sequence the core just started. It is dropped if the chain head moved in between.
+func (c *core) restoreImportedState() {
+	imported := c.importedState
+	c.importedState = nil
+	if imported == nil {
+		return
+	}
+	lastProposal, lastProposer := c.backend.LastProposal()
+	if lastProposal == nil || lastProposal.Hash() != imported.head {
+		c.logger.Warn("QBFT: chain head moved since the consensus state was imported, dropping it", "head", imported.head)
+		return
+	}
+
+	c.currentMutex.Lock()
+	c.current = imported.current
+	c.QBFTPreparedPrepares = imported.preparedPrepares
+	view := c.currentView()
+	c.valSet.CalcProposer(lastProposer, view)
+	c.roundChangeSet = newRoundChangeSet(c.valSet)
+	c.roundChangeSet.NewRound(view.Round)
+	c.currentMutex.Unlock()
+
+	c.setProposerStatus(c.IsProposer(), view)
+	for _, m := range imported.backlog {
+		c.addToBacklog(m)
+	}
+	if view.Round.Sign() > 0 {
+		c.newRoundChangeTimer()
+	}
+	c.setState(imported.state)
+
+	c.currentLogger(true, nil).Info("QBFT: restored imported consensus state", "prepares", c.current.QBFTPrepares.Size(), "commits", c.current.QBFTCommits.Size(), "locked", c.current.preparedRound != nil, "backlog", len(imported.backlog))
+}
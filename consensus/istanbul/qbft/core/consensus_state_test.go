@@ -0,0 +1,243 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// signedBy fakes the signature of m so that the test validateFn recovers addr as its source
+func signedBy(m qbfttypes.QBFTMessage, addr common.Address) qbfttypes.QBFTMessage {
+	m.SetSource(addr)
+	m.SetSignature(addr.Bytes())
+	return m
+}
+
+func newStateTestCore(valSet istanbul.ValidatorSet, head istanbul.Proposal) *core {
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.backend.(*testBackend).lastProposal = head
+	c.current = newRoundState(&istanbul.View{
+		Sequence: new(big.Int).Add(head.Number(), common.Big1),
+		Round:    big.NewInt(0),
+	}, valSet, nil, nil, nil, nil, c.backend.HasBadProposal)
+	return c
+}
+
+func TestExportImportState(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	head := makeBlock(4)
+	src := newStateTestCore(valSet, head)
+
+	// current view is sequence 5, round 2, the node prepared the block of the round
+	proposal := makeBlock(5)
+	digest := proposal.Hash()
+	proposer := valSet.GetByIndex(1).Address()
+	preprepare := signedBy(qbfttypes.NewPreprepare(big.NewInt(5), big.NewInt(2), proposal), proposer).(*qbfttypes.Preprepare)
+	src.current = newRoundState(&istanbul.View{
+		Sequence: big.NewInt(5),
+		Round:    big.NewInt(2),
+	}, valSet, preprepare, big.NewInt(2), proposal, nil, src.backend.HasBadProposal)
+	src.state = StatePrepared
+	for i := 0; i < 3; i++ {
+		prepare := signedBy(qbfttypes.NewPrepare(big.NewInt(5), big.NewInt(2), digest), valSet.GetByIndex(uint64(i)).Address())
+		src.current.QBFTPrepares.Add(prepare)
+		src.QBFTPreparedPrepares = append(src.QBFTPreparedPrepares, prepare.(*qbfttypes.Prepare))
+	}
+	prepareSender := valSet.GetByIndex(1).Address()
+
+	// a future COMMIT is waiting in the backlog
+	backlogSender := valSet.GetByIndex(2).Address()
+	src.addToBacklog(signedBy(qbfttypes.NewCommit(big.NewInt(6), big.NewInt(0), digest, nil), backlogSender))
+
+	data, err := src.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	if size := src.backlogs[backlogSender].Size(); size != 1 {
+		t.Errorf("exporting should leave the backlog untouched: have %v messages, want 1", size)
+	}
+
+	dst := newStateTestCore(valSet, head)
+	if err := dst.ImportState(data); err != nil {
+		t.Fatalf("failed to import state: %v", err)
+	}
+	if view := dst.currentView(); view.Sequence.Cmp(big.NewInt(5)) != 0 || view.Round.Cmp(big.NewInt(0)) != 0 {
+		t.Errorf("import should not touch the state before the core starts: have view %v", view)
+	}
+	dst.restoreImportedState()
+
+	if view := dst.currentView(); view.Sequence.Cmp(big.NewInt(5)) != 0 || view.Round.Cmp(big.NewInt(2)) != 0 {
+		t.Errorf("view mismatch: have %v, want {Round: 2, Sequence: 5}", view)
+	}
+	if dst.state != StatePrepared {
+		t.Errorf("state mismatch: have %v, want %v", dst.state, StatePrepared)
+	}
+	if prepare := dst.current.QBFTPrepares.Get(prepareSender); prepare == nil || prepare.(*qbfttypes.Prepare).Digest != digest {
+		t.Errorf("PREPARE from %v not imported", prepareSender.Hex())
+	}
+	if dst.current.preparedRound == nil || dst.current.preparedRound.Cmp(big.NewInt(2)) != 0 {
+		t.Errorf("prepared round mismatch: have %v, want 2", dst.current.preparedRound)
+	}
+	if dst.current.preparedBlock == nil || dst.current.preparedBlock.Hash() != digest {
+		t.Errorf("prepared block mismatch: have %v, want %v", dst.current.preparedBlock, digest)
+	}
+	if n := len(dst.QBFTPreparedPrepares); n != 3 {
+		t.Errorf("prepared PREPARE messages mismatch: have %d, want 3", n)
+	}
+	backlog := dst.backlogs[backlogSender]
+	if backlog == nil || backlog.Size() != 1 {
+		t.Fatalf("backlog of %v not imported", backlogSender.Hex())
+	}
+	msg := backlog.PopItem().(qbfttypes.QBFTMessage)
+	if msg.Code() != qbfttypes.CommitCode || msg.Source() != backlogSender || msg.View().Sequence.Cmp(big.NewInt(6)) != 0 {
+		t.Errorf("backlog message mismatch: have code %v from %v at %v", msg.Code(), msg.Source().Hex(), msg.View())
+	}
+}
+
+func TestImportStateInvalid(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	head := makeBlock(4)
+	proposal := makeBlock(5)
+	proposer := valSet.GetByIndex(1).Address()
+	preprepare, _ := rlp.EncodeToBytes(signedBy(qbfttypes.NewPreprepare(big.NewInt(5), big.NewInt(1), proposal), proposer))
+	block, _ := rlp.EncodeToBytes(proposal)
+	encode := func(view *istanbul.View, digest common.Hash, senders ...int) []EncodedMessage {
+		var encoded []EncodedMessage
+		for _, i := range senders {
+			m := signedBy(qbfttypes.NewPrepare(view.Sequence, view.Round, digest), valSet.GetByIndex(uint64(i)).Address())
+			e, _ := encodeMessage(m)
+			encoded = append(encoded, e)
+		}
+		return encoded
+	}
+	view := &istanbul.View{Sequence: big.NewInt(5), Round: big.NewInt(1)}
+
+	tests := []struct {
+		name   string
+		modify func(state *ConsensusState)
+	}{
+		{"sequence not following head", func(state *ConsensusState) {
+			state.Sequence = big.NewInt(6)
+		}},
+		{"unknown state", func(state *ConsensusState) {
+			state.State = uint64(StateCommitted) + 1
+		}},
+		{"PREPARE for another block", func(state *ConsensusState) {
+			state.Prepares = encode(view, common.HexToHash("0x1234"), 2)
+		}},
+		{"PREPARE for another round", func(state *ConsensusState) {
+			state.Prepares = encode(&istanbul.View{Sequence: big.NewInt(5), Round: big.NewInt(0)}, proposal.Hash(), 2)
+		}},
+		{"PREPARE without PRE-PREPARE", func(state *ConsensusState) {
+			state.Preprepare = nil
+		}},
+		{"prepared state without lock", func(state *ConsensusState) {
+			state.Prepared = nil
+		}},
+		{"lock in a later round", func(state *ConsensusState) {
+			state.Prepared.Round = big.NewInt(2)
+		}},
+		{"lock without quorum", func(state *ConsensusState) {
+			state.Prepared.Prepares = encode(view, proposal.Hash(), 0, 1)
+		}},
+		{"lock with duplicate PREPARE", func(state *ConsensusState) {
+			state.Prepared.Prepares = encode(view, proposal.Hash(), 0, 1, 1)
+		}},
+		{"prepared block not matching the lock", func(state *ConsensusState) {
+			state.Prepared.Block, _ = rlp.EncodeToBytes(makeBlock(6))
+		}},
+	}
+	// newState returns a valid state prepared in round 1
+	newState := func() *ConsensusState {
+		return &ConsensusState{
+			Head:       head.Hash(),
+			Sequence:   big.NewInt(5),
+			Round:      big.NewInt(1),
+			State:      uint64(StatePrepared),
+			Preprepare: preprepare,
+			Prepares:   encode(view, proposal.Hash(), 0, 1, 2),
+			Prepared: &PreparedState{
+				Round:    big.NewInt(1),
+				Block:    block,
+				Prepares: encode(view, proposal.Hash(), 0, 1, 2),
+			},
+		}
+	}
+	data, _ := rlp.EncodeToBytes(newState())
+	if err := newStateTestCore(valSet, head).ImportState(data); err != nil {
+		t.Fatalf("failed to import valid state: %v", err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := newState()
+			test.modify(state)
+			data, err := rlp.EncodeToBytes(state)
+			if err != nil {
+				t.Fatalf("failed to encode state: %v", err)
+			}
+
+			c := newStateTestCore(valSet, head)
+			if err := c.ImportState(data); !errors.Is(err, errInvalidConsensusState) {
+				t.Errorf("error mismatch: have %v, want %v", err, errInvalidConsensusState)
+			}
+			if c.importedState != nil {
+				t.Errorf("invalid state should not be restored")
+			}
+		})
+	}
+}
+
+func TestImportStateStarted(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	src := newStateTestCore(valSet, makeBlock(4))
+	data, err := src.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+
+	dst := newStateTestCore(valSet, makeBlock(4))
+	atomic.StoreInt32(&dst.handlingEvents, 1)
+	if err := dst.ImportState(data); err != istanbul.ErrStartedEngine {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbul.ErrStartedEngine)
+	}
+}
+
+func TestImportStateHeadMismatch(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	src := newStateTestCore(valSet, makeBlock(4))
+	data, err := src.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+
+	dst := newStateTestCore(valSet, makeBlock(3))
+	if err := dst.ImportState(data); err != errHeadMismatch {
+		t.Errorf("error mismatch: have %v, want %v", err, errHeadMismatch)
+	}
+	if view := dst.currentView(); view.Sequence.Cmp(big.NewInt(4)) != 0 {
+		t.Errorf("state should be untouched on failed import: have sequence %v, want 4", view.Sequence)
+	}
+}
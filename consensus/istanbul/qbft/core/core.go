@@ -17,13 +17,16 @@
 package core
 
 import (
+	"bytes"
 	"math"
 	"math/big"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	istanbulcommon "github.com/ethereum/go-ethereum/consensus/istanbul/common"
 	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/event"
@@ -36,25 +39,103 @@ var (
 	roundMeter     = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/round", nil)
 	sequenceMeter  = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/sequence", nil)
 	consensusTimer = metrics.NewRegisteredTimer("consensus/istanbul/qbft/core/consensus", nil)
+	// consensusEventDropMeter counts consensus events dropped for subscribers lagging behind, which get unsubscribed
+	consensusEventDropMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/consensusevent/dropped", nil)
+	// deferredEventMeter counts backlog events kept in the backlog because the event loop fell behind
+	deferredEventMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/event/deferred", nil)
+	// syncRequestMeter counts chain syncs requested after receiving persistent future sequence messages
+	syncRequestMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/syncrequest", nil)
+	// networkCommitMeter counts rounds abandoned because the block got committed by the network
+	networkCommitMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/networkcommit", nil)
+	// commitViolationMeter counts COMMIT messages rejected for breaking the one-commit-per-validator invariant
+	commitViolationMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/violation/commit", nil)
+	// conflictingCommitMeter counts COMMIT messages from validators which already sent one with another digest for the view
+	conflictingCommitMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/commit/conflict", nil)
+	// proposalConflictMeter counts PRE-PREPARE messages proposing another block than the one this node is locked on
+	proposalConflictMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/violation/proposal", nil)
+	// preprepareConflictMeter counts PRE-PREPARE messages proposing another block than the one accepted for the same view
+	preprepareConflictMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/violation/preprepare", nil)
+	// watchdogStallMeter counts the times the event loop was found unresponsive by the watchdog
+	watchdogStallMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/watchdog/stall", nil)
+	// lateMessageMeter counts PREPARE and COMMIT messages handed to the accountability sink after their view got committed
+	lateMessageMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/late", nil)
+	// maxRoundHaltMeter counts heights for which consensus halted after exceeding the maximum round
+	maxRoundHaltMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/halt/maxround", nil)
+	// sequenceGapGauge is the number of sequences between the highest one seen in future messages and the current one
+	sequenceGapGauge = metrics.NewRegisteredGauge("consensus/istanbul/qbft/core/sequence/gap", nil)
+	// faultToleranceGauge is the number of faulty validators the validator set tolerates (F)
+	faultToleranceGauge = metrics.NewRegisteredGauge("consensus/istanbul/qbft/core/validators/faulttolerance", nil)
+	// noFaultToleranceMeter counts the validator set changes leaving it unable to tolerate any faulty validator
+	noFaultToleranceMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/validators/nofaulttolerance", nil)
+	// selfEchoMeter counts messages of this node relayed back by peers and dropped
+	selfEchoMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/selfecho", nil)
+	// backlogSourceRejectMeter counts messages not backlogged because too many sources already have a backlog
+	backlogSourceRejectMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/backlog/sourcerejected", nil)
+	// dissentMeter counts blocks imported after a quorum of validators committed them while this node rejected them
+	dissentMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/dissent", nil)
+	// backlogStopFutureMeter and backlogStopEmptyMeter count the backlog source drains stopped on a future message
+	// and by emptying the backlog
+	backlogStopFutureMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/backlog/stop/future", nil)
+	backlogStopEmptyMeter  = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/backlog/stop/empty", nil)
+	// faultyProposerMeter counts the validators reported for proposing invalid blocks repeatedly
+	faultyProposerMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/proposer/faulty", nil)
+	// excludedCommitMeter counts committed blocks whose seals do not include the COMMIT this node sent for them
+	excludedCommitMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/commit/excluded", nil)
+	// backlogRedispatchMeter counts backlog messages suppressed because an identical message was just dispatched
+	backlogRedispatchMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/backlog/redispatch", nil)
+	// staleBacklogMeter counts backlog messages dropped at handling time as the view advanced since they were dispatched
+	staleBacklogMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/backlog/stale", nil)
+	// backlogLockTimer measures how long backlogsMu is held per acquisition, e.g. for a whole drain
+	backlogLockTimer = metrics.NewRegisteredTimer("consensus/istanbul/qbft/core/backlog/lockhold", nil)
+	// roundChangeAnomalyMeter counts validators flagged for sending mostly ROUND-CHANGE messages
+	roundChangeAnomalyMeter = metrics.NewRegisteredMeter("consensus/istanbul/qbft/core/roundchange/anomaly", nil)
+	// dispatchedBacklogGauge, rejectedProposalsGauge and retainedMessagesGauge are the sizes of the maps tracking
+	// the backlog messages dispatched for the current view, the blocks rejected for the current sequence and the
+	// messages retained within the reorg depth
+	dispatchedBacklogGauge = metrics.NewRegisteredGauge("consensus/istanbul/qbft/core/tracking/dispatched", nil)
+	rejectedProposalsGauge = metrics.NewRegisteredGauge("consensus/istanbul/qbft/core/tracking/rejected", nil)
+	retainedMessagesGauge  = metrics.NewRegisteredGauge("consensus/istanbul/qbft/core/tracking/retained", nil)
 )
 
 // New creates an Istanbul consensus core
 func New(backend istanbul.Backend, config *istanbul.Config) istanbul.Core {
 	c := &core{
-		config:             config,
-		address:            backend.Address(),
-		state:              StateAcceptRequest,
-		handlerWg:          new(sync.WaitGroup),
-		logger:             log.New("address", backend.Address()),
-		backend:            backend,
-		backlogs:           make(map[common.Address]*prque.Prque),
-		backlogsMu:         new(sync.Mutex),
-		pendingRequests:    prque.New(),
-		pendingRequestsMu:  new(sync.Mutex),
-		consensusTimestamp: time.Time{},
+		config:              config,
+		address:             backend.Address(),
+		state:               StateAcceptRequest,
+		handlerWg:           new(sync.WaitGroup),
+		logger:              log.New("address", backend.Address()),
+		backend:             backend,
+		backlogs:            make(map[common.Address]backlogQueue),
+		backlogsMu:          newTimedMutex(backlogLockTimer),
+		pendingRequests:     prque.New(),
+		pendingRequestsMu:   new(sync.Mutex),
+		queries:             make(chan func()),
+		consensusTimestamp:  time.Time{},
+		logSampler:          istanbulcommon.NewLogSampler(config.TraceLogSampleRate),
+		timeoutGracePending: true,
+		backlogDrainBudget:  time.Duration(config.BacklogDrainBudget) * time.Millisecond,
+		wal:                 config.WAL,
+		journal:             config.Journal,
+		recentStats:         newConsensusStatsRing(config.ConsensusStatsWindow),
+		participation:       newParticipationRing(config.UptimeWindow),
+	}
+
+	if config.BacklogViewIndex {
+		c.backlogIndex = newBacklogIndex()
+	}
+
+	if config.StepDebugging {
+		if steppingBuild {
+			c.logger.Warn("QBFT: single-step debugging enabled, the consensus halts while paused")
+			c.enableStepping()
+		} else {
+			c.logger.Error("QBFT: single-step debugging requires a build with the " + steppingBuildTag + " tag, ignoring it")
+		}
 	}
 
 	c.validateFn = c.checkValidatorSignature
+	c.lateMessageSink = c.logLateMessage
 	return c
 }
 
@@ -75,18 +156,44 @@ type core struct {
 	valSet     istanbul.ValidatorSet
 	validateFn func([]byte, []byte) (common.Address, error)
 
-	backlogs   map[common.Address]*prque.Prque
-	backlogsMu *sync.Mutex
+	// lateMessageSink receives the PREPARE and COMMIT messages arriving once their view is committed,
+	// when the committed message policy accounts for them
+	lateMessageSink func(qbfttypes.QBFTMessage)
+
+	// consensusSubs receive the activity of the core as consensus events
+	consensusSubs consensusSubscriptions
+
+	backlogs   map[common.Address]backlogQueue
+	backlogsMu *timedMutex
+
+	// backlogIndex groups the messages of the backlogs by view for the bulk operations, nil unless configured
+	backlogIndex *backlogIndex
+
+	// backlogDrainBudget bounds the time spent by a single backlog drain pass, backlogDrainPending
+	// is set while a yielded drain waits to be resumed
+	backlogDrainBudget  time.Duration
+	backlogDrainPending bool
+	backlogStats        backlogDrainStats
+
+	// dispatchedBacklog keeps the identity and view of the backlog messages dispatched for the current
+	// view, so that a copy resent by a peer meanwhile is not dispatched again. It is guarded by backlogsMu.
+	dispatchedBacklog map[common.Hash]*istanbul.View
 
 	current      *roundState
 	currentMutex sync.Mutex
 	handlerWg    *sync.WaitGroup
 
+	// stopped is set while the core is stopped, the events still in flight at that time are dropped
+	stopped int32
+
 	roundChangeSet   *roundChangeSet
 	roundChangeTimer *time.Timer
 
 	QBFTPreparedPrepares []*qbfttypes.Prepare
 
+	// importedState is the consensus state imported while the core was stopped, restored when it starts
+	importedState *importedState
+
 	pendingRequests   *prque.Prque
 	pendingRequestsMu *sync.Mutex
 
@@ -94,9 +201,120 @@ type core struct {
 
 	newRoundMutex sync.Mutex
 	newRoundTimer *time.Timer
+
+	// logSampler throttles the per-message backlog logs
+	logSampler *istanbulcommon.LogSampler
+
+	// eventQueue keeps backlog and pending request events in dispatch order
+	eventQueue istanbulcommon.EventQueue
+
+	// timeoutGracePending is set on boot and resync so that the next round change
+	// timeout is extended by the configured grace period
+	timeoutGracePending bool
+
+	// futureSequenceMsgs and futureSequenceSources track the messages received for sequences
+	// ahead of the current one, to detect the node is lagging behind
+	futureSequenceMsgs    uint64
+	futureSequenceSources map[common.Address]struct{}
+
+	// highestSeenSequence is the highest sequence of the future messages received from validators
+	highestSeenSequence uint64
+
+	// viewStartTime is when the current view started, used to measure validators latency
+	viewStartTime time.Time
+
+	// lastRoundChange records why and when the last round change occurred, it is read by the RPC API
+	lastRoundChange   *istanbul.RoundChangeInfo
+	lastRoundChangeMu sync.Mutex
+
+	// watchdogPing and watchdogQuit let the watchdog check the event loop is responsive
+	watchdogPing chan chan struct{}
+	watchdogQuit chan struct{}
+
+	// queries carries the reads of the consensus state made by the RPC API to the event loop, which serves
+	// them while handlingEvents is set
+	queries        chan func()
+	handlingEvents int32
+
+	// preprepareRebroadcast is the PRE-PREPARE message of the current view the proposer re-broadcasts
+	preprepareRebroadcast *preprepareRebroadcast
+
+	// commitSealWait is the view whose block the proposer holds back while waiting for the commit seal
+	// target, and commitSealTimer ends the wait
+	commitSealWait  *istanbul.View
+	commitSealTimer *time.Timer
+
+	// proposedDigests are the digests of the blocks proposed for the current sequence, by round
+	proposedDigests map[uint64]common.Hash
+
+	// halted is set once the maximum round got exceeded for the current sequence,
+	// no more round changes happen until the node moves to the next sequence
+	halted bool
+
+	// wal records the messages delivered for the current sequence, nil if disabled
+	wal istanbul.WAL
+
+	// journal records the input events handled by the core, nil if disabled
+	journal istanbul.Journal
+
+	// proposerStatus is whether the node is the proposer of the current view, as last notified to the backend
+	proposerStatus bool
+
+	// sequenceStartTime is when the current sequence started and roundChangeReasons are the reasons
+	// of its round changes, recorded with the consensus statistics of the committed blocks
+	sequenceStartTime  time.Time
+	roundChangeReasons []string
+
+	// recentStats keeps the consensus statistics of the last committed blocks, nil if disabled
+	recentStats *consensusStatsRing
+
+	// participation keeps the validators which took part in the last committed blocks, nil if disabled
+	participation *participationRing
+
+	// rejectedProposals are the blocks of the current sequence rejected by local policy, by hash
+	rejectedProposals map[common.Hash]*rejectedProposal
+
+	// invalidProposals counts the invalid blocks each validator proposed in a row, it is read by the RPC API
+	invalidProposals   map[common.Address]uint64
+	invalidProposalsMu sync.Mutex
+
+	// retainedMessages are the messages received for finalized sequences within the reorg depth, by sequence
+	retainedMessages map[uint64][]qbfttypes.QBFTMessage
+
+	// sentCommitSeals are the commit seals of the COMMIT messages this node sent for the current sequence
+	sentCommitSeals [][]byte
+
+	// sentPhaseMessages are the latest PREPARE and COMMIT messages this node sent, all for the same view
+	sentPhaseMessages []*sentPhaseMessage
+
+	// walRequests are the hashes of the blocks of the requests recorded in the WAL for walRequestsView,
+	// the requests resubmitted for the same block are recorded by reference
+	walRequests     map[common.Hash]struct{}
+	walRequestsView *istanbul.View
+
+	// messageMixes keeps the codes of the latest messages of each validator, to flag the ones sending mostly
+	// ROUND-CHANGE messages
+	messageMixes map[common.Address]*messageMix
+
+	// tieBreakHold is the view whose timeout got extended once for the validators behind to catch up
+	tieBreakHold *istanbul.View
+
+	// logHandler is the handler of the core logger while its logs are escalated, nil otherwise
+	logHandler log.Handler
+
+	// stepCommands carries the single-step debugging commands to the event loop, nil unless stepping is
+	// enabled. paused and stepQueue are only accessed by the event loop: while paused the input events
+	// are queued, to be handled one at a time on command.
+	stepCommands chan *stepCommand
+	paused       bool
+	stepQueue    []interface{}
 }
 
+// currentView returns a copy of the current view, nil until the first view is initialized
 func (c *core) currentView() *istanbul.View {
+	if c.current == nil {
+		return nil
+	}
 	return &istanbul.View{
 		Sequence: new(big.Int).Set(c.current.Sequence()),
 		Round:    new(big.Int).Set(c.current.Round()),
@@ -148,6 +366,13 @@ func (c *core) startNewRound(round *big.Int) {
 			consensusTimer.UpdateSince(c.consensusTimestamp)
 			c.consensusTimestamp = time.Time{}
 		}
+		if diff.Sign() > 0 {
+			// we skipped sequences, give the node time to receive buffered messages
+			c.timeoutGracePending = true
+		}
+		c.resetFutureSequenceTracking()
+		c.halted = false
+		c.proposedDigests = nil
 		logger.Debug("QBFT: catch up last block proposal")
 	} else if lastProposal.Number().Cmp(big.NewInt(c.current.Sequence().Int64()-1)) == 0 {
 		if round.Cmp(common.Big0) == 0 {
@@ -159,6 +384,12 @@ func (c *core) startNewRound(round *big.Int) {
 			return
 		}
 		roundChange = true
+	} else if c.withinReorgDepth(lastProposal.Number()) {
+		// the chain got reorganised below the current sequence, the sequences since are agreed on again
+		c.resetFutureSequenceTracking()
+		c.halted = false
+		c.proposedDigests = nil
+		logger.Warn("QBFT: chain reorganised, resume from last block proposal")
 	} else {
 		logger.Warn("QBFT: next sequence is before last block proposal")
 		return
@@ -183,14 +414,36 @@ func (c *core) startNewRound(round *big.Int) {
 			Sequence: new(big.Int).Add(lastProposal.Number(), common.Big1),
 			Round:    new(big.Int),
 		}
-		c.valSet = c.backend.Validators(lastProposal)
+		c.updateValidatorSet(c.backend.Validators(lastProposal), logger)
+		c.truncateWAL()
 	}
 
+	// a round change may land back on the current round, the round state is reset nevertheless
+	reentry := roundChange && round.Cmp(c.current.Round()) == 0
+
 	// New snapshot for new round
 	c.updateRoundState(newView, c.valSet, roundChange)
+	if !roundChange {
+		c.updateSequenceGap(0)
+		c.sequenceStartTime = time.Now()
+		c.roundChangeReasons = nil
+		c.rejectedProposals = nil
+		c.sentCommitSeals = nil
+		c.sentPhaseMessages = nil
+		c.revertLogEscalation()
+	}
+	c.viewStartTime = time.Now()
+	c.escalateLogs(newView.Round)
+	if roundChange {
+		c.sendConsensusEvent(newConsensusEvent(istanbul.ConsensusEventRoundChange, newView))
+	}
 
 	// Calculate new proposer
-	c.valSet.CalcProposer(lastProposer, newView.Round.Uint64())
+	c.valSet.CalcProposer(lastProposer, newView)
+	c.setProposerStatus(c.IsProposer(), newView)
+	if !roundChange {
+		c.restoreRetainedMessages()
+	}
 	c.setState(StateAcceptRequest)
 
 	if c.current != nil && round.Cmp(c.current.Round()) > 0 {
@@ -210,10 +463,71 @@ func (c *core) startNewRound(round *big.Int) {
 	if round.Uint64() > 0 {
 		c.newRoundChangeTimer()
 	}
+	if reentry {
+		c.rebroadcastOnReentry(logger)
+	}
 
 	oldLogger.Info("QBFT: start new round", "next.round", newView.Round, "next.seq", newView.Sequence, "next.proposer", c.valSet.GetProposer(), "next.valSet", c.valSet.List(), "next.size", c.valSet.Size(), "next.IsProposer", c.IsProposer())
 }
 
+// setProposerStatus records whether the node is the proposer of view, the backend is notified when it changes
+func (c *core) setProposerStatus(isProposer bool, view *istanbul.View) {
+	if isProposer == c.proposerStatus {
+		return
+	}
+	c.proposerStatus = isProposer
+	c.backend.ProposerChanged(isProposer, *view)
+}
+
+// raiseAlert hands an alert about a condition detected at view, nil if unknown, to the backend alert sinks
+func (c *core) raiseAlert(kind string, message string, view *istanbul.View, ctx ...interface{}) {
+	alert := &istanbul.Alert{
+		Kind:      kind,
+		Message:   message,
+		View:      view,
+		Context:   ctx,
+		Timestamp: time.Now(),
+	}
+	c.backend.Alert(alert)
+
+	ev := newConsensusEvent(istanbul.ConsensusEventAlert, view)
+	ev.Alert = alert
+	c.sendConsensusEvent(ev)
+}
+
+// updateValidatorSet switches to the validator set of a new sequence. The membership of the local node
+// is checked against the new set rather than assumed from the previous one, as it may have been removed
+// and re-added while the node was offline or catching up. The backlog is checked again against the new
+// set and view once the state is reset.
+func (c *core) updateValidatorSet(valSet istanbul.ValidatorSet, logger log.Logger) {
+	_, v := valSet.GetByAddress(c.address)
+	isValidator := v != nil
+	if c.valSet == nil {
+		logger.Info("QBFT: validator set loaded", "validator", isValidator, "size", valSet.Size())
+	} else if _, old := c.valSet.GetByAddress(c.address); (old != nil) != isValidator {
+		logger.Info("QBFT: local node membership changed", "validator", isValidator, "size", valSet.Size())
+	}
+	if valSet.Size() == 0 {
+		// nothing can be agreed on, messages are rejected until a block adds validators again
+		logger.Error("QBFT: empty validator set, consensus halted")
+		c.stopTimer()
+	} else if valSet.F() == 0 && (c.valSet == nil || c.valSet.F() > 0 || c.valSet.Size() == 0) {
+		// with 3 validators or less, any failing validator halts consensus
+		noFaultToleranceMeter.Mark(1)
+		logger.Warn("QBFT: validator set tolerates no faulty validator, consensus halts if any validator fails", "size", valSet.Size())
+		c.raiseAlert(istanbul.AlertNoFaultTolerance, "validator set tolerates no faulty validator", c.currentView(), "size", valSet.Size())
+	}
+	faultToleranceGauge.Update(int64(valSet.F()))
+	c.valSet = valSet
+	c.pruneMessageMixes()
+}
+
+// emptyValidatorSet reports whether the validator set of the current sequence is empty. F is then negative
+// and quorums are empty, so no request nor message can be handled safely.
+func (c *core) emptyValidatorSet() bool {
+	return c.valSet != nil && c.valSet.Size() == 0
+}
+
 // updateRoundState updates round state by checking if locking block is necessary
 func (c *core) updateRoundState(view *istanbul.View, validatorSet istanbul.ValidatorSet, roundChange bool) {
 	if roundChange && c.current != nil {
@@ -228,6 +542,10 @@ func (c *core) setState(state State) {
 		oldState := c.state
 		c.state = state
 		c.currentLogger(false, nil).Info("QBFT: changed state", "old.state", oldState.String(), "new.state", state.String())
+
+		ev := newConsensusEvent(istanbul.ConsensusEventState, c.currentView())
+		ev.State = state.String()
+		c.sendConsensusEvent(ev)
 	}
 	if state == StateAcceptRequest {
 		c.processPendingRequests()
@@ -248,6 +566,34 @@ func (c *core) stopFuturePreprepareTimer() {
 	}
 }
 
+// stopNewRoundTimer cancels a delayed PRE-PREPARE waiting for the empty block period
+func (c *core) stopNewRoundTimer() {
+	c.newRoundMutex.Lock()
+	defer c.newRoundMutex.Unlock()
+
+	if c.newRoundTimer != nil {
+		c.newRoundTimer.Stop()
+		c.newRoundTimer = nil
+	}
+}
+
+// haltOnMaxRound returns true if moving to round exceeds the configured maximum round,
+// in which case consensus is halted for the current sequence until operators intervene
+// or the chain moves on
+func (c *core) haltOnMaxRound(round *big.Int) bool {
+	if c.config.MaxRound == 0 || round.Cmp(new(big.Int).SetUint64(c.config.MaxRound)) <= 0 {
+		return false
+	}
+	if !c.halted {
+		c.halted = true
+		c.stopTimer()
+		maxRoundHaltMeter.Mark(1)
+		c.currentLogger(true, nil).Error("QBFT: maximum round exceeded, halting consensus for this height", "target.round", round, "max.round", c.config.MaxRound)
+		c.raiseAlert(istanbul.AlertMaxRound, "maximum round exceeded, consensus halted for this height", c.currentView(), "target.round", round, "max.round", c.config.MaxRound)
+	}
+	return true
+}
+
 func (c *core) stopTimer() {
 	c.stopFuturePreprepareTimer()
 	if c.roundChangeTimer != nil {
@@ -262,6 +608,30 @@ func (c *core) newRoundChangeTimer() {
 		time.Sleep(10 * time.Millisecond)
 	}
 
+	timeout := c.nextRoundChangeTimeout()
+
+	c.currentLogger(true, nil).Trace("QBFT: start new ROUND-CHANGE timer", "timeout", timeout.Seconds())
+	c.roundChangeTimer = time.AfterFunc(timeout, func() {
+		c.sendEvent(timeoutEvent{})
+	})
+}
+
+// nextRoundChangeTimeout returns the timeout of the round change timer about to be started,
+// consuming the one-time grace period if one is pending
+func (c *core) nextRoundChangeTimeout() time.Duration {
+	timeout := c.roundChangeTimeout()
+	if c.timeoutGracePending {
+		c.timeoutGracePending = false
+		if grace := time.Duration(c.config.FirstTimeoutGracePeriod) * time.Millisecond; grace > 0 {
+			c.currentLogger(true, nil).Debug("QBFT: extend first ROUND-CHANGE timeout with grace period", "grace", grace.Seconds())
+			timeout += grace
+		}
+	}
+	return timeout
+}
+
+// roundChangeTimeout returns the round change timeout for the current round
+func (c *core) roundChangeTimeout() time.Duration {
 	// set timeout based on the round number
 	baseTimeout := time.Duration(c.config.GetConfig(c.current.Sequence()).RequestTimeout) * time.Millisecond
 	round := c.current.Round().Uint64()
@@ -292,11 +662,7 @@ func (c *core) newRoundChangeTimer() {
 		// effectively impossible to observe overflow happen when maxRequestTimeout is disabled
 		timeout = baseTimeout * time.Duration(math.Pow(2, float64(round)))
 	}
-
-	c.currentLogger(true, nil).Trace("QBFT: start new ROUND-CHANGE timer", "timeout", timeout.Seconds())
-	c.roundChangeTimer = time.AfterFunc(timeout, func() {
-		c.sendEvent(timeoutEvent{})
-	})
+	return timeout
 }
 
 func (c *core) checkValidatorSignature(data []byte, sig []byte) (common.Address, error) {
@@ -304,16 +670,96 @@ func (c *core) checkValidatorSignature(data []byte, sig []byte) (common.Address,
 }
 
 func (c *core) QuorumSize() int {
-	if c.config.Get2FPlus1Enabled(c.current.sequence) || c.config.Ceil2Nby3Block == nil || (c.current != nil && c.current.sequence.Cmp(c.config.Ceil2Nby3Block) < 0) {
+	if c.config.Use2FPlus1Quorum(c.current.sequence) {
 		c.currentLogger(true, nil).Trace("QBFT: confirmation Formula used 2F+ 1")
-		return (2 * c.valSet.F()) + 1
+	} else {
+		c.currentLogger(true, nil).Trace("QBFT: confirmation Formula used ceil(2N/3)")
+	}
+	return istanbul.QuorumSize(c.config, c.valSet, c.current.sequence)
+}
+
+// prepareQuorumSize returns the number of PREPARE messages needed to move to the prepared state
+func (c *core) prepareQuorumSize() int {
+	return c.phaseQuorumSize(c.config.PrepareQuorum)
+}
+
+// commitQuorumSize returns the number of COMMIT messages needed to commit the block of the current view
+func (c *core) commitQuorumSize() int {
+	return c.phaseQuorumSize(c.config.CommitQuorum)
+}
+
+// phaseQuorumSize returns the configured number of messages of a phase, clamped between the quorum and
+// the validator set size
+func (c *core) phaseQuorumSize(configured uint64) int {
+	quorum := c.QuorumSize()
+	if configured <= uint64(quorum) {
+		return quorum
+	}
+	if size := c.valSet.Size(); configured > uint64(size) {
+		return size
 	}
-	c.currentLogger(true, nil).Trace("QBFT: confirmation Formula used ceil(2N/3)")
-	return int(math.Ceil(float64(2*c.valSet.Size()) / 3))
+	return int(configured)
+}
+
+// QuorumProgress returns the number of PREPARE and COMMIT messages received for the current view
+// against the quorum size of each phase, nil if no view has started yet
+func (c *core) QuorumProgress() *istanbul.QuorumProgress {
+	var progress *istanbul.QuorumProgress
+	c.queryEventLoop(func() { progress = c.quorumProgress() })
+	return progress
+}
+
+// quorumProgress builds the quorum progress of the current view, it must be called from the event loop
+func (c *core) quorumProgress() *istanbul.QuorumProgress {
+	current, valSet := c.current, c.valSet
+	if current == nil || valSet == nil {
+		return nil
+	}
+	return &istanbul.QuorumProgress{
+		Sequence:      current.Sequence().Uint64(),
+		Round:         current.Round().Uint64(),
+		State:         c.state.String(),
+		Prepares:      current.QBFTPrepares.Size(),
+		Commits:       current.QBFTCommits.Size(),
+		PrepareQuorum: c.prepareQuorumSize(),
+		CommitQuorum:  c.commitQuorumSize(),
+		Validators:    valSet.Size(),
+	}
+}
+
+// PreparedCertificate returns the prepared certificate the core holds for its current sequence, nil if it
+// did not prepare a block yet
+func (c *core) PreparedCertificate() *istanbul.PreparedCertificate {
+	var certificate *istanbul.PreparedCertificate
+	c.queryEventLoop(func() { certificate = c.preparedCertificate() })
+	return certificate
+}
+
+// preparedCertificate builds the prepared certificate of the current sequence, it must be called from the event loop
+func (c *core) preparedCertificate() *istanbul.PreparedCertificate {
+	current, prepares := c.current, c.QBFTPreparedPrepares
+	if current == nil || current.preparedRound == nil || len(prepares) == 0 {
+		return nil
+	}
+	certificate := &istanbul.PreparedCertificate{
+		Sequence:     current.Sequence().Uint64(),
+		Round:        current.preparedRound.Uint64(),
+		CurrentRound: current.Round().Uint64(),
+		Digest:       prepares[0].Digest,
+		HasBlock:     current.preparedBlock != nil,
+	}
+	for _, prepare := range prepares {
+		certificate.Senders = append(certificate.Senders, prepare.Source())
+	}
+	sort.Slice(certificate.Senders, func(i, j int) bool {
+		return bytes.Compare(certificate.Senders[i][:], certificate.Senders[j][:]) < 0
+	})
+	return certificate
 }
 
-// PrepareCommittedSeal returns a committed seal for the given header and takes current round under consideration
-func PrepareCommittedSeal(header *types.Header, round uint32) []byte {
+// PrepareCommittedSeal returns a committed seal for the given header and takes current round under consideration,
+// the seal is bound to chainID unless it is nil
+func PrepareCommittedSeal(header *types.Header, round uint32, chainID *big.Int) []byte {
 	h := types.CopyHeader(header)
-	return h.QBFTHashWithRoundNumber(round).Bytes()
+	return istanbul.BindChainID(h.QBFTHashWithRoundNumber(round).Bytes(), chainID)
 }
@@ -0,0 +1,706 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// testBackend implements the parts of istanbul.Backend used by the tests,
+// calling any other method panics
+type testBackend struct {
+	istanbul.Backend
+
+	address      common.Address
+	events       *event.TypeMux
+	peers        istanbul.ValidatorSet
+	lastProposal istanbul.Proposal
+	syncRequests int
+	broadcasts   []uint64
+	payloads     [][]byte // payloads of the broadcasts, in the same order
+	gossips      []uint64
+	resends      []testResend
+	committed    []istanbul.Proposal
+	verifyErr    error
+	verifyDelay  time.Duration // returned along with verifyErr
+	verifies     int
+	known        map[common.Hash]bool // blocks stored locally, besides the last proposal
+
+	// alerts may be raised by the watchdog goroutine
+	alertsMu sync.Mutex
+	alerts   []*istanbul.Alert
+
+	proposerChanges []testProposerChange
+}
+
+type testProposerChange struct {
+	isProposer bool
+	view       istanbul.View
+}
+
+type testResend struct {
+	targets []common.Address
+	code    uint64
+	payload []byte
+}
+
+func (b *testBackend) Address() common.Address {
+	return b.address
+}
+
+func (b *testBackend) EventMux() *event.TypeMux {
+	return b.events
+}
+
+func (b *testBackend) Validators(proposal istanbul.Proposal) istanbul.ValidatorSet {
+	return b.peers
+}
+
+func (b *testBackend) LastProposal() (istanbul.Proposal, common.Address) {
+	return b.lastProposal, common.Address{}
+}
+
+func (b *testBackend) HasBadProposal(hash common.Hash) bool {
+	return false
+}
+
+func (b *testBackend) RequestSync() {
+	b.syncRequests++
+}
+
+func (b *testBackend) ProposerChanged(isProposer bool, view istanbul.View) {
+	b.proposerChanges = append(b.proposerChanges, testProposerChange{isProposer, view})
+}
+
+func (b *testBackend) Alert(alert *istanbul.Alert) {
+	b.alertsMu.Lock()
+	defer b.alertsMu.Unlock()
+	b.alerts = append(b.alerts, alert)
+}
+
+// alertKinds returns the kinds of the alerts raised so far
+func (b *testBackend) alertKinds() []string {
+	b.alertsMu.Lock()
+	defer b.alertsMu.Unlock()
+	kinds := make([]string, len(b.alerts))
+	for i, alert := range b.alerts {
+		kinds[i] = alert.Kind
+	}
+	return kinds
+}
+
+func (b *testBackend) Sign(data []byte) ([]byte, error) {
+	// sign with the address so that test validateFn recovers it
+	return b.address.Bytes(), nil
+}
+
+func (b *testBackend) SignWithoutHashing(data []byte) ([]byte, error) {
+	return b.address.Bytes(), nil
+}
+
+func (b *testBackend) Broadcast(valSet istanbul.ValidatorSet, code uint64, payload []byte) error {
+	b.broadcasts = append(b.broadcasts, code)
+	b.payloads = append(b.payloads, payload)
+	return nil
+}
+
+func (b *testBackend) Gossip(valSet istanbul.ValidatorSet, code uint64, payload []byte) error {
+	b.gossips = append(b.gossips, code)
+	return nil
+}
+
+func (b *testBackend) Resend(targets []common.Address, code uint64, payload []byte) error {
+	b.resends = append(b.resends, testResend{targets, code, payload})
+	return nil
+}
+
+func (b *testBackend) Verify(proposal istanbul.Proposal) (time.Duration, error) {
+	b.verifies++
+	return b.verifyDelay, b.verifyErr
+}
+
+func (b *testBackend) HasPropsal(hash common.Hash, number *big.Int) bool {
+	return b.known[hash]
+}
+
+func (b *testBackend) Commit(proposal istanbul.Proposal, seals [][]byte, round *big.Int) error {
+	b.committed = append(b.committed, proposal)
+	return nil
+}
+
+func newTestValidatorSet(n int) istanbul.ValidatorSet {
+	addrs := make([]common.Address, n)
+	for i := 0; i < n; i++ {
+		privateKey, _ := crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(privateKey.PublicKey)
+	}
+	return validator.NewSet(addrs, istanbul.NewRoundRobinProposerPolicy())
+}
+
+// newTestCore creates a core for the first validator of valSet at sequence 1, round 0
+func newTestCore(config *istanbul.Config, valSet istanbul.ValidatorSet) *core {
+	backend := &testBackend{
+		address:      valSet.GetByIndex(0).Address(),
+		events:       new(event.TypeMux),
+		peers:        valSet,
+		lastProposal: makeBlock(0),
+	}
+	c := New(backend, config).(*core)
+	c.valSet = valSet
+	c.validateFn = func(data []byte, sig []byte) (common.Address, error) {
+		// test messages are "signed" with the address of their source
+		return common.BytesToAddress(sig), nil
+	}
+	c.current = newRoundState(&istanbul.View{
+		Sequence: big.NewInt(1),
+		Round:    big.NewInt(0),
+	}, valSet, nil, nil, nil, nil, func(hash common.Hash) bool {
+		return false
+	})
+	return c
+}
+
+func TestFirstRoundChangeTimeoutGracePeriod(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.RequestTimeout = 1000
+	config.FirstTimeoutGracePeriod = 500
+	c := newTestCore(&config, newTestValidatorSet(4))
+
+	// first timeout after boot is extended
+	if timeout := c.nextRoundChangeTimeout(); timeout != 1500*time.Millisecond {
+		t.Errorf("first timeout mismatch: have %v, want %v", timeout, 1500*time.Millisecond)
+	}
+	// next ones are not
+	if timeout := c.nextRoundChangeTimeout(); timeout != time.Second {
+		t.Errorf("timeout mismatch: have %v, want %v", timeout, time.Second)
+	}
+
+	// moving to the next sequence does not arm it again
+	backend := c.backend.(*testBackend)
+	backend.lastProposal = makeBlock(1)
+	c.startNewRound(common.Big0)
+	if timeout := c.nextRoundChangeTimeout(); timeout != time.Second {
+		t.Errorf("timeout after the next sequence mismatch: have %v, want %v", timeout, time.Second)
+	}
+
+	// a resync skipping sequences arms it again
+	backend.lastProposal = makeBlock(5)
+	c.startNewRound(common.Big0)
+	if view := c.currentView(); view.Sequence.Uint64() != 6 {
+		t.Fatalf("sequence after resync mismatch: have %v, want 6", view.Sequence)
+	}
+	if timeout := c.nextRoundChangeTimeout(); timeout != 1500*time.Millisecond {
+		t.Errorf("timeout after resync mismatch: have %v, want %v", timeout, 1500*time.Millisecond)
+	}
+}
+
+func TestFirstRoundChangeTimeoutWithoutGracePeriod(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.RequestTimeout = 1000
+	c := newTestCore(&config, newTestValidatorSet(4))
+
+	if timeout := c.nextRoundChangeTimeout(); timeout != time.Second {
+		t.Errorf("timeout mismatch: have %v, want %v", timeout, time.Second)
+	}
+}
+
+func TestMaxRoundHaltsConsensus(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.MaxRound = 2
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	backend := c.backend.(*testBackend)
+	defer c.stopTimer()
+
+	// rounds up to the cap go on as usual
+	for round := int64(1); round <= 2; round++ {
+		c.handleTimeoutMsg()
+		if c.current.Round().Int64() != round {
+			t.Fatalf("round mismatch: have %v, want %v", c.current.Round(), round)
+		}
+	}
+	if c.halted {
+		t.Fatalf("consensus should not be halted at the maximum round")
+	}
+	broadcasts := len(backend.broadcasts)
+	halts := maxRoundHaltMeter.Count()
+
+	// moving past the cap halts consensus for the height
+	c.handleTimeoutMsg()
+	if !c.halted {
+		t.Fatalf("consensus should be halted after exceeding the maximum round")
+	}
+	if c.current.Round().Int64() != 2 {
+		t.Errorf("round mismatch: have %v, want 2", c.current.Round())
+	}
+	if len(backend.broadcasts) != broadcasts {
+		t.Errorf("no ROUND-CHANGE should be broadcast once halted")
+	}
+	if c.roundChangeTimer.Stop() {
+		t.Errorf("ROUND-CHANGE timer should be stopped once halted")
+	}
+	if halted := maxRoundHaltMeter.Count() - halts; metrics.Enabled && halted != 1 {
+		t.Errorf("halt meter mismatch: have %v, want 1", halted)
+	}
+
+	// a new height resumes consensus
+	backend.lastProposal = makeBlock(1)
+	c.startNewRound(common.Big0)
+	if c.halted {
+		t.Errorf("consensus should resume on the next sequence")
+	}
+}
+
+// sequenceSelector picks the proposer from both the sequence and the round of the view
+type sequenceSelector struct{}
+
+func (sequenceSelector) SelectProposer(valSet istanbul.ValidatorSet, view *istanbul.View, lastProposer common.Address) istanbul.Validator {
+	seed := 3*view.Sequence.Uint64() + view.Round.Uint64()
+	return valSet.GetByIndex(seed % uint64(valSet.Size()))
+}
+
+func TestCustomProposerSelector(t *testing.T) {
+	addrs := make([]common.Address, 4)
+	for i := range addrs {
+		privateKey, _ := crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(privateKey.PublicKey)
+	}
+	valSet := validator.NewSet(addrs, istanbul.NewCustomProposerPolicy(istanbul.RoundRobin, sequenceSelector{}))
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+
+	checkProposer := func() {
+		view := c.currentView()
+		want := valSet.GetByIndex((3*view.Sequence.Uint64() + view.Round.Uint64()) % 4).Address()
+		if have := c.valSet.GetProposer().Address(); have != want {
+			t.Errorf("proposer mismatch at %v: have %v, want %v", view, have, want)
+		}
+		if c.IsProposer() != (want == c.Address()) {
+			t.Errorf("is proposer mismatch at %v", view)
+		}
+	}
+
+	// round changes
+	for round := int64(1); round < 4; round++ {
+		c.startNewRound(big.NewInt(round))
+		checkProposer()
+	}
+
+	// new sequences
+	for sequence := int64(1); sequence < 4; sequence++ {
+		c.backend.(*testBackend).lastProposal = makeBlock(sequence)
+		c.startNewRound(common.Big0)
+		if c.currentView().Sequence.Int64() != sequence+1 {
+			t.Fatalf("sequence mismatch: have %v, want %d", c.currentView().Sequence, sequence+1)
+		}
+		checkProposer()
+	}
+}
+
+func TestQuorumProgress(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.PrepareQuorum = 8
+	valSet := newTestValidatorSet(10)
+	c := newTestCore(&config, valSet)
+	proposal := makeBlock(1)
+	c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), proposal))
+	c.state = StatePreprepared
+
+	for i := 0; i < 6; i++ {
+		prepare := qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), proposal.Hash())
+		prepare.SetSource(valSet.GetByIndex(uint64(i)).Address())
+		if err := c.handlePrepare(prepare); err != nil {
+			t.Fatalf("handle PREPARE failed: %v", err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		commit := qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), proposal.Hash(), nil)
+		commit.SetSource(valSet.GetByIndex(uint64(i)).Address())
+		if err := c.handleCommitMsg(commit); err != nil {
+			t.Fatalf("handle COMMIT failed: %v", err)
+		}
+	}
+
+	want := istanbul.QuorumProgress{
+		Sequence:      1,
+		Round:         0,
+		State:         StatePreprepared.String(),
+		Prepares:      6,
+		Commits:       4,
+		PrepareQuorum: 8,
+		CommitQuorum:  7,
+		Validators:    10,
+	}
+	if have := c.QuorumProgress(); have == nil || *have != want {
+		t.Errorf("quorum progress mismatch: have %+v, want %+v", have, want)
+	}
+
+	c.current = nil
+	if have := c.QuorumProgress(); have != nil {
+		t.Errorf("quorum progress mismatch: have %+v, want nil", have)
+	}
+}
+
+func TestQuorumProgressServedByEventLoop(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	if err := c.Start(); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	defer c.Stop()
+
+	// the round changes handled by the event loop do not race with the reads of the RPC API
+	go func() {
+		for i := 0; i < 10; i++ {
+			c.backend.EventMux().Post(timeoutEvent{})
+		}
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		have := c.QuorumProgress()
+		if have == nil || have.Validators != 4 {
+			t.Fatalf("quorum progress mismatch: have %+v, want 4 validators", have)
+		}
+		if have.Round == 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("round mismatch: have %v, want 10", have.Round)
+		}
+	}
+}
+
+func TestPreparedCertificate(t *testing.T) {
+	valSet := newTestValidatorSet(10)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	proposal := makeBlock(1)
+	c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), proposal))
+	c.state = StatePreprepared
+
+	var senders []common.Address
+	for i := 0; i < 7; i++ {
+		if have := c.PreparedCertificate(); have != nil {
+			t.Fatalf("certificate before the quorum: have %+v, want nil", have)
+		}
+		src := valSet.GetByIndex(uint64(i)).Address()
+		prepare := qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), proposal.Hash())
+		prepare.SetSource(src)
+		if err := c.handlePrepare(prepare); err != nil {
+			t.Fatalf("handle PREPARE failed: %v", err)
+		}
+		senders = append(senders, src)
+	}
+	if c.state != StatePrepared {
+		t.Fatalf("state mismatch: have %v, want %v", c.state, StatePrepared)
+	}
+	sort.Slice(senders, func(i, j int) bool { return bytes.Compare(senders[i][:], senders[j][:]) < 0 })
+
+	want := &istanbul.PreparedCertificate{
+		Sequence: 1,
+		Digest:   proposal.Hash(),
+		HasBlock: true,
+		Senders:  senders,
+	}
+	if have := c.PreparedCertificate(); !reflect.DeepEqual(have, want) {
+		t.Errorf("certificate mismatch: have %+v, want %+v", have, want)
+	}
+
+	// the certificate is held across round changes
+	c.startNewRound(common.Big1)
+	want.CurrentRound = 1
+	if have := c.PreparedCertificate(); !reflect.DeepEqual(have, want) {
+		t.Errorf("certificate mismatch after round change: have %+v, want %+v", have, want)
+	}
+}
+
+func TestPreparedCertificateServedByEventLoop(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	if err := c.Start(); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	defer c.Stop()
+
+	// a block prepared by the event loop does not race with the reads of the RPC API
+	proposal := makeBlock(1)
+	go func() {
+		c.backend.EventMux().Post(backlogEvent{msg: signedBy(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), proposal), valSet.GetProposer().Address())})
+		for i := 0; i < 3; i++ {
+			src := valSet.GetByIndex(uint64(i)).Address()
+			c.backend.EventMux().Post(backlogEvent{msg: signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), proposal.Hash()), src)})
+		}
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if have := c.PreparedCertificate(); have != nil {
+			if have.Digest != proposal.Hash() || len(have.Senders) != 3 {
+				t.Fatalf("certificate mismatch: have %+v, want 3 senders of %v", have, proposal.Hash())
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("certificate mismatch: have nil, want a certificate")
+		}
+	}
+}
+
+func TestRejoinAfterOfflineValidatorSetChange(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	backend := c.backend.(*testBackend)
+	self, stayed, removed := c.Address(), valSet.GetByIndex(1).Address(), valSet.GetByIndex(2).Address()
+
+	c.addToBacklog(newFuturePrepare(3, stayed))
+	c.addToBacklog(newFuturePrepare(5, stayed))
+	c.addToBacklog(newFuturePrepare(5, removed))
+
+	// while offline, the local node and another validator got removed, then only the local node re-added
+	rejoined := valSet.Copy()
+	rejoined.RemoveValidator(removed)
+	backend.peers = rejoined
+	backend.lastProposal = makeBlock(4)
+	c.startNewRound(common.Big0)
+
+	if view := c.currentView(); view.Sequence.Uint64() != 5 || view.Round.Uint64() != 0 {
+		t.Fatalf("view mismatch: have %v, want sequence 5 round 0", view)
+	}
+	if c.valSet != rejoined || c.roundChangeSet.validatorSet != rejoined {
+		t.Errorf("validator set not re-synced")
+	}
+	if want := rejoined.IsProposer(self); c.IsProposer() != want {
+		t.Errorf("is proposer mismatch: have %v, want %v", c.IsProposer(), want)
+	}
+	// the backlog was checked again: stale and non validator messages are dropped
+	if _, ok := c.backlogs[removed]; ok {
+		t.Errorf("backlog of a removed validator kept")
+	}
+	if size := c.backlogs[stayed].Size(); size != 1 {
+		t.Errorf("backlog size mismatch: have %d, want 1", size)
+	}
+
+	// the local node is removed for good
+	left := rejoined.Copy()
+	left.RemoveValidator(self)
+	backend.peers = left
+	backend.lastProposal = makeBlock(5)
+	c.startNewRound(common.Big0)
+	if c.IsProposer() {
+		t.Errorf("removed node should not propose")
+	}
+	if _, v := c.valSet.GetByAddress(self); v != nil {
+		t.Errorf("removed node still in the validator set")
+	}
+}
+
+func TestEmptyValidatorSetHalts(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	backend := c.backend.(*testBackend)
+
+	// every validator got removed by the last block
+	backend.peers = validator.NewSet(nil, istanbul.NewRoundRobinProposerPolicy())
+	backend.lastProposal = makeBlock(1)
+	c.startNewRound(common.Big0)
+	if c.valSet.Size() != 0 || c.IsProposer() {
+		t.Fatalf("validator set mismatch: have size %d, proposer %v, want empty", c.valSet.Size(), c.IsProposer())
+	}
+
+	// requests and messages are rejected rather than reaching quorum checks
+	if err := c.handleRequest(&Request{Proposal: makeBlock(2)}); err != errEmptyValidatorSet {
+		t.Errorf("request error mismatch: have %v, want %v", err, errEmptyValidatorSet)
+	}
+	roundChange := signedBy(qbfttypes.NewRoundChange(big.NewInt(2), big.NewInt(1), nil, nil), valSet.GetByIndex(1).Address())
+	if err := c.handleDecodedMessage(roundChange); err != errEmptyValidatorSet {
+		t.Errorf("message error mismatch: have %v, want %v", err, errEmptyValidatorSet)
+	}
+	c.handleTimeoutMsg()
+	if round := c.current.Round().Uint64(); round != 0 || len(backend.broadcasts) != 0 {
+		t.Errorf("consensus not halted: round %d, broadcasts %v", round, backend.broadcasts)
+	}
+}
+
+func TestProposerChangeNotified(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	backend := c.backend.(*testBackend)
+
+	// the proposer rotates over the 4 validators, the node proposes round 4 only
+	var want []testProposerChange
+	status := false
+	for round := int64(1); round <= 5; round++ {
+		c.startNewRound(big.NewInt(round))
+		if isProposer := valSet.IsProposer(c.Address()); isProposer != status {
+			status = isProposer
+			want = append(want, testProposerChange{isProposer, istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(round)}})
+		}
+	}
+	if len(want) != 2 || !want[0].isProposer || want[1].isProposer {
+		t.Fatalf("unexpected proposer rotation: %v", want)
+	}
+
+	if len(backend.proposerChanges) != len(want) {
+		t.Fatalf("notifications mismatch: have %v, want %v", backend.proposerChanges, want)
+	}
+	for i, change := range backend.proposerChanges {
+		if change.isProposer != want[i].isProposer || change.view.Cmp(&want[i].view) != 0 {
+			t.Errorf("notification %d mismatch: have %v %v, want %v %v", i, change.isProposer, change.view, want[i].isProposer, want[i].view)
+		}
+	}
+}
+
+func TestNoFaultToleranceWarning(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	var warnings []*log.Record
+	c.logger.SetHandler(log.LvlFilterHandler(log.LvlWarn, log.FuncHandler(func(r *log.Record) error {
+		warnings = append(warnings, r)
+		return nil
+	})))
+	marked := noFaultToleranceMeter.Count()
+
+	// a set of 4 validators tolerates one faulty validator
+	c.updateValidatorSet(valSet.Copy(), c.logger)
+	if len(warnings) != 0 {
+		t.Fatalf("warnings mismatch with 4 validators: have %d, want 0", len(warnings))
+	}
+
+	// shrinking it to 3 leaves no fault tolerance
+	shrunk := valSet.Copy()
+	shrunk.RemoveValidator(valSet.GetByIndex(3).Address())
+	c.updateValidatorSet(shrunk, c.logger)
+	if len(warnings) != 1 {
+		t.Fatalf("warnings mismatch with 3 validators: have %d, want 1", len(warnings))
+	}
+	if f := faultToleranceGauge.Value(); metrics.Enabled && f != 0 {
+		t.Errorf("fault tolerance mismatch: have %d, want 0", f)
+	}
+	if n := noFaultToleranceMeter.Count() - marked; metrics.Enabled && n != 1 {
+		t.Errorf("meter mismatch: have %d, want 1", n)
+	}
+
+	// the warning fires once per shrink, not on every sequence
+	c.updateValidatorSet(shrunk.Copy(), c.logger)
+	if len(warnings) != 1 {
+		t.Errorf("warnings mismatch with the same 3 validators: have %d, want 1", len(warnings))
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.MaxRound = 1
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	backend := c.backend.(*testBackend)
+	valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+
+	// the proposer sends two blocks for the same view
+	for gasLimit := uint64(1); gasLimit <= 2; gasLimit++ {
+		block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), GasLimit: gasLimit})
+		c.handleDecodedMessage(signedBy(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), block), valSet.GetProposer().Address()))
+	}
+	// the rounds go past the cap
+	c.handleTimeoutMsg()
+	c.handleTimeoutMsg()
+	// the validator set shrinks to 3
+	shrunk := valSet.Copy()
+	shrunk.RemoveValidator(valSet.GetByIndex(3).Address())
+	c.updateValidatorSet(shrunk, c.logger)
+	// the event loop gets stuck
+	c.reportStall(time.Second)
+
+	want := []string{istanbul.AlertEquivocation, istanbul.AlertMaxRound, istanbul.AlertNoFaultTolerance, istanbul.AlertStall}
+	if kinds := backend.alertKinds(); !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("alerts mismatch: have %v, want %v", kinds, want)
+	}
+	if view := backend.alerts[1].View; view == nil || view.Round.Uint64() != 1 {
+		t.Errorf("maximum round alert view mismatch: have %v, want round 1", view)
+	}
+	if view := backend.alerts[3].View; view != nil {
+		t.Errorf("stall alert view mismatch: have %v, want nil", view)
+	}
+}
+
+func TestPhaseQuorums(t *testing.T) {
+	tests := []struct {
+		prepareQuorum, commitQuorum uint64
+		wantPrepares, wantCommits   int
+	}{
+		{wantPrepares: 5, wantCommits: 5},                                     // standard quorums
+		{prepareQuorum: 7, wantPrepares: 7, wantCommits: 5},                   // unanimous prepares, standard commits
+		{prepareQuorum: 3, commitQuorum: 6, wantPrepares: 5, wantCommits: 6},  // below the quorum is raised to it
+		{prepareQuorum: 6, commitQuorum: 10, wantPrepares: 6, wantCommits: 7}, // above the set size is clamped to it
+	}
+	for i, tt := range tests {
+		config := *istanbul.DefaultConfig
+		config.PrepareQuorum, config.CommitQuorum = tt.prepareQuorum, tt.commitQuorum
+		valSet := newTestValidatorSet(7)
+		c := newTestCore(&config, valSet)
+		valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(1)})
+		proposal := makeBlock(1)
+		c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), proposal))
+		c.state = StatePreprepared
+
+		for n := 1; n <= valSet.Size(); n++ {
+			prepare := signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), proposal.Hash()), valSet.GetByIndex(uint64(n-1)).Address())
+			if err := c.handlePrepare(prepare.(*qbfttypes.Prepare)); err != nil {
+				t.Fatalf("test %d: handle PREPARE failed: %v", i, err)
+			}
+			if prepared := c.state == StatePrepared; prepared != (n >= tt.wantPrepares) {
+				t.Fatalf("test %d: prepared after %d PREPARE messages: have %v, want %v", i, n, prepared, n >= tt.wantPrepares)
+			}
+		}
+		for n := 1; n <= valSet.Size() && c.state != StateCommitted; n++ {
+			commit := signedBy(qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), proposal.Hash(), nil), valSet.GetByIndex(uint64(n-1)).Address())
+			if err := c.handleCommitMsg(commit.(*qbfttypes.Commit)); err != nil {
+				t.Fatalf("test %d: handle COMMIT failed: %v", i, err)
+			}
+			if committed := c.state == StateCommitted; committed != (n >= tt.wantCommits) {
+				t.Fatalf("test %d: committed after %d COMMIT messages: have %v, want %v", i, n, committed, n >= tt.wantCommits)
+			}
+		}
+	}
+}
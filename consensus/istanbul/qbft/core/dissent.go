@@ -0,0 +1,105 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// maxRejectedProposals bounds the number of blocks rejected by local policy recorded for a sequence
+const maxRejectedProposals = 16
+
+// rejectedProposal is a block of the current sequence this node rejected by local policy, with the COMMIT
+// messages the other validators sent for it, by round
+type rejectedProposal struct {
+	proposal istanbul.Proposal
+	reason   string
+	commits  map[uint64]*qbftMsgSet
+}
+
+// rejectProposal records proposal as rejected for reason, so that it is imported if a quorum of validators
+// commits it anyway. It does nothing if the rejected proposal policy is to ignore such commits, or once
+// maxRejectedProposals blocks are recorded for the sequence, the chain synchronisation imports the others.
+func (c *core) rejectProposal(proposal istanbul.Proposal, reason string) {
+	if c.config.RejectedProposalPolicy == istanbul.RejectedProposalIgnore {
+		return
+	}
+	if c.rejectedProposals == nil {
+		c.rejectedProposals = make(map[common.Hash]*rejectedProposal)
+	}
+	if _, ok := c.rejectedProposals[proposal.Hash()]; ok {
+		return
+	}
+	if len(c.rejectedProposals) >= maxRejectedProposals {
+		c.currentLogger(true, nil).Debug("QBFT: too many rejected blocks for the sequence, not recorded", "hash", proposal.Hash(), "reason", reason)
+		return
+	}
+	c.rejectedProposals[proposal.Hash()] = &rejectedProposal{
+		proposal: proposal,
+		reason:   reason,
+		commits:  make(map[uint64]*qbftMsgSet),
+	}
+}
+
+// handleRejectedProposalCommit accumulates commit if it is for a block of the current sequence this node
+// rejected, and imports the block once a quorum of validators committed it in the same round, recording the
+// dissent with the consensus statistics. It returns whether commit was consumed.
+func (c *core) handleRejectedProposalCommit(commit *qbfttypes.Commit) bool {
+	rejected, ok := c.rejectedProposals[commit.Digest]
+	if !ok || commit.Sequence.Cmp(c.current.Sequence()) != 0 {
+		return false
+	}
+	// the block got accepted in a later round, its commits are handled as usual
+	if proposal := c.current.Proposal(); proposal != nil && proposal.Hash() == commit.Digest {
+		return false
+	}
+
+	logger := c.currentLogger(true, commit)
+
+	round := commit.Round.Uint64()
+	commits, ok := rejected.commits[round]
+	if !ok {
+		commits = newQBFTMsgSet(c.valSet)
+		rejected.commits[round] = commits
+	}
+	if err := commits.Add(commit); err != nil {
+		logger.Warn("QBFT: failed to save COMMIT message for rejected block", "err", err)
+		return true
+	}
+	if quorum := c.commitQuorumSize(); commits.Size() < quorum {
+		logger.Debug("QBFT: accepted COMMIT message for rejected block", "commits.count", commits.Size(), "quorum", quorum)
+		return true
+	}
+
+	committedSeals := make([][]byte, commits.Size())
+	for i, msg := range commits.Values() {
+		committedSeals[i] = make([]byte, types.IstanbulExtraSeal)
+		copy(committedSeals[i][:], msg.(*qbfttypes.Commit).CommitSeal[:])
+	}
+	if err := c.backend.Commit(rejected.proposal, committedSeals, commit.Round); err != nil {
+		logger.Error("QBFT: error committing rejected block", "err", err)
+		return true
+	}
+	delete(c.rejectedProposals, commit.Digest)
+	dissentMeter.Mark(1)
+	logger.Warn("QBFT: imported block committed by a quorum of validators despite local rejection", "reason", rejected.reason)
+	c.addConsensusStats(rejected.proposal, commit.Round, commits, rejected.reason)
+	return true
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestRejectedProposalPolicy(t *testing.T) {
+	for _, policy := range []string{"", istanbul.RejectedProposalImport, istanbul.RejectedProposalIgnore} {
+		t.Run(policy, func(t *testing.T) {
+			config := *istanbul.DefaultConfig
+			config.PreprepareMaxFutureDrift = 5
+			config.ConsensusStatsWindow = 1
+			config.RejectedProposalPolicy = policy
+			valSet := newTestValidatorSet(4)
+			c := newTestCore(&config, valSet)
+			c.roundChangeSet = newRoundChangeSet(valSet)
+			defer c.stopTimer()
+			valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+
+			// the block is rejected for its timestamp and the node moves to round 1
+			block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Time: uint64(time.Now().Add(time.Minute).Unix())})
+			preprepare := qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), block)
+			signedBy(preprepare, valSet.GetProposer().Address())
+			if err := c.handlePreprepareMsg(preprepare); err == nil {
+				t.Fatal("error mismatch: have nil, want timestamp error")
+			}
+			if round := c.current.Round().Uint64(); round != 1 {
+				t.Fatalf("round mismatch: have %d, want 1", round)
+			}
+
+			// the other validators commit it in round 0
+			for i := uint64(1); i < 4; i++ {
+				commit := qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), block.Hash(), nil)
+				err := c.handleDecodedMessage(signedBy(commit, valSet.GetByIndex(i).Address()))
+				if policy == istanbul.RejectedProposalIgnore {
+					if err != errOldMessage {
+						t.Fatalf("error mismatch: have %v, want %v", err, errOldMessage)
+					}
+				} else if err != errRejectedProposalCommit {
+					t.Fatalf("error mismatch: have %v, want %v", err, errRejectedProposalCommit)
+				}
+			}
+
+			committed := c.backend.(*testBackend).committed
+			if policy == istanbul.RejectedProposalIgnore {
+				if len(committed) != 0 {
+					t.Errorf("committed blocks mismatch: have %d, want 0", len(committed))
+				}
+				return
+			}
+			if len(committed) != 1 || committed[0].Hash() != block.Hash() {
+				t.Fatalf("committed blocks mismatch: have %v, want block %v", committed, block.Hash())
+			}
+			stats := c.RecentConsensusStats()
+			if len(stats) != 1 || stats[0].Hash != block.Hash() || stats[0].Dissent == "" || len(stats[0].Committers) != 3 {
+				t.Errorf("stats mismatch: have %+v, want the block with 3 committers and the dissent", stats)
+			}
+		})
+	}
+}
+
+func TestRejectedProposalCommitQuorum(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.PreprepareMaxFutureDrift = 5
+	config.CommitQuorum = 4
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Time: uint64(time.Now().Add(time.Minute).Unix())})
+	preprepare := qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), block)
+	signedBy(preprepare, valSet.GetProposer().Address())
+	if err := c.handlePreprepareMsg(preprepare); err == nil {
+		t.Fatal("error mismatch: have nil, want timestamp error")
+	}
+
+	// the quorum of COMMIT messages is not enough when more are configured
+	for i := uint64(1); i < 4; i++ {
+		commit := signedBy(qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), block.Hash(), nil), valSet.GetByIndex(i).Address())
+		payload, err := rlp.EncodeToBytes(commit)
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		c.handleEvent(istanbul.MessageEvent{Code: qbfttypes.CommitCode, Payload: payload})
+	}
+	backend := c.backend.(*testBackend)
+	if len(backend.committed) != 0 {
+		t.Errorf("committed blocks mismatch: have %d, want 0", len(backend.committed))
+	}
+	// the COMMIT messages for a block this node rejected are not gossiped further
+	if len(backend.gossips) != 0 {
+		t.Errorf("gossiped COMMIT messages: have %v, want none", backend.gossips)
+	}
+	if commits := c.rejectedProposals[block.Hash()].commits[0]; commits == nil || commits.Size() != 3 {
+		t.Errorf("COMMIT messages for the rejected block not accumulated")
+	}
+}
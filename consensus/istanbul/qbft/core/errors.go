@@ -34,4 +34,48 @@ var (
 	errInvalidSigner = errors.New("message not signed by the sender")
 	// errInvalidPreparedBlock is returned when prepared block is not validated in round change messages
 	errInvalidPreparedBlock = errors.New("invalid prepared block in round change messages")
+	// errInvalidPreparedCertificate is returned when a round change message claims a prepared block
+	// without a quorum of matching PREPARE messages from distinct validators
+	errInvalidPreparedCertificate = errors.New("invalid prepared certificate in round change message")
+	// errConsensusEventsLagging is reported to the consensus events subscribers dropped for not receiving the
+	// events as fast as they are sent
+	errConsensusEventsLagging = errors.New("consensus events subscriber lagging behind")
+	// errNotFromValidator is returned when a message source is not part of the validator set
+	errNotFromValidator = errors.New("message does not come from a validator")
+	// errTooManyMessages is returned when accepting a message would give more distinct
+	// sources than there are validators
+	errTooManyMessages = errors.New("more message sources than validators")
+	// errNoConsensusState is returned when exporting the consensus state before the core has a current view
+	errNoConsensusState = errors.New("no consensus state to export")
+	// errHeadMismatch is returned when importing a consensus state exported at a different chain head
+	errHeadMismatch = errors.New("consensus state chain head does not match local chain head")
+	// errInvalidConsensusState is returned when importing a consensus state whose view, messages or lock
+	// are not consistent
+	errInvalidConsensusState = errors.New("invalid consensus state")
+	// errQueryTimeout is returned when the event loop does not serve a read of the consensus state in time
+	errQueryTimeout = errors.New("consensus event loop busy")
+	// errSelfMessage is returned when a message sent by this node is received back from a peer
+	errSelfMessage = errors.New("own message received from a peer")
+	// errEmptyValidatorSet is returned when handling a message while the validator set is empty
+	errEmptyValidatorSet = errors.New("empty validator set")
+	// errProposalTimestamp is returned when the timestamp of a proposed block is outside the accepted window
+	errProposalTimestamp = errors.New("proposal timestamp out of window")
+	// errConflictingPreprepare is returned when a PRE-PREPARE message proposes another block than the one
+	// accepted for the same view
+	errConflictingPreprepare = errors.New("conflicting PRE-PREPARE for the current view")
+	// errInvalidCertificateSignature is returned when the justification of a PRE-PREPARE message is not signed
+	// by its proposer, while signed certificates are required
+	errInvalidCertificateSignature = errors.New("justification not signed by the proposer")
+	// errProposalParent is returned when a proposed block does not extend the local chain head
+	errProposalParent = errors.New("proposal parent is not the chain head")
+	// errSteppingDisabled is returned by the single-step debugging commands when stepping is not enabled
+	errSteppingDisabled = errors.New("single-step debugging not enabled")
+	// errNotPaused is returned when stepping through the events while the event loop is not paused
+	errNotPaused = errors.New("event loop not paused")
+	// errLateMessage is returned when a PREPARE or COMMIT message for the committed view is accounted for,
+	// it is not gossiped further
+	errLateMessage = errors.New("late message for the committed view")
+	// errRejectedProposalCommit is returned when a COMMIT message for a block rejected by this node is handled,
+	// it is not gossiped further
+	errRejectedProposalCommit = errors.New("COMMIT message for a rejected block")
 )
@@ -27,3 +27,9 @@ type backlogEvent struct {
 }
 
 type timeoutEvent struct{}
+
+type preprepareRebroadcastEvent struct{}
+
+type backlogDrainEvent struct{}
+
+type commitSealTimeoutEvent struct{}
@@ -0,0 +1,73 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// recordInvalidProposal counts a block proposed by proposer which failed validation with err, and reports
+// the proposer as faulty once it proposed the configured number of invalid blocks in a row. Blocks rejected
+// because this node lacks their ancestors say nothing about the proposer and are not counted.
+//
+// The report is diagnostic only, the proposer selection must be the same on all the validators and is left
+// untouched.
+func (c *core) recordInvalidProposal(proposer common.Address, err error) {
+	threshold := c.config.InvalidProposalThreshold
+	if threshold == 0 || err == consensus.ErrUnknownAncestor || err == consensus.ErrPrunedAncestor {
+		return
+	}
+	c.invalidProposalsMu.Lock()
+	if c.invalidProposals == nil {
+		c.invalidProposals = make(map[common.Address]uint64)
+	}
+	c.invalidProposals[proposer]++
+	invalid := c.invalidProposals[proposer]
+	c.invalidProposalsMu.Unlock()
+	if invalid != threshold {
+		return
+	}
+
+	faultyProposerMeter.Mark(1)
+	c.currentLogger(true, nil).Warn("QBFT: validator proposed invalid blocks repeatedly", "validator", proposer, "invalid", invalid)
+	c.raiseAlert(istanbul.AlertFaultyProposer, "validator proposed invalid blocks repeatedly", c.currentView(), "validator", proposer, "invalid", invalid)
+}
+
+// recordValidProposal resets the count of invalid blocks proposed in a row by proposer
+func (c *core) recordValidProposal(proposer common.Address) {
+	c.invalidProposalsMu.Lock()
+	defer c.invalidProposalsMu.Unlock()
+	delete(c.invalidProposals, proposer)
+}
+
+// FaultyProposers returns the validators which proposed at least InvalidProposalThreshold invalid blocks in
+// a row, with the number of invalid blocks they proposed since their last valid one
+func (c *core) FaultyProposers() map[common.Address]uint64 {
+	c.invalidProposalsMu.Lock()
+	defer c.invalidProposalsMu.Unlock()
+
+	faulty := make(map[common.Address]uint64)
+	threshold := c.config.InvalidProposalThreshold
+	for proposer, invalid := range c.invalidProposals {
+		if threshold > 0 && invalid >= threshold {
+			faulty[proposer] = invalid
+		}
+	}
+	return faulty
+}
@@ -16,10 +16,31 @@
 
 package core
 
-import "github.com/ethereum/go-ethereum/common"
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
 
 func (c *core) handleFinalCommitted() error {
-	c.currentLogger(true, nil).Info("QBFT: handle final committed")
+	logger := c.currentLogger(true, nil)
+	logger.Info("QBFT: handle final committed")
+
+	lastProposal, _ := c.backend.LastProposal()
+	if lastProposal != nil && c.current != nil && lastProposal.Number().Cmp(c.current.Sequence()) == 0 {
+		// A block for the sequence we are working on got committed before we reached the
+		// Committed state ourselves (e.g. it was imported from the network), the current
+		// round is abandoned rather than running until its timeout
+		if c.state != StateCommitted {
+			logger.Info("QBFT: adopt block committed by the network, cancel current round", "number", lastProposal.Number(), "hash", lastProposal.Hash())
+			networkCommitMeter.Mark(1)
+			c.stopNewRoundTimer()
+		}
+
+		// Our COMMIT may have reached the proposer after it committed the block
+		if block, ok := lastProposal.(*types.Block); ok {
+			c.checkCommitInclusion(block, logger)
+		}
+	}
 
 	// Stopping the timer, so that round changes do not happen
 	c.stopTimer()
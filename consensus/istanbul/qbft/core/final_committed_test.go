@@ -0,0 +1,124 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestHandleFinalCommittedByNetworkMidRound(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+
+	// node is in the middle of round 2 of sequence 1
+	c.current = newRoundState(&istanbul.View{
+		Sequence: big.NewInt(1),
+		Round:    big.NewInt(2),
+	}, valSet, nil, nil, nil, nil, c.backend.HasBadProposal)
+	c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(2), makeBlock(1)))
+	c.state = StatePreprepared
+	c.roundChangeTimer = time.AfterFunc(time.Hour, func() {})
+	c.newRoundTimer = time.AfterFunc(time.Hour, func() {})
+	newRoundTimer := c.newRoundTimer
+
+	// block 1 gets committed by the network and imported
+	committed := makeBlock(1)
+	c.backend.(*testBackend).lastProposal = committed
+	if err := c.handleFinalCommitted(); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+
+	if view := c.currentView(); view.Sequence.Cmp(big.NewInt(2)) != 0 || view.Round.Cmp(common.Big0) != 0 {
+		t.Errorf("view mismatch: have %v, want {Round: 0, Sequence: 2}", view)
+	}
+	if c.state != StateAcceptRequest {
+		t.Errorf("state mismatch: have %v, want %v", c.state, StateAcceptRequest)
+	}
+	if c.current.Proposal() != nil {
+		t.Errorf("the abandoned round proposal should be cleared")
+	}
+	if c.roundChangeTimer.Stop() {
+		t.Errorf("the ROUND-CHANGE timer of the abandoned round should be stopped")
+	}
+	if c.newRoundTimer != nil || newRoundTimer.Stop() {
+		t.Errorf("the delayed PRE-PREPARE of the abandoned round should be cancelled")
+	}
+}
+
+func TestExcludedCommitNotification(t *testing.T) {
+	for _, included := range []bool{false, true} {
+		valSet := newTestValidatorSet(4)
+		c := newTestCore(istanbul.DefaultConfig, valSet)
+		c.roundChangeSet = newRoundChangeSet(valSet)
+		defer c.stopTimer()
+		var warnings int
+		c.logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+			if r.Msg == "QBFT: own COMMIT not included in the committed block seals, node may be lagging" {
+				warnings++
+			}
+			return nil
+		}))
+
+		// the node prepared block 1 and sent its COMMIT
+		c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), makeBlock(1)))
+		c.state = StatePrepared
+		c.broadcastCommit()
+
+		// the proposer committed the block with the seals of the other validators, the COMMIT of the node
+		// arriving after the block got committed unless it is included
+		var seals [][]byte
+		for _, v := range valSet.List() {
+			if v.Address() != c.Address() || included {
+				seals = append(seals, v.Address().Bytes())
+			}
+		}
+		extra, err := rlp.EncodeToBytes(&types.QBFTExtra{CommittedSeal: seals})
+		if err != nil {
+			t.Fatalf("failed to encode extra: %v", err)
+		}
+		excluded := excludedCommitMeter.Count()
+		c.backend.(*testBackend).lastProposal = types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Extra: extra})
+		if err := c.handleFinalCommitted(); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+
+		want := 1
+		if included {
+			want = 0
+		}
+		if warnings != want {
+			t.Errorf("included %v: warnings mismatch: have %d, want %d", included, warnings, want)
+		}
+		if n := excludedCommitMeter.Count() - excluded; metrics.Enabled && n != int64(want) {
+			t.Errorf("included %v: excluded commits mismatch: have %d, want %d", included, n, want)
+		}
+		if len(c.sentCommitSeals) != 0 {
+			t.Errorf("included %v: sent commit seals kept for the next sequence", included)
+		}
+	}
+}
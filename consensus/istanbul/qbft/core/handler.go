@@ -19,6 +19,8 @@ package core
 import (
 	"fmt"
 	"math/big"
+	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
@@ -30,14 +32,23 @@ import (
 // Start implements core.Engine.Start
 func (c *core) Start() error {
 	c.logger.Info("QBFT: start")
+	atomic.StoreInt32(&c.stopped, 0)
 	// Tests will handle events itself, so we have to make subscribeEvents()
 	// be able to call in test.
 	c.subscribeEvents()
-	c.handlerWg.Add(1)
-	go c.handleEvents()
 
-	// Start a new round from last sequence + 1
+	// Start a new round from last sequence + 1, or resume from an imported consensus state, then replay the
+	// messages delivered for it before a restart
+	entries := c.readWAL()
 	c.startNewRound(common.Big0)
+	c.restoreImportedState()
+	c.replayWAL(entries)
+
+	c.startWatchdog()
+	c.handlerWg.Add(1)
+	// the queries are served by the event loop from now on
+	atomic.StoreInt32(&c.handlingEvents, 1)
+	go c.handleEvents()
 
 	return nil
 }
@@ -45,8 +56,10 @@ func (c *core) Start() error {
 // Stop implements core.Engine.Stop
 func (c *core) Stop() error {
 	c.logger.Info("QBFT: stopping...")
+	atomic.StoreInt32(&c.stopped, 1)
 	c.stopTimer()
 	c.unsubscribeEvents()
+	c.stopWatchdog()
 
 	// Make sure the handler goroutine exits
 	c.handlerWg.Wait()
@@ -64,6 +77,9 @@ func (c *core) subscribeEvents() {
 		istanbul.MessageEvent{},
 		// internal events
 		backlogEvent{},
+		preprepareRebroadcastEvent{},
+		backlogDrainEvent{},
+		commitSealTimeoutEvent{},
 	)
 	c.timeoutSub = c.backend.EventMux().Subscribe(
 		timeoutEvent{},
@@ -92,9 +108,15 @@ func (c *core) unsubscribeEvents() {
 
 // Each time a message is successfully handled it is gossiped to other validators
 func (c *core) handleEvents() {
-	// Clear state
+	// Clear state, the node is not the proposer anymore once stopped
 	defer func() {
+		atomic.StoreInt32(&c.handlingEvents, 0)
+		if c.current != nil {
+			c.setProposerStatus(false, c.currentView())
+		}
 		c.current = nil
+		// the events queued while paused are dropped with the other events in flight
+		c.paused, c.stepQueue = false, nil
 		c.handlerWg.Done()
 	}()
 
@@ -104,68 +126,148 @@ func (c *core) handleEvents() {
 			if !ok {
 				return
 			}
-
-			// A real event arrived, process interesting content
-			switch ev := event.Data.(type) {
-			case istanbul.RequestEvent:
-				// we are block proposer and look to get our block proposal validated by other validators
-				r := &Request{
-					Proposal: ev.Proposal,
-				}
-				err := c.handleRequest(r)
-				if err == errFutureMessage {
-					// store request for later treatment
-					c.storeRequestMsg(r)
-				}
-			case istanbul.MessageEvent:
-				// we received a message from another validator
-				if err := c.handleEncodedMsg(ev.Code, ev.Payload); err != nil {
-					continue
-				}
-
-				// if successfully processed, we gossip message to other validators
-				c.backend.Gossip(c.valSet, ev.Code, ev.Payload)
-			case backlogEvent:
-				// we process again a future message that was backlogged
-				// no need to check signature as it was already node when we first received message
-				if err := c.handleDecodedMessage(ev.msg); err != nil {
-					continue
-				}
-
-				data, err := rlp.EncodeToBytes(ev.msg)
-				if err != nil {
-					c.logger.Error("QBFT: can not encode backlog message", "err", err)
-					continue
-				}
-
-				// if successfully processed, we gossip message to other validators
-				c.backend.Gossip(c.valSet, ev.msg.Code(), data)
-			}
+			c.dispatchEvent(event.Data)
 		case _, ok := <-c.timeoutSub.Chan():
 			// we received a round change timeout
 			if !ok {
 				return
 			}
-			c.handleTimeoutMsg()
+			c.dispatchEvent(timeoutEvent{})
+		case ack := <-c.watchdogPing:
+			// the watchdog checks we are still handling events
+			close(ack)
 		case event, ok := <-c.finalCommittedSub.Chan():
 			// our block proposal got committed
 			if !ok {
 				return
 			}
-			switch event.Data.(type) {
-			case istanbul.FinalCommittedEvent:
-				c.handleFinalCommitted()
-			}
+			c.dispatchEvent(event.Data)
+		case query := <-c.queries:
+			// read of the consensus state on behalf of the RPC API
+			query()
+		case cmd := <-c.stepCommands:
+			// single-step debugging command, never received unless stepping is enabled
+			cmd.reply <- c.handleStepCommand(cmd.kind)
+		}
+	}
+}
+
+// queryTimeout bounds the wait for the event loop to serve a read of the consensus state
+const queryTimeout = 5 * time.Second
+
+// queryEventLoop runs query in the event loop, so that it reads the consensus state without racing with the
+// handling of the events. While the event loop is not running, e.g. before the core is started, query is run
+// directly. It returns false if the event loop did not take query in time, which is not run then.
+func (c *core) queryEventLoop(query func()) bool {
+	if atomic.LoadInt32(&c.handlingEvents) == 0 {
+		query()
+		return true
+	}
+	done := make(chan struct{})
+	select {
+	case c.queries <- func() { query(); close(done) }:
+	case <-time.After(queryTimeout):
+		return false
+	}
+	<-done
+	return true
+}
+
+// handleEvent handles an input event of the main handler loop, after recording it in the journal
+func (c *core) handleEvent(event interface{}) {
+	if c.isStopped() {
+		c.logger.Debug("QBFT: core stopped, drop event", "event", fmt.Sprintf("%T", event))
+		return
+	}
+	c.recordJournal(event)
+
+	// A real event arrived, process interesting content
+	switch ev := event.(type) {
+	case istanbul.RequestEvent:
+		// we are block proposer and look to get our block proposal validated by other validators
+		r := &Request{
+			Proposal: ev.Proposal,
+		}
+		err := c.handleRequest(r)
+		if err == errFutureMessage {
+			// store request for later treatment
+			c.storeRequestMsg(r)
+		}
+	case istanbul.MessageEvent:
+		// we received a message from another validator, or our own broadcast
+		if err := c.handleEncodedMsg(ev.Code, ev.Payload, ev.Local); err != nil {
+			return
+		}
+
+		// if successfully processed, we gossip message to other validators
+		c.backend.Gossip(c.valSet, ev.Code, ev.Payload)
+	case backlogEvent:
+		// we process again a future message that was backlogged
+		// no need to check signature as it was already node when we first received message
+		if c.dropStaleBacklogMessage(ev.msg) {
+			return
+		}
+		if err := c.handleDecodedMessage(ev.msg); err != nil {
+			return
+		}
+
+		data, err := rlp.EncodeToBytes(ev.msg)
+		if err != nil {
+			c.logger.Error("QBFT: can not encode backlog message", "err", err)
+			return
 		}
+
+		// if successfully processed, we gossip message to other validators
+		c.backend.Gossip(c.valSet, ev.msg.Code(), data)
+	case preprepareRebroadcastEvent:
+		// we are proposer and may have to send our PRE-PREPARE message again
+		c.handlePreprepareRebroadcast()
+	case backlogDrainEvent:
+		// resume the backlog drain after yielding
+		c.processBacklog()
+	case commitSealTimeoutEvent:
+		// we are proposer and waited long enough for more COMMIT messages than the quorum
+		c.handleCommitSealTimeout()
+	case timeoutEvent:
+		c.handleTimeoutMsg()
+	case istanbul.FinalCommittedEvent:
+		c.handleFinalCommitted()
 	}
 }
 
-// sendEvent sends events to mux
+// sendEvent sends events to mux, unless the core is stopped
 func (c *core) sendEvent(ev interface{}) {
+	if c.isStopped() {
+		return
+	}
 	c.backend.EventMux().Post(ev)
 }
 
-func (c *core) handleEncodedMsg(code uint64, data []byte) error {
+// isStopped returns whether Stop was called since the core last started
+func (c *core) isStopped() bool {
+	return atomic.LoadInt32(&c.stopped) == 1
+}
+
+// sendEventOrdered sends events to mux asynchronously, preserving the order
+// in which they were sent, and returns whether ev was queued. Backlog events
+// are not queued if the event loop falls too far behind, the caller keeps
+// them in the backlog until the queue drains.
+func (c *core) sendEventOrdered(ev interface{}) bool {
+	if _, ok := ev.(backlogEvent); ok {
+		if !c.eventQueue.TryPost(ev, c.sendEvent) {
+			deferredEventMeter.Mark(1)
+			return false
+		}
+		return true
+	}
+	c.eventQueue.Post(ev, c.sendEvent)
+	return true
+}
+
+// handleEncodedMsg decodes and handles a message, local is set for the messages sent by this node
+// to itself. Messages from this node received from peers are echoes relayed back by the network,
+// they are dropped so that they are not counted twice.
+func (c *core) handleEncodedMsg(code uint64, data []byte, local bool) error {
 	logger := c.logger.New("code", code, "data", data)
 
 	if _, ok := qbfttypes.MessageCodes()[code]; !ok {
@@ -185,15 +287,44 @@ func (c *core) handleEncodedMsg(code uint64, data []byte) error {
 		return err
 	}
 
+	if !local && m.Source() == c.Address() {
+		selfEchoMeter.Mark(1)
+		if c.logSampler.Sample() {
+			logger.Trace("QBFT: drop own message relayed back", "source", m.Source())
+		}
+		return errSelfMessage
+	}
+	if !local {
+		c.recordMessageMix(m)
+	}
+
 	return c.handleDecodedMessage(m)
 }
 
 func (c *core) handleDecodedMessage(m qbfttypes.QBFTMessage) error {
+	if c.emptyValidatorSet() {
+		return errEmptyValidatorSet
+	}
+
+	if c.accountLateMessage(m) {
+		return errLateMessage
+	}
+
+	if commit, ok := m.(*qbfttypes.Commit); ok && c.handleRejectedProposalCommit(commit) {
+		return errRejectedProposalCommit
+	}
+
+	if preprepare, ok := m.(*qbfttypes.Preprepare); ok && c.checkConflictingPreprepare(preprepare) {
+		return errConflictingPreprepare
+	}
+
 	view := m.View()
 	if err := c.checkMessage(m.Code(), &view); err != nil {
 		// Store in the backlog it it's a future message
 		if err == errFutureMessage {
 			c.addToBacklog(m)
+		} else if err == errOldMessage {
+			c.retainMessage(m)
 		}
 		return err
 	}
@@ -203,6 +334,10 @@ func (c *core) handleDecodedMessage(m qbfttypes.QBFTMessage) error {
 
 // Deliver to specific message handler
 func (c *core) deliverMessage(m qbfttypes.QBFTMessage) error {
+	if err := c.appendWAL(m); err != nil {
+		return err
+	}
+
 	var err error
 
 	switch m.Code() {
@@ -223,14 +358,34 @@ func (c *core) deliverMessage(m qbfttypes.QBFTMessage) error {
 }
 
 func (c *core) handleTimeoutMsg() {
-	logger := c.currentLogger(true, nil)
+	if c.emptyValidatorSet() {
+		return
+	}
+	if c.handleTieBreakTimeout() {
+		return
+	}
+	c.changeToNextRound(c.timeoutRoundChangeReason())
+}
+
+// changeToNextRound starts the next round of the current sequence for the given reason and broadcasts
+// a ROUND-CHANGE message for it
+func (c *core) changeToNextRound(reason string) {
+	c.changeToRound(reason, new(big.Int).Add(c.current.Round(), common.Big1))
+}
+
+// changeToRound starts the given round of the current sequence for the given reason and broadcasts
+// a ROUND-CHANGE message for it
+func (c *core) changeToRound(reason string, nextRound *big.Int) {
+	logger := c.currentLogger(true, nil).New("reason", reason)
 	// Start the new round
-	round := c.current.Round()
-	nextRound := new(big.Int).Add(round, common.Big1)
+	if c.haltOnMaxRound(nextRound) {
+		return
+	}
+	c.recordRoundChange(reason, nextRound)
 
-	logger.Warn("QBFT: TIMER CHANGING ROUND", "pr", c.current.preparedRound)
+	logger.Warn("QBFT: CHANGING ROUND", "pr", c.current.preparedRound)
 	c.startNewRound(nextRound)
-	logger.Warn("QBFT: TIMER CHANGED ROUND", "pr", c.current.preparedRound)
+	logger.Warn("QBFT: CHANGED ROUND", "pr", c.current.preparedRound)
 
 	// Send Round Change
 	c.broadcastRoundChange(nextRound)
@@ -255,6 +410,7 @@ func (c *core) verifySignatures(m qbfttypes.QBFTMessage) error {
 			return errInvalidSigner
 		}
 		m.SetSource(source)
+		m.SetSigner(source)
 		return nil
 	}
 
@@ -279,6 +435,10 @@ func (c *core) verifySignatures(m qbfttypes.QBFTMessage) error {
 				return err
 			}
 		}
+		if err := c.verifyCertificateSignature(msgType); err != nil {
+			logger.Warn("QBFT: invalid PRE-PREPARE justification signature", "err", err)
+			return err
+		}
 	}
 
 	return nil
@@ -318,3 +478,42 @@ func withMsg(logger log.Logger, msg qbfttypes.QBFTMessage) log.Logger {
 		"msg.sequence", msg.View().Sequence.Uint64(),
 	)
 }
+
+// dropStaleBacklogMessage checks again a backlog message when its event is handled and drops it if it became
+// old: the drain dispatches the events asynchronously, the view may have advanced since it popped the message.
+// Stale messages are still retained for a chain reorganisation, like the old messages received from peers.
+func (c *core) dropStaleBacklogMessage(m qbfttypes.QBFTMessage) bool {
+	view := m.View()
+	if c.checkMessage(m.Code(), &view) != errOldMessage {
+		return false
+	}
+	staleBacklogMeter.Mark(1)
+	if c.logSampler.Sample() {
+		c.currentLogger(true, m).Trace("QBFT: drop backlog message which became old since dispatched")
+	}
+	c.retainMessage(m)
+	return true
+}
+
+// accountLateMessage hands a PREPARE or COMMIT message for the committed view to the accountability
+// sink rather than rejecting it, if the committed message policy asks for it
+func (c *core) accountLateMessage(m qbfttypes.QBFTMessage) bool {
+	if c.config.CommittedMessagePolicy != istanbul.CommittedMessageAccount || c.state != StateCommitted {
+		return false
+	}
+	if code := m.Code(); code != qbfttypes.PrepareCode && code != qbfttypes.CommitCode {
+		return false
+	}
+	if view := m.View(); view.Cmp(c.currentView()) != 0 {
+		return false
+	}
+	c.lateMessageSink(m)
+	return true
+}
+
+// logLateMessage is the default accountability sink, it logs the late messages so that validators
+// lagging behind can be identified
+func (c *core) logLateMessage(m qbfttypes.QBFTMessage) {
+	lateMessageMeter.Mark(1)
+	c.currentLogger(true, m).Info("QBFT: late message for committed view")
+}
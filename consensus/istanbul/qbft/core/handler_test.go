@@ -0,0 +1,253 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestSendEventOrderedSlowConsumer(t *testing.T) {
+	c := newTestCore(istanbul.DefaultConfig, newTestValidatorSet(4))
+	c.eventQueue.Limit = 16
+	sub := c.backend.EventMux().Subscribe(backlogEvent{}, istanbul.RequestEvent{})
+	defer sub.Unsubscribe()
+
+	goroutines := runtime.NumGoroutine()
+	deferred := deferredEventMeter.Count()
+
+	// flood backlog events while the consumer is not reading
+	const requests, backlogsPerRequest = 10, 50
+	for i := 0; i < requests; i++ {
+		for j := 0; j < backlogsPerRequest; j++ {
+			c.sendEventOrdered(backlogEvent{})
+		}
+		c.sendEventOrdered(istanbul.RequestEvent{Proposal: makeBlock(int64(i))})
+	}
+	if n := runtime.NumGoroutine(); n > goroutines+1 {
+		t.Errorf("goroutines mismatch: have %d, want at most %d", n, goroutines+1)
+	}
+
+	// slowly consume the events, every request event must come through in order
+	var received, backlogs int
+	for received < requests {
+		select {
+		case ev := <-sub.Chan():
+			time.Sleep(time.Millisecond)
+			switch e := ev.Data.(type) {
+			case istanbul.RequestEvent:
+				if number := e.Proposal.Number().Int64(); number != int64(received) {
+					t.Fatalf("request order mismatch: have %d, want %d", number, received)
+				}
+				received++
+			case backlogEvent:
+				backlogs++
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("request events lost: have %d, want %d", received, requests)
+		}
+	}
+
+	// only the backlog events fitting in the queue were queued, the others are left to the caller
+	if backlogs > c.eventQueue.Limit+1 {
+		t.Errorf("backlog events mismatch: have %d, want at most %d", backlogs, c.eventQueue.Limit+1)
+	}
+	if n := deferredEventMeter.Count() - deferred; metrics.Enabled && n != int64(requests*backlogsPerRequest-backlogs) {
+		t.Errorf("deferred events mismatch: have %d, want %d", n, requests*backlogsPerRequest-backlogs)
+	}
+}
+
+func TestCommittedMessagePolicy(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	src := valSet.GetByIndex(1).Address()
+	late := []qbfttypes.QBFTMessage{
+		signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), src),
+		signedBy(qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), common.Hash{}, nil), src),
+	}
+
+	testCases := []struct {
+		policy string
+		err    error
+		sunk   int
+	}{
+		{"", errInvalidMessage, 0},
+		{istanbul.CommittedMessageReject, errInvalidMessage, 0},
+		{istanbul.CommittedMessageAccount, errLateMessage, len(late)},
+	}
+	for _, test := range testCases {
+		config := *istanbul.DefaultConfig
+		config.CommittedMessagePolicy = test.policy
+		c := newTestCore(&config, valSet)
+		c.state = StateCommitted
+		var sunk []qbfttypes.QBFTMessage
+		c.lateMessageSink = func(m qbfttypes.QBFTMessage) { sunk = append(sunk, m) }
+
+		for _, m := range late {
+			if err := c.handleDecodedMessage(m); err != test.err {
+				t.Errorf("policy %q: error mismatch: have %v, want %v", test.policy, err, test.err)
+			}
+		}
+		if len(sunk) != test.sunk {
+			t.Fatalf("policy %q: sunk messages mismatch: have %d, want %d", test.policy, len(sunk), test.sunk)
+		}
+		for i := range sunk {
+			if sunk[i] != late[i] {
+				t.Errorf("policy %q: sunk message %d mismatch", test.policy, i)
+			}
+		}
+
+		// late messages received from peers are not gossiped further
+		backend := c.backend.(*testBackend)
+		for _, m := range late {
+			payload, err := rlp.EncodeToBytes(m)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			c.handleEvent(istanbul.MessageEvent{Code: m.Code(), Payload: payload})
+		}
+		if len(backend.gossips) != 0 {
+			t.Errorf("policy %q: gossiped late messages: have %v, want none", test.policy, backend.gossips)
+		}
+
+		// messages for another view are handled as usual
+		sunk = nil
+		if err := c.handleDecodedMessage(newFuturePrepare(3, src)); err != errFutureMessage {
+			t.Errorf("policy %q: error mismatch: have %v, want %v", test.policy, err, errFutureMessage)
+		}
+		if len(sunk) != 0 {
+			t.Errorf("policy %q: future message should not be sunk", test.policy)
+		}
+	}
+}
+
+func TestOwnMessageEchoDropped(t *testing.T) {
+	c := newTestCore(istanbul.DefaultConfig, newTestValidatorSet(4))
+	proposal := makeBlock(1)
+	c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), proposal))
+	c.state = StatePreprepared
+
+	prepare := signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), proposal.Hash()), c.Address())
+	payload, err := rlp.EncodeToBytes(prepare)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+
+	// our own PREPARE relayed back by a peer is dropped at ingress
+	before := selfEchoMeter.Count()
+	if err := c.handleEncodedMsg(qbfttypes.PrepareCode, payload, false); err != errSelfMessage {
+		t.Fatalf("error mismatch: have %v, want %v", err, errSelfMessage)
+	}
+	if size := c.current.QBFTPrepares.Size(); size != 0 {
+		t.Errorf("PREPARE count mismatch: have %v, want 0", size)
+	}
+	if dropped := selfEchoMeter.Count() - before; metrics.Enabled && dropped != 1 {
+		t.Errorf("dropped mismatch: have %v, want 1", dropped)
+	}
+
+	// the PREPARE broadcast to ourselves is handled
+	if err := c.handleEncodedMsg(qbfttypes.PrepareCode, payload, true); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if size := c.current.QBFTPrepares.Size(); size != 1 {
+		t.Errorf("PREPARE count mismatch: have %v, want 1", size)
+	}
+}
+
+func TestEventsAfterStopDropped(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	if err := c.Start(); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	sub := c.backend.EventMux().Subscribe(backlogEvent{})
+	defer sub.Unsubscribe()
+
+	// the core stops while backlogged messages are still being dispatched
+	src := valSet.GetByIndex(1).Address()
+	late := backlogEvent{msg: signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), src)}
+	c.Stop()
+	c.sendEventOrdered(late)
+	select {
+	case <-sub.Chan():
+		t.Fatalf("event sent after stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// an event which made it to the handler before the stop is dropped instead of handled against
+	// the torn-down round state
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("late event handling panicked: %v", r)
+		}
+	}()
+	c.handleEvent(late)
+	c.handleEvent(timeoutEvent{})
+	if len(c.backend.(*testBackend).broadcasts) != 0 {
+		t.Errorf("broadcasts mismatch: have %v, want none", c.backend.(*testBackend).broadcasts)
+	}
+}
+
+func TestStaleBacklogEventDropped(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.state = StatePreprepared
+	src := valSet.GetByIndex(1).Address()
+	sub := c.backend.EventMux().Subscribe(backlogEvent{})
+	defer sub.Unsubscribe()
+
+	// the drain pops a message of the current view, the view advances while its event is dispatched
+	c.addToBacklog(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), common.Hash{}), src))
+	c.processBacklog()
+	advanced := make(chan struct{})
+	go func() {
+		defer close(advanced)
+		c.currentMutex.Lock()
+		defer c.currentMutex.Unlock()
+		c.current = newRoundState(&istanbul.View{Sequence: big.NewInt(2), Round: big.NewInt(0)}, valSet, nil, nil, nil, nil, nil)
+		c.state = StateAcceptRequest
+	}()
+
+	var ev backlogEvent
+	select {
+	case e := <-sub.Chan():
+		ev = e.Data.(backlogEvent)
+	case <-time.After(time.Second):
+		t.Fatalf("backlog event not dispatched")
+	}
+	<-advanced
+
+	stale := staleBacklogMeter.Count()
+	c.handleEvent(ev)
+	if have := staleBacklogMeter.Count() - stale; metrics.Enabled && have != 1 {
+		t.Errorf("stale meter mismatch: have %d, want 1", have)
+	}
+	if size := c.current.QBFTPrepares.Size(); size != 0 {
+		t.Errorf("PREPARE count mismatch: have %d, want 0", size)
+	}
+	if backlog := c.backlogs[src]; backlog != nil && !backlog.Empty() {
+		t.Errorf("stale message backlogged again: %d messages", backlog.Size())
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// recordJournal records an input event of the main handler loop in the journal
+func (c *core) recordJournal(event interface{}) {
+	if c.journal == nil {
+		return
+	}
+	entry := istanbul.JournalEvent{Time: time.Now()}
+	switch ev := event.(type) {
+	case istanbul.RequestEvent:
+		payload, err := rlp.EncodeToBytes(ev.Proposal)
+		if err != nil {
+			c.logger.Error("QBFT: can not encode journal request", "err", err)
+			return
+		}
+		entry.Kind, entry.Payload = istanbul.JournalRequest, payload
+	case istanbul.MessageEvent:
+		entry.Kind, entry.Code, entry.Payload, entry.Local = istanbul.JournalMessage, ev.Code, ev.Payload, ev.Local
+	case backlogEvent:
+		payload, err := rlp.EncodeToBytes(ev.msg)
+		if err != nil {
+			c.logger.Error("QBFT: can not encode journal backlog message", "err", err)
+			return
+		}
+		entry.Kind, entry.Code, entry.Payload = istanbul.JournalBacklog, ev.msg.Code(), payload
+	case timeoutEvent:
+		entry.Kind = istanbul.JournalTimeout
+	case preprepareRebroadcastEvent:
+		entry.Kind = istanbul.JournalRebroadcast
+	case backlogDrainEvent:
+		entry.Kind = istanbul.JournalBacklogDrain
+	case commitSealTimeoutEvent:
+		entry.Kind = istanbul.JournalCommitSeal
+	case istanbul.FinalCommittedEvent:
+		entry.Kind = istanbul.JournalFinalCommitted
+	default:
+		return
+	}
+	if err := c.journal.Record(entry); err != nil {
+		c.logger.Error("QBFT: failed to record event in the journal", "kind", entry.Kind, "err", err)
+	}
+}
+
+// journalEvent returns the input event of the main handler loop recorded as entry
+func (c *core) journalEvent(entry istanbul.JournalEvent) (interface{}, error) {
+	switch entry.Kind {
+	case istanbul.JournalRequest:
+		block := new(types.Block)
+		if err := rlp.DecodeBytes(entry.Payload, block); err != nil {
+			return nil, err
+		}
+		return istanbul.RequestEvent{Proposal: block}, nil
+	case istanbul.JournalMessage:
+		return istanbul.MessageEvent{Code: entry.Code, Payload: entry.Payload, Local: entry.Local}, nil
+	case istanbul.JournalBacklog:
+		msg, err := qbfttypes.Decode(entry.Code, entry.Payload)
+		if err != nil {
+			return nil, err
+		}
+		// backlogged messages got their signatures verified when first received, this sets their source
+		if err := c.verifySignatures(msg); err != nil {
+			return nil, err
+		}
+		_, src := c.valSet.GetByAddress(msg.Source())
+		return backlogEvent{src: src, msg: msg}, nil
+	case istanbul.JournalTimeout:
+		return timeoutEvent{}, nil
+	case istanbul.JournalRebroadcast:
+		return preprepareRebroadcastEvent{}, nil
+	case istanbul.JournalBacklogDrain:
+		return backlogDrainEvent{}, nil
+	case istanbul.JournalCommitSeal:
+		return commitSealTimeoutEvent{}, nil
+	case istanbul.JournalFinalCommitted:
+		return istanbul.FinalCommittedEvent{}, nil
+	}
+	return nil, fmt.Errorf("unknown journal event kind %q", entry.Kind)
+}
+
+// ReplayJournal handles the events of a journal with a fresh core created by New, whose backend is at the
+// chain head the journal was recorded from. The core must not be started: the events are handled in the
+// order they were recorded, without the event loop, so that the replay is deterministic. The events the
+// core posts to itself are dropped, as the journal already holds them where they got handled.
+func ReplayJournal(c istanbul.Core, events []istanbul.JournalEvent) error {
+	qc, ok := c.(*core)
+	if !ok {
+		return errors.New("not a QBFT consensus core")
+	}
+	journal := qc.journal
+	qc.journal = nil
+	defer func() { qc.journal = journal }()
+
+	if qc.current == nil {
+		qc.startNewRound(common.Big0)
+	}
+	for i, entry := range events {
+		event, err := qc.journalEvent(entry)
+		if err != nil {
+			return fmt.Errorf("journal event %d: %w", i, err)
+		}
+		qc.handleEvent(event)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// testJournal keeps the recorded events in memory
+type testJournal struct {
+	events []istanbul.JournalEvent
+}
+
+func (j *testJournal) Record(event istanbul.JournalEvent) error {
+	j.events = append(j.events, event)
+	return nil
+}
+
+func TestJournalReplay(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	newJournalTestCore := func(config *istanbul.Config) *core {
+		c := newTestCore(config, valSet.Copy())
+		c.valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+		c.roundChangeSet = newRoundChangeSet(c.valSet)
+		return c
+	}
+
+	journal := new(testJournal)
+	config := *istanbul.DefaultConfig
+	config.Journal = journal
+	c := newJournalTestCore(&config)
+	defer c.stopTimer()
+
+	message := func(m qbfttypes.QBFTMessage, src common.Address) istanbul.MessageEvent {
+		payload, err := rlp.EncodeToBytes(signedBy(m, src))
+		if err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		return istanbul.MessageEvent{Code: m.Code(), Payload: payload, Local: src == c.Address()}
+	}
+
+	// a short session: the block gets prepared, the round times out, then the round changes
+	// and a message for the next sequence arrive
+	sequence, round := big.NewInt(1), big.NewInt(0)
+	proposal := makeBlock(1)
+	proposer := c.valSet.GetProposer().Address()
+	events := []interface{}{
+		message(qbfttypes.NewPreprepare(sequence, round, proposal), proposer),
+	}
+	for _, v := range valSet.List() {
+		events = append(events, message(qbfttypes.NewPrepare(sequence, round, proposal.Hash()), v.Address()))
+	}
+	other := valSet.GetByIndex(1).Address()
+	events = append(events,
+		timeoutEvent{},
+		message(qbfttypes.NewRoundChange(sequence, big.NewInt(1), nil, nil), valSet.GetByIndex(2).Address()),
+		backlogEvent{msg: signedBy(qbfttypes.NewPrepare(big.NewInt(2), big.NewInt(0), common.Hash{}), other)},
+	)
+	for _, event := range events {
+		c.handleEvent(event)
+	}
+	if len(journal.events) != len(events) {
+		t.Fatalf("journal events mismatch: have %d, want %d", len(journal.events), len(events))
+	}
+
+	// a fresh core replaying the journal reaches the same state
+	replayed := newJournalTestCore(istanbul.DefaultConfig)
+	defer replayed.stopTimer()
+	if err := ReplayJournal(replayed, journal.events); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+
+	if replayed.state != c.state {
+		t.Errorf("state mismatch: have %v, want %v", replayed.state, c.state)
+	}
+	if view, want := replayed.currentView(), c.currentView(); view.Cmp(want) != 0 {
+		t.Errorf("view mismatch: have %v, want %v", view, want)
+	}
+	if c.current.Round().Uint64() != 1 || c.current.preparedRound == nil {
+		t.Fatalf("unexpected recorded session: round %v, prepared round %v", c.current.Round(), c.current.preparedRound)
+	}
+	if replayed.current.preparedRound == nil || replayed.current.preparedRound.Cmp(c.current.preparedRound) != 0 ||
+		replayed.current.preparedBlock.Hash() != c.current.preparedBlock.Hash() {
+		t.Errorf("prepared certificate mismatch: have %v %v, want %v %v", replayed.current.preparedRound, replayed.current.preparedBlock, c.current.preparedRound, c.current.preparedBlock)
+	}
+	if have, want := len(replayed.roundChangeSet.roundChanges), len(c.roundChangeSet.roundChanges); have != want {
+		t.Errorf("round change rounds mismatch: have %d, want %d", have, want)
+	}
+	if have, want := replayed.backlogs[other].Size(), c.backlogs[other].Size(); have != want || have != 1 {
+		t.Errorf("backlog size mismatch: have %d, want %d", have, want)
+	}
+	if have, want := len(replayed.backend.(*testBackend).broadcasts), len(c.backend.(*testBackend).broadcasts); have != want {
+		t.Errorf("broadcasts mismatch: have %d, want %d", have, want)
+	}
+}
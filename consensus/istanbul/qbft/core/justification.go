@@ -105,3 +105,50 @@ func hasMatchingRoundChangeAndPrepares(
 	}
 	return nil
 }
+
+// signCertificate signs the justification of preprepare, if any, from the signed certificates fork
+func (c *core) signCertificate(preprepare *qbfttypes.Preprepare) error {
+	if !c.config.IsSignedCertificates(preprepare.Sequence) || !hasJustification(preprepare) {
+		return nil
+	}
+	payload, err := preprepare.EncodeCertificateForSigning()
+	if err != nil {
+		return err
+	}
+	signature, err := c.backend.Sign(payload)
+	if err != nil {
+		return err
+	}
+	preprepare.CertificateSignature = signature
+	return nil
+}
+
+// verifyCertificateSignature checks the justification of preprepare, if any, is signed by the sender of
+// preprepare from the signed certificates fork. A justification assembled from valid messages but altered
+// after the proposer sent it is then rejected. Before the fork the signature is dropped, so that the message
+// keeps the encoding the validators without signed certificates decode when it is relayed.
+func (c *core) verifyCertificateSignature(preprepare *qbfttypes.Preprepare) error {
+	if !c.config.IsSignedCertificates(preprepare.Sequence) {
+		preprepare.CertificateSignature = nil
+		return nil
+	}
+	if !hasJustification(preprepare) {
+		return nil
+	}
+	if len(preprepare.CertificateSignature) == 0 {
+		return errInvalidCertificateSignature
+	}
+	payload, err := preprepare.EncodeCertificateForSigning()
+	if err != nil {
+		return err
+	}
+	signer, err := c.validateFn(payload, preprepare.CertificateSignature)
+	if err != nil || signer != preprepare.Source() {
+		return errInvalidCertificateSignature
+	}
+	return nil
+}
+
+func hasJustification(preprepare *qbfttypes.Preprepare) bool {
+	return len(preprepare.JustificationRoundChanges) > 0 || len(preprepare.JustificationPrepares) > 0
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"math/big"
 	"math/rand"
@@ -13,6 +14,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 // Tests combinations of justifications that evaluate to true.
@@ -175,3 +177,83 @@ func makeBlock(number int64) *types.Block {
 	block := &types.Block{}
 	return block.WithSeal(header)
 }
+
+func TestSignedCertificates(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 4)
+	addrs := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	valSet := validator.NewSet(addrs, istanbul.NewRoundRobinProposerPolicy())
+	sign := func(data []byte, key *ecdsa.PrivateKey) []byte {
+		sig, err := crypto.Sign(crypto.Keccak256(data), key)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return sig
+	}
+	roundChange := func(i int) *qbfttypes.SignedRoundChangePayload {
+		m := qbfttypes.NewRoundChange(big.NewInt(1), big.NewInt(1), nil, nil)
+		payload, _ := m.SignedRoundChangePayload.EncodePayloadForSigning()
+		m.SetSignature(sign(payload, keys[i]))
+		return &m.SignedRoundChangePayload
+	}
+	// the proposer of round 1 justifies its block with the ROUND-CHANGE messages of the first 3 validators
+	proposer := keys[1]
+	newPreprepare := func() *qbfttypes.Preprepare {
+		preprepare := qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(1), makeBlock(1))
+		payload, _ := preprepare.EncodePayloadForSigning()
+		preprepare.SetSignature(sign(payload, proposer))
+		preprepare.JustificationRoundChanges = []*qbfttypes.SignedRoundChangePayload{roundChange(0), roundChange(1), roundChange(2)}
+		certificate, _ := preprepare.EncodeCertificateForSigning()
+		preprepare.CertificateSignature = sign(certificate, proposer)
+		return preprepare
+	}
+	// relay the message, as peers do
+	relay := func(preprepare *qbfttypes.Preprepare) qbfttypes.QBFTMessage {
+		payload, err := rlp.EncodeToBytes(preprepare)
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		m, err := qbfttypes.Decode(qbfttypes.PreprepareCode, payload)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return m
+	}
+
+	// the messages are for block 1, signed certificates are disabled, enabled after it or from it
+	for _, fork := range []*big.Int{nil, big.NewInt(2), big.NewInt(1)} {
+		signed := fork != nil && fork.Cmp(common.Big1) == 0
+		config := *istanbul.DefaultConfig
+		config.SignedCertificatesBlock = fork
+		c := newTestCore(&config, valSet)
+		c.validateFn = istanbul.GetSignatureAddress
+
+		intact := relay(newPreprepare())
+		if err := c.verifySignatures(intact); err != nil {
+			t.Errorf("signed %v: error mismatch for an intact justification: have %v, want nil", signed, err)
+		}
+		if kept := len(intact.(*qbfttypes.Preprepare).CertificateSignature) > 0; kept != signed {
+			t.Errorf("signed %v: certificate signature kept mismatch: have %v, want %v", signed, kept, signed)
+		}
+
+		// each ROUND-CHANGE message is valid, but the set is not the one the proposer sent
+		tampered := newPreprepare()
+		tampered.JustificationRoundChanges[2] = roundChange(3)
+		var want error
+		if signed {
+			want = errInvalidCertificateSignature
+		}
+		if err := c.verifySignatures(relay(tampered)); err != want {
+			t.Errorf("signed %v: error mismatch for a tampered justification: have %v, want %v", signed, err, want)
+		}
+
+		unsigned := newPreprepare()
+		unsigned.CertificateSignature = nil
+		if err := c.verifySignatures(relay(unsigned)); err != want {
+			t.Errorf("signed %v: error mismatch for an unsigned justification: have %v, want %v", signed, err, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// latencyHistogramName returns the name of the histogram tracking, for the given validator and
+// message code, the time between a view becoming current and the receipt of the validator's message
+func latencyHistogramName(code uint64, validator common.Address) string {
+	kind := "prepare"
+	if code == qbfttypes.CommitCode {
+		kind = "commit"
+	}
+	return "consensus/istanbul/qbft/core/latency/" + kind + "/" + validator.Hex()
+}
+
+// recordMessageLatency records how long after the current view started the message m was received
+func (c *core) recordMessageLatency(m qbfttypes.QBFTMessage) {
+	if c.viewStartTime.IsZero() {
+		return
+	}
+	metrics.GetOrRegisterHistogramLazy(latencyHistogramName(m.Code(), m.Source()), nil, func() metrics.Sample {
+		return metrics.NewExpDecaySample(1028, 0.015)
+	}).Update(time.Since(c.viewStartTime).Milliseconds())
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+func TestValidatorMessageLatency(t *testing.T) {
+	enabled := metrics.Enabled
+	metrics.Enabled = true
+	defer func() { metrics.Enabled = enabled }()
+
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	proposal := makeBlock(1)
+	c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), proposal))
+
+	slow := valSet.GetByIndex(1).Address()
+	fast := valSet.GetByIndex(2).Address()
+
+	// the slow validator PREPARE arrives 300ms after the view started
+	c.state = StatePreprepared
+	c.viewStartTime = time.Now().Add(-300 * time.Millisecond)
+	prepare := qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), proposal.Hash())
+	prepare.SetSource(slow)
+	if err := c.handlePrepare(prepare); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+
+	// the fast validator COMMIT arrives right away
+	c.state = StatePrepared
+	c.viewStartTime = time.Now()
+	commit := qbfttypes.NewCommit(big.NewInt(1), big.NewInt(0), proposal.Hash(), nil)
+	commit.SetSource(fast)
+	if err := c.handleCommitMsg(commit); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+
+	slowPrepares := metrics.DefaultRegistry.Get(latencyHistogramName(qbfttypes.PrepareCode, slow)).(metrics.Histogram)
+	if slowPrepares.Count() != 1 || slowPrepares.Max() < 300 {
+		t.Errorf("slow validator PREPARE latency mismatch: have count %v max %vms, want count 1 max >= 300ms", slowPrepares.Count(), slowPrepares.Max())
+	}
+	fastCommits := metrics.DefaultRegistry.Get(latencyHistogramName(qbfttypes.CommitCode, fast)).(metrics.Histogram)
+	if fastCommits.Count() != 1 || fastCommits.Max() >= 300 {
+		t.Errorf("fast validator COMMIT latency mismatch: have count %v max %vms, want count 1 max < 300ms", fastCommits.Count(), fastCommits.Max())
+	}
+	if h := metrics.DefaultRegistry.Get(latencyHistogramName(qbfttypes.CommitCode, slow)); h != nil {
+		t.Errorf("no COMMIT latency expected for the slow validator")
+	}
+}
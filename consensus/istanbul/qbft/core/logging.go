@@ -0,0 +1,57 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// escalatedHandler passes the debug and trace records to h at info level, so that they are not
+// filtered out by the configured verbosity. The original level is kept in the record context.
+func escalatedHandler(h log.Handler) log.Handler {
+	return log.FuncHandler(func(r *log.Record) error {
+		if r.Lvl > log.LvlInfo {
+			r.Ctx = append(r.Ctx, "escalated", r.Lvl.String())
+			r.Lvl = log.LvlInfo
+		}
+		return h.Log(r)
+	})
+}
+
+// escalateLogs makes the core log its debug and trace messages once the round exceeds the configured
+// threshold, until the sequence is committed
+func (c *core) escalateLogs(round *big.Int) {
+	threshold := c.config.LogEscalationRound
+	if threshold == 0 || c.logHandler != nil || round.Uint64() <= threshold {
+		return
+	}
+	c.logger.Info("QBFT: consensus unstable, escalating logs", "round", round, "threshold", threshold)
+	c.logHandler = c.logger.GetHandler()
+	c.logger.SetHandler(escalatedHandler(c.logHandler))
+}
+
+// revertLogEscalation restores the logs of the core to the configured verbosity
+func (c *core) revertLogEscalation() {
+	if c.logHandler == nil {
+		return
+	}
+	c.logger.SetHandler(c.logHandler)
+	c.logHandler = nil
+	c.logger.Info("QBFT: consensus recovered, logs back to the configured verbosity")
+}
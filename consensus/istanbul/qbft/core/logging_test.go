@@ -0,0 +1,74 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestLogEscalation(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.LogEscalationRound = 2
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+
+	// the node logs at info level
+	var records []*log.Record
+	c.logger.SetHandler(log.LvlFilterHandler(log.LvlInfo, log.FuncHandler(func(r *log.Record) error {
+		records = append(records, r)
+		return nil
+	})))
+	logsDebug := func() bool {
+		records = nil
+		c.currentLogger(true, nil).Debug("QBFT: probe")
+		return len(records) == 1
+	}
+
+	for round := int64(1); round <= 2; round++ {
+		c.startNewRound(big.NewInt(round))
+		if logsDebug() {
+			t.Fatalf("round %d: debug logs escalated below the threshold", round)
+		}
+	}
+	c.startNewRound(big.NewInt(3))
+	if !logsDebug() {
+		t.Fatalf("debug logs not escalated above the threshold")
+	}
+	if escalated := records[0].Ctx[len(records[0].Ctx)-1]; records[0].Lvl != log.LvlInfo || escalated != log.LvlDebug.String() {
+		t.Errorf("escalated record mismatch: have level %v from %v, want %v from %v", records[0].Lvl, escalated, log.LvlInfo, log.LvlDebug)
+	}
+
+	// the logs are back to the configured verbosity once the block is committed
+	proposal := makeBlock(1)
+	c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(3), proposal))
+	c.commitQBFT()
+	if logsDebug() {
+		t.Errorf("debug logs still escalated after the commit")
+	}
+	records = nil
+	c.logger.Info("QBFT: probe")
+	if len(records) != 1 {
+		t.Errorf("info logs mismatch: have %d records, want 1", len(records))
+	}
+}
@@ -62,6 +62,7 @@ func (c *core) broadcastPrepare() {
 		withMsg(logger, prepare).Error("QBFT: failed to broadcast PREPARE message", "err", err)
 		return
 	}
+	c.recordSentPhaseMessage(sub.View, prepare.Code(), payload)
 }
 
 // handlePrepare is called when receiving a PREPARE message
@@ -73,7 +74,7 @@ func (c *core) broadcastPrepare() {
 func (c *core) handlePrepare(prepare *qbfttypes.Prepare) error {
 	logger := c.currentLogger(true, prepare).New()
 
-	logger.Info("QBFT: handle PREPARE message", "prepares.count", c.current.QBFTPrepares.Size(), "quorum", c.QuorumSize())
+	logger.Info("QBFT: handle PREPARE message", "prepares.count", c.current.QBFTPrepares.Size(), "quorum", c.prepareQuorumSize())
 
 	// Check digest
 	if prepare.Digest != c.current.Proposal().Hash() {
@@ -86,12 +87,13 @@ func (c *core) handlePrepare(prepare *qbfttypes.Prepare) error {
 		logger.Error("QBFT: failed to save PREPARE message", "err", err)
 		return err
 	}
+	c.recordMessageLatency(prepare)
 
-	logger = logger.New("prepares.count", c.current.QBFTPrepares.Size(), "quorum", c.QuorumSize())
+	logger = logger.New("prepares.count", c.current.QBFTPrepares.Size(), "quorum", c.prepareQuorumSize())
 
 	// Change to "Prepared" state if we've received quorum of PREPARE messages
 	// and we are in earlier state than "Prepared"
-	if (c.current.QBFTPrepares.Size() >= c.QuorumSize()) && c.state.Cmp(StatePrepared) < 0 {
+	if (c.current.QBFTPrepares.Size() >= c.prepareQuorumSize()) && c.state.Cmp(StatePrepared) < 0 {
 		logger.Info("QBFT: received quorum of PREPARE messages")
 
 		// Accumulates PREPARE messages
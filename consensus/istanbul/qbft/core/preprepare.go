@@ -17,11 +17,16 @@
 package core
 
 import (
+	"fmt"
+	"math/big"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
 	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
@@ -77,6 +82,12 @@ func (c *core) sendPreprepareMsg(request *Request) {
 			withMsg(logger, preprepare).Trace("QBFT: extended PRE-PREPARE message with PREPARE justification", "justification", preprepare.JustificationPrepares)
 		}
 
+		// Bind the justification to this node
+		if err := c.signCertificate(preprepare); err != nil {
+			withMsg(logger, preprepare).Error("QBFT: failed to sign PRE-PREPARE justification", "err", err)
+			return
+		}
+
 		// RLP-encode message
 		payload, err := rlp.EncodeToBytes(&preprepare)
 		if err != nil {
@@ -96,6 +107,9 @@ func (c *core) sendPreprepareMsg(request *Request) {
 
 		// Set the preprepareSent to the current round
 		c.current.preprepareSent = curView.Round
+
+		// Re-broadcast PRE-PREPARE message to validators which may have missed it
+		c.schedulePreprepareRebroadcast(curView, preprepare.Code(), payload)
 	}
 }
 
@@ -126,6 +140,28 @@ func (c *core) handlePreprepareMsg(preprepare *qbfttypes.Preprepare) error {
 		}
 	}
 
+	// Flags a proposer not re-proposing the block we are locked on
+	c.checkProposalConflict(preprepare)
+
+	// Rejects a block timestamp set far from our clock, the proposer is faulty and the round is changed
+	if err := c.checkProposalTimestamp(preprepare, time.Now()); err != nil {
+		logger.Warn("QBFT: invalid PRE-PREPARE block timestamp", "err", err)
+		c.rejectProposal(preprepare.Proposal, err.Error())
+		if c.state == StateAcceptRequest {
+			c.changeToNextRound(roundChangeReasonTimestamp)
+		}
+		return err
+	}
+
+	// Rejects a block not extending our chain head before verifying it, the proposer is partitioned and the round is changed
+	if err := c.checkProposalParent(preprepare.Proposal); err != nil {
+		logger.Warn("QBFT: PRE-PREPARE block does not extend the chain head", "err", err)
+		if c.state == StateAcceptRequest {
+			c.changeToNextRound(roundChangeReasonParent)
+		}
+		return err
+	}
+
 	// Validates PRE-PREPARE block proposal we received
 	if duration, err := c.backend.Verify(preprepare.Proposal); err != nil {
 		// if it's a future block, we will handle it again after the duration
@@ -143,11 +179,14 @@ func (c *core) handlePreprepareMsg(preprepare *qbfttypes.Preprepare) error {
 			})
 		} else {
 			logger.Warn("QBFT: invalid PRE-PREPARE block proposal", "err", err)
+			c.recordInvalidProposal(preprepare.Source(), err)
 		}
 
 		return err
 	}
 
+	c.recordValidProposal(preprepare.Source())
+
 	// Here is about to accept the PRE-PREPARE
 	if c.state == StateAcceptRequest {
 		c.logger.Info("QBFT: accepted PRE-PREPARE message")
@@ -166,3 +205,135 @@ func (c *core) handlePreprepareMsg(preprepare *qbfttypes.Preprepare) error {
 
 	return nil
 }
+
+// Names of the checks of a proposed block, in the order the PRE-PREPARE handling runs them
+const (
+	proposalCheckTimestamp = "timestamp" // block timestamp within the configured window
+	proposalCheckParent    = "parent"    // block extends the chain head, when configured
+	proposalCheckBlock     = "block"     // header fields, signer in the validator set and body, verified by the backend
+)
+
+// ValidateProposal applies to proposal the checks the core runs on the block of a PRE-PREPARE message, without
+// any effect on the consensus state. Unlike the PRE-PREPARE handling, every check is run even after a failure
+// so that the result details all of them, the block is valid if it passes them all. A block in the future passes
+// the block check but is reported pending, the core verifying it again once its timestamp is reached.
+func (c *core) ValidateProposal(proposal istanbul.Proposal) *istanbul.ProposalValidation {
+	result := &istanbul.ProposalValidation{
+		Number: proposal.Number().Uint64(),
+		Hash:   proposal.Hash(),
+		Valid:  true,
+	}
+	check := func(name string, err error) {
+		outcome := istanbul.ProposalCheck{Name: name}
+		if err != nil {
+			outcome.Error = err.Error()
+			result.Valid = false
+		}
+		result.Checks = append(result.Checks, outcome)
+	}
+
+	// the block is checked as a new proposal, which has no justification
+	preprepare := qbfttypes.NewPreprepare(proposal.Number(), big.NewInt(0), proposal)
+	check(proposalCheckTimestamp, c.checkProposalTimestamp(preprepare, time.Now()))
+	check(proposalCheckParent, c.checkProposalParent(proposal))
+	delay, err := c.backend.Verify(proposal)
+	if err == consensus.ErrFutureBlock {
+		result.Pending, result.Delay = true, delay
+		err = nil
+	}
+	check(proposalCheckBlock, err)
+	return result
+}
+
+// checkProposalTimestamp checks the timestamp of the proposed block is within the configured window around now.
+// A block proposed again after being prepared keeps its original timestamp, so its age is not bounded.
+func (c *core) checkProposalTimestamp(preprepare *qbfttypes.Preprepare, now time.Time) error {
+	block, ok := preprepare.Proposal.(*types.Block)
+	if !ok {
+		return nil
+	}
+	timestamp := time.Unix(int64(block.Time()), 0)
+	if drift := c.config.PreprepareMaxFutureDrift; drift > 0 && timestamp.After(now.Add(time.Duration(drift)*time.Second)) {
+		return fmt.Errorf("%w: %v is more than %ds ahead", errProposalTimestamp, timestamp, drift)
+	}
+	if age := c.config.PreprepareMaxAge; age > 0 && len(preprepare.JustificationPrepares) == 0 && timestamp.Before(now.Add(-time.Duration(age)*time.Second)) {
+		return fmt.Errorf("%w: %v is more than %ds old", errProposalTimestamp, timestamp, age)
+	}
+	return nil
+}
+
+// checkProposalParent checks the proposed block extends the local chain head, when configured. A block whose
+// parent is known locally without being the head yet, e.g. as it is being imported, is accepted.
+func (c *core) checkProposalParent(proposal istanbul.Proposal) error {
+	block, ok := proposal.(*types.Block)
+	if !ok || !c.config.PreprepareParentCheck {
+		return nil
+	}
+	head, _ := c.backend.LastProposal()
+	if head == nil || block.ParentHash() == head.Hash() {
+		return nil
+	}
+	if number := block.Number(); number.Sign() > 0 && c.backend.HasPropsal(block.ParentHash(), new(big.Int).Sub(number, common.Big1)) {
+		return nil
+	}
+	return fmt.Errorf("%w: parent %v, head %v", errProposalParent, block.ParentHash(), head.Hash())
+}
+
+// checkProposalConflict records the block proposed by preprepare and flags it if it is not the block this
+// node is locked on, while its justification does not show another block got prepared since we locked:
+// the proposer should then have re-proposed the locked block. It returns true if a conflict is flagged.
+func (c *core) checkProposalConflict(preprepare *qbfttypes.Preprepare) bool {
+	digest := preprepare.Proposal.Hash()
+	if c.proposedDigests == nil {
+		c.proposedDigests = make(map[uint64]common.Hash)
+	}
+	c.proposedDigests[preprepare.Round.Uint64()] = digest
+
+	lockedRound, lockedBlock := c.current.preparedRound, c.current.preparedBlock
+	if lockedRound == nil || lockedBlock == nil || lockedBlock.Hash() == digest {
+		return false
+	}
+	for _, rc := range preprepare.JustificationRoundChanges {
+		if rc.PreparedRound != nil && rc.PreparedRound.Cmp(lockedRound) >= 0 && !common.EmptyHash(rc.PreparedDigest) {
+			// a block got prepared again since we locked, the proposer may change the value
+			return false
+		}
+	}
+
+	proposalConflictMeter.Mark(1)
+	c.currentLogger(true, preprepare).Warn("QBFT: PRE-PREPARE proposal conflicts with locked block", "locked.round", lockedRound, "locked.hash", lockedBlock.Hash(), "proposal.hash", digest, "proposals", c.proposedDigests)
+	return true
+}
+
+// checkConflictingPreprepare flags a PRE-PREPARE message for the current view proposing another block than
+// the one already accepted. Whichever arrived first, only a PRE-PREPARE from the proposer is ever accepted, and
+// once one is, any other for the view is an anomaly: an equivocation if it comes from the proposer too. It
+// returns true if preprepare conflicts with the accepted one, which is kept, and false before the first view.
+// The accepted PRE-PREPARE is carried over on a round change, so it is only compared with a PRE-PREPARE for
+// its own view: the proposer of a new round may propose another block.
+func (c *core) checkConflictingPreprepare(preprepare *qbfttypes.Preprepare) bool {
+	if c.current == nil {
+		return false
+	}
+	accepted := c.current.Preprepare
+	if accepted == nil || preprepare.Sequence.Cmp(c.current.Sequence()) != 0 || preprepare.Round.Cmp(c.current.Round()) != 0 {
+		return false
+	}
+	if accepted.Sequence.Cmp(preprepare.Sequence) != 0 || accepted.Round.Cmp(preprepare.Round) != 0 {
+		return false
+	}
+	digest := preprepare.Proposal.Hash()
+	if digest == accepted.Proposal.Hash() {
+		return false
+	}
+
+	preprepareConflictMeter.Mark(1)
+	logger := c.currentLogger(true, preprepare).New("accepted.hash", accepted.Proposal.Hash(), "proposal.hash", digest)
+	if c.valSet.IsProposer(preprepare.Source()) {
+		logger.Error("QBFT: proposer equivocation, conflicting PRE-PREPARE for the current view")
+		c.raiseAlert(istanbul.AlertEquivocation, "proposer sent conflicting PRE-PREPARE messages", c.currentView(), "source", preprepare.Source(), "accepted.hash", accepted.Proposal.Hash(), "proposal.hash", digest)
+	} else {
+		logger.Warn("QBFT: conflicting PRE-PREPARE from non proposer for the current view", "proposer", c.valSet.GetProposer().Address())
+	}
+	return true
+}
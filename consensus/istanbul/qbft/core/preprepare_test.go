@@ -0,0 +1,457 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+func TestProposalConflictWithLockedBlock(t *testing.T) {
+	meter := proposalConflictMeter
+	defer func() { proposalConflictMeter = meter }()
+
+	locked := makeBlock(1)
+	other := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), GasLimit: 1})
+
+	testCases := []struct {
+		name          string
+		round         int64
+		proposal      *types.Block
+		preparedRound *big.Int // prepared round shown by the justification, nil for none
+		conflict      bool
+	}{
+		{"locked block changed without justification", 1, other, nil, true},
+		{"locked block re-proposed", 1, locked, nil, false},
+		{"block prepared again since locked", 2, other, big.NewInt(1), false},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			proposalConflictMeter = metrics.NewMeterForced()
+			defer proposalConflictMeter.Stop()
+
+			// the node got locked on a block in round 0
+			valSet := newTestValidatorSet(4)
+			c := newTestCore(istanbul.DefaultConfig, valSet)
+			defer c.stopTimer()
+			sequence, round := big.NewInt(1), big.NewInt(test.round)
+			c.current = newRoundState(&istanbul.View{Sequence: sequence, Round: round}, valSet, nil, big.NewInt(0), locked, nil, c.backend.HasBadProposal)
+			valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: sequence, Round: round})
+
+			var preparedBlock istanbul.Proposal
+			preprepare := qbfttypes.NewPreprepare(sequence, round, test.proposal)
+			if test.preparedRound != nil {
+				preparedBlock = test.proposal
+			}
+			for _, v := range valSet.List()[:c.QuorumSize()] {
+				rc := signedBy(qbfttypes.NewRoundChange(sequence, round, test.preparedRound, preparedBlock), v.Address()).(*qbfttypes.RoundChange)
+				preprepare.JustificationRoundChanges = append(preprepare.JustificationRoundChanges, &rc.SignedRoundChangePayload)
+				if test.preparedRound != nil {
+					prepare := qbfttypes.NewPrepare(sequence, test.preparedRound, test.proposal.Hash())
+					preprepare.JustificationPrepares = append(preprepare.JustificationPrepares, signedBy(prepare, v.Address()).(*qbfttypes.Prepare))
+				}
+			}
+			signedBy(preprepare, valSet.GetProposer().Address())
+
+			if err := c.handlePreprepareMsg(preprepare); err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+			if digest := c.proposedDigests[round.Uint64()]; digest != test.proposal.Hash() {
+				t.Errorf("proposed digest mismatch: have %v, want %v", digest, test.proposal.Hash())
+			}
+			flagged := proposalConflictMeter.Count() == 1
+			if flagged != test.conflict {
+				t.Errorf("conflict mismatch: have %v, want %v", flagged, test.conflict)
+			}
+		})
+	}
+}
+
+func TestPreprepareTimestampWindow(t *testing.T) {
+	now := time.Now()
+	newBlock := func(timestamp time.Time) *types.Block {
+		return types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Time: uint64(timestamp.Unix())})
+	}
+
+	testCases := []struct {
+		name     string
+		drift    uint64
+		age      uint64
+		proposal *types.Block
+		err      error
+	}{
+		{"in window", 5, 60, newBlock(now), nil},
+		{"too far in the future", 5, 60, newBlock(now.Add(time.Minute)), errProposalTimestamp},
+		{"too old", 5, 60, newBlock(now.Add(-time.Hour)), errProposalTimestamp},
+		{"unbounded", 0, 0, newBlock(now.Add(time.Hour)), nil},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := *istanbul.DefaultConfig
+			config.PreprepareMaxFutureDrift = test.drift
+			config.PreprepareMaxAge = test.age
+			valSet := newTestValidatorSet(4)
+			c := newTestCore(&config, valSet)
+			c.roundChangeSet = newRoundChangeSet(valSet)
+			defer c.stopTimer()
+			valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+
+			preprepare := qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), test.proposal)
+			signedBy(preprepare, valSet.GetProposer().Address())
+
+			if err := c.handlePreprepareMsg(preprepare); !errors.Is(err, test.err) {
+				t.Fatalf("error mismatch: have %v, want %v", err, test.err)
+			}
+			rejected := test.err != nil
+			if changed := c.current.Round().Uint64() == 1; changed != rejected {
+				t.Errorf("round change mismatch: have %v, want %v", changed, rejected)
+			}
+			if broadcast := hasBroadcast(c.backend.(*testBackend), qbfttypes.RoundChangeCode); broadcast != rejected {
+				t.Errorf("ROUND-CHANGE broadcast mismatch: have %v, want %v", broadcast, rejected)
+			}
+		})
+	}
+}
+
+func TestPreprepareTimestampAgeOfPreparedBlock(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.PreprepareMaxAge = 60
+	c := newTestCore(&config, newTestValidatorSet(4))
+	defer c.stopTimer()
+
+	// a block prepared an hour ago and proposed again keeps its timestamp
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Time: uint64(time.Now().Add(-time.Hour).Unix())})
+	preprepare := qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(1), block)
+	if err := c.checkProposalTimestamp(preprepare, time.Now()); !errors.Is(err, errProposalTimestamp) {
+		t.Errorf("error mismatch: have %v, want %v", err, errProposalTimestamp)
+	}
+	preprepare.JustificationPrepares = []*qbfttypes.Prepare{qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), block.Hash())}
+	if err := c.checkProposalTimestamp(preprepare, time.Now()); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+}
+
+func TestConflictingPreprepares(t *testing.T) {
+	newBlock := func(gasLimit uint64) *types.Block {
+		return types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), GasLimit: gasLimit})
+	}
+	proposed, forged, equivocated := newBlock(1), newBlock(2), newBlock(3)
+
+	for _, proposerFirst := range []bool{true, false} {
+		valSet := newTestValidatorSet(4)
+		c := newTestCore(istanbul.DefaultConfig, valSet)
+		defer c.stopTimer()
+		valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+		proposer, other := valSet.GetProposer().Address(), valSet.GetByIndex(1).Address()
+
+		fromProposer := signedBy(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), proposed), proposer)
+		fromOther := signedBy(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), forged), other)
+		conflicts := preprepareConflictMeter.Count()
+
+		// only the PRE-PREPARE from the proposer is accepted, whichever arrives first
+		if proposerFirst {
+			if err := c.handleDecodedMessage(fromProposer); err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+			if err := c.handleDecodedMessage(fromOther); err != errConflictingPreprepare {
+				t.Fatalf("error mismatch: have %v, want %v", err, errConflictingPreprepare)
+			}
+		} else {
+			if err := c.handleDecodedMessage(fromOther); err != errNotFromProposer {
+				t.Fatalf("error mismatch: have %v, want %v", err, errNotFromProposer)
+			}
+			if err := c.handleDecodedMessage(fromProposer); err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+		}
+		if hash := c.current.Proposal().Hash(); hash != proposed.Hash() {
+			t.Fatalf("accepted proposal mismatch (proposer first %v): have %v, want %v", proposerFirst, hash, proposed.Hash())
+		}
+
+		// a second block from the proposer is flagged as an equivocation, the accepted one is kept
+		second := signedBy(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), equivocated), proposer)
+		if err := c.handleDecodedMessage(second); err != errConflictingPreprepare {
+			t.Fatalf("error mismatch: have %v, want %v", err, errConflictingPreprepare)
+		}
+		if hash := c.current.Proposal().Hash(); hash != proposed.Hash() {
+			t.Errorf("accepted proposal mismatch after equivocation: have %v, want %v", hash, proposed.Hash())
+		}
+		want := int64(2)
+		if !proposerFirst {
+			want = 1
+		}
+		if flagged := preprepareConflictMeter.Count() - conflicts; metrics.Enabled && flagged != want {
+			t.Errorf("conflicts mismatch (proposer first %v): have %d, want %d", proposerFirst, flagged, want)
+		}
+	}
+}
+
+// The PRE-PREPARE accepted in a round is carried over on a round change, the proposer of the next round
+// may still propose another block
+func TestPreprepareOfNextRoundNotConflicting(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	alerts := make(chan *istanbul.ConsensusEvent, 16)
+	defer c.SubscribeConsensusEvents(alerts).Unsubscribe()
+	conflicts := preprepareConflictMeter.Count()
+
+	sequence := big.NewInt(1)
+	valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: sequence, Round: big.NewInt(0)})
+	first := signedBy(qbfttypes.NewPreprepare(sequence, big.NewInt(0), makeBlock(1)), valSet.GetProposer().Address())
+	if err := c.handleDecodedMessage(first); err != nil {
+		t.Fatalf("error mismatch for the round 0 PRE-PREPARE: have %v, want nil", err)
+	}
+
+	// nothing got prepared, the proposer of round 1 proposes another block
+	c.startNewRound(big.NewInt(1))
+	round := big.NewInt(1)
+	proposal := types.NewBlockWithHeader(&types.Header{Number: sequence, GasLimit: 1})
+	preprepare := qbfttypes.NewPreprepare(sequence, round, proposal)
+	for _, v := range valSet.List()[:c.QuorumSize()] {
+		rc := signedBy(qbfttypes.NewRoundChange(sequence, round, nil, nil), v.Address()).(*qbfttypes.RoundChange)
+		preprepare.JustificationRoundChanges = append(preprepare.JustificationRoundChanges, &rc.SignedRoundChangePayload)
+	}
+	signedBy(preprepare, valSet.GetProposer().Address())
+
+	if err := c.handleDecodedMessage(preprepare); err != nil {
+		t.Fatalf("error mismatch for the round 1 PRE-PREPARE: have %v, want nil", err)
+	}
+	if hash := c.current.Proposal().Hash(); hash != proposal.Hash() {
+		t.Errorf("accepted proposal mismatch: have %v, want %v", hash, proposal.Hash())
+	}
+	if flagged := preprepareConflictMeter.Count() - conflicts; metrics.Enabled && flagged != 0 {
+		t.Errorf("conflicts mismatch: have %d, want 0", flagged)
+	}
+	for len(alerts) > 0 {
+		if ev := <-alerts; ev.Alert != nil {
+			t.Errorf("unexpected alert: %+v", ev.Alert)
+		}
+	}
+}
+
+// The proposer does not count its PRE-PREPARE as its PREPARE: it sends a PREPARE like every other validator,
+// so that nodes of any protocol version count the same votes
+func TestProposerSendsOwnPrepare(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	defer c.stopTimer()
+	valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+	if !c.IsProposer() {
+		t.Fatal("test node should be the proposer")
+	}
+
+	preprepare := signedBy(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), makeBlock(1)), c.Address())
+	if err := c.handleDecodedMessage(preprepare); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if !hasBroadcast(c.backend.(*testBackend), qbfttypes.PrepareCode) {
+		t.Error("proposer did not broadcast its PREPARE message")
+	}
+	if size := c.current.QBFTPrepares.Size(); size != 0 {
+		t.Errorf("PREPARE count mismatch before the proposer PREPARE is received: have %d, want 0", size)
+	}
+}
+
+func TestFaultyProposers(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.InvalidProposalThreshold = 2
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+	defer c.stopTimer()
+	backend := c.backend.(*testBackend)
+	backend.verifyErr = errors.New("invalid merkle root")
+
+	view := func(sequence int64) *istanbul.View {
+		return &istanbul.View{Sequence: big.NewInt(sequence), Round: big.NewInt(0)}
+	}
+	nextProposer := func() common.Address {
+		next := valSet.Copy()
+		next.CalcProposer(common.Address{}, view(2))
+		return next.GetProposer().Address()
+	}
+	valSet.CalcProposer(common.Address{}, view(1))
+	proposer := valSet.GetProposer().Address()
+	next := nextProposer()
+	faulty := faultyProposerMeter.Count()
+
+	// the proposer keeps proposing blocks failing validation
+	for i := 0; i < 3; i++ {
+		preprepare := signedBy(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), makeBlock(1)), proposer).(*qbfttypes.Preprepare)
+		if err := c.handlePreprepareMsg(preprepare); err != backend.verifyErr {
+			t.Fatalf("error mismatch: have %v, want %v", err, backend.verifyErr)
+		}
+		reported := c.FaultyProposers()
+		if want := i >= 1; (reported[proposer] == uint64(i+1)) != want || (len(reported) == 1) != want {
+			t.Fatalf("invalid proposal %d: faulty proposers mismatch: have %v, want reported %v", i+1, reported, want)
+		}
+
+		// the proposer selection is left untouched
+		if p := nextProposer(); p != next {
+			t.Fatalf("invalid proposal %d: proposer mismatch: have %v, want %v", i+1, p, next)
+		}
+	}
+	if n := faultyProposerMeter.Count() - faulty; metrics.Enabled && n != 1 {
+		t.Errorf("faulty proposers mismatch: have %d, want 1", n)
+	}
+
+	// the proposer is reported once, when it reaches the threshold
+	if kinds := backend.alertKinds(); !reflect.DeepEqual(kinds, []string{istanbul.AlertFaultyProposer}) {
+		t.Errorf("alerts mismatch: have %v, want %v", kinds, []string{istanbul.AlertFaultyProposer})
+	}
+
+	// a valid block clears the report
+	backend.verifyErr = nil
+	preprepare := signedBy(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), makeBlock(1)), proposer).(*qbfttypes.Preprepare)
+	c.handlePreprepareMsg(preprepare)
+	if reported := c.FaultyProposers(); len(reported) != 0 {
+		t.Errorf("faulty proposers mismatch after a valid block: have %v, want none", reported)
+	}
+}
+
+func TestPreprepareParentCheck(t *testing.T) {
+	head := makeBlock(0)
+	importing := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(0), Extra: []byte("importing")})
+	newBlock := func(parent common.Hash) *types.Block {
+		return types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), ParentHash: parent})
+	}
+
+	testCases := []struct {
+		name     string
+		check    bool
+		proposal *types.Block
+		err      error
+	}{
+		{"extends head", true, newBlock(head.Hash()), nil},
+		{"extends block being imported", true, newBlock(importing.Hash()), nil},
+		{"extends unknown block", true, newBlock(common.HexToHash("0xdead")), errProposalParent},
+		{"check disabled", false, newBlock(common.HexToHash("0xdead")), nil},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := *istanbul.DefaultConfig
+			config.PreprepareParentCheck = test.check
+			valSet := newTestValidatorSet(4)
+			c := newTestCore(&config, valSet)
+			c.roundChangeSet = newRoundChangeSet(valSet)
+			defer c.stopTimer()
+			backend := c.backend.(*testBackend)
+			backend.lastProposal = head
+			backend.known = map[common.Hash]bool{importing.Hash(): true}
+			valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+
+			preprepare := qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), test.proposal)
+			signedBy(preprepare, valSet.GetProposer().Address())
+
+			if err := c.handlePreprepareMsg(preprepare); !errors.Is(err, test.err) {
+				t.Fatalf("error mismatch: have %v, want %v", err, test.err)
+			}
+			rejected := test.err != nil
+			// rejected blocks are not verified, the round changes at once
+			if verified := backend.verifies > 0; verified == rejected {
+				t.Errorf("verification mismatch: have %v, want %v", verified, !rejected)
+			}
+			if changed := c.current.Round().Uint64() == 1; changed != rejected {
+				t.Errorf("round change mismatch: have %v, want %v", changed, rejected)
+			}
+		})
+	}
+}
+
+func TestValidateProposal(t *testing.T) {
+	head := makeBlock(0)
+	newBlock := func(parent common.Hash, timestamp time.Time) *types.Block {
+		return types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), ParentHash: parent, Time: uint64(timestamp.Unix())})
+	}
+	errInvalidHeader := errors.New("invalid header")
+
+	testCases := []struct {
+		name      string
+		proposal  *types.Block
+		verifyErr error
+		failed    []string
+	}{
+		{"valid", newBlock(head.Hash(), time.Now()), nil, nil},
+		{"timestamp ahead", newBlock(head.Hash(), time.Now().Add(time.Hour)), nil, []string{proposalCheckTimestamp}},
+		{"unknown parent", newBlock(common.HexToHash("0xdead"), time.Now()), nil, []string{proposalCheckParent}},
+		{"invalid header", newBlock(head.Hash(), time.Now()), errInvalidHeader, []string{proposalCheckBlock}},
+		{"several failures", newBlock(common.HexToHash("0xdead"), time.Now().Add(time.Hour)), errInvalidHeader,
+			[]string{proposalCheckTimestamp, proposalCheckParent, proposalCheckBlock}},
+		{"future block", newBlock(head.Hash(), time.Now()), consensus.ErrFutureBlock, nil},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := *istanbul.DefaultConfig
+			config.PreprepareMaxFutureDrift = 60
+			config.PreprepareParentCheck = true
+			valSet := newTestValidatorSet(4)
+			c := newTestCore(&config, valSet)
+			c.roundChangeSet = newRoundChangeSet(valSet)
+			defer c.stopTimer()
+			backend := c.backend.(*testBackend)
+			backend.lastProposal = head
+			backend.verifyErr = test.verifyErr
+			if test.verifyErr == consensus.ErrFutureBlock {
+				backend.verifyDelay = time.Minute
+			}
+			valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+
+			result := c.ValidateProposal(test.proposal)
+			if result.Number != 1 || result.Hash != test.proposal.Hash() {
+				t.Errorf("block mismatch: have %d %v, want 1 %v", result.Number, result.Hash, test.proposal.Hash())
+			}
+			if len(result.Checks) != 3 {
+				t.Fatalf("checks mismatch: have %+v, want all of them", result.Checks)
+			}
+			var failed []string
+			for _, check := range result.Checks {
+				if check.Error != "" {
+					failed = append(failed, check.Name)
+				}
+			}
+			if !reflect.DeepEqual(failed, test.failed) {
+				t.Errorf("failed checks mismatch: have %v, want %v", failed, test.failed)
+			}
+			if pending := test.verifyErr == consensus.ErrFutureBlock; result.Pending != pending || (pending && result.Delay != time.Minute) {
+				t.Errorf("pending mismatch: have %v after %v, want %v", result.Pending, result.Delay, pending)
+			}
+			if c.state != StateAcceptRequest || c.current.Round().Sign() != 0 {
+				t.Fatalf("validation changed the consensus state: %v at round %v", c.state, c.current.Round())
+			}
+
+			// the core accepts the block proposed in a PRE-PREPARE message if and only if it is valid
+			preprepare := qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), test.proposal)
+			signedBy(preprepare, valSet.GetProposer().Address())
+			err := c.handlePreprepareMsg(preprepare)
+			c.stopFuturePreprepareTimer()
+			if accepted := err == nil && c.state == StatePreprepared; accepted != (result.Valid && !result.Pending) {
+				t.Errorf("validity mismatch: have %v, core accepted %v (err %v)", result.Valid, accepted, err)
+			}
+		})
+	}
+}
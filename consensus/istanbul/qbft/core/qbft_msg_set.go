@@ -61,9 +61,22 @@ func (ms *qbftMsgSet) View() *istanbul.View {
 	return ms.view
 }
 
+// Add stores msg, replacing any previous message from the same source.
+// It enforces that only validators can contribute, so the number of distinct
+// sources can never exceed the validator set size.
 func (ms *qbftMsgSet) Add(msg qbfttypes.QBFTMessage) error {
 	ms.messagesMu.Lock()
 	defer ms.messagesMu.Unlock()
+
+	// valSet is not part of the RLP encoding, decoded sets can not be checked
+	if ms.valSet != nil {
+		if _, v := ms.valSet.GetByAddress(msg.Source()); v == nil {
+			return errNotFromValidator
+		}
+		if _, ok := ms.messages[msg.Source()]; !ok && len(ms.messages) >= ms.valSet.Size() {
+			return errTooManyMessages
+		}
+	}
 	ms.messages[msg.Source()] = msg
 	return nil
 }
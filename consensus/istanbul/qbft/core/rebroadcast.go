@@ -0,0 +1,150 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// preprepareRebroadcast tracks the re-broadcasts of the PRE-PREPARE message sent by the proposer
+type preprepareRebroadcast struct {
+	view    *istanbul.View
+	code    uint64
+	payload []byte
+	left    uint64
+	timer   *time.Timer
+}
+
+// preprepareRebroadcastDelay returns the time between two PRE-PREPARE re-broadcasts, by default
+// they are spread over the request timeout so that they all happen before a round change
+func (c *core) preprepareRebroadcastDelay() time.Duration {
+	if c.config.PreprepareRebroadcastDelay > 0 {
+		return time.Duration(c.config.PreprepareRebroadcastDelay) * time.Millisecond
+	}
+	return time.Duration(c.config.RequestTimeout) * time.Millisecond / time.Duration(c.config.PreprepareRebroadcasts+1)
+}
+
+// schedulePreprepareRebroadcast schedules the re-broadcasts of the PRE-PREPARE message sent for view,
+// if enabled in the configuration. The very same payload is sent again so re-broadcasts are idempotent.
+func (c *core) schedulePreprepareRebroadcast(view *istanbul.View, code uint64, payload []byte) {
+	c.stopPreprepareRebroadcast()
+	if c.config.PreprepareRebroadcasts == 0 {
+		return
+	}
+	c.preprepareRebroadcast = &preprepareRebroadcast{
+		view:    view,
+		code:    code,
+		payload: payload,
+		left:    c.config.PreprepareRebroadcasts,
+	}
+	c.preprepareRebroadcast.timer = time.AfterFunc(c.preprepareRebroadcastDelay(), func() {
+		c.sendEvent(preprepareRebroadcastEvent{})
+	})
+}
+
+// stopPreprepareRebroadcast cancels the pending PRE-PREPARE re-broadcasts
+func (c *core) stopPreprepareRebroadcast() {
+	if c.preprepareRebroadcast != nil {
+		c.preprepareRebroadcast.timer.Stop()
+		c.preprepareRebroadcast = nil
+	}
+}
+
+// handlePreprepareRebroadcast sends the PRE-PREPARE message again to the validators we did not receive
+// PREPARE from, as long as we are still in the same view and did not receive a quorum of PREPARE
+func (c *core) handlePreprepareRebroadcast() {
+	r := c.preprepareRebroadcast
+	if r == nil {
+		return
+	}
+	if c.currentView().Cmp(r.view) != 0 || c.state.Cmp(StatePreprepared) > 0 {
+		c.stopPreprepareRebroadcast()
+		return
+	}
+
+	var targets []common.Address
+	for _, val := range c.valSet.List() {
+		if val.Address() != c.Address() && c.current.QBFTPrepares.Get(val.Address()) == nil {
+			targets = append(targets, val.Address())
+		}
+	}
+
+	logger := c.currentLogger(true, nil)
+	if len(targets) > 0 {
+		logger.Debug("QBFT: re-broadcast PRE-PREPARE message", "targets", targets, "left", r.left-1)
+		if err := c.backend.Resend(targets, r.code, r.payload); err != nil {
+			logger.Error("QBFT: failed to re-broadcast PRE-PREPARE message", "err", err)
+		}
+	}
+
+	r.left--
+	if r.left == 0 {
+		c.preprepareRebroadcast = nil
+		return
+	}
+	r.timer = time.AfterFunc(c.preprepareRebroadcastDelay(), func() {
+		c.sendEvent(preprepareRebroadcastEvent{})
+	})
+}
+
+// sentPhaseMessage is a PREPARE or COMMIT message sent by this node, kept as sent for re-broadcasts
+type sentPhaseMessage struct {
+	view    *istanbul.View
+	code    uint64
+	payload []byte
+}
+
+// recordSentPhaseMessage keeps the PREPARE or COMMIT message sent for view, replacing the previous one
+// with the same code and dropping the ones sent for another view
+func (c *core) recordSentPhaseMessage(view *istanbul.View, code uint64, payload []byte) {
+	sent := c.sentPhaseMessages[:0]
+	for _, m := range c.sentPhaseMessages {
+		if m.view.Cmp(view) == 0 && m.code != code {
+			sent = append(sent, m)
+		}
+	}
+	c.sentPhaseMessages = append(sent, &sentPhaseMessage{view: view, code: code, payload: payload})
+}
+
+// rebroadcastOnReentry re-sends the PREPARE and COMMIT messages sent for the current view to the other
+// validators, if enabled in the configuration. The very same payloads are sent again, without signing
+// new messages, so that re-entering a view any number of times is idempotent.
+func (c *core) rebroadcastOnReentry(logger log.Logger) {
+	if !c.config.ReentryRebroadcast {
+		return
+	}
+
+	var targets []common.Address
+	for _, val := range c.valSet.List() {
+		if val.Address() != c.Address() {
+			targets = append(targets, val.Address())
+		}
+	}
+	for _, m := range c.sentPhaseMessages {
+		if m.view.Cmp(c.currentView()) != 0 {
+			continue
+		}
+		logger.Info("QBFT: re-broadcast message on view re-entry", "code", m.code)
+		if err := c.backend.Resend(targets, m.code, m.payload); err != nil {
+			logger.Error("QBFT: failed to re-broadcast message on view re-entry", "code", m.code, "err", err)
+		}
+	}
+}
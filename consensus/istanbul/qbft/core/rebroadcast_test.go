@@ -0,0 +1,173 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+)
+
+func containsAddress(addrs []common.Address, addr common.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPreprepareRebroadcast(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.PreprepareRebroadcasts = 3
+	config.PreprepareRebroadcastDelay = 3600 * 1000
+	valSet := newTestValidatorSet(4)
+	valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+	proposer := valSet.GetProposer().Address()
+	var validator common.Address
+	for _, v := range valSet.List() {
+		if v.Address() != proposer {
+			validator = v.Address()
+			break
+		}
+	}
+
+	newCore := func(addr common.Address) (*core, *testBackend) {
+		c := newTestCore(&config, valSet)
+		backend := c.backend.(*testBackend)
+		backend.address = addr
+		c.address = addr
+		return c, backend
+	}
+	p, proposerBackend := newCore(proposer)
+	defer p.stopPreprepareRebroadcast()
+	v, _ := newCore(validator)
+	defer v.stopTimer()
+
+	// the PRE-PREPARE broadcast gets lost on the way to the validator
+	block := makeBlock(1)
+	p.sendPreprepareMsg(&Request{Proposal: block})
+	if len(proposerBackend.broadcasts) != 1 || proposerBackend.broadcasts[0] != qbfttypes.PreprepareCode {
+		t.Fatalf("broadcasts mismatch: have %v, want [%v]", proposerBackend.broadcasts, qbfttypes.PreprepareCode)
+	}
+
+	// the re-broadcast reaches it, it catches up without waiting for a round change
+	p.handlePreprepareRebroadcast()
+	if len(proposerBackend.resends) != 1 {
+		t.Fatalf("resends mismatch: have %d, want 1", len(proposerBackend.resends))
+	}
+	resend := proposerBackend.resends[0]
+	if !containsAddress(resend.targets, validator) || containsAddress(resend.targets, proposer) {
+		t.Errorf("targets mismatch: have %v, want validators other than the proposer", resend.targets)
+	}
+	if err := v.handleEncodedMsg(resend.code, resend.payload, false); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if v.state != StatePreprepared || v.current.Round().Sign() != 0 {
+		t.Fatalf("state mismatch: have %v at round %v, want %v at round 0", v.state, v.current.Round(), StatePreprepared)
+	}
+	if v.current.Proposal().Hash() != block.Hash() {
+		t.Errorf("proposal mismatch: have %v, want %v", v.current.Proposal().Hash(), block.Hash())
+	}
+
+	// validators which sent their PREPARE are not targeted anymore, the payload is unchanged
+	prepare := qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), block.Hash())
+	if err := p.current.QBFTPrepares.Add(signedBy(prepare, validator)); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	p.handlePreprepareRebroadcast()
+	if len(proposerBackend.resends) != 2 {
+		t.Fatalf("resends mismatch: have %d, want 2", len(proposerBackend.resends))
+	}
+	resend = proposerBackend.resends[1]
+	if containsAddress(resend.targets, validator) {
+		t.Errorf("validator which sent PREPARE should not be targeted")
+	}
+	if !bytes.Equal(resend.payload, proposerBackend.resends[0].payload) {
+		t.Errorf("re-broadcast payload should not change")
+	}
+
+	// re-broadcasts stop with a quorum of PREPARE
+	p.state = StatePrepared
+	p.handlePreprepareRebroadcast()
+	if len(proposerBackend.resends) != 2 {
+		t.Errorf("resends mismatch: have %d, want 2", len(proposerBackend.resends))
+	}
+	if p.preprepareRebroadcast != nil {
+		t.Errorf("re-broadcasts should be cancelled")
+	}
+}
+
+func TestReentryRebroadcast(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		config := *istanbul.DefaultConfig
+		config.ReentryRebroadcast = enabled
+		valSet := newTestValidatorSet(4)
+		c := newTestCore(&config, valSet)
+		c.roundChangeSet = newRoundChangeSet(valSet)
+		backend := c.backend.(*testBackend)
+
+		// the node sends its PREPARE and COMMIT at round 1
+		c.current.SetPreprepare(qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), makeBlock(1)))
+		c.startNewRound(common.Big1)
+		c.broadcastPrepare()
+		c.broadcastCommit()
+		if len(backend.broadcasts) != 2 {
+			t.Fatalf("broadcasts mismatch: have %v, want PREPARE and COMMIT", backend.broadcasts)
+		}
+		sent := c.sentPhaseMessages
+
+		// a round change lands back on round 1, twice
+		for i := 0; i < 2; i++ {
+			c.startNewRound(common.Big1)
+			if !enabled {
+				continue
+			}
+			if len(backend.resends) != 2*(i+1) {
+				t.Fatalf("re-entry %d: resends mismatch: have %d, want %d", i, len(backend.resends), 2*(i+1))
+			}
+			for j, resend := range backend.resends[2*i:] {
+				if resend.code != sent[j].code || !bytes.Equal(resend.payload, sent[j].payload) {
+					t.Errorf("re-entry %d: resend %d mismatch: have code %#x, want the sent message with code %#x", i, j, resend.code, sent[j].code)
+				}
+				if len(resend.targets) != 3 || containsAddress(resend.targets, c.Address()) {
+					t.Errorf("re-entry %d: targets mismatch: have %v, want the other validators", i, resend.targets)
+				}
+			}
+		}
+		c.stopTimer()
+		if !enabled && len(backend.resends) != 0 {
+			t.Errorf("resends mismatch with re-broadcasts disabled: have %d, want 0", len(backend.resends))
+		}
+		if len(backend.broadcasts) != 2 {
+			t.Errorf("broadcasts mismatch: have %v, want no new message signed", backend.broadcasts)
+		}
+
+		// messages of another round are not re-broadcast
+		resends := len(backend.resends)
+		c.startNewRound(big.NewInt(2))
+		c.startNewRound(big.NewInt(2))
+		c.stopTimer()
+		if len(backend.resends) != resends {
+			t.Errorf("resends mismatch at round 2: have %d, want %d", len(backend.resends), resends)
+		}
+	}
+}
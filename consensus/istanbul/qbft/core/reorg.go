@@ -0,0 +1,86 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+)
+
+// maxRetainedMessages bounds the number of messages retained for each finalized sequence
+const maxRetainedMessages = 1024
+
+// retainMessage keeps m, received for a sequence before the current one, if the sequence is within the reorg
+// depth. The messages of older sequences are forgotten.
+func (c *core) retainMessage(m qbfttypes.QBFTMessage) {
+	depth := c.config.ReorgDepth
+	if depth == 0 {
+		return
+	}
+	current := c.current.Sequence().Uint64()
+	c.pruneRetainedMessages(current)
+
+	sequence := m.View().Sequence.Uint64()
+	if sequence >= current || current-sequence > depth {
+		return
+	}
+	if c.retainedMessages == nil {
+		c.retainedMessages = make(map[uint64][]qbfttypes.QBFTMessage)
+	}
+	if len(c.retainedMessages[sequence]) >= maxRetainedMessages {
+		return
+	}
+	c.retainedMessages[sequence] = append(c.retainedMessages[sequence], m)
+	c.currentLogger(true, m).Trace("QBFT: retain message of finalized sequence within reorg depth")
+}
+
+// pruneRetainedMessages forgets the retained messages of the sequences beyond the reorg depth of current
+func (c *core) pruneRetainedMessages(current uint64) {
+	for sequence := range c.retainedMessages {
+		if sequence >= current || current-sequence > c.config.ReorgDepth {
+			delete(c.retainedMessages, sequence)
+		}
+	}
+}
+
+// withinReorgDepth reports whether a chain whose last block is number got reorganised below the current
+// sequence by no more than the reorg depth, consensus then resumes from it
+func (c *core) withinReorgDepth(number *big.Int) bool {
+	depth := c.config.ReorgDepth
+	if depth == 0 || c.current == nil {
+		return false
+	}
+	next, current := number.Uint64()+1, c.current.Sequence().Uint64()
+	return next < current && current-next <= depth
+}
+
+// restoreRetainedMessages moves the retained messages of the current sequence and the later ones to the
+// backlog, once the chain got reorganised and these sequences are agreed on again
+func (c *core) restoreRetainedMessages() {
+	current := c.current.Sequence().Uint64()
+	for sequence, msgs := range c.retainedMessages {
+		if sequence < current {
+			continue
+		}
+		for _, m := range msgs {
+			c.addToBacklog(m)
+		}
+		delete(c.retainedMessages, sequence)
+	}
+	c.pruneRetainedMessages(current)
+}
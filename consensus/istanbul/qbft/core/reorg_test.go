@@ -0,0 +1,81 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+func TestReorgDepthRetention(t *testing.T) {
+	for _, depth := range []uint64{0, 2} {
+		config := *istanbul.DefaultConfig
+		config.ReorgDepth = depth
+		valSet := newTestValidatorSet(4)
+		c := newTestCore(&config, valSet)
+		c.roundChangeSet = newRoundChangeSet(valSet)
+		defer c.stopTimer()
+		backend := c.backend.(*testBackend)
+		src := valSet.GetByIndex(1).Address()
+
+		// the chain finalized sequence 5, messages for the previous sequences arrive late
+		backend.lastProposal = makeBlock(5)
+		c.startNewRound(common.Big0)
+		for sequence := int64(3); sequence <= 5; sequence++ {
+			if err := c.handleDecodedMessage(newFuturePrepare(sequence, src)); err != errOldMessage {
+				t.Fatalf("depth %d, sequence %d: error mismatch: have %v, want %v", depth, sequence, err, errOldMessage)
+			}
+		}
+		want := map[uint64]int{}
+		if depth > 0 {
+			// sequence 3 is beyond the reorg depth of sequence 6
+			want = map[uint64]int{4: 1, 5: 1}
+		}
+		if len(c.retainedMessages) != len(want) {
+			t.Fatalf("depth %d: retained sequences mismatch: have %d, want %d", depth, len(c.retainedMessages), len(want))
+		}
+		for sequence, n := range want {
+			if have := len(c.retainedMessages[sequence]); have != n {
+				t.Errorf("depth %d, sequence %d: retained messages mismatch: have %d, want %d", depth, sequence, have, n)
+			}
+		}
+
+		// a shallow reorg takes the chain back to block 3, consensus resumes from sequence 4 with the
+		// retained messages backlogged again
+		backend.lastProposal = makeBlock(3)
+		c.startNewRound(common.Big0)
+		wantSequence, wantBacklog := uint64(6), 0
+		if depth > 0 {
+			wantSequence, wantBacklog = 4, 2
+		}
+		if sequence := c.current.Sequence().Uint64(); sequence != wantSequence {
+			t.Errorf("depth %d: sequence mismatch after reorg: have %d, want %d", depth, sequence, wantSequence)
+		}
+		backlog := 0
+		if c.backlogs[src] != nil {
+			backlog = c.backlogs[src].Size()
+		}
+		if backlog != wantBacklog {
+			t.Errorf("depth %d: backlog mismatch after reorg: have %d, want %d", depth, backlog, wantBacklog)
+		}
+		if len(c.retainedMessages) != 0 {
+			t.Errorf("depth %d: retained messages left after reorg: %d sequences", depth, len(c.retainedMessages))
+		}
+	}
+}
@@ -35,6 +35,11 @@ func (c *core) handleRequest(request *Request) error {
 
 	logger.Info("QBFT: handle block proposal request")
 
+	if c.emptyValidatorSet() {
+		logger.Error("QBFT: empty validator set, request not handled")
+		return errEmptyValidatorSet
+	}
+
 	if err := c.checkRequestMsg(request); err != nil {
 		if err == errInvalidMessage {
 			logger.Error("QBFT: invalid request")
@@ -45,6 +50,11 @@ func (c *core) handleRequest(request *Request) error {
 	}
 
 	c.current.pendingRequest = request
+	c.appendRequestWAL(request)
+	if c.state == StateAcceptRequest && c.skipEmptyProposal(request) {
+		logger.Debug("QBFT: empty block proposal skipped, waiting for transactions")
+		return nil
+	}
 	if c.state == StateAcceptRequest {
 		config := c.config.GetConfig(c.current.Sequence())
 		if config.EmptyBlockPeriod == 0 { // emptyBlockPeriod is not set
@@ -81,6 +91,9 @@ func (c *core) handleRequest(request *Request) error {
 			if delay > 0 {
 				c.newRoundTimer = time.AfterFunc(delay, func() {
 					c.newRoundTimer = nil
+					if c.isStopped() {
+						return
+					}
 					// Start ROUND-CHANGE timer
 					c.newRoundChangeTimer()
 
@@ -100,6 +113,19 @@ func (c *core) handleRequest(request *Request) error {
 	return nil
 }
 
+// skipEmptyProposal returns whether the proposer should not propose the block of request as it has no
+// transaction. Only proposals of round 0 are skipped: the other validators start their timer on the
+// PRE-PREPARE of round 0, so they idle without it. Once a round change started, they run the timer of the
+// new round whatever the proposer does, skipping the proposal would only make them change round again, so
+// a proposer of a later round always proposes, an empty block included.
+func (c *core) skipEmptyProposal(request *Request) bool {
+	if !c.config.SkipEmptyBlocks || c.current.Round().Sign() != 0 {
+		return false
+	}
+	block, ok := request.Proposal.(*types.Block)
+	return ok && len(block.Transactions()) == 0
+}
+
 // check request state
 // return errInvalidMessage if the message is invalid
 // return errFutureMessage if the sequence of proposal is larger than current sequence
@@ -158,7 +184,7 @@ func (c *core) processPendingRequests() {
 		}
 		logger.Debug("QBFT: found pending block proposal request", "proposal.number", r.Proposal.Number(), "proposal.hash", r.Proposal.Hash())
 
-		go c.sendEvent(istanbul.RequestEvent{
+		c.sendEventOrdered(istanbul.RequestEvent{
 			Proposal: r.Proposal,
 		})
 	}
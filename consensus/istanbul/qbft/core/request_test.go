@@ -0,0 +1,114 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestSkipEmptyBlocks(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.SkipEmptyBlocks = true
+	valSet := newTestValidatorSet(4)
+
+	// the core is the proposer of round 0
+	c := newTestCore(&config, valSet)
+	backend := c.backend.(*testBackend)
+	defer c.stopTimer()
+
+	// while idle the miner keeps requesting empty blocks, none of them is proposed
+	for i := 0; i < 3; i++ {
+		if err := c.handleRequest(&Request{Proposal: makeBlock(1)}); err != nil {
+			t.Fatalf("handle empty request: %v", err)
+		}
+	}
+	if hasBroadcast(backend, qbfttypes.PreprepareCode) {
+		t.Fatalf("empty block proposed while idle")
+	}
+	if c.roundChangeTimer != nil {
+		t.Fatalf("round change timer started while idle")
+	}
+
+	// a transaction arrives, the block including it is proposed
+	tx := types.NewTransaction(0, common.Address{}, big.NewInt(0), 21000, big.NewInt(0), nil)
+	block := makeBlock(1).WithBody([]*types.Transaction{tx}, nil)
+	if err := c.handleRequest(&Request{Proposal: block}); err != nil {
+		t.Fatalf("handle request: %v", err)
+	}
+	if !hasBroadcast(backend, qbfttypes.PreprepareCode) {
+		t.Fatalf("block with transactions not proposed")
+	}
+}
+
+func TestSkipEmptyBlocksAfterRoundChange(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.SkipEmptyBlocks = true
+	valSet := newTestValidatorSet(4)
+
+	// run the core as the proposer of round 1
+	proposers := valSet.Copy()
+	proposers.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(1)})
+	proposer := proposers.GetProposer().Address()
+	c := newTestCore(&config, valSet)
+	c.backend.(*testBackend).address = proposer
+	c.address = proposer
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	defer c.stopPreprepareRebroadcast()
+
+	// the empty block requested by the miner is held back at round 0
+	empty := makeBlock(1)
+	if err := c.handleRequest(&Request{Proposal: empty}); err != nil {
+		t.Fatalf("handle empty request: %v", err)
+	}
+	backend := c.backend.(*testBackend)
+	if hasBroadcast(backend, qbfttypes.PreprepareCode) {
+		t.Fatalf("empty block proposed at round 0")
+	}
+
+	// the other validators change round, the empty block is proposed at round 1 to complete the round change
+	for _, v := range valSet.List() {
+		if v.Address() == proposer {
+			continue
+		}
+		if err := c.handleRoundChange(newTestRoundChange(1, v.Address())); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+	}
+	var payload []byte
+	for i, code := range backend.broadcasts {
+		if code == qbfttypes.PreprepareCode {
+			payload = backend.payloads[i]
+		}
+	}
+	if payload == nil {
+		t.Fatalf("empty block not proposed after the round change")
+	}
+	msg, err := qbfttypes.Decode(qbfttypes.PreprepareCode, payload)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if preprepare := msg.(*qbfttypes.Preprepare); preprepare.Round.Uint64() != 1 || preprepare.Proposal.Hash() != empty.Hash() {
+		t.Errorf("PRE-PREPARE mismatch: have round %v block %v, want round 1 block %v", preprepare.Round, preprepare.Proposal.Hash(), empty.Hash())
+	}
+}
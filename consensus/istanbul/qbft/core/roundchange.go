@@ -17,10 +17,13 @@
 package core
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"math/big"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
@@ -31,6 +34,86 @@ import (
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
+// Reasons of a round change reported by LastRoundChange
+const (
+	roundChangeReasonPreprepareTimeout = "timeout waiting for PRE-PREPARE"
+	roundChangeReasonPrepareTimeout    = "timeout waiting for PREPARE quorum"
+	roundChangeReasonCommitTimeout     = "timeout waiting for COMMIT quorum"
+	roundChangeReasonTimeout           = "round timeout"
+	roundChangeReasonPeers             = "received F+1 ROUND-CHANGE messages"
+	roundChangeReasonTimestamp         = "PRE-PREPARE block timestamp out of window"
+	roundChangeReasonParent            = "PRE-PREPARE block does not extend the chain head"
+)
+
+// timeoutRoundChangeReason returns the reason of a round change caused by the
+// ROUND-CHANGE timer expiring, depending on the step the round got stuck at
+func (c *core) timeoutRoundChangeReason() string {
+	switch c.state {
+	case StateAcceptRequest:
+		return roundChangeReasonPreprepareTimeout
+	case StatePreprepared:
+		return roundChangeReasonPrepareTimeout
+	case StatePrepared:
+		return roundChangeReasonCommitTimeout
+	default:
+		return roundChangeReasonTimeout
+	}
+}
+
+// handleTieBreakTimeout handles a timeout from the tie-break round on, so that validators split between two
+// rounds, neither reaching a quorum of ROUND-CHANGE messages, converge on the higher one. A validator joined
+// at its round by others, without a quorum, waits for one more timeout and sends its ROUND-CHANGE message
+// again, so that the validators behind catch up. It returns whether the timeout was handled, the validator
+// moves to the next round otherwise.
+func (c *core) handleTieBreakTimeout() bool {
+	threshold := c.config.RoundChangeTieBreakRound
+	round := c.current.Round()
+	if threshold == 0 || round.Uint64() < threshold || c.roundChangeSet == nil {
+		return false
+	}
+
+	view := c.currentView()
+	if c.tieBreakHold != nil && c.tieBreakHold.Cmp(view) == 0 {
+		return false
+	}
+	others := c.roundChangeSet.getRCMessagesFromOthers(round, c.Address())
+	if others == 0 || c.roundChangeSet.getRCMessagesForGivenRound(round) >= c.QuorumSize() {
+		return false
+	}
+	c.tieBreakHold = view
+	c.currentLogger(true, nil).Info("QBFT: validators split between rounds, wait for the ones behind", "roundChanges.count", others)
+	c.newRoundChangeTimer()
+	c.broadcastRoundChange(round)
+	return true
+}
+
+// recordRoundChange records a round change from the current view to the target round
+func (c *core) recordRoundChange(reason string, round *big.Int) {
+	info := &istanbul.RoundChangeInfo{
+		Reason:      reason,
+		Sequence:    c.current.Sequence().Uint64(),
+		Round:       c.current.Round().Uint64(),
+		TargetRound: round.Uint64(),
+		Timestamp:   time.Now(),
+	}
+	c.roundChangeReasons = append(c.roundChangeReasons, reason)
+
+	c.lastRoundChangeMu.Lock()
+	defer c.lastRoundChangeMu.Unlock()
+	c.lastRoundChange = info
+}
+
+// LastRoundChange returns why and when the last round change occurred, nil if none did
+func (c *core) LastRoundChange() *istanbul.RoundChangeInfo {
+	c.lastRoundChangeMu.Lock()
+	defer c.lastRoundChangeMu.Unlock()
+	if c.lastRoundChange == nil {
+		return nil
+	}
+	info := *c.lastRoundChange
+	return &info
+}
+
 // broadcastNextRoundChange sends the ROUND CHANGE message with current round + 1
 func (c *core) broadcastNextRoundChange() {
 	cv := c.currentView()
@@ -108,6 +191,13 @@ func (c *core) handleRoundChange(roundChange *qbfttypes.RoundChange) error {
 
 	logger.Info("QBFT: handle ROUND-CHANGE message", "higherRoundChanges.count", num, "currentRoundChanges.count", currentRoundMessages)
 
+	if c.config.StrictPreparedCertificates {
+		if err := c.checkPreparedCertificate(roundChange); err != nil {
+			logger.Warn("QBFT: reject ROUND-CHANGE message", "err", err)
+			return err
+		}
+	}
+
 	// Add ROUND-CHANGE message to message set
 	if view.Round.Cmp(currentRound) >= 0 {
 		var prepareMessages []*qbfttypes.Prepare = nil
@@ -133,16 +223,27 @@ func (c *core) handleRoundChange(roundChange *qbfttypes.RoundChange) error {
 
 	logger = logger.New("higherRoundChanges.count", num, "currentRoundChanges.count", currentRoundMessages)
 
-	if num == c.valSet.F()+1 {
-		// We received F+1 ROUND-CHANGE messages (this may happen before our timeout exprired)
-		// we start new round and broadcast ROUND-CHANGE message
-		newRound := c.roundChangeSet.getMinRoundChange(currentRound)
+	if num >= c.valSet.F()+1 {
+		// We received ROUND-CHANGE messages for higher rounds from F+1 validators (this may happen before our
+		// timeout expired), at least one of them is honest. We catch up to the highest round reached by F+1
+		// validators and broadcast ROUND-CHANGE message, the new round only starts with a PRE-PREPARE once
+		// a quorum of ROUND-CHANGE messages is received for it
+		newRound := c.roundChangeSet.highestRoundWithMessages(currentRound, c.valSet.F()+1)
 
-		logger.Info("QBFT: received F+1 ROUND-CHANGE messages", "F", c.valSet.F())
+		logger.Info("QBFT: received F+1 ROUND-CHANGE messages", "F", c.valSet.F(), "target.round", newRound)
+
+		if c.haltOnMaxRound(newRound) {
+			return nil
+		}
+		c.recordRoundChange(roundChangeReasonPeers, newRound)
 
 		c.startNewRound(newRound)
 		c.broadcastRoundChange(newRound)
 	} else if currentRoundMessages >= c.QuorumSize() && c.IsProposer() && c.current.preprepareSent.Cmp(currentRound) < 0 {
+		if c.halted {
+			logger.Warn("QBFT: received quorum of ROUND-CHANGE messages while halted, do not propose")
+			return nil
+		}
 		logger.Info("QBFT: received quorum of ROUND-CHANGE messages")
 
 		// We received quorum of ROUND-CHANGE for current round and we are proposer
@@ -186,6 +287,39 @@ func (c *core) handleRoundChange(roundChange *qbfttypes.RoundChange) error {
 	return nil
 }
 
+// checkPreparedCertificate checks that a ROUND-CHANGE message claiming a prepared block carries the
+// certificate of it: the block itself and a quorum of PREPARE messages for it, from distinct validators,
+// at the prepared round which must be lower than the round of the ROUND-CHANGE message.
+// Messages not claiming any prepared block are valid.
+func (c *core) checkPreparedCertificate(roundChange *qbfttypes.RoundChange) error {
+	if common.EmptyHash(roundChange.PreparedDigest) && roundChange.PreparedBlock == nil && len(roundChange.Justification) == 0 {
+		return nil
+	}
+	if roundChange.PreparedRound == nil || roundChange.PreparedBlock == nil || roundChange.PreparedBlock.Hash() != roundChange.PreparedDigest {
+		return fmt.Errorf("%w: missing or mismatching prepared block", errInvalidPreparedCertificate)
+	}
+	if roundChange.PreparedRound.Cmp(roundChange.Round) >= 0 {
+		return fmt.Errorf("%w: prepared round %v not lower than round %v", errInvalidPreparedCertificate, roundChange.PreparedRound, roundChange.Round)
+	}
+	if err := hasMatchingRoundChangeAndPrepares(roundChange, roundChange.Justification, c.QuorumSize()); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidPreparedCertificate, err)
+	}
+	sources := make(map[common.Address]struct{})
+	for _, prepare := range roundChange.Justification {
+		if prepare.Sequence.Cmp(roundChange.Sequence) != 0 {
+			return fmt.Errorf("%w: PREPARE message for sequence %v", errInvalidPreparedCertificate, prepare.Sequence)
+		}
+		if _, v := c.valSet.GetByAddress(prepare.Source()); v == nil {
+			return fmt.Errorf("%w: PREPARE message from non validator %v", errInvalidPreparedCertificate, prepare.Source())
+		}
+		if _, ok := sources[prepare.Source()]; ok {
+			return fmt.Errorf("%w: duplicate PREPARE message from %v", errInvalidPreparedCertificate, prepare.Source())
+		}
+		sources[prepare.Source()] = struct{}{}
+	}
+	return nil
+}
+
 // highestPrepared returns the highest Prepared Round and the corresponding Prepared Block
 func (c *core) highestPrepared(round *big.Int) (*big.Int, istanbul.Proposal) {
 	return c.roundChangeSet.highestPreparedRound[round.Uint64()], c.roundChangeSet.highestPreparedBlock[round.Uint64()]
@@ -279,22 +413,47 @@ func (rcs *roundChangeSet) getRCMessagesForGivenRound(round *big.Int) int {
 	return 0
 }
 
-// getMinRoundChange returns the minimum round greater than the given round
-func (rcs *roundChangeSet) getMinRoundChange(round *big.Int) *big.Int {
+// getRCMessagesFromOthers returns the count of validators other than self we received a ROUND-CHANGE
+// message from for a given round
+func (rcs *roundChangeSet) getRCMessagesFromOthers(round *big.Int, self common.Address) int {
+	rcs.mu.Lock()
+	defer rcs.mu.Unlock()
+
+	rms := rcs.roundChanges[round.Uint64()]
+	if rms == nil {
+		return 0
+	}
+	count := len(rms.messages)
+	if _, ok := rms.messages[self]; ok {
+		count--
+	}
+	return count
+}
+
+// highestRoundWithMessages returns the highest round greater than the given round such that at least
+// num validators sent a ROUND-CHANGE message for this round or a higher one, nil if there is none
+func (rcs *roundChangeSet) highestRoundWithMessages(round *big.Int, num int) *big.Int {
 	rcs.mu.Lock()
 	defer rcs.mu.Unlock()
 
-	var keys []int
+	var keys []uint64
 	for k := range rcs.roundChanges {
 		if k > round.Uint64() {
-			keys = append(keys, int(k))
+			keys = append(keys, k)
 		}
 	}
-	sort.Ints(keys)
-	if len(keys) == 0 {
-		return round
+	sort.Slice(keys, func(i, j int) bool { return keys[i] > keys[j] })
+
+	addresses := make(map[common.Address]struct{})
+	for _, k := range keys {
+		for addr := range rcs.roundChanges[k].messages {
+			addresses[addr] = struct{}{}
+		}
+		if len(addresses) >= num {
+			return new(big.Int).SetUint64(k)
+		}
 	}
-	return big.NewInt(int64(keys[0]))
+	return nil
 }
 
 // ClearLowerThan deletes the messages for round earlier than the given round
@@ -329,3 +488,63 @@ func (rcs *roundChangeSet) MaxRound(num int) *big.Int {
 	}
 	return maxRound
 }
+
+// groups returns the senders of the messages of each round, in ascending round order
+func (rcs *roundChangeSet) groups() []*istanbul.RoundChangeGroup {
+	rcs.mu.Lock()
+	defer rcs.mu.Unlock()
+
+	groups := make([]*istanbul.RoundChangeGroup, 0, len(rcs.roundChanges))
+	for k, rms := range rcs.roundChanges {
+		if rms.Size() == 0 {
+			continue
+		}
+		group := &istanbul.RoundChangeGroup{Round: k}
+		for addr := range rms.messages {
+			group.Senders = append(group.Senders, addr)
+		}
+		sort.Slice(group.Senders, func(i, j int) bool {
+			return bytes.Compare(group.Senders[i].Bytes(), group.Senders[j].Bytes()) < 0
+		})
+		group.Count = len(group.Senders)
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Round < groups[j].Round })
+
+	addresses := make(map[common.Address]struct{})
+	for i := len(groups) - 1; i >= 0; i-- {
+		for _, addr := range groups[i].Senders {
+			addresses[addr] = struct{}{}
+		}
+		groups[i].AtOrAbove = len(addresses)
+	}
+	return groups
+}
+
+// RoundChanges reports the ROUND-CHANGE messages collected for the current sequence against the F+1
+// and quorum thresholds, nil if no view has started yet
+func (c *core) RoundChanges() *istanbul.RoundChangeReport {
+	var report *istanbul.RoundChangeReport
+	c.queryEventLoop(func() { report = c.roundChanges() })
+	return report
+}
+
+// roundChanges builds the ROUND-CHANGE report of the current sequence, it must be called from the event loop
+func (c *core) roundChanges() *istanbul.RoundChangeReport {
+	current, valSet, rcs := c.current, c.valSet, c.roundChangeSet
+	if current == nil || valSet == nil {
+		return nil
+	}
+	sequence := current.Sequence()
+	report := &istanbul.RoundChangeReport{
+		Sequence: sequence.Uint64(),
+		Round:    current.Round().Uint64(),
+		FPlusOne: valSet.F() + 1,
+		Quorum:   istanbul.QuorumSize(c.config, valSet, sequence),
+		Rounds:   []*istanbul.RoundChangeGroup{},
+	}
+	if rcs != nil {
+		report.Rounds = rcs.groups()
+	}
+	return report
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+)
+
+// defaultRoundChangeAnomalyRatio is the share (in percent) of ROUND-CHANGE messages among the latest messages
+// of a validator from which it is flagged, unless configured otherwise
+const defaultRoundChangeAnomalyRatio = 90
+
+// messageMix keeps the codes of the latest messages received from a validator
+type messageMix struct {
+	codes        []uint64
+	next         int
+	full         bool
+	roundChanges int  // number of ROUND-CHANGE messages in codes
+	flagged      bool // whether the validator is currently flagged
+}
+
+func (m *messageMix) add(code uint64) {
+	if m.full && m.codes[m.next] == qbfttypes.RoundChangeCode {
+		m.roundChanges--
+	}
+	m.codes[m.next] = code
+	if code == qbfttypes.RoundChangeCode {
+		m.roundChanges++
+	}
+	m.next = (m.next + 1) % len(m.codes)
+	if m.next == 0 {
+		m.full = true
+	}
+}
+
+// recordMessageMix accounts for a message received from a validator, and warns once the share of ROUND-CHANGE
+// messages among its latest messages reaches the configured ratio. A validator which never sends PREPARE nor
+// COMMIT messages is likely misconfigured or faulty, and its ROUND-CHANGE messages help trigger round changes.
+func (c *core) recordMessageMix(m qbfttypes.QBFTMessage) {
+	window := c.config.RoundChangeAnomalyWindow
+	if window == 0 || c.valSet == nil {
+		return
+	}
+	src := m.Source()
+	if _, v := c.valSet.GetByAddress(src); v == nil {
+		return
+	}
+	if c.messageMixes == nil {
+		c.messageMixes = make(map[common.Address]*messageMix)
+	}
+	mix, ok := c.messageMixes[src]
+	if !ok || uint64(len(mix.codes)) != window {
+		mix = &messageMix{codes: make([]uint64, window)}
+		c.messageMixes[src] = mix
+	}
+	mix.add(m.Code())
+	if !mix.full {
+		return
+	}
+
+	ratio := c.config.RoundChangeAnomalyRatio
+	if ratio == 0 {
+		ratio = defaultRoundChangeAnomalyRatio
+	}
+	anomalous := uint64(mix.roundChanges)*100 >= ratio*window
+	if anomalous && !mix.flagged {
+		roundChangeAnomalyMeter.Mark(1)
+		c.currentLogger(true, m).Warn("QBFT: validator sends mostly ROUND-CHANGE messages", "validator", src, "roundChanges", mix.roundChanges, "messages", window)
+	}
+	mix.flagged = anomalous
+}
+
+// pruneMessageMixes forgets the messages of the validators which left the validator set
+func (c *core) pruneMessageMixes() {
+	for src := range c.messageMixes {
+		if _, v := c.valSet.GetByAddress(src); v == nil {
+			delete(c.messageMixes, src)
+		}
+	}
+}
+
+// roundChangeAnomalies returns the validators currently flagged for sending mostly ROUND-CHANGE messages
+func (c *core) roundChangeAnomalies() []common.Address {
+	var flagged []common.Address
+	for src, mix := range c.messageMixes {
+		if mix.flagged {
+			flagged = append(flagged, src)
+		}
+	}
+	return flagged
+}
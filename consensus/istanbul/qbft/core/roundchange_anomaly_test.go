@@ -0,0 +1,62 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+func TestRoundChangeAnomaly(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.RoundChangeAnomalyWindow = 10
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+	faulty, honest := valSet.GetByIndex(1).Address(), valSet.GetByIndex(2).Address()
+
+	before := roundChangeAnomalyMeter.Count()
+	for round := int64(1); round <= 20; round++ {
+		c.recordMessageMix(newTestRoundChange(round, faulty))
+
+		// the honest validator changes round too, but keeps preparing and committing
+		c.recordMessageMix(newTestRoundChange(round, honest))
+		c.recordMessageMix(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(round), common.Hash{}), honest))
+		c.recordMessageMix(signedBy(qbfttypes.NewCommit(big.NewInt(1), big.NewInt(round), common.Hash{}, nil), honest))
+	}
+
+	flagged := c.roundChangeAnomalies()
+	if len(flagged) != 1 || flagged[0] != faulty {
+		t.Fatalf("flagged validators mismatch: have %v, want [%v]", flagged, faulty)
+	}
+	// the validator is flagged once, not for every message
+	if have := roundChangeAnomalyMeter.Count() - before; metrics.Enabled && have != 1 {
+		t.Errorf("anomaly meter mismatch: have %d, want 1", have)
+	}
+
+	// the flag is cleared once the validator participates again
+	for round := int64(21); round <= 25; round++ {
+		c.recordMessageMix(signedBy(qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(round), common.Hash{}), faulty))
+	}
+	if flagged := c.roundChangeAnomalies(); len(flagged) != 0 {
+		t.Errorf("flagged validators mismatch: have %v, want none", flagged)
+	}
+}
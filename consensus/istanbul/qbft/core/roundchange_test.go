@@ -0,0 +1,485 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestLastRoundChange(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+
+	if info := c.LastRoundChange(); info != nil {
+		t.Fatalf("last round change mismatch: have %v, want nil", info)
+	}
+
+	// no PRE-PREPARE received in round 0
+	c.handleTimeoutMsg()
+	info := c.LastRoundChange()
+	if info == nil {
+		t.Fatalf("last round change should be recorded")
+	}
+	if info.Reason != roundChangeReasonPreprepareTimeout {
+		t.Errorf("reason mismatch: have %q, want %q", info.Reason, roundChangeReasonPreprepareTimeout)
+	}
+	if info.Sequence != 1 || info.Round != 0 || info.TargetRound != 1 {
+		t.Errorf("view mismatch: have {Sequence: %d, Round: %d, TargetRound: %d}, want {Sequence: 1, Round: 0, TargetRound: 1}", info.Sequence, info.Round, info.TargetRound)
+	}
+	if info.Timestamp.IsZero() {
+		t.Errorf("timestamp should be set")
+	}
+
+	// no COMMIT quorum received in round 1
+	c.state = StatePrepared
+	c.handleTimeoutMsg()
+	info = c.LastRoundChange()
+	if info.Reason != roundChangeReasonCommitTimeout {
+		t.Errorf("reason mismatch: have %q, want %q", info.Reason, roundChangeReasonCommitTimeout)
+	}
+	if info.Round != 1 || info.TargetRound != 2 {
+		t.Errorf("view mismatch: have {Round: %d, TargetRound: %d}, want {Round: 1, TargetRound: 2}", info.Round, info.TargetRound)
+	}
+
+	// a timeout once committed waits for no message in particular
+	c.state = StateCommitted
+	c.handleTimeoutMsg()
+	if info = c.LastRoundChange(); info.Reason != roundChangeReasonTimeout {
+		t.Errorf("reason mismatch: have %q, want %q", info.Reason, roundChangeReasonTimeout)
+	}
+}
+
+func newTestRoundChange(round int64, src common.Address) *qbfttypes.RoundChange {
+	return signedBy(qbfttypes.NewRoundChange(big.NewInt(1), big.NewInt(round), nil, nil), src).(*qbfttypes.RoundChange)
+}
+
+func hasBroadcast(backend *testBackend, code uint64) bool {
+	for _, c := range backend.broadcasts {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRoundChangeThresholds(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+
+	// run the core as the proposer of round 3
+	proposers := valSet.Copy()
+	proposers.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(3)})
+	proposer := proposers.GetProposer().Address()
+	var others []common.Address
+	for _, v := range valSet.List() {
+		if v.Address() != proposer {
+			others = append(others, v.Address())
+		}
+	}
+
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	backend := c.backend.(*testBackend)
+	backend.address = proposer
+	c.address = proposer
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	c.current.pendingRequest = &Request{Proposal: makeBlock(1)}
+	defer c.stopTimer()
+
+	// a single validator can not make the node change round
+	if err := c.handleRoundChange(newTestRoundChange(3, others[0])); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if round := c.current.Round().Int64(); round != 0 {
+		t.Fatalf("round mismatch: have %d, want 0", round)
+	}
+
+	// F+1 validators at round 3 or higher, the node catches up to round 3
+	if err := c.handleRoundChange(newTestRoundChange(5, others[1])); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if round := c.current.Round().Int64(); round != 3 {
+		t.Fatalf("round mismatch: have %d, want 3", round)
+	}
+	if !hasBroadcast(backend, qbfttypes.RoundChangeCode) {
+		t.Errorf("ROUND-CHANGE should be broadcast when catching up")
+	}
+
+	// the new round does not start below quorum
+	if err := c.handleRoundChange(newTestRoundChange(3, others[2])); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if hasBroadcast(backend, qbfttypes.PreprepareCode) {
+		t.Fatalf("PRE-PREPARE should not be broadcast below quorum of ROUND-CHANGE messages")
+	}
+
+	// the new round starts with a quorum of ROUND-CHANGE messages
+	if err := c.handleRoundChange(newTestRoundChange(3, others[1])); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if round := c.current.Round().Int64(); round != 3 {
+		t.Errorf("round mismatch: have %d, want 3", round)
+	}
+	if !hasBroadcast(backend, qbfttypes.PreprepareCode) {
+		t.Errorf("PRE-PREPARE should be broadcast with a quorum of ROUND-CHANGE messages")
+	}
+}
+
+func TestStaleRoundChangesDropped(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	c.startNewRound(big.NewInt(10))
+	src := valSet.GetByIndex(1).Address()
+
+	testCases := []struct {
+		round int64
+		err   error
+	}{
+		{0, errOldMessage},
+		{5, errOldMessage},
+		{9, errOldMessage},
+		{10, nil},
+		{11, nil},
+	}
+	for _, test := range testCases {
+		if err := c.handleDecodedMessage(newTestRoundChange(test.round, src)); err != test.err {
+			t.Errorf("round %d: error mismatch: have %v, want %v", test.round, err, test.err)
+		}
+		stored := c.roundChangeSet.roundChanges[uint64(test.round)] != nil && c.roundChangeSet.roundChanges[uint64(test.round)].Get(src) != nil
+		if stored != (test.err == nil) {
+			t.Errorf("round %d: stored mismatch: have %v, want %v", test.round, stored, test.err == nil)
+		}
+	}
+}
+
+// newPreparedRoundChange creates a ROUND-CHANGE message for sequence 1 from src, claiming block got
+// prepared at preparedRound with the PREPARE messages of the given sources as certificate
+func newPreparedRoundChange(round int64, src common.Address, preparedRound int64, block *types.Block, prepareSources ...common.Address) *qbfttypes.RoundChange {
+	roundChange := qbfttypes.NewRoundChange(big.NewInt(1), big.NewInt(round), big.NewInt(preparedRound), block)
+	for _, prepareSrc := range prepareSources {
+		prepare := qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(preparedRound), block.Hash())
+		roundChange.Justification = append(roundChange.Justification, signedBy(prepare, prepareSrc).(*qbfttypes.Prepare))
+	}
+	return signedBy(roundChange, src).(*qbfttypes.RoundChange)
+}
+
+func TestHaltedProposerDoesNotPropose(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+
+	// run the core as the proposer of round 1, the last round allowed
+	proposers := valSet.Copy()
+	proposers.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(1)})
+	proposer := proposers.GetProposer().Address()
+	config := *istanbul.DefaultConfig
+	config.MaxRound = 1
+	c := newTestCore(&config, valSet)
+	c.backend.(*testBackend).address = proposer
+	c.address = proposer
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	defer c.stopTimer()
+	defer c.stopPreprepareRebroadcast()
+	c.startNewRound(big.NewInt(1))
+	c.current.pendingRequest = &Request{Proposal: makeBlock(1)}
+
+	// the round times out, consensus halts for the height
+	if !c.haltOnMaxRound(big.NewInt(2)) {
+		t.Fatal("consensus should be halted after exceeding the maximum round")
+	}
+
+	// a quorum of ROUND-CHANGE messages for the current round does not make it propose
+	for _, v := range valSet.List() {
+		if v.Address() == proposer {
+			continue
+		}
+		if err := c.handleRoundChange(newTestRoundChange(1, v.Address())); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+	}
+	if hasBroadcast(c.backend.(*testBackend), qbfttypes.PreprepareCode) {
+		t.Error("PRE-PREPARE should not be broadcast once halted")
+	}
+}
+
+func TestProposerReproposesPreparedBlock(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+
+	// run the core as the proposer of round 1
+	proposers := valSet.Copy()
+	proposers.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(1)})
+	proposer := proposers.GetProposer().Address()
+	var others []common.Address
+	for _, v := range valSet.List() {
+		if v.Address() != proposer {
+			others = append(others, v.Address())
+		}
+	}
+
+	config := *istanbul.DefaultConfig
+	config.StrictPreparedCertificates = true
+	c := newTestCore(&config, valSet)
+	c.backend.(*testBackend).address = proposer
+	c.address = proposer
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	c.current.pendingRequest = &Request{Proposal: makeBlock(1)}
+	defer c.stopTimer()
+	defer c.stopPreprepareRebroadcast()
+
+	// a validator got the block prepared at round 0, the others did not
+	prepared := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), GasLimit: 1})
+	roundChanges := []*qbfttypes.RoundChange{
+		newTestRoundChange(1, others[0]),
+		newPreparedRoundChange(1, others[1], 0, prepared, others...),
+		newTestRoundChange(1, others[2]),
+	}
+	for _, roundChange := range roundChanges {
+		if err := c.handleRoundChange(roundChange); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+	}
+
+	// the prepared block is proposed again rather than the pending request
+	backend := c.backend.(*testBackend)
+	var payload []byte
+	for i, code := range backend.broadcasts {
+		if code == qbfttypes.PreprepareCode {
+			payload = backend.payloads[i]
+		}
+	}
+	if payload == nil {
+		t.Fatalf("PRE-PREPARE should be broadcast with a quorum of ROUND-CHANGE messages")
+	}
+	msg, err := qbfttypes.Decode(qbfttypes.PreprepareCode, payload)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	preprepare := msg.(*qbfttypes.Preprepare)
+	if preprepare.Round.Uint64() != 1 || preprepare.Proposal.Hash() != prepared.Hash() {
+		t.Errorf("PRE-PREPARE mismatch: have round %v block %v, want round 1 block %v", preprepare.Round, preprepare.Proposal.Hash(), prepared.Hash())
+	}
+}
+
+func TestInvalidPreparedCertificates(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	src := valSet.GetByIndex(1).Address()
+	validators := []common.Address{valSet.GetByIndex(1).Address(), valSet.GetByIndex(2).Address(), valSet.GetByIndex(3).Address()}
+	block := makeBlock(1)
+
+	unknown := newPreparedRoundChange(2, src, 1, block, validators...)
+	unknown.Justification[0].SetSource(common.HexToAddress("0xdead"))
+	mismatch := newPreparedRoundChange(2, src, 1, block, validators...)
+	mismatch.Justification[0].Digest = common.HexToHash("0x1")
+	noBlock := newPreparedRoundChange(2, src, 1, block, validators...)
+	noBlock.PreparedBlock = nil
+
+	testCases := []struct {
+		name        string
+		roundChange *qbfttypes.RoundChange
+		valid       bool
+	}{
+		{"no prepared block", newTestRoundChange(2, src), true},
+		{"valid certificate", newPreparedRoundChange(2, src, 1, block, validators...), true},
+		{"below quorum", newPreparedRoundChange(2, src, 1, block, validators[:2]...), false},
+		{"duplicate source", newPreparedRoundChange(2, src, 1, block, validators[0], validators[1], validators[1]), false},
+		{"unknown source", unknown, false},
+		{"digest mismatch", mismatch, false},
+		{"missing block", noBlock, false},
+		{"prepared round not lower", newPreparedRoundChange(2, src, 2, block, validators...), false},
+	}
+	for _, strict := range []bool{false, true} {
+		config := *istanbul.DefaultConfig
+		config.StrictPreparedCertificates = strict
+		for _, test := range testCases {
+			c := newTestCore(&config, valSet)
+			c.roundChangeSet = newRoundChangeSet(valSet)
+
+			err := c.handleRoundChange(test.roundChange)
+			if rejected := !test.valid && strict; rejected != errors.Is(err, errInvalidPreparedCertificate) {
+				t.Errorf("%s, strict %v: error mismatch: have %v, want rejected %v", test.name, strict, err, rejected)
+			}
+			if prepared := c.roundChangeSet.highestPreparedBlock[2]; strict && !test.valid && prepared != nil {
+				t.Errorf("%s: invalid certificate used", test.name)
+			}
+			c.stopTimer()
+		}
+	}
+}
+
+func TestRoundChangeReport(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+
+	validators := valSet.List()
+	seed := map[int64][]common.Address{
+		1: {validators[1].Address(), validators[2].Address()},
+		3: {validators[2].Address(), validators[3].Address()},
+	}
+	for round, senders := range seed {
+		for _, src := range senders {
+			if err := c.roundChangeSet.Add(big.NewInt(round), newTestRoundChange(round, src), nil, nil, nil, c.QuorumSize()); err != nil {
+				t.Fatalf("seed round %d: %v", round, err)
+			}
+		}
+	}
+
+	report := c.RoundChanges()
+	if report.Sequence != 1 || report.Round != 0 || report.FPlusOne != 2 || report.Quorum != 3 {
+		t.Fatalf("report mismatch: have %+v, want sequence 1, round 0, F+1 2, quorum 3", report)
+	}
+	want := []struct {
+		round     uint64
+		atOrAbove int
+	}{{1, 3}, {3, 2}}
+	if len(report.Rounds) != len(want) {
+		t.Fatalf("rounds mismatch: have %d, want %d", len(report.Rounds), len(want))
+	}
+	for i, group := range report.Rounds {
+		if group.Round != want[i].round || group.Count != 2 || group.AtOrAbove != want[i].atOrAbove {
+			t.Errorf("group %d mismatch: have round %d, count %d, at or above %d, want round %d, count 2, at or above %d",
+				i, group.Round, group.Count, group.AtOrAbove, want[i].round, want[i].atOrAbove)
+		}
+		for _, src := range seed[int64(group.Round)] {
+			if !containsAddress(group.Senders, src) {
+				t.Errorf("group %d: sender %x missing from %v", i, src, group.Senders)
+			}
+		}
+	}
+}
+
+func TestRoundChangeReportServedByEventLoop(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(istanbul.DefaultConfig, valSet)
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	if err := c.Start(); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	defer c.Stop()
+
+	// the ROUND-CHANGE messages handled by the event loop do not race with the reads of the RPC API
+	go func() {
+		for i := 1; i < 3; i++ {
+			c.backend.EventMux().Post(backlogEvent{msg: newTestRoundChange(1, valSet.GetByIndex(uint64(i)).Address())})
+		}
+	}()
+	deadline := time.Now().Add(time.Second)
+	for {
+		report := c.RoundChanges()
+		if report == nil {
+			t.Fatalf("report mismatch: have nil, want a report")
+		}
+		if report.Round == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("round mismatch: have %v, want 1", report.Round)
+		}
+	}
+}
+
+func TestRoundChangeTieBreak(t *testing.T) {
+	// validators 0 to 2 are at round 2 and validators 3 and 4 at round 4, the others are offline: neither
+	// round reaches a quorum and the validators ahead are not F+1 for the ones behind to catch up
+	valSet := newTestValidatorSet(7)
+	validators := valSet.List()
+	split := func(c *core, rounds map[int64][]int) {
+		for round, indexes := range rounds {
+			for _, i := range indexes {
+				if err := c.handleRoundChange(newTestRoundChange(round, validators[i].Address())); err != nil {
+					t.Fatalf("ROUND-CHANGE for round %d: %v", round, err)
+				}
+			}
+		}
+	}
+	newSplitCore := func(tieBreakRound uint64, round int64) *core {
+		config := *istanbul.DefaultConfig
+		config.RoundChangeTieBreakRound = tieBreakRound
+		c := newTestCore(&config, valSet)
+		c.roundChangeSet = newRoundChangeSet(valSet)
+		c.startNewRound(big.NewInt(round))
+		split(c, map[int64][]int{2: {1, 2}, 4: {3, 4}})
+		return c
+	}
+
+	// without tie-break, a validator behind moves to the next round
+	c := newSplitCore(0, 2)
+	defer c.stopTimer()
+	c.handleTimeoutMsg()
+	if round := c.current.Round().Int64(); round != 3 {
+		t.Errorf("round without tie-break mismatch: have %d, want 3", round)
+	}
+
+	// with tie-break, a validator behind joined by others at its round waits once for the validators behind,
+	// then moves to the next round
+	c = newSplitCore(1, 2)
+	defer c.stopTimer()
+	for _, want := range []int64{2, 3} {
+		c.handleTimeoutMsg()
+		if round := c.current.Round().Int64(); round != want {
+			t.Errorf("round of a validator behind fewer than F+1 mismatch: have %d, want %d", round, want)
+		}
+	}
+
+	// F+1 validators ahead make it catch up as their ROUND-CHANGE messages are received, before any timeout
+	c = newSplitCore(1, 2)
+	defer c.stopTimer()
+	split(c, map[int64][]int{4: {5}})
+	if round := c.current.Round().Int64(); round != 4 {
+		t.Errorf("round of a validator behind F+1 mismatch: have %d, want 4", round)
+	}
+
+	// a single faulty validator sending a ROUND-CHANGE message for a far round does not move it
+	config := *istanbul.DefaultConfig
+	config.RoundChangeTieBreakRound = 1
+	c = newTestCore(&config, valSet)
+	defer c.stopTimer()
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	c.startNewRound(big.NewInt(2))
+	split(c, map[int64][]int{2: {1, 2}, 100: {5}})
+	for _, want := range []int64{2, 3} {
+		c.handleTimeoutMsg()
+		if round := c.current.Round().Int64(); round != want {
+			t.Errorf("round after a far ROUND-CHANGE mismatch: have %d, want %d", round, want)
+		}
+	}
+
+	// a validator ahead waits once more for the ones behind, then moves on if they did not catch up
+	c = newSplitCore(1, 4)
+	defer c.stopTimer()
+	backend := c.backend.(*testBackend)
+	backend.broadcasts, backend.payloads = nil, nil
+	c.handleTimeoutMsg()
+	if round := c.current.Round().Int64(); round != 4 {
+		t.Fatalf("round of a validator ahead mismatch: have %d, want 4", round)
+	}
+	if !hasBroadcast(backend, qbfttypes.RoundChangeCode) {
+		t.Errorf("ROUND-CHANGE should be sent again while waiting")
+	}
+	c.handleTimeoutMsg()
+	if round := c.current.Round().Int64(); round != 5 {
+		t.Errorf("round after the extended timeout mismatch: have %d, want 5", round)
+	}
+}
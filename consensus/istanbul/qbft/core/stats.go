@@ -0,0 +1,101 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// consensusStatsRing keeps the consensus statistics of the last committed blocks, the oldest ones
+// are overwritten once the ring is full
+type consensusStatsRing struct {
+	mu    sync.Mutex
+	stats []*istanbul.ConsensusStats
+	next  int
+	full  bool
+}
+
+// newConsensusStatsRing creates a ring of the given size, it returns nil if size is 0
+func newConsensusStatsRing(size uint64) *consensusStatsRing {
+	if size == 0 {
+		return nil
+	}
+	return &consensusStatsRing{stats: make([]*istanbul.ConsensusStats, size)}
+}
+
+func (r *consensusStatsRing) add(stats *istanbul.ConsensusStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats[r.next] = stats
+	r.next = (r.next + 1) % len(r.stats)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the statistics in the ring, from the oldest to the most recent block
+func (r *consensusStatsRing) list() []*istanbul.ConsensusStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]*istanbul.ConsensusStats(nil), r.stats[:r.next]...)
+	}
+	return append(append([]*istanbul.ConsensusStats(nil), r.stats[r.next:]...), r.stats[:r.next]...)
+}
+
+// recordConsensusStats records how consensus was reached on the proposal of the current view once it is committed
+func (c *core) recordConsensusStats() {
+	c.addConsensusStats(c.current.Proposal(), c.current.Round(), c.current.QBFTCommits, "")
+}
+
+// addConsensusStats records how consensus was reached on proposal, committed at round by commits. dissent is
+// the reason this node rejected the proposal, if it did.
+func (c *core) addConsensusStats(proposal istanbul.Proposal, round *big.Int, commits *qbftMsgSet, dissent string) {
+	if c.recentStats == nil {
+		return
+	}
+	msgs := commits.Values()
+	committers := make([]common.Address, len(msgs))
+	for i, m := range msgs {
+		committers[i] = m.Source()
+	}
+	now := time.Now()
+	c.recentStats.add(&istanbul.ConsensusStats{
+		Sequence:           c.current.Sequence().Uint64(),
+		Hash:               proposal.Hash(),
+		Round:              round.Uint64(),
+		Duration:           now.Sub(c.sequenceStartTime),
+		Committers:         committers,
+		RoundChangeReasons: append([]string(nil), c.roundChangeReasons...),
+		Dissent:            dissent,
+		Timestamp:          now,
+	})
+}
+
+// RecentConsensusStats returns the consensus statistics of the last committed blocks, from the oldest
+// to the most recent one, nil if they are not kept
+func (c *core) RecentConsensusStats() []*istanbul.ConsensusStats {
+	if c.recentStats == nil {
+		return nil
+	}
+	return c.recentStats.list()
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+)
+
+func TestRecentConsensusStats(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.ConsensusStatsWindow = 2
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+
+	// commit sequences 1 to 3, sequence 2 after a round change
+	for sequence := int64(1); sequence <= 3; sequence++ {
+		round := int64(0)
+		c.roundChangeReasons = nil
+		if sequence == 2 {
+			c.recordRoundChange(roundChangeReasonPreprepareTimeout, big.NewInt(1))
+			round = 1
+		}
+		view := &istanbul.View{Sequence: big.NewInt(sequence), Round: big.NewInt(round)}
+		c.current = newRoundState(view, valSet, nil, nil, nil, nil, func(hash common.Hash) bool { return false })
+		proposal := makeBlock(sequence)
+		c.current.SetPreprepare(qbfttypes.NewPreprepare(view.Sequence, view.Round, proposal))
+		for i := 0; i < 3; i++ {
+			commit := qbfttypes.NewCommit(view.Sequence, view.Round, proposal.Hash(), nil)
+			if err := c.current.QBFTCommits.Add(signedBy(commit, valSet.GetByIndex(uint64(i)).Address())); err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+		}
+		c.recordConsensusStats()
+	}
+
+	stats := c.RecentConsensusStats()
+	if len(stats) != 2 {
+		t.Fatalf("stats size mismatch: have %d, want 2", len(stats))
+	}
+	if s := stats[0]; s.Sequence != 2 || s.Round != 1 || len(s.RoundChangeReasons) != 1 || s.RoundChangeReasons[0] != roundChangeReasonPreprepareTimeout {
+		t.Errorf("stats mismatch: have sequence %d round %d reasons %v, want sequence 2 round 1 after a PRE-PREPARE timeout", s.Sequence, s.Round, s.RoundChangeReasons)
+	}
+	if s := stats[1]; s.Sequence != 3 || s.Round != 0 || len(s.RoundChangeReasons) != 0 || len(s.Committers) != 3 {
+		t.Errorf("stats mismatch: have sequence %d round %d reasons %v committers %v, want sequence 3 round 0 with 3 committers", s.Sequence, s.Round, s.RoundChangeReasons, s.Committers)
+	}
+
+	// nothing is kept when disabled
+	if stats := newTestCore(istanbul.DefaultConfig, valSet).RecentConsensusStats(); stats != nil {
+		t.Errorf("stats mismatch: have %v, want nil", stats)
+	}
+}
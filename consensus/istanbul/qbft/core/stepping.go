@@ -0,0 +1,141 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// Single-step debugging commands
+const (
+	stepPause  = "pause"  // queue the input events instead of handling them
+	stepNext   = "step"   // handle the first queued event
+	stepResume = "resume" // handle the queued events then the next ones as they arrive
+)
+
+// steppingBuildTag is the build tag of the only builds honouring the StepDebugging option
+const steppingBuildTag = "qbftdebug"
+
+// stepQueueLimit bounds the events queued while paused: once reached, the queued events are handled and the
+// event loop resumes, so that a forgotten pause does not grow the queue without limit
+const stepQueueLimit = 4096
+
+// stepCommandTimeout bounds the wait for the event loop to serve a single-step debugging command
+const stepCommandTimeout = 5 * time.Second
+
+// stepCommand is a single-step debugging command served by the event loop
+type stepCommand struct {
+	kind  string
+	reply chan *istanbul.StepResult
+}
+
+// enableStepping lets the single-step debugging commands reach the event loop
+func (c *core) enableStepping() {
+	c.stepCommands = make(chan *stepCommand)
+}
+
+// dispatchEvent handles an input event of the main handler loop, or queues it while paused
+func (c *core) dispatchEvent(event interface{}) {
+	if !c.paused {
+		c.handleEvent(event)
+		return
+	}
+	c.stepQueue = append(c.stepQueue, event)
+	if len(c.stepQueue) >= stepQueueLimit {
+		c.logger.Warn("QBFT: single-step queue full, resume the event loop", "queued", len(c.stepQueue))
+		c.resumeEvents()
+	}
+}
+
+// resumeEvents handles the events queued while paused, in order, and leaves the paused state
+func (c *core) resumeEvents() {
+	c.paused = false
+	queue := c.stepQueue
+	c.stepQueue = nil
+	for _, event := range queue {
+		c.handleEvent(event)
+	}
+}
+
+// handleStepCommand serves a single-step debugging command in the event loop, it returns nil if stepping
+// through the events while not paused
+func (c *core) handleStepCommand(kind string) *istanbul.StepResult {
+	result := new(istanbul.StepResult)
+	switch kind {
+	case stepPause:
+		c.paused = true
+	case stepNext:
+		if !c.paused {
+			return nil
+		}
+		if len(c.stepQueue) > 0 {
+			event := c.stepQueue[0]
+			c.stepQueue[0] = nil
+			c.stepQueue = c.stepQueue[1:]
+			result.Event = fmt.Sprintf("%T", event)
+			c.handleEvent(event)
+		}
+	case stepResume:
+		c.resumeEvents()
+	}
+
+	result.Paused = c.paused
+	result.Queued = len(c.stepQueue)
+	result.State = c.state.String()
+	if view := c.currentView(); view != nil {
+		result.Sequence = view.Sequence.Uint64()
+		result.Round = view.Round.Uint64()
+	}
+	return result
+}
+
+// sendStepCommand has the event loop serve a single-step debugging command and returns the resulting state
+func (c *core) sendStepCommand(kind string) (*istanbul.StepResult, error) {
+	if c.stepCommands == nil {
+		return nil, errSteppingDisabled
+	}
+	cmd := &stepCommand{kind: kind, reply: make(chan *istanbul.StepResult, 1)}
+	select {
+	case c.stepCommands <- cmd:
+	case <-time.After(stepCommandTimeout):
+		return nil, fmt.Errorf("consensus event loop did not accept the %s command", kind)
+	}
+	result := <-cmd.reply
+	if result == nil {
+		return nil, errNotPaused
+	}
+	return result, nil
+}
+
+// PauseEvents stops handling the input events of the consensus, queuing them to be stepped through one at a
+// time. It fails unless single-step debugging is enabled.
+func (c *core) PauseEvents() (*istanbul.StepResult, error) {
+	return c.sendStepCommand(stepPause)
+}
+
+// StepEvent handles the first event queued while paused and returns the resulting consensus state
+func (c *core) StepEvent() (*istanbul.StepResult, error) {
+	return c.sendStepCommand(stepNext)
+}
+
+// ResumeEvents handles the events queued while paused, in order, and resumes the normal event handling
+func (c *core) ResumeEvents() (*istanbul.StepResult, error) {
+	return c.sendStepCommand(stepResume)
+}
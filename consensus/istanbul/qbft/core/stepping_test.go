@@ -0,0 +1,122 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestSingleStepRound(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+
+	// stepping can not be enabled by the configuration alone on a production build
+	config := *istanbul.DefaultConfig
+	config.StepDebugging = true
+	c := newTestCore(&config, valSet)
+	defer c.stopTimer()
+	if !steppingBuild {
+		if _, err := c.PauseEvents(); err != errSteppingDisabled {
+			t.Fatalf("error mismatch: have %v, want %v", err, errSteppingDisabled)
+		}
+		c.enableStepping()
+	}
+	c.valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+	c.roundChangeSet = newRoundChangeSet(c.valSet)
+
+	message := func(m qbfttypes.QBFTMessage, src common.Address) istanbul.MessageEvent {
+		payload, err := rlp.EncodeToBytes(signedBy(m, src))
+		if err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		return istanbul.MessageEvent{Code: m.Code(), Payload: payload, Local: src == c.Address()}
+	}
+
+	// the commands are served by the event loop, which is driven by hand here
+	if result := c.handleStepCommand(stepNext); result != nil {
+		t.Errorf("step while running: have %+v, want nil", result)
+	}
+	if result := c.handleStepCommand(stepPause); !result.Paused || result.Queued != 0 {
+		t.Fatalf("pause mismatch: have %+v", result)
+	}
+
+	sequence, round := big.NewInt(1), big.NewInt(0)
+	proposal := makeBlock(1)
+	events := []interface{}{message(qbfttypes.NewPreprepare(sequence, round, proposal), c.valSet.GetProposer().Address())}
+	for _, v := range valSet.List() {
+		events = append(events, message(qbfttypes.NewPrepare(sequence, round, proposal.Hash()), v.Address()))
+	}
+	for _, event := range events {
+		c.dispatchEvent(event)
+	}
+	if c.state != StateAcceptRequest || len(c.stepQueue) != len(events) {
+		t.Fatalf("events handled while paused: state %v, queued %d", c.state, len(c.stepQueue))
+	}
+
+	// the block is preprepared by the first step, prepared once the quorum of PREPARE messages is stepped through
+	want := []State{StatePreprepared, StatePreprepared, StatePreprepared, StatePrepared, StatePrepared}
+	for i, state := range want {
+		result := c.handleStepCommand(stepNext)
+		if result == nil {
+			t.Fatalf("step %d: not paused", i)
+		}
+		if result.State != state.String() || result.Queued != len(events)-i-1 || result.Event != "istanbul.MessageEvent" {
+			t.Errorf("step %d mismatch: have %+v, want state %v with %d queued", i, result, state, len(events)-i-1)
+		}
+		if result.Sequence != 1 || result.Round != 0 || !result.Paused {
+			t.Errorf("step %d view mismatch: have %+v", i, result)
+		}
+	}
+	if result := c.handleStepCommand(stepNext); result.Event != "" || result.Queued != 0 {
+		t.Errorf("step without events mismatch: have %+v", result)
+	}
+
+	// events arriving once resumed are handled at once
+	c.dispatchEvent(timeoutEvent{})
+	if result := c.handleStepCommand(stepResume); result.Paused || result.Queued != 0 || result.Round != 1 {
+		t.Errorf("resume mismatch: have %+v", result)
+	}
+	c.dispatchEvent(timeoutEvent{})
+	if c.current.Round().Uint64() != 2 {
+		t.Errorf("round mismatch: have %v, want 2", c.current.Round())
+	}
+}
+
+func TestSingleStepQueueLimit(t *testing.T) {
+	c := newTestCore(istanbul.DefaultConfig, newTestValidatorSet(4))
+	defer c.stopTimer()
+	c.enableStepping()
+	c.handleStepCommand(stepPause)
+
+	event := istanbul.MessageEvent{Code: qbfttypes.PrepareCode, Payload: []byte{0x01}}
+	for i := 0; i < stepQueueLimit-1; i++ {
+		c.dispatchEvent(event)
+	}
+	if !c.paused || len(c.stepQueue) != stepQueueLimit-1 {
+		t.Fatalf("queue below the limit mismatch: paused %v, queued %d", c.paused, len(c.stepQueue))
+	}
+	// the event reaching the limit resumes the event loop
+	c.dispatchEvent(event)
+	if c.paused || len(c.stepQueue) != 0 {
+		t.Errorf("queue at the limit mismatch: paused %v, queued %d", c.paused, len(c.stepQueue))
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// timedMutex is a mutex reporting how long it is held per acquisition, to quantify the contention on it
+type timedMutex struct {
+	mu       sync.Mutex
+	acquired time.Time
+
+	// observe receives the hold time of each acquisition, once released
+	observe func(time.Duration)
+}
+
+// newTimedMutex creates a mutex whose hold times are recorded by timer
+func newTimedMutex(timer metrics.Timer) *timedMutex {
+	return &timedMutex{observe: timer.Update}
+}
+
+// Lock locks the mutex and starts timing the hold
+func (m *timedMutex) Lock() {
+	m.mu.Lock()
+	m.acquired = time.Now()
+}
+
+// Unlock reports the hold time then unlocks the mutex
+func (m *timedMutex) Unlock() {
+	m.observe(time.Since(m.acquired))
+	m.mu.Unlock()
+}
@@ -0,0 +1,127 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// blockParticipation is the validator set of a committed block and the validators which sent PREPARE or
+// COMMIT messages for it
+type blockParticipation struct {
+	validators   []common.Address
+	participants map[common.Address]struct{}
+}
+
+// participationRing keeps the participation of the validators in the last committed blocks, the oldest
+// blocks are overwritten once the ring is full
+type participationRing struct {
+	mu     sync.Mutex
+	blocks []*blockParticipation
+	next   int
+	full   bool
+}
+
+// newParticipationRing creates a ring of the given size, it returns nil if size is 0
+func newParticipationRing(size uint64) *participationRing {
+	if size == 0 {
+		return nil
+	}
+	return &participationRing{blocks: make([]*blockParticipation, size)}
+}
+
+func (r *participationRing) add(block *blockParticipation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blocks[r.next] = block
+	r.next = (r.next + 1) % len(r.blocks)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// uptime returns the share of the blocks of the ring each validator took part in, among the blocks it was
+// a validator for, sorted by address
+func (r *participationRing) uptime() []*istanbul.ValidatorUptime {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	blocks := r.blocks[:r.next]
+	if r.full {
+		blocks = r.blocks
+	}
+	uptimes := make(map[common.Address]*istanbul.ValidatorUptime)
+	for _, block := range blocks {
+		for _, addr := range block.validators {
+			uptime, ok := uptimes[addr]
+			if !ok {
+				uptime = &istanbul.ValidatorUptime{Address: addr}
+				uptimes[addr] = uptime
+			}
+			uptime.Blocks++
+			if _, ok := block.participants[addr]; ok {
+				uptime.Participated++
+			}
+		}
+	}
+	result := make([]*istanbul.ValidatorUptime, 0, len(uptimes))
+	for _, uptime := range uptimes {
+		uptime.Uptime = 100 * float64(uptime.Participated) / float64(uptime.Blocks)
+		result = append(result, uptime)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return bytes.Compare(result[i].Address.Bytes(), result[j].Address.Bytes()) < 0
+	})
+	return result
+}
+
+// recordParticipation records the validators which sent PREPARE or COMMIT messages for the proposal of the
+// current view once it is committed. Messages received after the commit are not accounted for.
+func (c *core) recordParticipation() {
+	if c.participation == nil {
+		return
+	}
+	validators := c.valSet.List()
+	block := &blockParticipation{
+		validators:   make([]common.Address, len(validators)),
+		participants: make(map[common.Address]struct{}),
+	}
+	for i, v := range validators {
+		block.validators[i] = v.Address()
+	}
+	for _, m := range c.current.QBFTPrepares.Values() {
+		block.participants[m.Source()] = struct{}{}
+	}
+	for _, m := range c.current.QBFTCommits.Values() {
+		block.participants[m.Source()] = struct{}{}
+	}
+	c.participation.add(block)
+}
+
+// ValidatorUptime returns the share of the last committed blocks each validator took part in, nil if
+// it is not tracked
+func (c *core) ValidatorUptime() []*istanbul.ValidatorUptime {
+	if c.participation == nil {
+		return nil
+	}
+	return c.participation.uptime()
+}
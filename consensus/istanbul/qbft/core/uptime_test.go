@@ -0,0 +1,79 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+)
+
+func TestValidatorUptime(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.UptimeWindow = 4
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+
+	if uptime := c.ValidatorUptime(); len(uptime) != 0 {
+		t.Fatalf("uptime before any block mismatch: have %v, want none", uptime)
+	}
+
+	// validators 0 and 1 take part in every block, 1 with PREPARE messages only, validator 2 misses every
+	// other block and validator 3 is offline
+	for sequence := int64(1); sequence <= 6; sequence++ {
+		view := &istanbul.View{Sequence: big.NewInt(sequence), Round: big.NewInt(0)}
+		c.current = newRoundState(view, valSet, nil, nil, nil, nil, func(hash common.Hash) bool { return false })
+		proposal := makeBlock(sequence)
+		participants := []uint64{0, 1}
+		if sequence%2 == 0 {
+			participants = append(participants, 2)
+		}
+		for _, i := range participants {
+			src := valSet.GetByIndex(i).Address()
+			if err := c.current.QBFTPrepares.Add(signedBy(qbfttypes.NewPrepare(view.Sequence, view.Round, proposal.Hash()), src)); err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+			if i == 1 {
+				continue
+			}
+			if err := c.current.QBFTCommits.Add(signedBy(qbfttypes.NewCommit(view.Sequence, view.Round, proposal.Hash(), nil), src)); err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+		}
+		c.recordParticipation()
+	}
+
+	// only the last 4 blocks are accounted for
+	want := map[common.Address]float64{
+		valSet.GetByIndex(0).Address(): 100,
+		valSet.GetByIndex(1).Address(): 100,
+		valSet.GetByIndex(2).Address(): 50,
+		valSet.GetByIndex(3).Address(): 0,
+	}
+	uptime := c.ValidatorUptime()
+	if len(uptime) != len(want) {
+		t.Fatalf("uptime size mismatch: have %d, want %d", len(uptime), len(want))
+	}
+	for _, u := range uptime {
+		if u.Blocks != 4 || u.Uptime != want[u.Address] || u.Participated != uint64(want[u.Address])*4/100 {
+			t.Errorf("uptime of %v mismatch: have %d of %d blocks (%v%%), want %v%% of 4 blocks", u.Address, u.Participated, u.Blocks, u.Uptime, want[u.Address])
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// walRequestCode is the code of the write-ahead log entries recording block proposal requests, no
+// message has it
+const walRequestCode = 0
+
+// walRequestRefCode is the code of the write-ahead log entries recording a block proposal request for a
+// block already recorded in the view, by hash, no message has it either
+const walRequestRefCode = 1
+
+// appendWAL records m in the write-ahead log before it is delivered to its handler
+func (c *core) appendWAL(m qbfttypes.QBFTMessage) error {
+	if c.wal == nil {
+		return nil
+	}
+	payload, err := rlp.EncodeToBytes(m)
+	if err != nil {
+		return err
+	}
+	if err := c.wal.Append(istanbul.WALEntry{Code: m.Code(), Payload: payload}); err != nil {
+		withMsg(c.currentLogger(true, nil), m).Error("QBFT: failed to write message to the WAL", "err", err)
+		return err
+	}
+	return nil
+}
+
+// appendRequestWAL records the block proposal request in the write-ahead log, if requests are recorded.
+// The block is recorded once per view, the requests resubmitted for it only record its hash.
+func (c *core) appendRequestWAL(request *Request) {
+	if c.wal == nil || !c.config.WALRequests {
+		return
+	}
+	if view := c.currentView(); c.walRequestsView == nil || view == nil || c.walRequestsView.Cmp(view) != 0 {
+		c.walRequests = make(map[common.Hash]struct{})
+		c.walRequestsView = view
+	}
+	hash := request.Proposal.Hash()
+	entry := istanbul.WALEntry{Code: walRequestRefCode, Payload: hash.Bytes()}
+	var err error
+	if _, recorded := c.walRequests[hash]; !recorded {
+		entry.Code = walRequestCode
+		entry.Payload, err = rlp.EncodeToBytes(request.Proposal)
+	}
+	if err == nil {
+		err = c.wal.Append(entry)
+	}
+	if err != nil {
+		c.currentLogger(true, nil).Error("QBFT: failed to write block proposal request to the WAL", "err", err)
+		return
+	}
+	c.walRequests[hash] = struct{}{}
+}
+
+// truncateWAL drops the messages of the previous sequence from the write-ahead log
+func (c *core) truncateWAL() {
+	if c.wal == nil {
+		return
+	}
+	if err := c.wal.Truncate(); err != nil {
+		c.logger.Error("QBFT: failed to truncate the WAL", "err", err)
+	}
+}
+
+// readWAL returns the messages recorded in the write-ahead log
+func (c *core) readWAL() []istanbul.WALEntry {
+	if c.wal == nil {
+		return nil
+	}
+	entries, err := c.wal.Entries()
+	if err != nil {
+		c.logger.Error("QBFT: failed to read the WAL", "err", err)
+		return nil
+	}
+	return entries
+}
+
+// replayWAL handles again the messages read from the write-ahead log, their signatures are verified
+// against the current validator set and the ones of an older sequence are dropped as old messages
+func (c *core) replayWAL(entries []istanbul.WALEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	replayed := 0
+	requests := make(map[common.Hash]*types.Block)
+	for _, entry := range entries {
+		if entry.Code == walRequestCode || entry.Code == walRequestRefCode {
+			// the node is proposing again if it is still the proposer of the view
+			var block *types.Block
+			if entry.Code == walRequestRefCode {
+				hash := common.BytesToHash(entry.Payload)
+				if block = requests[hash]; block == nil {
+					c.logger.Error("QBFT: block proposal request in the WAL refers to an unknown block", "hash", hash)
+					continue
+				}
+			} else {
+				block = new(types.Block)
+				if err := rlp.DecodeBytes(entry.Payload, block); err != nil {
+					c.logger.Error("QBFT: invalid block proposal request in the WAL", "err", err)
+					continue
+				}
+				requests[block.Hash()] = block
+			}
+			if err := c.handleRequest(&Request{Proposal: block}); err != nil {
+				c.logger.Debug("QBFT: WAL block proposal request not replayed", "err", err)
+				continue
+			}
+			replayed++
+			continue
+		}
+		if err := c.handleEncodedMsg(entry.Code, entry.Payload, true); err != nil {
+			c.logger.Debug("QBFT: WAL message not replayed", "code", entry.Code, "err", err)
+			continue
+		}
+		replayed++
+	}
+	c.currentLogger(true, nil).Info("QBFT: replayed WAL", "messages", len(entries), "replayed", replayed)
+}
@@ -0,0 +1,181 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	qbfttypes "github.com/ethereum/go-ethereum/consensus/istanbul/qbft/types"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/wal"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestWALReplay(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.WAL = wal.NewMemoryWAL()
+	valSet := newTestValidatorSet(4)
+	c := newTestCore(&config, valSet)
+	defer c.stopTimer()
+	proposal := makeBlock(1)
+
+	// the proposer of sequence 1 round 0 is the first validator, the core itself
+	preprepare := qbfttypes.NewPreprepare(big.NewInt(1), big.NewInt(0), proposal)
+	if err := c.handleDecodedMessage(signedBy(preprepare, c.Address())); err != nil {
+		t.Fatalf("handle PRE-PREPARE failed: %v", err)
+	}
+	for i := 1; i < 3; i++ {
+		prepare := qbfttypes.NewPrepare(big.NewInt(1), big.NewInt(0), proposal.Hash())
+		if err := c.handleDecodedMessage(signedBy(prepare, valSet.GetByIndex(uint64(i)).Address())); err != nil {
+			t.Fatalf("handle PREPARE failed: %v", err)
+		}
+	}
+	// messages which are not delivered are not recorded
+	c.handleDecodedMessage(newFuturePrepare(3, valSet.GetByIndex(1).Address()))
+	if entries, _ := config.WAL.Entries(); len(entries) != 3 {
+		t.Fatalf("WAL entries mismatch: have %d, want 3", len(entries))
+	}
+
+	// restart: a new core resumes the sequence from the WAL
+	r := newTestCore(&config, valSet)
+	r.current = nil
+	r.roundChangeSet = newRoundChangeSet(valSet)
+	defer r.stopTimer()
+	entries := r.readWAL()
+	r.startNewRound(common.Big0)
+	r.replayWAL(entries)
+
+	if r.state != StatePreprepared {
+		t.Errorf("state mismatch: have %v, want %v", r.state, StatePreprepared)
+	}
+	if have := r.current.Proposal(); have == nil || have.Hash() != proposal.Hash() {
+		t.Errorf("proposal mismatch: have %v, want %v", have, proposal.Hash())
+	}
+	if size := r.current.QBFTPrepares.Size(); size != 2 {
+		t.Errorf("PREPARE count mismatch: have %d, want 2", size)
+	}
+	if entries, _ := config.WAL.Entries(); len(entries) != 3 {
+		t.Errorf("WAL entries mismatch after replay: have %d, want 3", len(entries))
+	}
+
+	// the log is dropped once the sequence is over
+	r.backend.(*testBackend).lastProposal = proposal
+	r.startNewRound(common.Big0)
+	if entries, _ := config.WAL.Entries(); len(entries) != 0 {
+		t.Errorf("WAL entries mismatch after a new sequence: have %d, want 0", len(entries))
+	}
+}
+
+func TestWALRequestReplay(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.WAL = wal.NewMemoryWAL()
+	config.WALRequests = true
+	valSet := newTestValidatorSet(4)
+	proposal := makeBlock(1)
+
+	// the proposer of sequence 1 round 0 crashes right after recording the request, before proposing
+	c := newTestCore(&config, valSet)
+	c.appendRequestWAL(&Request{Proposal: proposal})
+	if entries, _ := config.WAL.Entries(); len(entries) != 1 || entries[0].Code != walRequestCode {
+		t.Fatalf("WAL entries mismatch: have %v, want the request", entries)
+	}
+
+	for _, proposer := range []bool{true, false} {
+		// restart: the request is handled again, the block is proposed if the node is still the proposer
+		r := newTestCore(&config, valSet)
+		r.current = nil
+		r.roundChangeSet = newRoundChangeSet(valSet)
+		if !proposer {
+			r.address = valSet.GetByIndex(1).Address()
+			r.backend.(*testBackend).address = r.address
+		}
+		entries := r.readWAL()
+		r.startNewRound(common.Big0)
+		r.replayWAL(entries)
+		r.stopTimer()
+
+		if have := r.current.pendingRequest; have == nil || have.Proposal.Hash() != proposal.Hash() {
+			t.Errorf("proposer %v: pending request mismatch: have %v, want %v", proposer, have, proposal.Hash())
+		}
+		if hasBroadcast(r.backend.(*testBackend), qbfttypes.PreprepareCode) != proposer {
+			t.Errorf("proposer %v: PRE-PREPARE broadcast mismatch", proposer)
+		}
+		// the request is recorded again for a further restart
+		if entries, _ := config.WAL.Entries(); len(entries) != 1 || entries[0].Code != walRequestCode {
+			t.Errorf("proposer %v: WAL entries mismatch after replay: have %v, want the request", proposer, entries)
+		}
+	}
+}
+
+func TestWALRequestResubmit(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.WAL = wal.NewMemoryWAL()
+	config.WALRequests = true
+	valSet := newTestValidatorSet(4)
+	proposal := makeBlock(1)
+	codes := func() []uint64 {
+		entries, _ := config.WAL.Entries()
+		var codes []uint64
+		for _, entry := range entries {
+			codes = append(codes, entry.Code)
+		}
+		return codes
+	}
+
+	// the block is recorded once for the view, the resubmitted requests refer to it
+	c := newTestCore(&config, valSet)
+	for i := 0; i < 3; i++ {
+		c.appendRequestWAL(&Request{Proposal: proposal})
+	}
+	if have, want := codes(), []uint64{walRequestCode, walRequestRefCode, walRequestRefCode}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("WAL entries mismatch: have %v, want %v", have, want)
+	}
+
+	// the block is recorded again for a new view
+	c.current.SetRound(common.Big1)
+	c.appendRequestWAL(&Request{Proposal: proposal})
+	if have, want := codes(), []uint64{walRequestCode, walRequestRefCode, walRequestRefCode, walRequestCode}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("WAL entries mismatch after a round change: have %v, want %v", have, want)
+	}
+
+	// the references are resolved on replay, the last request is for the block referred to
+	other := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Extra: []byte("other")})
+	config.WAL.Truncate()
+	c = newTestCore(&config, valSet)
+	c.appendRequestWAL(&Request{Proposal: proposal})
+	c.appendRequestWAL(&Request{Proposal: other})
+	c.appendRequestWAL(&Request{Proposal: proposal})
+	if have, want := codes(), []uint64{walRequestCode, walRequestCode, walRequestRefCode}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("WAL entries mismatch: have %v, want %v", have, want)
+	}
+
+	r := newTestCore(&config, valSet)
+	r.current = nil
+	r.roundChangeSet = newRoundChangeSet(valSet)
+	r.address = valSet.GetByIndex(1).Address()
+	r.backend.(*testBackend).address = r.address
+	entries := r.readWAL()
+	r.startNewRound(common.Big0)
+	r.replayWAL(entries)
+	r.stopTimer()
+	if have := r.current.pendingRequest; have == nil || have.Proposal.Hash() != proposal.Hash() {
+		t.Errorf("pending request mismatch: have %v, want %v", have, proposal.Hash())
+	}
+}
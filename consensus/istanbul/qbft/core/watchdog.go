@@ -0,0 +1,99 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// startWatchdog starts a goroutine periodically checking the event loop is still
+// handling events, if enabled in the configuration
+func (c *core) startWatchdog() {
+	timeout := time.Duration(c.config.WatchdogTimeout) * time.Millisecond
+	if timeout == 0 {
+		return
+	}
+	// buffered so that pinging never blocks, the next ping is only sent once the previous one got acknowledged
+	c.watchdogPing = make(chan chan struct{}, 1)
+	c.watchdogQuit = make(chan struct{})
+
+	c.handlerWg.Add(1)
+	go c.watchdog(timeout, c.watchdogPing, c.watchdogQuit)
+}
+
+// stopWatchdog stops the watchdog goroutine, if started
+func (c *core) stopWatchdog() {
+	if c.watchdogQuit != nil {
+		close(c.watchdogQuit)
+		c.watchdogQuit = nil
+	}
+}
+
+// watchdog pings the event loop every timeout and reports it as stalled if it does
+// not acknowledge the ping within timeout
+func (c *core) watchdog(timeout time.Duration, ping chan chan struct{}, quit chan struct{}) {
+	defer c.handlerWg.Done()
+
+	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+
+		ack := make(chan struct{})
+		ping <- ack
+
+		timer := time.NewTimer(timeout)
+		select {
+		case <-ack:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		case <-quit:
+			timer.Stop()
+			return
+		}
+
+		c.reportStall(timeout)
+
+		// report the stall once, then wait for the event loop to recover
+		select {
+		case <-ack:
+			c.logger.Warn("QBFT: event loop recovered")
+		case <-quit:
+			return
+		}
+	}
+}
+
+// reportStall logs the stack of all goroutines to find out where the event loop is stuck
+func (c *core) reportStall(timeout time.Duration) {
+	watchdogStallMeter.Mark(1)
+
+	buf := make([]byte, 1024*1024)
+	buf = buf[:runtime.Stack(buf, true)]
+	c.logger.Error("QBFT: event loop unresponsive", "timeout", timeout, "stack", string(buf))
+	// the view is owned by the stuck event loop
+	c.raiseAlert(istanbul.AlertStall, "event loop unresponsive", nil, "timeout", timeout)
+}
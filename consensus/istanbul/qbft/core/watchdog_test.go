@@ -0,0 +1,77 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestWatchdogReportsStalledEventLoop(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.WatchdogTimeout = 20
+	c := newTestCore(&config, newTestValidatorSet(4))
+	c.roundChangeSet = newRoundChangeSet(c.valSet)
+
+	stalls := make(chan struct{}, 100)
+	recoveries := make(chan struct{}, 100)
+	c.logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		switch r.Msg {
+		case "QBFT: event loop unresponsive":
+			stalls <- struct{}{}
+		case "QBFT: event loop recovered":
+			recoveries <- struct{}{}
+		}
+		return nil
+	}))
+
+	if err := c.Start(); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	defer c.Stop()
+
+	// a responsive event loop is not reported
+	select {
+	case <-stalls:
+		t.Fatalf("responsive event loop reported as stalled")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// wedge the event loop on the round state lock while handling a timeout
+	c.currentMutex.Lock()
+	go c.backend.EventMux().Post(timeoutEvent{})
+
+	select {
+	case <-stalls:
+	case <-time.After(time.Second):
+		c.currentMutex.Unlock()
+		t.Fatalf("stalled event loop not reported")
+	}
+	c.currentMutex.Unlock()
+
+	select {
+	case <-recoveries:
+	case <-time.After(time.Second):
+		t.Fatalf("event loop recovery not reported")
+	}
+	if len(stalls) != 0 {
+		t.Errorf("stall reports mismatch: have %d more, want 0", len(stalls))
+	}
+}
@@ -263,20 +263,34 @@ func (e *Engine) verifyCommittedSeals(chain consensus.ChainHeaderReader, header
 	}
 
 	validatorsCpy := validators.Copy()
+	chainID := e.commitSealChainID(header.Number)
+	proposalSeal := PrepareCommittedSeal(header, extra.Round, chainID)
 
-	// Check whether the committed seals are generated by validators
-	validSeal := 0
-	committers, err := e.Signers(header)
-	if err != nil {
-		return err
+	// Check whether every committed seal is generated by a distinct validator
+	recoverSigner := func(i int) (common.Address, error) {
+		return istanbul.GetSignatureAddressNoHashing(proposalSeal, committedSeal[i])
 	}
-
-	for _, addr := range committers {
-		if validatorsCpy.RemoveValidator(addr) {
-			validSeal++
-			continue
+	if e.cfg != nil && e.cfg.CommittedSealWorkers > 1 && len(committedSeal) > 1 {
+		// recover the signers ahead on several workers, they are still checked in order
+		signers := recoverSealSigners(proposalSeal, committedSeal, e.cfg.CommittedSealWorkers)
+		defer signers.stop()
+		recoverSigner = signers.get
+	}
+	validSeal := 0
+	for i, seal := range committedSeal {
+		addr, err := recoverSigner(i)
+		if err != nil {
+			// reported as by Signers, whether the seal got recovered serially or by the workers
+			return istanbulcommon.ErrInvalidSignature
 		}
-		return istanbulcommon.ErrInvalidCommittedSeals
+		// a recovered signer outside the set, or a duplicate, invalidates the committed seals
+		if !validatorsCpy.RemoveValidator(addr) {
+			if chainID != nil {
+				warnUnboundCommittedSeal(header, extra.Round, seal, validators)
+			}
+			return istanbulcommon.ErrInvalidCommittedSeals
+		}
+		validSeal++
 	}
 
 	// The length of validSeal should be larger than number of faulty node + 1
@@ -287,6 +301,18 @@ func (e *Engine) verifyCommittedSeals(chain consensus.ChainHeaderReader, header
 	return nil
 }
 
+// warnUnboundCommittedSeal reports a rejected committed seal which a validator signed without binding it
+// to this chain, as it may have been replayed from another chain sharing the same validators
+func warnUnboundCommittedSeal(header *types.Header, round uint32, seal []byte, validators istanbul.ValidatorSet) {
+	addr, err := istanbul.GetSignatureAddressNoHashing(PrepareCommittedSeal(header, round, nil), seal)
+	if err != nil {
+		return
+	}
+	if _, v := validators.GetByAddress(addr); v != nil {
+		log.Warn("BFT: committed seal not bound to the chain ID", "number", header.Number, "hash", header.Hash(), "validator", addr)
+	}
+}
+
 // VerifyUncles verifies that the given block's uncles conform to the consensus
 // rules of a given engine.
 func (e *Engine) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
@@ -436,7 +462,7 @@ func (e *Engine) Signers(header *types.Header) ([]common.Address, error) {
 		return []common.Address{}, err
 	}
 	committedSeal := extra.CommittedSeal
-	proposalSeal := PrepareCommittedSeal(header, extra.Round)
+	proposalSeal := PrepareCommittedSeal(header, extra.Round, e.commitSealChainID(header.Number))
 
 	var addrs []common.Address
 	// 1. Get committed seals from current header
@@ -471,10 +497,18 @@ func sigHash(header *types.Header) (hash common.Hash) {
 	return hash
 }
 
-// PrepareCommittedSeal returns a committed seal for the given hash
-func PrepareCommittedSeal(header *types.Header, round uint32) []byte {
+// PrepareCommittedSeal returns a committed seal for the given hash, bound to chainID unless it is nil
+func PrepareCommittedSeal(header *types.Header, round uint32, chainID *big.Int) []byte {
 	h := types.CopyHeader(header)
-	return h.QBFTHashWithRoundNumber(round).Bytes()
+	return istanbul.BindChainID(h.QBFTHashWithRoundNumber(round).Bytes(), chainID)
+}
+
+// commitSealChainID returns the chain ID the committed seals of the given block are bound to
+func (e *Engine) commitSealChainID(number *big.Int) *big.Int {
+	if e.cfg == nil {
+		return nil
+	}
+	return e.cfg.CommitSealChainID(number)
 }
 
 func (e *Engine) WriteVote(header *types.Header, candidate common.Address, authorize bool) error {
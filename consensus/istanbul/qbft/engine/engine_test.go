@@ -2,14 +2,19 @@ package qbftengine
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"fmt"
 	"math/big"
 	"reflect"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
 	istanbulcommon "github.com/ethereum/go-ethereum/consensus/istanbul/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 func TestPrepareExtra(t *testing.T) {
@@ -159,3 +164,210 @@ func TestWriteValidatorVote(t *testing.T) {
 		t.Errorf("extra data mismatch: have %v, want %v", istExtra, expectedIstExtra)
 	}
 }
+
+func TestTrailingInvalidCommittedSeal(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 4)
+	addrs := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	validators := validator.NewSet(addrs, istanbul.NewRoundRobinProposerPolicy())
+	outsider, _ := crypto.GenerateKey()
+
+	h := &types.Header{Number: big.NewInt(1)}
+	if err := ApplyHeaderQBFTExtra(h, WriteValidators(addrs)); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+
+	// quorum of valid seals followed by a seal from a non validator
+	proposalSeal := PrepareCommittedSeal(h, 0, nil)
+	var seals [][]byte
+	for _, key := range append(keys[:3:3], outsider) {
+		seal, err := crypto.Sign(proposalSeal, key)
+		if err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		seals = append(seals, seal)
+	}
+	if err := ApplyHeaderQBFTExtra(h, writeCommittedSeals(seals)); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+
+	// every seal is verified, even once enough valid seals have been found
+	engine := NewEngine(&istanbul.Config{}, common.Address{}, nil)
+	if err := engine.verifyCommittedSeals(nil, h, nil, validators); err != istanbulcommon.ErrInvalidCommittedSeals {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbulcommon.ErrInvalidCommittedSeals)
+	}
+}
+
+func TestCommittedSealChainID(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 4)
+	addrs := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	validators := validator.NewSet(addrs, istanbul.NewRoundRobinProposerPolicy())
+
+	// sealed returns a header at the given height, with a quorum of seals bound to chainID
+	sealed := func(number int64, chainID *big.Int) *types.Header {
+		h := &types.Header{Number: big.NewInt(number)}
+		if err := ApplyHeaderQBFTExtra(h, WriteValidators(addrs)); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		proposalSeal := PrepareCommittedSeal(h, 0, chainID)
+		var seals [][]byte
+		for _, key := range keys[:3] {
+			seal, err := crypto.Sign(proposalSeal, key)
+			if err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+			seals = append(seals, seal)
+		}
+		if err := ApplyHeaderQBFTExtra(h, writeCommittedSeals(seals)); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		return h
+	}
+
+	engine := NewEngine(&istanbul.Config{ChainID: big.NewInt(10), CommitSealChainIDBlock: big.NewInt(5)}, common.Address{}, nil)
+	testCases := []struct {
+		name    string
+		number  int64
+		chainID *big.Int
+		err     error
+	}{
+		{"unbound seals before the fork", 4, nil, nil},
+		{"bound seals from the fork", 5, big.NewInt(10), nil},
+		{"seals bound to another chain", 5, big.NewInt(11), istanbulcommon.ErrInvalidCommittedSeals},
+		{"unbound seals from the fork", 6, nil, istanbulcommon.ErrInvalidCommittedSeals},
+	}
+	for _, test := range testCases {
+		h := sealed(test.number, test.chainID)
+		if err := engine.verifyCommittedSeals(nil, h, nil, validators); err != test.err {
+			t.Errorf("%s: error mismatch: have %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+// newSealedHeader returns a header of the given validators carrying a committed seal signed by each of keys,
+// or an invalid signature for the nil keys
+func newSealedHeader(t testing.TB, addrs []common.Address, keys []*ecdsa.PrivateKey) *types.Header {
+	h := &types.Header{Number: big.NewInt(1)}
+	if err := ApplyHeaderQBFTExtra(h, WriteValidators(addrs)); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	proposalSeal := PrepareCommittedSeal(h, 0, nil)
+	var seals [][]byte
+	for _, key := range keys {
+		seal := make([]byte, types.IstanbulExtraSeal)
+		if key != nil {
+			var err error
+			if seal, err = crypto.Sign(proposalSeal, key); err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+		}
+		seals = append(seals, seal)
+	}
+	if err := ApplyHeaderQBFTExtra(h, writeCommittedSeals(seals)); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	return h
+}
+
+func TestParallelCommittedSealVerification(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 7)
+	addrs := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	validators := validator.NewSet(addrs, istanbul.NewRoundRobinProposerPolicy())
+	outsider, _ := crypto.GenerateKey()
+
+	// F is 2, 3 valid seals are enough
+	testCases := []struct {
+		name string
+		keys []*ecdsa.PrivateKey
+		err  error
+	}{
+		{"all valid", keys, nil},
+		{"quorum of valid", keys[:3], nil},
+		{"below quorum", keys[:2], istanbulcommon.ErrInvalidCommittedSeals},
+		{"invalid signature before quorum", []*ecdsa.PrivateKey{keys[0], nil, keys[1], keys[2]}, istanbulcommon.ErrInvalidSignature},
+		{"non validator before quorum", []*ecdsa.PrivateKey{keys[0], keys[1], outsider, keys[2]}, istanbulcommon.ErrInvalidCommittedSeals},
+		{"duplicate before quorum", []*ecdsa.PrivateKey{keys[0], keys[1], keys[1], keys[2]}, istanbulcommon.ErrInvalidCommittedSeals},
+		{"invalid signature after quorum", []*ecdsa.PrivateKey{keys[0], keys[1], keys[2], nil}, istanbulcommon.ErrInvalidSignature},
+		{"non validator after quorum", []*ecdsa.PrivateKey{keys[0], keys[1], keys[2], keys[3], outsider}, istanbulcommon.ErrInvalidCommittedSeals},
+	}
+	for _, test := range testCases {
+		h := newSealedHeader(t, addrs, test.keys)
+		// the workers must reach the same result as the serial verification
+		for _, workers := range []int{0, 2, 16} {
+			engine := NewEngine(&istanbul.Config{CommittedSealWorkers: workers}, common.Address{}, nil)
+			if err := engine.verifyCommittedSeals(nil, h, nil, validators); err != test.err {
+				t.Errorf("%s with %d workers: error mismatch: have %v, want %v", test.name, workers, err, test.err)
+			}
+		}
+	}
+}
+
+// The errors of the committed seal verification are the ones returned before the seals could be recovered
+// by several workers: an unrecoverable seal fails as it does in Signers, a seal recovered to a signer outside
+// the validator set invalidates the committed seals
+func TestCommittedSealErrors(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 4)
+	addrs := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	validators := validator.NewSet(addrs, istanbul.NewRoundRobinProposerPolicy())
+	outsider, _ := crypto.GenerateKey()
+
+	for _, workers := range []int{0, 4} {
+		engine := NewEngine(&istanbul.Config{CommittedSealWorkers: workers}, common.Address{}, nil)
+
+		// unrecoverable seal
+		h := newSealedHeader(t, addrs, []*ecdsa.PrivateKey{keys[0], keys[1], nil, keys[2]})
+		if _, err := engine.Signers(h); err != istanbulcommon.ErrInvalidSignature {
+			t.Fatalf("signers error mismatch: have %v, want %v", err, istanbulcommon.ErrInvalidSignature)
+		}
+		if err := engine.verifyCommittedSeals(nil, h, nil, validators); err != istanbulcommon.ErrInvalidSignature {
+			t.Errorf("unrecoverable seal with %d workers: error mismatch: have %v, want %v", workers, err, istanbulcommon.ErrInvalidSignature)
+		}
+
+		// signer outside the validator set
+		h = newSealedHeader(t, addrs, []*ecdsa.PrivateKey{keys[0], keys[1], outsider, keys[2]})
+		if _, err := engine.Signers(h); err != nil {
+			t.Fatalf("signers error mismatch: have %v, want nil", err)
+		}
+		if err := engine.verifyCommittedSeals(nil, h, nil, validators); err != istanbulcommon.ErrInvalidCommittedSeals {
+			t.Errorf("signer outside the set with %d workers: error mismatch: have %v, want %v", workers, err, istanbulcommon.ErrInvalidCommittedSeals)
+		}
+	}
+}
+
+func BenchmarkVerifyCommittedSeals(b *testing.B) {
+	keys := make([]*ecdsa.PrivateKey, 100)
+	addrs := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	validators := validator.NewSet(addrs, istanbul.NewRoundRobinProposerPolicy())
+	// headers carry the 2F+1 seals of a QBFT quorum
+	h := newSealedHeader(b, addrs, keys[:2*validators.F()+1])
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		engine := NewEngine(&istanbul.Config{CommittedSealWorkers: workers}, common.Address{}, nil)
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := engine.verifyCommittedSeals(nil, h, nil, validators); err != nil {
+					b.Fatalf("error mismatch: have %v, want nil", err)
+				}
+			}
+		})
+	}
+}
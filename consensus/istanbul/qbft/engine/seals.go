@@ -0,0 +1,66 @@
+package qbftengine
+
+import (
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// sealSigner is the signer recovered from a committed seal
+type sealSigner struct {
+	addr common.Address
+	err  error
+}
+
+// sealSigners recovers the signers of committed seals on concurrent workers. The seals are picked in
+// order, so the first ones are recovered first and their verification can stop the workers early.
+type sealSigners struct {
+	results []chan sealSigner
+	next    int32 // index of the next seal to recover
+	abort   chan struct{}
+}
+
+// recoverSealSigners starts recovering the signers of seals on the given number of workers,
+// stop must be called once the signers are not needed anymore
+func recoverSealSigners(proposalSeal []byte, seals [][]byte, workers int) *sealSigners {
+	s := &sealSigners{
+		results: make([]chan sealSigner, len(seals)),
+		abort:   make(chan struct{}),
+	}
+	for i := range s.results {
+		s.results[i] = make(chan sealSigner, 1)
+	}
+	if workers > len(seals) {
+		workers = len(seals)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for {
+				i := int(atomic.AddInt32(&s.next, 1) - 1)
+				if i >= len(seals) {
+					return
+				}
+				select {
+				case <-s.abort:
+					return
+				default:
+				}
+				addr, err := istanbul.GetSignatureAddressNoHashing(proposalSeal, seals[i])
+				s.results[i] <- sealSigner{addr: addr, err: err}
+			}
+		}()
+	}
+	return s
+}
+
+// get waits for the signer of the i-th seal
+func (s *sealSigners) get(i int) (common.Address, error) {
+	r := <-s.results[i]
+	return r.addr, r.err
+}
+
+// stop makes the workers exit without recovering the remaining seals
+func (s *sealSigners) stop() {
+	close(s.abort)
+}
@@ -14,6 +14,7 @@ type CommonPayload struct {
 	Sequence  *big.Int
 	Round     *big.Int
 	signature []byte
+	signer    *common.Address // address recovered from signature, nil until recovered
 }
 
 func (m *CommonPayload) Code() uint64 {
@@ -38,4 +39,18 @@ func (m *CommonPayload) Signature() []byte {
 
 func (m *CommonPayload) SetSignature(signature []byte) {
 	m.signature = signature
+	m.signer = nil
+}
+
+// Signer returns the address recovered from the signature of the message, if it has been recovered
+func (m *CommonPayload) Signer() (common.Address, bool) {
+	if m.signer == nil {
+		return common.Address{}, false
+	}
+	return *m.signer, true
+}
+
+// SetSigner caches the address recovered from the signature of the message
+func (m *CommonPayload) SetSigner(address common.Address) {
+	m.signer = &address
 }
@@ -32,4 +32,6 @@ type QBFTMessage interface {
 	EncodePayloadForSigning() ([]byte, error)
 	Signature() []byte
 	SetSignature(signature []byte)
+	Signer() (common.Address, bool)
+	SetSigner(address common.Address)
 }
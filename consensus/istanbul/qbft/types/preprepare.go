@@ -15,6 +15,11 @@ type Preprepare struct {
 	Proposal                  istanbul.Proposal
 	JustificationRoundChanges []*SignedRoundChangePayload
 	JustificationPrepares     []*Prepare
+
+	// CertificateSignature is the proposer signature over the justification, so that it can not be altered once
+	// the message is sent. It is only set from the signed certificates fork block and only encoded when set, so
+	// the messages before the fork keep their former encoding.
+	CertificateSignature []byte
 }
 
 func NewPreprepare(sequence *big.Int, round *big.Int, proposal istanbul.Proposal) *Preprepare {
@@ -36,7 +41,26 @@ func (m *Preprepare) EncodePayloadForSigning() ([]byte, error) {
 		})
 }
 
+// EncodeCertificateForSigning encodes the justification of the message with its view and proposal, for the
+// proposer to sign it
+func (m *Preprepare) EncodeCertificateForSigning() ([]byte, error) {
+	return rlp.EncodeToBytes(
+		[]interface{}{
+			m.Code(),
+			[]interface{}{m.Sequence, m.Round, m.Proposal.Hash()},
+			m.JustificationRoundChanges,
+			m.JustificationPrepares,
+		})
+}
+
 func (m *Preprepare) EncodeRLP(w io.Writer) error {
+	justification := []interface{}{
+		m.JustificationRoundChanges,
+		m.JustificationPrepares,
+	}
+	if len(m.CertificateSignature) > 0 {
+		justification = append(justification, m.CertificateSignature)
+	}
 	return rlp.Encode(
 		w,
 		[]interface{}{
@@ -44,10 +68,7 @@ func (m *Preprepare) EncodeRLP(w io.Writer) error {
 				[]interface{}{m.Sequence, m.Round, m.Proposal},
 				m.signature,
 			},
-			[]interface{}{
-				m.JustificationRoundChanges,
-				m.JustificationPrepares,
-			},
+			justification,
 		})
 }
 
@@ -62,8 +83,9 @@ func (m *Preprepare) DecodeRLP(stream *rlp.Stream) error {
 			Signature []byte
 		}
 		Justification struct {
-			RoundChanges []*SignedRoundChangePayload
-			Prepares     []*Prepare
+			RoundChanges         []*SignedRoundChangePayload
+			Prepares             []*Prepare
+			CertificateSignature [][]byte `rlp:"tail"`
 		}
 	}
 	if err := stream.Decode(&message); err != nil {
@@ -76,6 +98,9 @@ func (m *Preprepare) DecodeRLP(stream *rlp.Stream) error {
 	m.signature = message.SignedPayload.Signature
 	m.JustificationPrepares = message.Justification.Prepares
 	m.JustificationRoundChanges = message.Justification.RoundChanges
+	if sigs := message.Justification.CertificateSignature; len(sigs) > 0 {
+		m.CertificateSignature = sigs[0]
+	}
 	return nil
 }
 
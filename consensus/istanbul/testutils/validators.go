@@ -0,0 +1,73 @@
+package testutils
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// NewTestValidatorSet creates a validator set of the given addresses, it panics if an address is repeated.
+// The round robin policy is used when policy is nil.
+func NewTestValidatorSet(addrs []common.Address, policy *istanbul.ProposerPolicy) istanbul.ValidatorSet {
+	if err := validator.CheckDuplicates(addrs); err != nil {
+		panic(err)
+	}
+	if policy == nil {
+		policy = istanbul.NewRoundRobinProposerPolicy()
+	}
+	return validator.NewSet(addrs, policy)
+}
+
+// ValidatorsAndKeys generates n validator keys and their addresses
+func ValidatorsAndKeys(n int) ([]common.Address, []*ecdsa.PrivateKey) {
+	addrs := make([]common.Address, n)
+	keys := make([]*ecdsa.PrivateKey, n)
+	for i := 0; i < n; i++ {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	return addrs, keys
+}
+
+// SetProposer makes addr the proposer of valSet, looking for the previous proposer and round
+// that the proposer policy of the set maps to it
+func SetProposer(valSet istanbul.ValidatorSet, addr common.Address) error {
+	if _, v := valSet.GetByAddress(addr); v == nil {
+		return fmt.Errorf("%v is not a validator", addr)
+	}
+	// no previous proposer, then each validator as the previous proposer
+	lastProposers := []common.Address{{}}
+	for _, v := range valSet.List() {
+		lastProposers = append(lastProposers, v.Address())
+	}
+	for round := 0; round < valSet.Size(); round++ {
+		view := &istanbul.View{Sequence: common.Big1, Round: big.NewInt(int64(round))}
+		for _, last := range lastProposers {
+			valSet.CalcProposer(last, view)
+			if valSet.IsProposer(addr) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("proposer policy never selects %v", addr)
+}
+
+// FixedProposers is a proposer selector picking the proposer of each view from a list of addresses,
+// by sequence then round. Views selecting an address which is not part of the set have no proposer.
+type FixedProposers []common.Address
+
+// SelectProposer implements istanbul.ProposerSelector
+func (p FixedProposers) SelectProposer(valSet istanbul.ValidatorSet, view *istanbul.View, lastProposer common.Address) istanbul.Validator {
+	if len(p) == 0 {
+		return nil
+	}
+	seed := new(big.Int).Add(view.Sequence, view.Round)
+	pick := new(big.Int).Mod(seed, big.NewInt(int64(len(p)))).Uint64()
+	_, v := valSet.GetByAddress(p[pick])
+	return v
+}
@@ -0,0 +1,84 @@
+package testutils_test
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/testutils"
+)
+
+var (
+	alice = common.HexToAddress("0x000000000000000000000000000000000000000a")
+	bob   = common.HexToAddress("0x000000000000000000000000000000000000000b")
+	carol = common.HexToAddress("0x000000000000000000000000000000000000000c")
+	dave  = common.HexToAddress("0x000000000000000000000000000000000000000d")
+)
+
+func ExampleNewTestValidatorSet() {
+	valSet := testutils.NewTestValidatorSet([]common.Address{dave, bob, carol, alice}, nil)
+
+	// validators are sorted, the first one proposes until a proposer is calculated
+	fmt.Println(valSet.Size(), valSet.F(), valSet.GetProposer().Address() == alice)
+
+	// round robin moves to the next validator after the last proposer, the validators being sorted by
+	// checksummed address carol (0x...0C) follows alice (0x...0A)
+	valSet.CalcProposer(alice, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+	fmt.Println(valSet.IsProposer(carol))
+	// Output:
+	// 4 1 true
+	// true
+}
+
+func ExampleSetProposer() {
+	valSet := testutils.NewTestValidatorSet([]common.Address{alice, bob, carol, dave}, istanbul.NewStickyProposerPolicy())
+	if err := testutils.SetProposer(valSet, carol); err != nil {
+		panic(err)
+	}
+	fmt.Println(valSet.IsProposer(carol))
+	// Output: true
+}
+
+func ExampleFixedProposers() {
+	policy := istanbul.NewCustomProposerPolicy(istanbul.RoundRobin, testutils.FixedProposers{dave, dave, bob})
+	valSet := testutils.NewTestValidatorSet([]common.Address{alice, bob, carol, dave}, policy)
+
+	for round := int64(0); round < 3; round++ {
+		valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(3), Round: big.NewInt(round)})
+		fmt.Println(valSet.GetProposer().Address() == dave)
+	}
+	// Output:
+	// true
+	// true
+	// false
+}
+
+func TestSetProposer(t *testing.T) {
+	addrs, _ := testutils.ValidatorsAndKeys(5)
+	for _, policy := range []*istanbul.ProposerPolicy{istanbul.NewRoundRobinProposerPolicy(), istanbul.NewStickyProposerPolicy()} {
+		valSet := testutils.NewTestValidatorSet(addrs, policy)
+		for _, addr := range addrs {
+			if err := testutils.SetProposer(valSet, addr); err != nil {
+				t.Fatalf("policy %v: set proposer failed: %v", policy.Id, err)
+			}
+			if !valSet.IsProposer(addr) {
+				t.Errorf("policy %v: proposer mismatch: have %v, want %v", policy.Id, valSet.GetProposer(), addr)
+			}
+		}
+	}
+
+	if err := testutils.SetProposer(testutils.NewTestValidatorSet(addrs, nil), alice); err == nil {
+		t.Errorf("error mismatch: have nil, want an error for a non validator")
+	}
+}
+
+func TestNewTestValidatorSetRejectsDuplicates(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic for duplicate validators")
+		}
+	}()
+	testutils.NewTestValidatorSet([]common.Address{alice, bob, alice}, nil)
+}
@@ -17,6 +17,9 @@
 package istanbul
 
 import (
+	"math"
+	"math/big"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
@@ -52,6 +55,15 @@ func GetSignatureAddressNoHashing(data []byte, sig []byte) (common.Address, erro
 	return crypto.PubkeyToAddress(*pubkey), nil
 }
 
+// BindChainID binds the data of a committed seal to the given chain, so that the seal cannot be
+// replayed on another chain. A nil chainID leaves the data unchanged.
+func BindChainID(data []byte, chainID *big.Int) []byte {
+	if chainID == nil {
+		return data
+	}
+	return crypto.Keccak256(data, common.LeftPadBytes(chainID.Bytes(), 32))
+}
+
 func CheckValidatorSignature(valSet ValidatorSet, data []byte, sig []byte) (common.Address, error) {
 	// 1. Get signature address
 	signer, err := GetSignatureAddress(data, sig)
@@ -67,3 +79,12 @@ func CheckValidatorSignature(valSet ValidatorSet, data []byte, sig []byte) (comm
 
 	return common.Address{}, ErrUnauthorizedAddress
 }
+
+// QuorumSize returns the number of confirmations required to move from one state to the next
+// for the given validator set at the given block
+func QuorumSize(config *Config, valSet ValidatorSet, blockNumber *big.Int) int {
+	if config.Use2FPlus1Quorum(blockNumber) {
+		return (2 * valSet.F()) + 1
+	}
+	return int(math.Ceil(float64(2*valSet.Size()) / 3))
+}
@@ -78,8 +78,8 @@ func (by ValidatorSortByFunc) Sort(validators []Validator) {
 // ----------------------------------------------------------------------------
 
 type ValidatorSet interface {
-	// Calculate the proposer
-	CalcProposer(lastProposer common.Address, round uint64)
+	// Calculate the proposer of the given view
+	CalcProposer(lastProposer common.Address, view *View)
 	// Return the validator size
 	Size() int
 	// Return the validator array
@@ -109,4 +109,32 @@ type ValidatorSet interface {
 
 // ----------------------------------------------------------------------------
 
+// ProposerSelector selects the proposer of a view among a validator set, given the proposer of the
+// previous block. Implementations must be deterministic: every node has to select the same proposer.
+type ProposerSelector interface {
+	SelectProposer(valSet ValidatorSet, view *View, lastProposer common.Address) Validator
+}
+
+// ProposerGrace is implemented by the validator sets able to hold back recently added validators from
+// the proposer selection
+type ProposerGrace interface {
+	// SetProposerGrace makes the given validators selectable as proposer from the given sequence only,
+	// a view selecting one earlier gets the next selectable validator of the set instead
+	SetProposerGrace(eligible map[common.Address]uint64)
+}
+
+// ProposerSeed is implemented by the validator sets able to mix a seed into the proposer selection, the
+// hash of the block they are the validators after
+type ProposerSeed interface {
+	// SetProposerSeed sets the seed of the HashSeeded proposer policy
+	SetProposerSeed(seed common.Hash)
+}
+
+// ProposalSelector is a ProposerSelector only depending on the round of the view, as the built-in
+// round robin and sticky policies
 type ProposalSelector func(ValidatorSet, common.Address, uint64) Validator
+
+// SelectProposer implements ProposerSelector
+func (f ProposalSelector) SelectProposer(valSet ValidatorSet, view *View, lastProposer common.Address) Validator {
+	return f(valSet, lastProposer, view.Round.Uint64())
+}
@@ -17,6 +17,7 @@
 package validator
 
 import (
+	"encoding/binary"
 	"math"
 	"reflect"
 	"sync"
@@ -45,7 +46,16 @@ type defaultSet struct {
 
 	proposer    istanbul.Validator
 	validatorMu sync.RWMutex
-	selector    istanbul.ProposalSelector
+	selector    istanbul.ProposerSelector
+
+	// eligible is the sequence from which recently added validators may be selected as proposer
+	eligible map[common.Address]uint64
+
+	// index is the position of each validator in validators, rebuilt whenever they change
+	index map[common.Address]int
+
+	// seed is the hash of the block the set is the validators after, mixed into the HashSeeded selection
+	seed common.Hash
 }
 
 func newDefaultSet(addrs []common.Address, policy *istanbul.ProposerPolicy) *defaultSet {
@@ -63,9 +73,15 @@ func newDefaultSet(addrs []common.Address, policy *istanbul.ProposerPolicy) *def
 	if valSet.Size() > 0 {
 		valSet.proposer = valSet.GetByIndex(0)
 	}
-	valSet.selector = roundRobinProposer
-	if policy.Id == istanbul.Sticky {
-		valSet.selector = stickyProposer
+	switch {
+	case policy.Selector != nil:
+		valSet.selector = policy.Selector
+	case policy.Id == istanbul.Sticky:
+		valSet.selector = istanbul.ProposalSelector(stickyProposer)
+	case policy.Id == istanbul.HashSeeded:
+		valSet.selector = istanbul.ProposalSelector(valSet.seededProposer)
+	default:
+		valSet.selector = istanbul.ProposalSelector(roundRobinProposer)
 	}
 
 	policy.RegisterValidatorSet(valSet)
@@ -95,10 +111,10 @@ func (valSet *defaultSet) GetByIndex(i uint64) istanbul.Validator {
 }
 
 func (valSet *defaultSet) GetByAddress(addr common.Address) (int, istanbul.Validator) {
-	for i, val := range valSet.List() {
-		if addr == val.Address() {
-			return i, val
-		}
+	valSet.validatorMu.RLock()
+	defer valSet.validatorMu.RUnlock()
+	if i, ok := valSet.index[addr]; ok {
+		return i, valSet.validators[i]
 	}
 	return -1, nil
 }
@@ -109,18 +125,60 @@ func (valSet *defaultSet) GetProposer() istanbul.Validator {
 
 func (valSet *defaultSet) IsProposer(address common.Address) bool {
 	_, val := valSet.GetByAddress(address)
-	return reflect.DeepEqual(valSet.GetProposer(), val)
+	return val != nil && reflect.DeepEqual(valSet.GetProposer(), val)
 }
 
-func (valSet *defaultSet) CalcProposer(lastProposer common.Address, round uint64) {
+func (valSet *defaultSet) CalcProposer(lastProposer common.Address, view *istanbul.View) {
 	valSet.validatorMu.RLock()
 	defer valSet.validatorMu.RUnlock()
-	valSet.proposer = valSet.selector(valSet, lastProposer, round)
+	proposer := valSet.selector.SelectProposer(valSet, view, lastProposer)
+	if proposer != nil && !valSet.isEligible(proposer.Address(), view) {
+		// hand the view to the next validator out of its grace period, if any
+		idx, _ := valSet.GetByAddress(proposer.Address())
+		for i := 1; i < len(valSet.validators); i++ {
+			next := valSet.validators[(idx+i)%len(valSet.validators)]
+			if valSet.isEligible(next.Address(), view) {
+				proposer = next
+				break
+			}
+		}
+	}
+	valSet.proposer = proposer
+}
+
+// SetProposerGrace implements istanbul.ProposerGrace
+func (valSet *defaultSet) SetProposerGrace(eligible map[common.Address]uint64) {
+	valSet.validatorMu.Lock()
+	defer valSet.validatorMu.Unlock()
+	valSet.eligible = eligible
+}
+
+// SetProposerSeed implements istanbul.ProposerSeed
+func (valSet *defaultSet) SetProposerSeed(seed common.Hash) {
+	valSet.validatorMu.Lock()
+	defer valSet.validatorMu.Unlock()
+	valSet.seed = seed
+}
+
+// isEligible reports whether addr may be selected as proposer of view
+func (valSet *defaultSet) isEligible(addr common.Address, view *istanbul.View) bool {
+	from, ok := valSet.eligible[addr]
+	return !ok || view.Sequence.Uint64() >= from
 }
 
 // ValidatorSetSorter sorts the validators based on the configured By function
 func (valSet *defaultSet) SortValidators() {
 	valSet.Policy().By.Sort(valSet.validators)
+	valSet.reindex()
+}
+
+// reindex rebuilds the index of the validators by address, once they got added, removed or sorted
+func (valSet *defaultSet) reindex() {
+	index := make(map[common.Address]int, len(valSet.validators))
+	for i, v := range valSet.validators {
+		index[v.Address()] = i
+	}
+	valSet.index = index
 }
 
 func calcSeed(valSet istanbul.ValidatorSet, proposer common.Address, round uint64) uint64 {
@@ -163,13 +221,22 @@ func stickyProposer(valSet istanbul.ValidatorSet, proposer common.Address, round
 	return valSet.GetByIndex(pick)
 }
 
+// seededProposer selects the proposers in round robin from the validator drawn with the seed of the set,
+// whatever the previous proposer: the order is unknown until the seeding block is, then anyone can verify it
+func (valSet *defaultSet) seededProposer(vs istanbul.ValidatorSet, proposer common.Address, round uint64) istanbul.Validator {
+	size := uint64(vs.Size())
+	if size == 0 {
+		return nil
+	}
+	start := binary.BigEndian.Uint64(valSet.seed[:8])
+	return vs.GetByIndex((start%size + round%size) % size)
+}
+
 func (valSet *defaultSet) AddValidator(address common.Address) bool {
 	valSet.validatorMu.Lock()
 	defer valSet.validatorMu.Unlock()
-	for _, v := range valSet.validators {
-		if v.Address() == address {
-			return false
-		}
+	if _, ok := valSet.index[address]; ok {
+		return false
 	}
 	valSet.validators = append(valSet.validators, New(address))
 	// TODO: we may not need to re-sort it again
@@ -185,6 +252,7 @@ func (valSet *defaultSet) RemoveValidator(address common.Address) bool {
 	for i, v := range valSet.validators {
 		if v.Address() == address {
 			valSet.validators = append(valSet.validators[:i], valSet.validators[i+1:]...)
+			valSet.reindex()
 			return true
 		}
 	}
@@ -199,7 +267,10 @@ func (valSet *defaultSet) Copy() istanbul.ValidatorSet {
 	for _, v := range valSet.validators {
 		addresses = append(addresses, v.Address())
 	}
-	return NewSet(addresses, valSet.policy)
+	cpy := newDefaultSet(addresses, valSet.policy)
+	cpy.eligible = valSet.eligible
+	cpy.seed = valSet.seed
+	return cpy
 }
 
 func (valSet *defaultSet) F() int { return int(math.Ceil(float64(valSet.Size())/3)) - 1 }
@@ -17,7 +17,10 @@
 package validator
 
 import (
+	"encoding/json"
+	"errors"
 	fmt "fmt"
+	"math/big"
 	"reflect"
 	"strings"
 	"testing"
@@ -32,6 +35,10 @@ var (
 	testAddress2 = "b37866a925bccd69cfa98d43b510f1d23d78a851"
 )
 
+func testView(round int64) *istanbul.View {
+	return &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(round)}
+}
+
 func TestValidatorSet(t *testing.T) {
 	testNewValidatorSet(t)
 	testNormalValSet(t)
@@ -112,17 +119,17 @@ func testNormalValSet(t *testing.T) {
 	}
 	// test calculate proposer
 	lastProposer := addr1
-	valSet.CalcProposer(lastProposer, uint64(0))
+	valSet.CalcProposer(lastProposer, testView(0))
 	if val := valSet.GetProposer(); !reflect.DeepEqual(val, val2) {
 		t.Errorf("proposer mismatch: have %v, want %v", val, val2)
 	}
-	valSet.CalcProposer(lastProposer, uint64(3))
+	valSet.CalcProposer(lastProposer, testView(3))
 	if val := valSet.GetProposer(); !reflect.DeepEqual(val, val1) {
 		t.Errorf("proposer mismatch: have %v, want %v", val, val1)
 	}
 	// test empty last proposer
 	lastProposer = common.Address{}
-	valSet.CalcProposer(lastProposer, uint64(3))
+	valSet.CalcProposer(lastProposer, testView(3))
 	if val := valSet.GetProposer(); !reflect.DeepEqual(val, val2) {
 		t.Errorf("proposer mismatch: have %v, want %v", val, val2)
 	}
@@ -133,6 +140,10 @@ func testEmptyValSet(t *testing.T) {
 	if valSet == nil {
 		t.Errorf("validator set should not be nil")
 	}
+	valSet.CalcProposer(common.Address{}, &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)})
+	if valSet.GetProposer() != nil || valSet.IsProposer(common.StringToAddress("1")) {
+		t.Errorf("empty validator set should have no proposer")
+	}
 }
 
 func testAddAndRemoveValidator(t *testing.T) {
@@ -191,19 +202,248 @@ func testStickyProposer(t *testing.T) {
 	}
 	// test calculate proposer
 	lastProposer := addr1
-	valSet.CalcProposer(lastProposer, uint64(0))
+	valSet.CalcProposer(lastProposer, testView(0))
 	if val := valSet.GetProposer(); !reflect.DeepEqual(val, val1) {
 		t.Errorf("proposer mismatch: have %v, want %v", val, val1)
 	}
 
-	valSet.CalcProposer(lastProposer, uint64(1))
+	valSet.CalcProposer(lastProposer, testView(1))
 	if val := valSet.GetProposer(); !reflect.DeepEqual(val, val2) {
 		t.Errorf("proposer mismatch: have %v, want %v", val, val2)
 	}
 	// test empty last proposer
 	lastProposer = common.Address{}
-	valSet.CalcProposer(lastProposer, uint64(3))
+	valSet.CalcProposer(lastProposer, testView(3))
 	if val := valSet.GetProposer(); !reflect.DeepEqual(val, val2) {
 		t.Errorf("proposer mismatch: have %v, want %v", val, val2)
 	}
 }
+
+// sequenceSelector picks the proposer from both the sequence and the round of the view
+type sequenceSelector struct{}
+
+func (sequenceSelector) SelectProposer(valSet istanbul.ValidatorSet, view *istanbul.View, lastProposer common.Address) istanbul.Validator {
+	if valSet.Size() == 0 {
+		return nil
+	}
+	seed := 3*view.Sequence.Uint64() + view.Round.Uint64()
+	return valSet.GetByIndex(seed % uint64(valSet.Size()))
+}
+
+func TestCustomProposerSelector(t *testing.T) {
+	var addrs []common.Address
+	for i := 0; i < 4; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	valSet := NewSet(addrs, istanbul.NewCustomProposerPolicy(istanbul.RoundRobin, sequenceSelector{}))
+	copied := valSet.Copy()
+
+	for sequence := int64(1); sequence < 4; sequence++ {
+		for round := int64(0); round < 4; round++ {
+			view := &istanbul.View{Sequence: big.NewInt(sequence), Round: big.NewInt(round)}
+			want := valSet.GetByIndex(uint64(3*sequence+round) % 4)
+
+			valSet.CalcProposer(addrs[0], view)
+			copied.CalcProposer(addrs[1], view)
+			if have := valSet.GetProposer(); have.Address() != want.Address() {
+				t.Errorf("proposer mismatch at %v: have %v, want %v", view, have, want)
+			}
+			if have := copied.GetProposer(); have.Address() != want.Address() {
+				t.Errorf("copied set proposer mismatch at %v: have %v, want %v", view, have, want)
+			}
+		}
+	}
+}
+
+func TestProposerGrace(t *testing.T) {
+	var addrs []common.Address
+	for i := 0; i < 4; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	valSet := NewSet(addrs[:3], istanbul.NewRoundRobinProposerPolicy())
+
+	// the fourth validator joins, it may propose from sequence 5 only
+	valSet.AddValidator(addrs[3])
+	valSet.(istanbul.ProposerGrace).SetProposerGrace(map[common.Address]uint64{addrs[3]: 5})
+	idx, _ := valSet.GetByAddress(addrs[3])
+	copied := valSet.Copy()
+
+	for sequence := int64(3); sequence <= 5; sequence++ {
+		// the round of the view the newcomer is picked by the round robin
+		view := &istanbul.View{Sequence: big.NewInt(sequence), Round: big.NewInt(int64(idx))}
+		want := addrs[3]
+		if sequence < 5 {
+			want = valSet.GetByIndex(uint64(idx+1) % 4).Address()
+		}
+		for _, set := range []istanbul.ValidatorSet{valSet, copied} {
+			set.CalcProposer(common.Address{}, view)
+			if have := set.GetProposer().Address(); have != want {
+				t.Errorf("proposer mismatch at sequence %d: have %v, want %v", sequence, have, want)
+			}
+		}
+	}
+
+	// its votes still count
+	if valSet.Size() != 4 || valSet.F() != 1 {
+		t.Errorf("validator set mismatch: have size %d F %d, want 4 and 1", valSet.Size(), valSet.F())
+	}
+}
+
+func TestHashSeededProposer(t *testing.T) {
+	var addrs []common.Address
+	for i := 0; i < 4; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	newSeededSet := func(seed common.Hash) istanbul.ValidatorSet {
+		valSet := NewSet(addrs, istanbul.NewProposerPolicy(istanbul.HashSeeded))
+		valSet.(istanbul.ProposerSeed).SetProposerSeed(seed)
+		return valSet
+	}
+	proposers := func(valSet istanbul.ValidatorSet) []common.Address {
+		var selected []common.Address
+		for round := int64(0); round < 4; round++ {
+			// the previous proposer does not matter
+			valSet.CalcProposer(addrs[round%2], &istanbul.View{Sequence: big.NewInt(10), Round: big.NewInt(round)})
+			selected = append(selected, valSet.GetProposer().Address())
+		}
+		return selected
+	}
+
+	// the selection is the same for a given previous block hash, on every node and copy of the set
+	seed := common.HexToHash("0x0000000000000002" + strings.Repeat("ab", 24))
+	want := proposers(newSeededSet(seed))
+	if want[0] != newSeededSet(seed).GetByIndex(2).Address() {
+		t.Errorf("first proposer mismatch: have %v, want validator 2", want[0])
+	}
+	seen := make(map[common.Address]bool)
+	for _, addr := range want {
+		seen[addr] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("round changes should go through every validator: have %v", want)
+	}
+	for _, valSet := range []istanbul.ValidatorSet{newSeededSet(seed), newSeededSet(seed).Copy()} {
+		if have := proposers(valSet); !reflect.DeepEqual(have, want) {
+			t.Errorf("proposers mismatch: have %v, want %v", have, want)
+		}
+	}
+
+	// another previous block hash changes the selection
+	other := common.HexToHash("0x0000000000000003" + strings.Repeat("ab", 24))
+	if have := proposers(newSeededSet(other)); reflect.DeepEqual(have, want) {
+		t.Errorf("proposers should change with the previous block hash: have %v", have)
+	}
+}
+
+func TestCheckDuplicates(t *testing.T) {
+	addr1 := common.HexToAddress(testAddress)
+	addr2 := common.HexToAddress(testAddress2)
+	if err := CheckDuplicates([]common.Address{addr1, addr2}); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	err := CheckDuplicates([]common.Address{addr1, addr2, addr1})
+	if !errors.Is(err, istanbul.ErrDuplicateValidator) || !strings.Contains(err.Error(), addr1.Hex()) {
+		t.Errorf("error mismatch: have %v, want %v for %s", err, istanbul.ErrDuplicateValidator, addr1.Hex())
+	}
+}
+
+func TestCheckDuplicatesMixedCase(t *testing.T) {
+	addr1 := common.HexToAddress(testAddress)
+	addr2 := common.HexToAddress(testAddress2)
+
+	// the same validator listed lowercase, checksummed and uppercase in the configuration
+	var addrs []common.Address
+	config := fmt.Sprintf(`["0x%s", "%s", "0x%s", "0x%s"]`, testAddress, addr1.Hex(), testAddress2, strings.ToUpper(testAddress))
+	if err := json.Unmarshal([]byte(config), &addrs); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if want := []common.Address{addr1, addr1, addr2, addr1}; !reflect.DeepEqual(addrs, want) {
+		t.Fatalf("decoded addresses mismatch: have %v, want %v", addrs, want)
+	}
+	err := CheckDuplicates(addrs)
+	if !errors.Is(err, istanbul.ErrDuplicateValidator) || !strings.Contains(err.Error(), addr1.Hex()) {
+		t.Errorf("error mismatch: have %v, want %v for %s", err, istanbul.ErrDuplicateValidator, addr1.Hex())
+	}
+}
+
+func TestGetByAddressAcrossChanges(t *testing.T) {
+	var addrs []common.Address
+	for i := 0; i < 6; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	policy := istanbul.NewRoundRobinProposerPolicy()
+	valSet := NewSet(addrs[:4], policy)
+
+	// every lookup must agree with the position of the validator in the list
+	check := func(stage string, members, others []common.Address) {
+		for _, addr := range members {
+			i, v := valSet.GetByAddress(addr)
+			if v == nil || v.Address() != addr || valSet.GetByIndex(uint64(i)).Address() != addr {
+				t.Errorf("%s: lookup of validator %v mismatch: have index %d, validator %v", stage, addr, i, v)
+			}
+		}
+		for _, addr := range others {
+			if i, v := valSet.GetByAddress(addr); i != -1 || v != nil {
+				t.Errorf("%s: lookup of non validator %v mismatch: have index %d, validator %v", stage, addr, i, v)
+			}
+		}
+	}
+	check("initial", addrs[:4], addrs[4:])
+
+	valSet.AddValidator(addrs[4])
+	valSet.AddValidator(addrs[5])
+	if valSet.AddValidator(addrs[5]) {
+		t.Errorf("duplicate validator added")
+	}
+	check("added", addrs, nil)
+
+	valSet.RemoveValidator(addrs[0])
+	valSet.RemoveValidator(addrs[3])
+	check("removed", []common.Address{addrs[1], addrs[2], addrs[4], addrs[5]}, []common.Address{addrs[0], addrs[3]})
+
+	// sorting the validators the other way round moves every one of them
+	policy.Use(func(lhs istanbul.Validator, rhs istanbul.Validator) bool {
+		return strings.Compare(lhs.String(), rhs.String()) > 0
+	})
+	check("resorted", []common.Address{addrs[1], addrs[2], addrs[4], addrs[5]}, []common.Address{addrs[0], addrs[3]})
+
+	valSet = valSet.Copy()
+	check("copied", []common.Address{addrs[1], addrs[2], addrs[4], addrs[5]}, []common.Address{addrs[0], addrs[3]})
+}
+
+func benchmarkValidatorSet(b *testing.B, size int) (istanbul.ValidatorSet, common.Address) {
+	addrs := make([]common.Address, size)
+	for i := range addrs {
+		key, _ := crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	valSet := NewSet(addrs, istanbul.NewRoundRobinProposerPolicy())
+	// the last validator of the list is the worst case of a linear scan
+	return valSet, valSet.GetByIndex(uint64(size - 1)).Address()
+}
+
+func BenchmarkGetByAddress(b *testing.B) {
+	valSet, addr := benchmarkValidatorSet(b, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		valSet.GetByAddress(addr)
+	}
+}
+
+// BenchmarkGetByAddressLinearScan measures the lookup by scanning the validator list, as GetByAddress
+// did before validator sets kept an index by address
+func BenchmarkGetByAddressLinearScan(b *testing.B) {
+	valSet, addr := benchmarkValidatorSet(b, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, v := range valSet.List() {
+			if v.Address() == addr {
+				break
+			}
+		}
+	}
+}
@@ -18,6 +18,7 @@ package validator
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
@@ -33,6 +34,19 @@ func NewSet(addrs []common.Address, policy *istanbul.ProposerPolicy) istanbul.Va
 	return newDefaultSet(addrs, policy)
 }
 
+// CheckDuplicates returns an error wrapping istanbul.ErrDuplicateValidator if an address is listed several times,
+// which would break the quorum size and the proposer selection of the validator set
+func CheckDuplicates(addrs []common.Address) error {
+	seen := make(map[common.Address]struct{}, len(addrs))
+	for _, addr := range addrs {
+		if _, ok := seen[addr]; ok {
+			return fmt.Errorf("%w: %s", istanbul.ErrDuplicateValidator, addr.Hex())
+		}
+		seen[addr] = struct{}{}
+	}
+	return nil
+}
+
 func ExtractValidators(extraData []byte) []common.Address {
 	// get the validator addresses
 	addrs := make([]common.Address, (len(extraData) / common.AddressLength))
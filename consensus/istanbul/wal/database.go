@@ -0,0 +1,114 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package wal
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// dbKeyWALPrefix prefixes the keys of the write-ahead log entries, followed by their big endian index
+var dbKeyWALPrefix = []byte("istanbul-wal-")
+
+// DatabaseWAL keeps the write-ahead log in a key-value store, e.g. the LevelDB database of the node
+type DatabaseWAL struct {
+	db   ethdb.KeyValueStore
+	next uint64 // index of the next entry
+	mu   sync.Mutex
+}
+
+// NewDatabaseWAL creates a write-ahead log stored in db, continuing the one already stored there if any
+func NewDatabaseWAL(db ethdb.KeyValueStore) *DatabaseWAL {
+	w := &DatabaseWAL{db: db}
+
+	it := db.NewIterator(dbKeyWALPrefix, nil)
+	defer it.Release()
+	for it.Next() {
+		w.next = binary.BigEndian.Uint64(it.Key()[len(dbKeyWALPrefix):]) + 1
+	}
+	return w
+}
+
+func walKey(index uint64) []byte {
+	key := make([]byte, len(dbKeyWALPrefix)+8)
+	copy(key, dbKeyWALPrefix)
+	binary.BigEndian.PutUint64(key[len(dbKeyWALPrefix):], index)
+	return key
+}
+
+// Append implements istanbul.WAL.Append
+func (w *DatabaseWAL) Append(entry istanbul.WALEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	blob, err := rlp.EncodeToBytes(&entry)
+	if err != nil {
+		return err
+	}
+	if err := w.db.Put(walKey(w.next), blob); err != nil {
+		return err
+	}
+	w.next++
+	return nil
+}
+
+// Entries implements istanbul.WAL.Entries
+func (w *DatabaseWAL) Entries() ([]istanbul.WALEntry, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	it := w.db.NewIterator(dbKeyWALPrefix, nil)
+	defer it.Release()
+
+	var entries []istanbul.WALEntry
+	for it.Next() {
+		var entry istanbul.WALEntry
+		if err := rlp.DecodeBytes(it.Value(), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, it.Error()
+}
+
+// Truncate implements istanbul.WAL.Truncate
+func (w *DatabaseWAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	it := w.db.NewIterator(dbKeyWALPrefix, nil)
+	defer it.Release()
+
+	batch := w.db.NewBatch()
+	for it.Next() {
+		if err := batch.Delete(it.Key()); err != nil {
+			return err
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	w.next = 0
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package wal implements stores for the write-ahead log of the istanbul consensus messages
+package wal
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// MemoryWAL keeps the write-ahead log in memory, it only survives restarts of the consensus engine.
+// It is mostly meant for tests.
+type MemoryWAL struct {
+	entries []istanbul.WALEntry
+	mu      sync.Mutex
+}
+
+// NewMemoryWAL creates an empty in-memory write-ahead log
+func NewMemoryWAL() *MemoryWAL {
+	return &MemoryWAL{}
+}
+
+// Append implements istanbul.WAL.Append
+func (w *MemoryWAL) Append(entry istanbul.WALEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	payload := make([]byte, len(entry.Payload))
+	copy(payload, entry.Payload)
+	w.entries = append(w.entries, istanbul.WALEntry{Code: entry.Code, Payload: payload})
+	return nil
+}
+
+// Entries implements istanbul.WAL.Entries
+func (w *MemoryWAL) Entries() ([]istanbul.WALEntry, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entries := make([]istanbul.WALEntry, len(w.entries))
+	copy(entries, w.entries)
+	return entries, nil
+}
+
+// Truncate implements istanbul.WAL.Truncate
+func (w *MemoryWAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.entries = nil
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package wal
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+func testWAL(t *testing.T, w istanbul.WAL, reopen func() istanbul.WAL) {
+	entries := []istanbul.WALEntry{
+		{Code: 0x12, Payload: []byte("preprepare")},
+		{Code: 0x13, Payload: []byte("prepare")},
+		{Code: 0x14, Payload: []byte("commit")},
+	}
+	for _, entry := range entries {
+		if err := w.Append(entry); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+	// the caller may reuse its buffers once appended
+	entries[0].Payload[0] = 'P'
+	entries[0].Payload = []byte("preprepare")
+
+	for _, w := range []istanbul.WAL{w, reopen()} {
+		have, err := w.Entries()
+		if err != nil {
+			t.Fatalf("entries failed: %v", err)
+		}
+		if !reflect.DeepEqual(have, entries) {
+			t.Errorf("entries mismatch: have %v, want %v", have, entries)
+		}
+	}
+
+	if err := w.Truncate(); err != nil {
+		t.Fatalf("truncate failed: %v", err)
+	}
+	if have, _ := w.Entries(); len(have) != 0 {
+		t.Errorf("entries mismatch after truncation: have %v, want none", have)
+	}
+
+	// appending after a truncation starts a new log
+	if err := w.Append(entries[2]); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if have, _ := reopen().Entries(); !reflect.DeepEqual(have, entries[2:]) {
+		t.Errorf("entries mismatch: have %v, want %v", have, entries[2:])
+	}
+}
+
+func TestMemoryWAL(t *testing.T) {
+	w := NewMemoryWAL()
+	testWAL(t, w, func() istanbul.WAL { return w })
+}
+
+func TestDatabaseWAL(t *testing.T) {
+	db := memorydb.New()
+	testWAL(t, NewDatabaseWAL(db), func() istanbul.WAL { return NewDatabaseWAL(db) })
+}
+
+func TestDatabaseWALContinuesStoredLog(t *testing.T) {
+	db := memorydb.New()
+	NewDatabaseWAL(db).Append(istanbul.WALEntry{Code: 0x12, Payload: []byte("first")})
+
+	w := NewDatabaseWAL(db)
+	w.Append(istanbul.WALEntry{Code: 0x13, Payload: []byte("second")})
+	have, err := w.Entries()
+	if err != nil {
+		t.Fatalf("entries failed: %v", err)
+	}
+	if len(have) != 2 || string(have[0].Payload) != "first" || string(have[1].Payload) != "second" {
+		t.Errorf("entries mismatch: have %v, want first then second", have)
+	}
+}
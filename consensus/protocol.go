@@ -2,6 +2,9 @@
 package consensus
 
 import (
+	"fmt"
+	"math"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 )
@@ -19,6 +22,15 @@ const (
 	Istanbul99 = 99
 	// this istanbul subprotocol will be registered in addition to "eth"
 	Istanbul100 = 100
+	// istanbul/101 is dedicated to consensus messages, their codes are shifted to start at a configurable base
+	Istanbul101 = 101
+)
+
+const (
+	// IstanbulCodeBase is the first message code used by the istanbul engine (istanbulMsg)
+	IstanbulCodeBase = 0x11
+	// istanbulCodeCount is the number of message codes used by the istanbul engine (istanbulMsg and the qbft messages)
+	istanbulCodeCount = 5
 )
 
 var (
@@ -58,6 +70,78 @@ type Protocol struct {
 	Versions []uint
 	// Number of implemented message corresponding to different protocol versions.
 	Lengths map[uint]uint64
+	// First message code of the versions remapping the engine message codes on the wire.
+	CodeBases map[uint]uint64
+}
+
+// NewIstanbulProtocol returns the istanbul protocol advertising the versions from minVersion up to maxVersion,
+// a zero bound keeping the default one. Peers negotiate the highest version they share, which lets nodes
+// advertising different windows interoperate as long as the windows overlap.
+// The code base of istanbul/101 is checked only when the window includes it.
+func NewIstanbulProtocol(minVersion, maxVersion uint, codeBase uint64) (Protocol, error) {
+	if maxVersion == 0 {
+		maxVersion = Istanbul100
+	}
+	if minVersion <= Istanbul101 && maxVersion >= Istanbul101 {
+		if err := checkIstanbulCodeBase(codeBase); err != nil {
+			return Protocol{}, err
+		}
+	}
+	all := []uint{Istanbul101, Istanbul100, Istanbul99, Istanbul64}
+	lengths := map[uint]uint64{
+		Istanbul101: codeBase + istanbulCodeCount,
+		Istanbul100: IstanbulProtocol.Lengths[Istanbul100],
+		Istanbul99:  IstanbulProtocol.Lengths[Istanbul99],
+		Istanbul64:  IstanbulProtocol.Lengths[Istanbul64],
+	}
+	protocol := Protocol{
+		Name:    IstanbulProtocol.Name,
+		Lengths: make(map[uint]uint64),
+	}
+	for _, version := range all {
+		if version > maxVersion || version < minVersion {
+			continue
+		}
+		protocol.Versions = append(protocol.Versions, version)
+		protocol.Lengths[version] = lengths[version]
+		if version == Istanbul101 {
+			protocol.CodeBases = map[uint]uint64{Istanbul101: codeBase}
+		}
+	}
+	if len(protocol.Versions) == 0 {
+		return Protocol{}, fmt.Errorf("no istanbul protocol version between %d and %d", minVersion, maxVersion)
+	}
+	return protocol, nil
+}
+
+// checkIstanbulCodeBase checks that the engine message codes can be shifted to start at codeBase. The codes below
+// IstanbulCodeBase, such as the handshake status, are sent as is: a lower base would make them ambiguous.
+func checkIstanbulCodeBase(codeBase uint64) error {
+	if codeBase < IstanbulCodeBase {
+		return fmt.Errorf("istanbul message code base %#x overlaps the codes below %#x", codeBase, IstanbulCodeBase)
+	}
+	if codeBase > math.MaxUint64-istanbulCodeCount {
+		return fmt.Errorf("istanbul message code base %#x overflows the protocol length", codeBase)
+	}
+	return nil
+}
+
+// WireCode returns the code an engine message is sent with on a version of the protocol
+func (p Protocol) WireCode(version uint, code uint64) uint64 {
+	base, ok := p.CodeBases[version]
+	if !ok || code < IstanbulCodeBase {
+		return code
+	}
+	return code - IstanbulCodeBase + base
+}
+
+// EngineCode returns the engine message code of a message received on a version of the protocol
+func (p Protocol) EngineCode(version uint, code uint64) uint64 {
+	base, ok := p.CodeBases[version]
+	if !ok || code < base {
+		return code
+	}
+	return code - base + IstanbulCodeBase
 }
 
 // Broadcaster defines the interface to enqueue blocks to fetcher and find peer
@@ -66,6 +150,8 @@ type Broadcaster interface {
 	Enqueue(id string, block *types.Block)
 	// FindPeers retrives peers by addresses
 	FindPeers(map[common.Address]bool) map[common.Address]Peer
+	// RequestSync asks the node to synchronise its chain with the best peer
+	RequestSync()
 }
 
 // Peer defines the interface to communicate with peer
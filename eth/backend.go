@@ -170,6 +170,11 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 		if chainConfig.IBFT != nil && chainConfig.QBFT != nil {
 			return nil, errors.New("the attributes config.IBFT and config.QBFT are mutually exclusive on the genesis file")
 		}
+		if chainConfig.Istanbul != nil || chainConfig.IBFT != nil || chainConfig.QBFT != nil {
+			if _, err := consensus.NewIstanbulProtocol(config.Istanbul.MinProtocolVersion, config.Istanbul.ProtocolVersion, config.Istanbul.ProtocolCodeBase); err != nil {
+				return nil, fmt.Errorf("invalid istanbul protocol configuration: %v", err)
+			}
+		}
 	}
 
 	if !rawdb.GetIsQuorumEIP155Activated(chainDb) && chainConfig.ChainID != nil {
@@ -209,6 +214,7 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 		quorumConsensusProtocolName = quorumProtocol.Name
 		quorumConsensusProtocolVersions = quorumProtocol.Versions
 		quorumConsensusProtocolLengths = quorumProtocol.Lengths
+		quorumConsensusProtocol = quorumProtocol
 	}
 
 	// force to set the istanbul etherbase to node key address
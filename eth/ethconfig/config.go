@@ -301,6 +301,12 @@ func CreateConsensusEngine(stack *node.Node, chainConfig *params.ChainConfig, co
 		config.Istanbul.MiningBeneficiary = chainConfig.QBFT.MiningBeneficiary
 		config.Istanbul.ValidatorSelectionMode = chainConfig.QBFT.ValidatorSelectionMode
 		config.Istanbul.Validators = chainConfig.QBFT.Validators
+		config.Istanbul.ChainID = chainConfig.ChainID
+		config.Istanbul.CommitSealChainIDBlock = chainConfig.QBFT.CommitSealChainIDBlock
+		config.Istanbul.SignedCertificatesBlock = chainConfig.QBFT.SignedCertificatesBlock
+		if chainConfig.QBFT.ProposerGracePeriod != nil {
+			config.Istanbul.ProposerGracePeriod = *chainConfig.QBFT.ProposerGracePeriod
+		}
 
 		if chainConfig.QBFT.MaxRequestTimeoutSeconds != nil && *chainConfig.QBFT.MaxRequestTimeoutSeconds > 0 {
 			config.Istanbul.MaxRequestTimeoutSeconds = *chainConfig.QBFT.MaxRequestTimeoutSeconds
@@ -521,6 +521,12 @@ func (h *handler) Enqueue(id string, block *types.Block) {
 	h.blockFetcher.Enqueue(id, block)
 }
 
+// Quorum
+// RequestSync makes the chain syncer re-evaluate whether a sync with the best peer is needed
+func (h *handler) RequestSync() {
+	go h.chainSync.handlePeerEvent(nil)
+}
+
 // BroadcastBlock will either propagate a block to a subset of its peers, or
 // will only announce its availability (depending what's requested).
 func (h *handler) BroadcastBlock(block *types.Block, propagate bool) {
@@ -715,6 +721,13 @@ func (h *handler) makeQuorumConsensusProtocol(protoName string, version uint, le
 		Length:  length,
 		// no new peer created, uses the "eth" peer, so no peer management needed.
 		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			// the versions remapping the message codes, e.g. "istanbul/101", only talk to peers using the same code base
+			if err := consensusHandshake(rw, quorumConsensusProtocol, version); err != nil {
+				p.Log().Debug("consensus subprotocol handshake failed", "protoName", protoName, "version", version, "err", err)
+				return err
+			}
+			// translate the message codes of the versions remapping them
+			rw = newConsensusCodeRW(rw, quorumConsensusProtocol, version)
 			/*
 			* 1. wait for the eth protocol to create and register an eth peer.
 			* 2. get the associate eth peer that was registered by he "eth" protocol.
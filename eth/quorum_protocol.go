@@ -2,7 +2,10 @@ package eth
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
+	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/eth/protocols/eth"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
@@ -17,6 +20,16 @@ var (
 	// errEthPeerNil is returned when no eth peer is found to be associated with a p2p peer.
 	errEthPeerNil           = errors.New("eth peer was nil")
 	errEthPeerNotRegistered = errors.New("eth peer was not registered")
+	// errConsensusCodeBaseMismatch is returned when a peer remaps the consensus message codes from another code base.
+	errConsensusCodeBaseMismatch = errors.New("consensus message code base mismatch")
+)
+
+const (
+	// consensusStatusMsg is the code of the handshake message of the consensus subprotocol versions remapping the
+	// message codes, sent first, before any message code is remapped.
+	consensusStatusMsg = 0x00
+	// consensusHandshakeTimeout is the maximum allowed time for the consensus handshake to complete.
+	consensusHandshakeTimeout = 5 * time.Second
 )
 
 // quorum consensus Protocol variables are optionally set in addition to the "eth" protocol variables (eth/protocol.go).
@@ -28,6 +41,9 @@ var quorumConsensusProtocolVersions []uint
 // protocol Length describe the number of messages support by the protocol/version map[uint]uint64{Istanbul64: 18, Istanbul99: 18, Istanbul100: 18}
 var quorumConsensusProtocolLengths map[uint]uint64
 
+// quorumConsensusProtocol is the full consensus protocol, used to translate the message codes of the versions remapping them.
+var quorumConsensusProtocol consensus.Protocol
+
 func (s *Ethereum) quorumConsensusProtocols(backend eth.Backend, network uint64, dnsdisc enode.Iterator) []p2p.Protocol {
 	protos := make([]p2p.Protocol, len(quorumConsensusProtocolVersions))
 	for i, vsn := range quorumConsensusProtocolVersions {
@@ -67,3 +83,79 @@ func isLegacyProtocol(name string, version uint) bool {
 	}
 	return false
 }
+
+// consensusCodeRW translates between the engine message codes and the wire message codes of a consensus
+// subprotocol version, so that the engine and the eth peer only deal with engine codes.
+type consensusCodeRW struct {
+	p2p.MsgReadWriter
+	protocol consensus.Protocol
+	version  uint
+}
+
+// newConsensusCodeRW wraps rw if the version remaps the message codes, otherwise rw is returned as is.
+func newConsensusCodeRW(rw p2p.MsgReadWriter, protocol consensus.Protocol, version uint) p2p.MsgReadWriter {
+	if _, ok := protocol.CodeBases[version]; !ok {
+		return rw
+	}
+	return &consensusCodeRW{MsgReadWriter: rw, protocol: protocol, version: version}
+}
+
+func (rw *consensusCodeRW) ReadMsg() (p2p.Msg, error) {
+	msg, err := rw.MsgReadWriter.ReadMsg()
+	if err != nil {
+		return msg, err
+	}
+	msg.Code = rw.protocol.EngineCode(rw.version, msg.Code)
+	return msg, nil
+}
+
+func (rw *consensusCodeRW) WriteMsg(msg p2p.Msg) error {
+	msg.Code = rw.protocol.WireCode(rw.version, msg.Code)
+	return rw.MsgReadWriter.WriteMsg(msg)
+}
+
+// consensusHandshake exchanges the code bases of a subprotocol version remapping the message codes, e.g. "istanbul/101",
+// and rejects a peer using another one, whose messages would be misread since the code base is configured per node.
+// Versions which do not remap the message codes have no handshake.
+func consensusHandshake(rw p2p.MsgReadWriter, protocol consensus.Protocol, version uint) error {
+	base, ok := protocol.CodeBases[version]
+	if !ok {
+		return nil
+	}
+	errc := make(chan error, 2)
+
+	var remote uint64 // safe to read after two values have been received from errc
+
+	go func() {
+		errc <- p2p.Send(rw, consensusStatusMsg, base)
+	}()
+	go func() {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer msg.Discard()
+		if msg.Code != consensusStatusMsg {
+			errc <- fmt.Errorf("first message has code %#x, want status %#x", msg.Code, consensusStatusMsg)
+			return
+		}
+		errc <- msg.Decode(&remote)
+	}()
+	timeout := time.NewTimer(consensusHandshakeTimeout)
+	defer timeout.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				return err
+			}
+		case <-timeout.C:
+			return p2p.DiscReadTimeout
+		}
+	}
+	if remote != base {
+		return fmt.Errorf("%w: have %#x, want %#x", errConsensusCodeBaseMismatch, remote, base)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package eth
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+// negotiatedVersion mirrors the devp2p capability matching, returning the highest version shared by both protocols.
+func negotiatedVersion(local, remote consensus.Protocol) (uint, bool) {
+	var (
+		best  uint
+		found bool
+	)
+	for _, lv := range local.Versions {
+		for _, rv := range remote.Versions {
+			if lv == rv && (!found || lv > best) {
+				best, found = lv, true
+			}
+		}
+	}
+	return best, found
+}
+
+func TestConsensusProtocolVersionNegotiation(t *testing.T) {
+	const prepareCode = 0x13 // qbft PREPARE message code
+
+	upgraded, err := consensus.NewIstanbulProtocol(consensus.Istanbul100, consensus.Istanbul101, consensus.IstanbulCodeBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dedicated, err := consensus.NewIstanbulProtocol(consensus.Istanbul101, consensus.Istanbul101, consensus.IstanbulCodeBase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := consensus.NewIstanbulProtocol(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name          string
+		local, remote consensus.Protocol
+		version       uint
+		compatible    bool
+	}{
+		{"upgraded nodes", upgraded, upgraded, consensus.Istanbul101, true},
+		{"upgraded and legacy nodes", upgraded, legacy, consensus.Istanbul100, true},
+		{"dedicated and upgraded nodes", dedicated, upgraded, consensus.Istanbul101, true},
+		{"dedicated and legacy nodes", dedicated, legacy, 0, false},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			version, ok := negotiatedVersion(test.local, test.remote)
+			if ok != test.compatible || version != test.version {
+				t.Fatalf("negotiated version mismatch: have %d (%v), want %d (%v)", version, ok, test.version, test.compatible)
+			}
+			if !ok {
+				return
+			}
+			if length := test.local.Lengths[version]; test.local.WireCode(version, prepareCode) >= length {
+				t.Fatalf("wire code out of the protocol length %d", length)
+			}
+
+			localRW, remoteRW := p2p.MsgPipe()
+			defer localRW.Close()
+			local := newConsensusCodeRW(localRW, test.local, version)
+			remote := newConsensusCodeRW(remoteRW, test.remote, version)
+
+			payload := []byte{0x01}
+			go p2p.SendWithNoEncoding(local, prepareCode, payload)
+			msg, err := remote.ReadMsg()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Code != prepareCode {
+				t.Errorf("message code mismatch: have %#x, want %#x", msg.Code, prepareCode)
+			}
+			msg.Discard()
+		})
+	}
+}
+
+func TestNewIstanbulProtocolInvalidWindow(t *testing.T) {
+	if _, err := consensus.NewIstanbulProtocol(consensus.Istanbul101, consensus.Istanbul100, 0); err == nil {
+		t.Fatal("expected an error for an empty version window")
+	}
+}
+
+func TestNewIstanbulProtocolCodeBase(t *testing.T) {
+	testCases := []struct {
+		name       string
+		maxVersion uint
+		codeBase   uint64
+		valid      bool
+	}{
+		{"zero", consensus.Istanbul101, 0, false},
+		{"overlapping the codes sent as is", consensus.Istanbul101, consensus.IstanbulCodeBase - 1, false},
+		{"overflowing the protocol length", consensus.Istanbul101, math.MaxUint64 - 2, false},
+		{"legacy codes", consensus.Istanbul101, consensus.IstanbulCodeBase, true},
+		{"shifted codes", consensus.Istanbul101, 0x20, true},
+		{"highest", consensus.Istanbul101, math.MaxUint64 - 5, true},
+		{"istanbul/101 not advertised", consensus.Istanbul100, 0, true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			protocol, err := consensus.NewIstanbulProtocol(0, test.maxVersion, test.codeBase)
+			if (err == nil) != test.valid {
+				t.Fatalf("error mismatch: have %v, want valid %v", err, test.valid)
+			}
+			if length, ok := protocol.Lengths[consensus.Istanbul101]; ok && length <= test.codeBase {
+				t.Errorf("protocol length %#x does not cover the code base %#x", length, test.codeBase)
+			}
+		})
+	}
+}
+
+func TestConsensusCodeBaseHandshake(t *testing.T) {
+	newProtocol := func(codeBase uint64) consensus.Protocol {
+		protocol, err := consensus.NewIstanbulProtocol(consensus.Istanbul100, consensus.Istanbul101, codeBase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return protocol
+	}
+	testCases := []struct {
+		name          string
+		local, remote consensus.Protocol
+		version       uint
+		err           error
+	}{
+		{"same code base", newProtocol(0x20), newProtocol(0x20), consensus.Istanbul101, nil},
+		{"other code base", newProtocol(0x20), newProtocol(0x30), consensus.Istanbul101, errConsensusCodeBaseMismatch},
+		{"version not remapping codes", newProtocol(0x20), newProtocol(0x30), consensus.Istanbul100, nil},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			localRW, remoteRW := p2p.MsgPipe()
+			defer localRW.Close()
+
+			errc := make(chan error, 1)
+			go func() { errc <- consensusHandshake(remoteRW, test.remote, test.version) }()
+			if err := consensusHandshake(localRW, test.local, test.version); !errors.Is(err, test.err) {
+				t.Errorf("local error mismatch: have %v, want %v", err, test.err)
+			}
+			if err := <-errc; !errors.Is(err, test.err) {
+				t.Errorf("remote error mismatch: have %v, want %v", err, test.err)
+			}
+		})
+	}
+}
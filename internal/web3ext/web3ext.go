@@ -37,6 +37,8 @@ var Modules = map[string]string{
 	// Quorum
 	"raft":             Raft_JS,
 	"istanbul":         Istanbul_JS,
+	"qbftdebug":        QBFTDebug_JS,
+	"istanbuladmin":    IstanbulAdmin_JS,
 	"quorumPermission": QUORUM_NODE_JS,
 	"quorumExtension":  Extension_JS,
 	"plugin_account":   Account_Plugin_Js,
@@ -1161,6 +1163,101 @@ web3._extend({
 			params: 1,
             inputFormatter: [web3._extend.formatters.inputBlockNumberFormatter]
 		}),
+		new web3._extend.Method({
+			name: 'simulateValidatorChange',
+			call: 'istanbul_simulateValidatorChange',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'lastRoundChange',
+			call: 'istanbul_lastRoundChange',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'probeBacklogMessage',
+			call: 'istanbul_probeBacklogMessage',
+			params: 3
+		}),
+		new web3._extend.Method({
+			name: 'validateConfig',
+			call: 'istanbul_validateConfig',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'backlogStats',
+			call: 'istanbul_backlogStats',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'quorumProgress',
+			call: 'istanbul_quorumProgress',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'recentConsensusStats',
+			call: 'istanbul_recentConsensusStats',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'metrics',
+			call: 'istanbul_metrics',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'proposeRemoval',
+			call: 'istanbul_proposeRemoval',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'validatorRemovals',
+			call: 'istanbul_validatorRemovals',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'validatorConnectivity',
+			call: 'istanbul_validatorConnectivity',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'backlogPriorities',
+			call: 'istanbul_backlogPriorities',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'roundChanges',
+			call: 'istanbul_roundChanges',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'upcomingProposers',
+			call: 'istanbul_upcomingProposers',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'validatorUptime',
+			call: 'istanbul_validatorUptime',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'safetyViolation',
+			call: 'istanbul_safetyViolation',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'preparedCertificate',
+			call: 'istanbul_preparedCertificate',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'validateProposal',
+			call: 'istanbul_validateProposal',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'faultyProposers',
+			call: 'istanbul_faultyProposers',
+			params: 0
+		}),
 
 	],
 	properties:
@@ -1177,6 +1274,54 @@ web3._extend({
 });
 `
 
+const QBFTDebug_JS = `
+web3._extend({
+	property: 'qbftdebug',
+	methods:
+	[
+		new web3._extend.Method({
+			name: 'pauseEvents',
+			call: 'qbftdebug_pauseEvents',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'stepEvent',
+			call: 'qbftdebug_stepEvent',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'resumeEvents',
+			call: 'qbftdebug_resumeEvents',
+			params: 0
+		}),
+	]
+});
+`
+
+const IstanbulAdmin_JS = `
+web3._extend({
+	property: 'istanbuladmin',
+	methods:
+	[
+		new web3._extend.Method({
+			name: 'exportState',
+			call: 'istanbuladmin_exportState',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'importState',
+			call: 'istanbuladmin_importState',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'clearSafetyViolation',
+			call: 'istanbuladmin_clearSafetyViolation',
+			params: 1
+		}),
+	]
+});
+`
+
 const AccountingJs = `
 web3._extend({
 	property: 'accounting',
@@ -55,7 +55,8 @@ type Config struct {
 	Noverify   bool           // Disable remote mining solution verification(only useful in ethash).
 
 	// Quorum
-	AllowedFutureBlockTime uint64 // Max time (in seconds) from current time allowed for blocks, before they're considered future blocks
+	AllowedFutureBlockTime uint64           // Max time (in seconds) from current time allowed for blocks, before they're considered future blocks
+	PriorityAccounts       []common.Address `toml:",omitempty"` // Accounts whose transactions are included first in the blocks of this node, before the local ones
 }
 
 // Miner creates blocks and searches for proof-of-work values.
@@ -1088,14 +1088,26 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 		w.updateSnapshot()
 		return
 	}
-	// Split the pending transactions into locals and remotes
-	localTxs, remoteTxs := make(map[common.Address]types.Transactions), pending
+	// Split the pending transactions into priority accounts, locals and remotes
+	priorityTxs, localTxs, remoteTxs := make(map[common.Address]types.Transactions), make(map[common.Address]types.Transactions), pending
+	for _, account := range w.config.PriorityAccounts {
+		if txs := remoteTxs[account]; len(txs) > 0 {
+			delete(remoteTxs, account)
+			priorityTxs[account] = txs
+		}
+	}
 	for _, account := range w.eth.TxPool().Locals() {
 		if txs := remoteTxs[account]; len(txs) > 0 {
 			delete(remoteTxs, account)
 			localTxs[account] = txs
 		}
 	}
+	if len(priorityTxs) > 0 {
+		txs := types.NewTransactionsByPriceAndNonce(w.current.signer, priorityTxs)
+		if w.commitTransactions(txs, w.coinbase, interrupt) {
+			return
+		}
+	}
 	if len(localTxs) > 0 {
 		txs := types.NewTransactionsByPriceAndNonce(w.current.signer, localTxs)
 		if w.commitTransactions(txs, w.coinbase, interrupt) {
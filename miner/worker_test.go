@@ -750,3 +750,44 @@ func TestPrivateLegacyStateCreated(t *testing.T) {
 		}
 	}
 }
+
+func TestPriorityAccountsFirst(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	backend := newTestWorkerBackend(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+	signer := types.HomesteadSigner{}
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, testUserAddress, big.NewInt(1000), params.TxGas, nil, nil), signer, testBankKey)
+		backend.txPool.AddLocal(tx)
+	}
+	priorityTx, _ := types.SignTx(types.NewTransaction(0, testBankAddress, big.NewInt(0), params.TxGas, nil, nil), signer, testUserKey)
+	backend.txPool.AddLocal(priorityTx)
+
+	config := *testConfig
+	config.PriorityAccounts = []common.Address{testUserAddress}
+	w := newWorker(&config, ethashChainConfig, engine, backend, new(event.TypeMux), nil, false)
+	w.setEtherbase(testBankAddress)
+	defer w.close()
+
+	blockCh := make(chan *types.Block, 1)
+	w.newTaskHook = func(task *task) {
+		if task.block.NumberU64() == 1 && len(task.block.Transactions()) == 4 {
+			select {
+			case blockCh <- task.block:
+			default:
+			}
+		}
+	}
+	w.skipSealHook = func(task *task) bool { return true }
+	w.start()
+
+	select {
+	case block := <-blockCh:
+		if have := block.Transactions()[0].Hash(); have != priorityTx.Hash() {
+			t.Errorf("first transaction mismatch: have %x, want %x", have, priorityTx.Hash())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("block with all transactions not assembled")
+	}
+}
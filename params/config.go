@@ -429,12 +429,15 @@ func (c IBFTConfig) String() string {
 
 type QBFTConfig struct {
 	*BFTConfig
-	BlockReward              *math.HexOrDecimal256 `json:"blockReward,omitempty"`            // Reward from start, works only on QBFT consensus protocol
-	BeneficiaryMode          *string               `json:"beneficiaryMode,omitempty"`        // Mode for setting the beneficiary, either: list, besu, validators (beneficiary list is the list of validators)
-	MiningBeneficiary        *common.Address       `json:"miningBeneficiary,omitempty"`      // Wallet address that benefits at every new block (besu mode)
-	ValidatorSelectionMode   *string               `json:"validatorselectionmode,omitempty"` // Select model for validators
-	Validators               []common.Address      `json:"validators"`                       // Validators list
-	MaxRequestTimeoutSeconds *uint64               `json:"maxRequestTimeoutSeconds"`         // The max round time
+	BlockReward              *math.HexOrDecimal256 `json:"blockReward,omitempty"`             // Reward from start, works only on QBFT consensus protocol
+	BeneficiaryMode          *string               `json:"beneficiaryMode,omitempty"`         // Mode for setting the beneficiary, either: list, besu, validators (beneficiary list is the list of validators)
+	MiningBeneficiary        *common.Address       `json:"miningBeneficiary,omitempty"`       // Wallet address that benefits at every new block (besu mode)
+	ValidatorSelectionMode   *string               `json:"validatorselectionmode,omitempty"`  // Select model for validators
+	Validators               []common.Address      `json:"validators"`                        // Validators list
+	MaxRequestTimeoutSeconds *uint64               `json:"maxRequestTimeoutSeconds"`          // The max round time
+	CommitSealChainIDBlock   *big.Int              `json:"commitSealChainIdBlock,omitempty"`  // Fork block from which committed seals are bound to the chain ID
+	ProposerGracePeriod      *uint64               `json:"proposerGracePeriod,omitempty"`     // Number of blocks a validator added by vote is not selected as proposer
+	SignedCertificatesBlock  *big.Int              `json:"signedCertificatesBlock,omitempty"` // Fork block from which proposers sign the justification of their PRE-PREPARE messages
 }
 
 func (c QBFTConfig) String() string {
@@ -471,6 +474,7 @@ type Transition struct {
 	BeneficiaryMode              *string               `json:"beneficiaryMode,omitempty"`              // Mode for setting the beneficiary, either: list, besu, validators (beneficiary list is the list of validators)
 	MiningBeneficiary            *common.Address       `json:"miningBeneficiary,omitempty"`            // Wallet address that benefits at every new block (besu mode)
 	MaxRequestTimeoutSeconds     *uint64               `json:"maxRequestTimeoutSeconds,omitempty"`     // The max a timeout should be for a round change
+	ProposerGracePeriod          *uint64               `json:"proposerGracePeriod,omitempty"`          // Number of blocks a validator added by vote is not selected as proposer
 }
 
 // String implements the fmt.Stringer interface.
@@ -1122,6 +1126,12 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int, isQuor
 	if c.Istanbul != nil && newcfg.Istanbul != nil && isForkIncompatible(c.Istanbul.TestQBFTBlock, newcfg.Istanbul.TestQBFTBlock, head) {
 		return newCompatError("Test QBFT fork block", c.Istanbul.TestQBFTBlock, newcfg.Istanbul.TestQBFTBlock)
 	}
+	if c.QBFT != nil && newcfg.QBFT != nil && isForkIncompatible(c.QBFT.CommitSealChainIDBlock, newcfg.QBFT.CommitSealChainIDBlock, head) {
+		return newCompatError("QBFT commit seal chain ID fork block", c.QBFT.CommitSealChainIDBlock, newcfg.QBFT.CommitSealChainIDBlock)
+	}
+	if c.QBFT != nil && newcfg.QBFT != nil && isForkIncompatible(c.QBFT.SignedCertificatesBlock, newcfg.QBFT.SignedCertificatesBlock, head) {
+		return newCompatError("QBFT signed certificates fork block", c.QBFT.SignedCertificatesBlock, newcfg.QBFT.SignedCertificatesBlock)
+	}
 	if isForkIncompatible(c.QIP714Block, newcfg.QIP714Block, head) {
 		return newCompatError("permissions fork block", c.QIP714Block, newcfg.QIP714Block)
 	}
@@ -211,6 +211,34 @@ func TestCheckCompatible(t *testing.T) {
 				RewindTo:     19,
 			},
 		},
+		{
+			stored:  &ChainConfig{QBFT: &QBFTConfig{CommitSealChainIDBlock: big.NewInt(50)}},
+			new:     &ChainConfig{QBFT: &QBFTConfig{CommitSealChainIDBlock: big.NewInt(60)}},
+			head:    40,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{QBFT: &QBFTConfig{CommitSealChainIDBlock: big.NewInt(20)}},
+			new:    &ChainConfig{QBFT: &QBFTConfig{CommitSealChainIDBlock: big.NewInt(30)}},
+			head:   20,
+			wantErr: &ConfigCompatError{
+				What:         "QBFT commit seal chain ID fork block",
+				StoredConfig: big.NewInt(20),
+				NewConfig:    big.NewInt(30),
+				RewindTo:     19,
+			},
+		},
+		{
+			stored: &ChainConfig{QBFT: &QBFTConfig{SignedCertificatesBlock: big.NewInt(20)}},
+			new:    &ChainConfig{QBFT: &QBFTConfig{SignedCertificatesBlock: nil}},
+			head:   25,
+			wantErr: &ConfigCompatError{
+				What:         "QBFT signed certificates fork block",
+				StoredConfig: big.NewInt(20),
+				NewConfig:    nil,
+				RewindTo:     19,
+			},
+		},
 		{
 			stored: &ChainConfig{MaxCodeSizeChangeBlock: big.NewInt(10)},
 			new:    &ChainConfig{MaxCodeSizeChangeBlock: big.NewInt(20)},
@@ -332,10 +360,10 @@ func TestCheckTransitionsData(t *testing.T) {
 	var ibftTransitionsConfig, qbftTransitionsConfig, invalidTransition, invalidBlockOrder []Transition
 	var emptyBlockPeriodSeconds uint64 = 10
 
-	tranI0 := Transition{big.NewInt(0), IBFT, 30000, 5, nil, 10, 50, common.Address{}, nil, "", nil, nil, nil, nil, 0, nil, 0, nil, nil, nil, nil}
-	tranQ5 := Transition{big.NewInt(5), QBFT, 30000, 5, &emptyBlockPeriodSeconds, 10, 50, common.Address{}, nil, "", nil, nil, nil, nil, 0, nil, 0, nil, nil, nil, nil}
-	tranI10 := Transition{big.NewInt(10), IBFT, 30000, 5, nil, 10, 50, common.Address{}, nil, "", nil, nil, nil, nil, 0, nil, 0, nil, nil, nil, nil}
-	tranQ8 := Transition{big.NewInt(8), QBFT, 30000, 5, &emptyBlockPeriodSeconds, 10, 50, common.Address{}, nil, "", nil, nil, nil, nil, 0, nil, 0, nil, nil, nil, nil}
+	tranI0 := Transition{big.NewInt(0), IBFT, 30000, 5, nil, 10, 50, common.Address{}, nil, "", nil, nil, nil, nil, 0, nil, 0, nil, nil, nil, nil, nil}
+	tranQ5 := Transition{big.NewInt(5), QBFT, 30000, 5, &emptyBlockPeriodSeconds, 10, 50, common.Address{}, nil, "", nil, nil, nil, nil, 0, nil, 0, nil, nil, nil, nil, nil}
+	tranI10 := Transition{big.NewInt(10), IBFT, 30000, 5, nil, 10, 50, common.Address{}, nil, "", nil, nil, nil, nil, 0, nil, 0, nil, nil, nil, nil, nil}
+	tranQ8 := Transition{big.NewInt(8), QBFT, 30000, 5, &emptyBlockPeriodSeconds, 10, 50, common.Address{}, nil, "", nil, nil, nil, nil, 0, nil, 0, nil, nil, nil, nil, nil}
 
 	ibftTransitionsConfig = append(ibftTransitionsConfig, tranI0, tranI10)
 	qbftTransitionsConfig = append(qbftTransitionsConfig, tranQ5, tranQ8)
@@ -395,7 +423,7 @@ func TestCheckTransitionsData(t *testing.T) {
 			wantErr: ErrBlockOrder,
 		},
 		{
-			stored:  &ChainConfig{Transitions: []Transition{{nil, IBFT, 30000, 5, &emptyBlockPeriodSeconds, 10, 50, common.Address{}, nil, "", nil, nil, nil, nil, 0, nil, 0, nil, nil, nil, nil}}},
+			stored:  &ChainConfig{Transitions: []Transition{{nil, IBFT, 30000, 5, &emptyBlockPeriodSeconds, 10, 50, common.Address{}, nil, "", nil, nil, nil, nil, 0, nil, 0, nil, nil, nil, nil, nil}}},
 			wantErr: ErrBlockNumberMissing,
 		},
 		{